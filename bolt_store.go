@@ -5,6 +5,7 @@ package raftboltdb
 
 import (
 	"errors"
+	"os"
 	"time"
 
 	metrics "github.com/armon/go-metrics"
@@ -14,7 +15,8 @@ import (
 
 const (
 	// Permissions to use on the db file. This is only used if the
-	// database file does not exist and needs to be created.
+	// database file does not exist and needs to be created and
+	// Options.FileMode is not set.
 	dbFileMode = 0600
 )
 
@@ -38,28 +40,6 @@ type BoltStore struct {
 	path string
 }
 
-// Options contains all the configuration used to open the BoltDB
-type Options struct {
-	// Path is the file path to the BoltDB to use
-	Path string
-
-	// BoltOptions contains any specific BoltDB options you might
-	// want to specify [e.g. open timeout]
-	BoltOptions *bolt.Options
-
-	// NoSync causes the database to skip fsync calls after each
-	// write to the log. This is unsafe, so it should be used
-	// with caution.
-	NoSync bool
-}
-
-// readOnly returns true if the contained bolt options say to open
-// the DB in readOnly mode [this can be useful to tools that want
-// to examine the log]
-func (o *Options) readOnly() bool {
-	return o != nil && o.BoltOptions != nil && o.BoltOptions.ReadOnly
-}
-
 // NewBoltStore takes a file path and returns a connected Raft backend.
 func NewBoltStore(path string) (*BoltStore, error) {
 	return New(Options{Path: path})
@@ -67,8 +47,20 @@ func NewBoltStore(path string) (*BoltStore, error) {
 
 // New uses the supplied options to open the BoltDB and prepare it for use as a raft backend.
 func New(options Options) (*BoltStore, error) {
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+
+	// BoltDB always opens with O_CREATE, so make sure a read-only
+	// open doesn't leave an empty file behind
+	if options.readOnly() {
+		if _, err := os.Stat(options.Path); err != nil {
+			return nil, err
+		}
+	}
+
 	// Try to connect
-	handle, err := bolt.Open(options.Path, dbFileMode, options.BoltOptions)
+	handle, err := bolt.Open(options.Path, options.fileMode(), options.boltOptions())
 	if err != nil {
 		return nil, err
 	}
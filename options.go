@@ -0,0 +1,110 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+var (
+	// ErrInvalidOptions is returned when the supplied Options can not be
+	// used to open the store.
+	ErrInvalidOptions = errors.New("invalid options")
+)
+
+// Options contains all the configuration used to open the BoltDB
+type Options struct {
+	// Path is the file path to the BoltDB to use
+	Path string
+
+	// BoltOptions contains any specific BoltDB options you might
+	// want to specify [e.g. open timeout]. Any of the first-class
+	// fields below that are set take precedence over these.
+	BoltOptions *bolt.Options
+
+	// NoSync causes the database to skip fsync calls after each
+	// write to the log. This is unsafe, so it should be used
+	// with caution.
+	NoSync bool
+
+	// FileMode is used for the database file if it has to be created.
+	// Defaults to 0600.
+	FileMode os.FileMode
+
+	// LockTimeout is the amount of time to wait to obtain the file lock
+	// when opening the database. Zero waits indefinitely.
+	LockTimeout time.Duration
+
+	// ReadOnly opens the database with a shared lock and without
+	// creating any buckets, so it can be inspected while another
+	// read-only process has it open.
+	ReadOnly bool
+
+	// InitialMmapSize is the initial size of the memory map in bytes.
+	InitialMmapSize int
+
+	// MmapFlags are passed through to mmap(2) when mapping the file.
+	MmapFlags int
+}
+
+// readOnly returns true if the options say to open the DB in
+// readOnly mode [this can be useful to tools that want to examine
+// the log]
+func (o *Options) readOnly() bool {
+	if o == nil {
+		return false
+	}
+	return o.ReadOnly || (o.BoltOptions != nil && o.BoltOptions.ReadOnly)
+}
+
+// fileMode returns the permissions to create the database file with.
+func (o *Options) fileMode() os.FileMode {
+	if o.FileMode == 0 {
+		return dbFileMode
+	}
+	return o.FileMode
+}
+
+// validate checks the first-class fields for values BoltDB would
+// either reject or silently misbehave with.
+func (o *Options) validate() error {
+	if o.FileMode&^os.ModePerm != 0 {
+		return fmt.Errorf("%w: FileMode %v must only contain permission bits", ErrInvalidOptions, o.FileMode)
+	}
+	if o.LockTimeout < 0 {
+		return fmt.Errorf("%w: LockTimeout must not be negative", ErrInvalidOptions)
+	}
+	if o.InitialMmapSize < 0 {
+		return fmt.Errorf("%w: InitialMmapSize must not be negative", ErrInvalidOptions)
+	}
+	return nil
+}
+
+// boltOptions merges the first-class fields over BoltOptions, returning
+// a fresh copy that is safe to hand to bolt.Open.
+func (o *Options) boltOptions() *bolt.Options {
+	opts := *bolt.DefaultOptions
+	if o.BoltOptions != nil {
+		opts = *o.BoltOptions
+	}
+
+	if o.LockTimeout != 0 {
+		opts.Timeout = o.LockTimeout
+	}
+	if o.ReadOnly {
+		opts.ReadOnly = true
+	}
+	if o.InitialMmapSize != 0 {
+		opts.InitialMmapSize = o.InitialMmapSize
+	}
+	if o.MmapFlags != 0 {
+		opts.MmapFlags = o.MmapFlags
+	}
+	return &opts
+}
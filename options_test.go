@@ -0,0 +1,114 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/hashicorp/raft"
+)
+
+func TestOptions_Validate(t *testing.T) {
+	cases := []struct {
+		name    string
+		options Options
+		valid   bool
+	}{
+		{"defaults", Options{}, true},
+		{"all set", Options{FileMode: 0640, LockTimeout: time.Second, ReadOnly: true, InitialMmapSize: 1 << 20}, true},
+		{"non-permission mode bits", Options{FileMode: os.ModeDir | 0700}, false},
+		{"negative lock timeout", Options{LockTimeout: -1}, false},
+		{"negative mmap size", Options{InitialMmapSize: -1}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.options.validate()
+			if tc.valid && err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if !tc.valid && !errors.Is(err, ErrInvalidOptions) {
+				t.Fatalf("expected invalid options error, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestNew_FirstClassOptions(t *testing.T) {
+	fh, err := ioutil.TempFile("", "bolt")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	os.Remove(fh.Name())
+	defer os.Remove(fh.Name())
+
+	store, err := New(Options{
+		Path:        fh.Name(),
+		FileMode:    0640,
+		NoSync:      true,
+		LockTimeout: time.Second / 10,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !store.conn.NoSync {
+		t.Fatalf("expected NoSync to be set")
+	}
+	if err := store.StoreLog(testRaftLog(1, "log1")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The file mode is applied on creation, subject to the umask
+	fi, err := os.Stat(fh.Name())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if perm := fi.Mode().Perm(); perm&^0640 != 0 {
+		t.Fatalf("bad: %v", perm)
+	}
+
+	// The lock timeout applies while the store is held open
+	if _, err := New(Options{Path: fh.Name(), LockTimeout: time.Second / 10}); err != bolt.ErrTimeout {
+		t.Fatalf("expected timeout error, got: %v", err)
+	}
+	store.Close()
+
+	// Opening read-only works without BoltOptions and rejects writes
+	roStore, err := New(Options{Path: fh.Name(), ReadOnly: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer roStore.Close()
+	if err := roStore.GetLog(1, new(raft.Log)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := roStore.StoreLog(testRaftLog(2, "log2")); err != bolt.ErrDatabaseReadOnly {
+		t.Fatalf("expecting error %v, but got %v", bolt.ErrDatabaseReadOnly, err)
+	}
+}
+
+func TestNew_ReadOnlyMissingFile(t *testing.T) {
+	fh, err := ioutil.TempFile("", "bolt")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	os.Remove(fh.Name())
+
+	// A read-only open of a missing file fails rather than creating it
+	for _, options := range []Options{
+		{Path: fh.Name(), ReadOnly: true},
+		{Path: fh.Name(), BoltOptions: &bolt.Options{ReadOnly: true}},
+	} {
+		if _, err := New(options); !os.IsNotExist(err) {
+			t.Fatalf("expected not exist error, got: %v", err)
+		}
+		if _, err := os.Stat(fh.Name()); !os.IsNotExist(err) {
+			t.Fatalf("file should not have been created: %v", err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/raft"
+)
+
+const (
+	// archiveBatchSize is the most entries passed to an ArchiveFunc in
+	// one call.
+	archiveBatchSize = 1000
+)
+
+var (
+	// ErrArchiveFailed is returned by DeleteRange and TrimPrefixAsync when
+	// the ArchiveFunc fails, in which case nothing is deleted.
+	ErrArchiveFailed = errors.New("failed archiving logs")
+)
+
+// ArchiveFunc receives log entries that are about to be deleted, in
+// index order, so they can be shipped to cold storage. If it returns an
+// error the deletion is abandoned.
+//
+// It's called from within the write transaction doing the deletion, so
+// other writes wait for it, and a large range is passed in batches.
+// Batches that were archived before a failure are deleted later along
+// with the rest of the range, so the archive must tolerate receiving
+// the same entries more than once.
+type ArchiveFunc func(logs []*raft.Log) error
+
+// archiveRange passes the entries in bucket from min to max inclusive to
+// the store's ArchiveFunc, if it has one.
+func (b *BoltStore) archiveRange(bucket *logBucket, min, max uint64) error {
+	if b.archiveFunc == nil {
+		return nil
+	}
+
+	var batch []*raft.Log
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := b.archiveFunc(batch); err != nil {
+			return fmt.Errorf("%w from %d to %d: %w", ErrArchiveFailed,
+				batch[0].Index, batch[len(batch)-1].Index, err)
+		}
+		batch = nil
+		return nil
+	}
+
+	curs := bucket.cursor()
+	for k, v := curs.Seek(uint64ToBytes(min)); k != nil; k, v = curs.Next() {
+		idx := bytesToUint64(k)
+		if idx > max {
+			break
+		}
+		log := new(raft.Log)
+		if err := bucket.decode(idx, v, log); err != nil {
+			return fmt.Errorf("%w at index %d: %w", ErrLogCorrupt, idx, err)
+		}
+		batch = append(batch, log)
+		if len(batch) == archiveBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
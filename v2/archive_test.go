@@ -0,0 +1,74 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestBoltStore_ArchiveFunc(t *testing.T) {
+	var archived []uint64
+	var batches int
+	fail := false
+	store, err := New(Options{
+		Path: filepath.Join(t.TempDir(), "raft.db"),
+		ArchiveFunc: func(logs []*raft.Log) error {
+			if fail {
+				return errors.New("archive unavailable")
+			}
+			batches++
+			for _, log := range logs {
+				archived = append(archived, log.Index)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	var logs []*raft.Log
+	for i := uint64(1); i <= 2000; i++ {
+		logs = append(logs, testRaftLog(i, "data"))
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// A large range is archived in batches
+	if err := store.DeleteRange(1, 1500); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if batches != 2 || len(archived) != 1500 || archived[0] != 1 || archived[1499] != 1500 {
+		t.Fatalf("bad: %d batches of %d entries", batches, len(archived))
+	}
+
+	// Nothing is deleted if archiving fails
+	fail = true
+	err = store.DeleteRange(1501, 1600)
+	if !errors.Is(err, ErrArchiveFailed) {
+		t.Fatalf("expected archive error, got: %v", err)
+	}
+	checkIndexes(t, store, 1501, 2000)
+	if err := <-store.TrimPrefixAsync(1600); !errors.Is(err, ErrArchiveFailed) {
+		t.Fatalf("expected archive error, got: %v", err)
+	}
+	checkIndexes(t, store, 1501, 2000)
+
+	// Trimming archives too
+	fail = false
+	archived = nil
+	if err := <-store.TrimPrefixAsync(1600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(archived) != 100 || archived[0] != 1501 {
+		t.Fatalf("bad: %d entries", len(archived))
+	}
+	checkIndexes(t, store, 1601, 2000)
+}
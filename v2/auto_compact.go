@@ -0,0 +1,107 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// defaultAutoCompactInterval is used when AutoCompact.CheckInterval
+	// isn't set.
+	defaultAutoCompactInterval = time.Minute
+)
+
+// AutoCompact configures the store to compact itself in the background,
+// see Compact. Each threshold that is set is checked every CheckInterval,
+// and the store is compacted if any is exceeded and no writes have been
+// made since the previous check. At least one threshold must be set.
+type AutoCompact struct {
+	// FreelistBytesThreshold compacts the store once its freelist takes
+	// more than this many bytes, as the freelist is written on every
+	// commit unless NoFreelistSync is set.
+	FreelistBytesThreshold int
+
+	// FileGrowthRatio compacts the store once the file is this many
+	// times the size it was when it was opened or last compacted. It
+	// must be more than 1.
+	FileGrowthRatio float64
+
+	// CheckInterval is how often the thresholds are checked. Defaults to
+	// one minute.
+	CheckInterval time.Duration
+}
+
+// checkInterval returns how often the thresholds are checked.
+func (a *AutoCompact) checkInterval() time.Duration {
+	if a.CheckInterval == 0 {
+		return defaultAutoCompactInterval
+	}
+	return a.CheckInterval
+}
+
+// runAutoCompact checks the thresholds every interval until the store is
+// closed, compacting it when one is exceeded and it's idle.
+func (b *BoltStore) runAutoCompact(opts AutoCompact) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-b.closeCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(opts.checkInterval())
+	defer ticker.Stop()
+	writes := b.writes.Load()
+	for {
+		select {
+		case <-ticker.C:
+		case <-b.closeCh:
+			return
+		}
+
+		// Only compact while nothing is being written, as writes wait
+		// for the whole compaction
+		prev := writes
+		writes = b.writes.Load()
+		if writes != prev {
+			continue
+		}
+		reason, ok := b.autoCompactReason(opts)
+		if !ok {
+			continue
+		}
+
+		b.logger.Info("compacting bolt file", "path", b.path, "reason", reason)
+		if err := b.Compact(ctx); err != nil {
+			if ctx.Err() == nil {
+				b.logger.Error("automatic compaction failed", "path", b.path, "error", err)
+			}
+			continue
+		}
+		b.metrics.incrCounter([]string{"autoCompact"}, 1)
+		writes = b.writes.Load()
+	}
+}
+
+// autoCompactReason returns which threshold has been exceeded, if any.
+func (b *BoltStore) autoCompactReason(opts AutoCompact) (string, bool) {
+	if opts.FreelistBytesThreshold > 0 {
+		if b.db().Stats().FreelistInuse > opts.FreelistBytesThreshold {
+			return "freelist", true
+		}
+	}
+	if opts.FileGrowthRatio > 0 {
+		size, err := b.fileSize()
+		base := b.compactedSize.Load()
+		if err == nil && base > 0 && float64(size) > float64(base)*opts.FileGrowthRatio {
+			return "file growth", true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,99 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBoltStore_AutoCompact(t *testing.T) {
+	fh, err := ioutil.TempFile("", "bolt")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	os.Remove(fh.Name())
+	defer os.Remove(fh.Name())
+
+	// Fragment the store before turning on automatic compaction, as
+	// commits slower than the check interval would look idle and could
+	// be compacted part way through
+	store, err := New(Options{Path: fh.Name()})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	testFragmentedStore(t, store)
+	store.Close()
+	grown, err := store.fileSize()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	store, err = New(Options{
+		Path: fh.Name(),
+		AutoCompact: &AutoCompact{
+			FreelistBytesThreshold: 1024,
+			CheckInterval:          10 * time.Millisecond,
+		},
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	if err := store.Set([]byte("foo"), []byte("baz")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats, err := store.LogStats()
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if !stats.LastCompaction.IsZero() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("store was never compacted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if size, _ := store.fileSize(); size >= grown {
+		t.Fatalf("expected the file to shrink from %d bytes, got %d", grown, size)
+	}
+	checkLogs(t, store, 1901, 2000)
+
+	// Close stops the background checks
+	done := make(chan error)
+	go func() { done <- store.Close() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("close didn't return")
+	}
+}
+
+func TestBoltStore_AutoCompactReason(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	opts := AutoCompact{FreelistBytesThreshold: 1 << 30, FileGrowthRatio: 1000}
+	if reason, ok := store.autoCompactReason(opts); ok {
+		t.Fatalf("bad: %s", reason)
+	}
+
+	testFragmentedStore(t, store)
+	if reason, ok := store.autoCompactReason(AutoCompact{FreelistBytesThreshold: 1}); !ok || reason != "freelist" {
+		t.Fatalf("bad: %s %v", reason, ok)
+	}
+	if reason, ok := store.autoCompactReason(AutoCompact{FileGrowthRatio: 2}); !ok || reason != "file growth" {
+		t.Fatalf("bad: %s %v", reason, ok)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+const (
+	// autoMigrateSuffix is added to the store's path to name the file
+	// AutoMigrate copies it into.
+	autoMigrateSuffix = ".migrating"
+)
+
+var (
+	// dbOpenedKey is the key in the conf bucket marking a file that has
+	// been opened with AutoMigrate, so it can be told apart from one
+	// written by the v1 store.
+	dbOpenedKey = []byte("raftboltdb.v2")
+)
+
+// markOpened records in tx that the file doesn't need migrating.
+func markOpened(tx *bbolt.Tx) error {
+	conf := tx.Bucket(dbConf)
+	if conf.Get(dbOpenedKey) != nil {
+		return nil
+	}
+	return conf.Put(dbOpenedKey, []byte{1})
+}
+
+// needsMigration returns whether the file at path was written by the v1
+// store and has never been opened with AutoMigrate.
+func needsMigration(path string, options *Options) (bool, error) {
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if fi.Size() == 0 {
+		return false, nil
+	}
+
+	opts := options.boltOptions()
+	opts.ReadOnly = true
+	db, err := bbolt.Open(path, options.fileMode(), opts)
+	if err != nil {
+		return false, openError(path, err)
+	}
+	defer db.Close()
+
+	needed := false
+	err = db.View(func(tx *bbolt.Tx) error {
+		conf := tx.Bucket(dbConf)
+		if conf == nil || tx.Bucket(dbLogs) == nil {
+			return nil
+		}
+		needed = conf.Get(dbOpenedKey) == nil && conf.Get(dbFormatVersionKey) == nil
+		return nil
+	})
+	return needed, err
+}
+
+// autoMigrate replaces a file written by the v1 store at options.Path with
+// a copy made by MigrateToV2, see Options.AutoMigrate. The copy is made
+// next to it and renamed over it, so a crash leaves either the original
+// file or the migrated one.
+func autoMigrate(options *Options) error {
+	needed, err := needsMigration(options.Path, options)
+	if err != nil || !needed {
+		return err
+	}
+	start := time.Now()
+
+	// Anything left over from an earlier attempt is of no use
+	tmpPath := options.Path + autoMigrateSuffix
+	if err := os.Remove(tmpPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	fi, err := os.Stat(options.Path)
+	if err != nil {
+		return err
+	}
+	store, err := MigrateToV2(options.Path, tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to migrate %s from v1: %w", options.Path, err)
+	}
+	if err := store.conn.Update(markOpened); err != nil {
+		store.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := store.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, fi.Mode().Perm()); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, options.Path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := syncDir(filepath.Dir(options.Path)); err != nil {
+		return err
+	}
+	options.logger().Info("migrated store from v1", "path", options.Path, "duration", time.Since(start))
+	return nil
+}
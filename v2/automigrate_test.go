@@ -0,0 +1,62 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	v1 "github.com/hashicorp/raft-boltdb"
+)
+
+func TestBoltStore_AutoMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "raft.db")
+	srcDb, err := v1.NewBoltStore(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := srcDb.StoreLogs([]*raft.Log{testRaftLog(1, "a"), testRaftLog(2, "b")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := srcDb.SetUint64([]byte("CurrentTerm"), 3); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	srcDb.Close()
+
+	for i := 0; i < 2; i++ {
+		store, err := New(Options{Path: path, AutoMigrate: true})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		var log raft.Log
+		if err := store.GetLog(2, &log); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if string(log.Data) != "b" {
+			t.Fatalf("bad: %q", log.Data)
+		}
+		term, err := store.GetUint64([]byte("CurrentTerm"))
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if term != 3 {
+			t.Fatalf("bad: %d", term)
+		}
+		store.Close()
+	}
+	if _, err := os.Stat(path + autoMigrateSuffix); !os.IsNotExist(err) {
+		t.Fatalf("migration file left behind: %v", err)
+	}
+
+	// Files opened by this package aren't migrated again
+	needed, err := needsMigration(path, &Options{})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if needed {
+		t.Fatalf("should not need migrating")
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// Backup writes a consistent copy of the whole database to w, returning
+// the number of bytes written. The copy is taken from a read
+// transaction, so the store can be used as normal while it's written,
+// and the result can be opened like any other store file. A Compact
+// started during a backup waits for it to finish.
+func (b *BoltStore) Backup(w io.Writer) (int64, error) {
+	tx, err := b.BeginBackup()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Close()
+	return tx.WriteTo(w)
+}
+
+// BackupTx is a consistent view of the database that can be written out
+// as a backup, possibly more than once, e.g. to serve ranges of it. It
+// holds a read transaction open until it's closed, which stops the pages
+// it refers to from being reused, so it must always be closed.
+type BackupTx struct {
+	store *BoltStore
+	tx    *bbolt.Tx
+}
+
+// BeginBackup starts a backup transaction.
+func (b *BoltStore) BeginBackup() (*BackupTx, error) {
+	tx, err := b.begin(false)
+	if err != nil {
+		return nil, err
+	}
+	return &BackupTx{store: b, tx: tx}, nil
+}
+
+// Size returns the size of the backup in bytes.
+func (t *BackupTx) Size() int64 {
+	return t.tx.Size()
+}
+
+// ID identifies the contents of the backup. Two backups of the same file
+// with the same ID are identical, even if the store was reopened in
+// between.
+func (t *BackupTx) ID() string {
+	var compacted uint64
+	if conf := t.tx.Bucket(dbConf); conf != nil {
+		if v := conf.Get(dbLastCompactionKey); len(v) == 8 {
+			compacted = bytesToUint64(v)
+		}
+	}
+	// Compaction starts the transaction IDs again, so include when it
+	// happened to tell files apart
+	return fmt.Sprintf("%x-%x", compacted, t.tx.ID())
+}
+
+// WriteTo writes the backup to w, returning the number of bytes written.
+func (t *BackupTx) WriteTo(w io.Writer) (int64, error) {
+	start := time.Now()
+	n, err := t.tx.WriteTo(w)
+	if err != nil {
+		return n, err
+	}
+	t.store.metrics.measureSince([]string{"backup"}, start)
+	return n, nil
+}
+
+// Close ends the transaction.
+func (t *BackupTx) Close() error {
+	return t.tx.Rollback()
+}
+
+// BackupToFile writes a consistent copy of the database to path. The
+// copy is written to a temporary file in the same directory, synced and
+// then renamed into place, so path never holds a partial backup. Any
+// existing file at path is replaced.
+func (b *BoltStore) BackupToFile(path string) (err error) {
+	fh, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			fh.Close()
+			os.Remove(fh.Name())
+		}
+	}()
+
+	if err := fh.Chmod(dbFileMode); err != nil {
+		return err
+	}
+	if _, err := b.Backup(fh); err != nil {
+		return err
+	}
+	if err := fh.Sync(); err != nil {
+		return err
+	}
+	if err := fh.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(fh.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
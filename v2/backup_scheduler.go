@@ -0,0 +1,149 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrSchedulerStarted is returned when a BackupScheduler is started
+	// more than once.
+	ErrSchedulerStarted = errors.New("backup scheduler already started")
+)
+
+// BackupPruner is implemented by sinks that can delete old backups, which
+// BackupScheduler needs to enforce a retention count.
+type BackupPruner interface {
+	// Prune deletes all but the newest keep backups in the sink.
+	Prune(keep int) error
+}
+
+// BackupSchedulerOptions configures a BackupScheduler.
+type BackupSchedulerOptions struct {
+	// Interval is the time between backups. It must be set.
+	Interval time.Duration
+
+	// Retain is the number of backups kept in the sink, with older ones
+	// deleted after each successful backup. The sink must implement
+	// BackupPruner. If zero, backups are never deleted.
+	Retain int
+
+	// OnSuccess is called after each backup has been committed and old
+	// backups have been pruned.
+	OnSuccess func(meta *BackupMeta)
+
+	// OnFailure is called when a backup, or pruning after it, fails.
+	OnFailure func(err error)
+}
+
+// BackupScheduler takes backups of a store on an interval, see BackupTo.
+// It stops when Stop is called or the store is closed.
+type BackupScheduler struct {
+	store *BoltStore
+	sink  BackupSink
+	opts  BackupSchedulerOptions
+
+	lock    sync.Mutex
+	started bool
+	stopCh  chan struct{}
+	doneCh  chan struct{}
+}
+
+// NewBackupScheduler returns a scheduler that writes backups of store to
+// sink. It doesn't take any backups until it's started.
+func NewBackupScheduler(store *BoltStore, sink BackupSink, opts BackupSchedulerOptions) (*BackupScheduler, error) {
+	if opts.Interval <= 0 {
+		return nil, fmt.Errorf("%w: backup Interval must be positive", ErrInvalidOptions)
+	}
+	if opts.Retain < 0 {
+		return nil, fmt.Errorf("%w: backup Retain must not be negative", ErrInvalidOptions)
+	}
+	if _, ok := sink.(BackupPruner); opts.Retain > 0 && !ok {
+		return nil, fmt.Errorf("%w: backup Retain needs a sink that implements BackupPruner", ErrInvalidOptions)
+	}
+	return &BackupScheduler{
+		store:  store,
+		sink:   sink,
+		opts:   opts,
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}, nil
+}
+
+// Start begins taking backups, the first one after Interval.
+func (s *BackupScheduler) Start() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.started {
+		return ErrSchedulerStarted
+	}
+	if !s.store.background(s.run) {
+		return ErrClosed
+	}
+	s.started = true
+	return nil
+}
+
+// Stop stops taking backups, waiting for one that's in progress to
+// finish. It's safe to call more than once.
+func (s *BackupScheduler) Stop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.started {
+		return
+	}
+	select {
+	case <-s.stopCh:
+	default:
+		close(s.stopCh)
+	}
+	<-s.doneCh
+}
+
+// run takes backups until the scheduler is stopped or the store is
+// closed.
+func (s *BackupScheduler) run() {
+	defer close(s.doneCh)
+	ticker := time.NewTicker(s.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.stopCh:
+			return
+		case <-s.store.closeCh:
+			return
+		}
+		s.backup()
+	}
+}
+
+// backup takes a single backup and prunes old ones, reporting the
+// result.
+func (s *BackupScheduler) backup() {
+	meta, err := s.store.BackupTo(s.sink)
+	if err == nil && s.opts.Retain > 0 {
+		if err = s.sink.(BackupPruner).Prune(s.opts.Retain); err != nil {
+			err = fmt.Errorf("failed pruning backups: %w", err)
+		}
+	}
+
+	if err != nil {
+		s.store.metrics.incrCounter([]string{"backupScheduler", "failure"}, 1)
+		s.store.logger.Error("scheduled backup failed", "path", s.store.path, "error", err)
+		if s.opts.OnFailure != nil {
+			s.opts.OnFailure(err)
+		}
+		return
+	}
+	s.store.metrics.incrCounter([]string{"backupScheduler", "success"}, 1)
+	s.store.logger.Debug("scheduled backup complete", "path", s.store.path, "id", meta.ID, "size", meta.Size)
+	if s.opts.OnSuccess != nil {
+		s.opts.OnSuccess(meta)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/armon/go-metrics"
+)
+
+func TestBackupScheduler(t *testing.T) {
+	sink := metrics.NewInmemSink(time.Minute, time.Minute)
+	store, err := New(Options{
+		Path:       filepath.Join(t.TempDir(), "raft.db"),
+		MetricSink: sink,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	if err := store.StoreLog(testRaftLog(1, "data")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	backups, err := NewFileBackupSink(t.TempDir())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	done := make(chan *BackupMeta, 10)
+	scheduler, err := NewBackupScheduler(store, backups, BackupSchedulerOptions{
+		Interval:  5 * time.Millisecond,
+		Retain:    2,
+		OnSuccess: func(meta *BackupMeta) { done <- meta },
+		OnFailure: func(err error) { t.Errorf("err: %s", err) },
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := scheduler.Start(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := scheduler.Start(); err != ErrSchedulerStarted {
+		t.Fatalf("expected started error, got: %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for backup %d", i)
+		}
+	}
+	scheduler.Stop()
+	scheduler.Stop()
+
+	entries, err := os.ReadDir(backups.Dir())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 backups to be retained, got: %v", entries)
+	}
+	if c := sink.Data()[0].Counters["raft.boltdb.backupScheduler.success"]; c.Count < 4 {
+		t.Fatalf("bad: %#v", c)
+	}
+}
+
+func TestBackupScheduler_Failure(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	failed := make(chan error, 10)
+	scheduler, err := NewBackupScheduler(store, &failingSink{}, BackupSchedulerOptions{
+		Interval:  5 * time.Millisecond,
+		OnFailure: func(err error) { failed <- err },
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := scheduler.Start(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	select {
+	case <-failed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for a failure")
+	}
+
+	// Closing the store stops the scheduler
+	store.Close()
+	scheduler.Stop()
+
+	// Retention needs a sink that can prune
+	_, err = NewBackupScheduler(store, &failingSink{}, BackupSchedulerOptions{Interval: time.Minute, Retain: 1})
+	if !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("expected invalid options error, got: %v", err)
+	}
+	_, err = NewBackupScheduler(store, &failingSink{}, BackupSchedulerOptions{})
+	if !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("expected invalid options error, got: %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BackupMeta describes a backup being written to a BackupSink.
+type BackupMeta struct {
+	// ID identifies the contents of the backup, see BackupTx.ID.
+	ID string
+
+	// Size is the size of the backup in bytes.
+	Size int64
+
+	// Time is when the backup was started.
+	Time time.Time
+}
+
+// BackupSink is somewhere backups can be written, such as a directory or
+// an object store. Implementations outside this package can be passed to
+// BackupTo to archive backups without reimplementing the streaming.
+type BackupSink interface {
+	// Open starts writing a new backup.
+	Open(meta BackupMeta) (BackupWriter, error)
+}
+
+// BackupWriter receives a single backup. Exactly one of Commit or Abort
+// is called once the backup has been written or has failed, and a backup
+// must not become visible in the sink until it's committed.
+type BackupWriter interface {
+	io.Writer
+
+	// Commit makes the backup durable and visible in the sink.
+	Commit() error
+
+	// Abort discards anything written so far.
+	Abort() error
+}
+
+// BackupTo writes a consistent copy of the database to sink, returning a
+// description of the backup once it has been committed. The backup is
+// aborted if it can't be written in full.
+func (b *BoltStore) BackupTo(sink BackupSink) (*BackupMeta, error) {
+	tx, err := b.BeginBackup()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Close()
+
+	meta := &BackupMeta{ID: tx.ID(), Size: tx.Size(), Time: time.Now()}
+	w, err := sink.Open(*meta)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening backup: %w", err)
+	}
+	if _, err := tx.WriteTo(w); err != nil {
+		w.Abort()
+		return nil, err
+	}
+	if err := w.Commit(); err != nil {
+		return nil, fmt.Errorf("failed committing backup: %w", err)
+	}
+	return meta, nil
+}
+
+// FileBackupSink is a BackupSink that writes each backup to its own file
+// in a directory. Backups are written to a temporary file, synced and
+// renamed into place when they're committed.
+type FileBackupSink struct {
+	dir string
+}
+
+// NewFileBackupSink returns a sink that writes backups to dir, creating
+// it if needed.
+func NewFileBackupSink(dir string) (*FileBackupSink, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileBackupSink{dir: dir}, nil
+}
+
+// Dir returns the directory backups are written to.
+func (s *FileBackupSink) Dir() string {
+	return s.dir
+}
+
+// Open implements BackupSink. Backups are named after the time they were
+// started, so they sort in the order they were taken.
+func (s *FileBackupSink) Open(meta BackupMeta) (BackupWriter, error) {
+	name := fmt.Sprintf("raft-%s-%s.db", meta.Time.UTC().Format("20060102T150405.000Z"), meta.ID)
+	fh, err := os.CreateTemp(s.dir, name+".tmp*")
+	if err != nil {
+		return nil, err
+	}
+	if err := fh.Chmod(dbFileMode); err != nil {
+		fh.Close()
+		os.Remove(fh.Name())
+		return nil, err
+	}
+	return &fileBackupWriter{File: fh, path: filepath.Join(s.dir, name)}, nil
+}
+
+// Prune implements BackupPruner, deleting the oldest committed backups
+// in the directory. Other files are left alone.
+func (s *FileBackupSink) Prune(keep int) error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, "raft-") && strings.HasSuffix(name, ".db") {
+			names = append(names, name)
+		}
+	}
+	if len(names) <= keep {
+		return nil
+	}
+	sort.Strings(names)
+	for _, name := range names[:len(names)-keep] {
+		if err := os.Remove(filepath.Join(s.dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileBackupWriter writes a backup for a FileBackupSink.
+type fileBackupWriter struct {
+	*os.File
+	path string
+}
+
+// Commit implements BackupWriter.
+func (w *fileBackupWriter) Commit() error {
+	if err := w.Sync(); err != nil {
+		w.Abort()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		os.Remove(w.Name())
+		return err
+	}
+	if err := os.Rename(w.Name(), w.path); err != nil {
+		os.Remove(w.Name())
+		return err
+	}
+	return syncDir(filepath.Dir(w.path))
+}
+
+// Abort implements BackupWriter.
+func (w *fileBackupWriter) Abort() error {
+	w.Close()
+	return os.Remove(w.Name())
+}
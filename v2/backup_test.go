@@ -0,0 +1,196 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestBoltStore_Backup(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	var logs []*raft.Log
+	for i := uint64(1); i <= 100; i++ {
+		logs = append(logs, testRaftLog(i, "data"))
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Set([]byte("foo"), []byte("bar")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var buf bytes.Buffer
+	n, err := store.Backup(&buf)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if n != int64(buf.Len()) {
+		t.Fatalf("bad: %d, expected %d", n, buf.Len())
+	}
+
+	// The store carries on as normal, and the backup doesn't see the
+	// later writes
+	if err := store.StoreLog(testRaftLog(101, "data")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	dir, err := ioutil.TempDir("", "bolt")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "backup.db")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	backup, err := NewReadOnlyStore(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer backup.Close()
+	checkIndexes(t, backup, 1, 100)
+	if val, err := backup.Get([]byte("foo")); err != nil || string(val) != "bar" {
+		t.Fatalf("bad: %q %v", val, err)
+	}
+}
+
+func TestBoltStore_BackupToFile(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	if err := store.StoreLog(testRaftLog(1, "data")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	dir, err := ioutil.TempDir("", "bolt")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "backup.db")
+
+	// An existing file is replaced
+	if err := ioutil.WriteFile(path, []byte("old"), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.BackupToFile(path); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected only the backup, got: %v", entries)
+	}
+
+	backup, err := New(Options{Path: path, CheckOnOpen: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer backup.Close()
+	checkIndexes(t, backup, 1, 1)
+
+	// Nothing is left behind if the backup fails
+	store.Close()
+	if err := store.BackupToFile(filepath.Join(dir, "other.db")); err != ErrClosed {
+		t.Fatalf("expected closed error, got: %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("expected only the backup, got: %v", entries)
+	}
+}
+
+// failingSink is a BackupSink whose writers fail after the first write.
+type failingSink struct {
+	aborted bool
+}
+
+func (s *failingSink) Open(BackupMeta) (BackupWriter, error) {
+	return &failingWriter{sink: s}, nil
+}
+
+type failingWriter struct {
+	sink   *failingSink
+	writes int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.writes++; w.writes > 1 {
+		return 0, errors.New("sink failed")
+	}
+	return len(p), nil
+}
+
+func (w *failingWriter) Commit() error {
+	return errors.New("unexpected commit")
+}
+
+func (w *failingWriter) Abort() error {
+	w.sink.aborted = true
+	return nil
+}
+
+func TestBoltStore_BackupTo(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+	for i := uint64(1); i <= 10; i++ {
+		if err := store.StoreLog(testRaftLog(i, "data")); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	dir := t.TempDir()
+	sink, err := NewFileBackupSink(filepath.Join(dir, "backups"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	meta, err := store.BackupTo(sink)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	entries, err := os.ReadDir(sink.Dir())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(entries) != 1 || !strings.HasSuffix(entries[0].Name(), meta.ID+".db") {
+		t.Fatalf("bad: %v", entries)
+	}
+	fi, err := entries[0].Info()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if fi.Size() != meta.Size {
+		t.Fatalf("bad: %d, expected %d", fi.Size(), meta.Size)
+	}
+	backup, err := NewReadOnlyStore(filepath.Join(sink.Dir(), entries[0].Name()))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer backup.Close()
+	checkLogs(t, backup, 1, 10)
+
+	// A backup that can't be written is aborted
+	failing := &failingSink{}
+	if _, err := store.BackupTo(failing); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if !failing.aborted {
+		t.Fatalf("expected the backup to be aborted")
+	}
+}
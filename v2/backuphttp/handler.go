@@ -0,0 +1,219 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package backuphttp serves consistent backups of a raftboltdb.BoltStore
+// over HTTP, so agents can expose them on an admin port:
+//
+//	mux.Handle("/debug/raft-db", backuphttp.New(store, backuphttp.Options{}))
+//
+// Each request is served from its own read transaction. Responses carry
+// the backup's size and an ETag, and single byte ranges are supported so
+// an interrupted download can be resumed with If-Range. A range request
+// for a backup that has changed since the ETag was issued gets the whole
+// of the new backup instead.
+package backuphttp
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+)
+
+// errRangeDone stops writing a backup once the requested range has been
+// sent.
+var errRangeDone = errors.New("range done")
+
+// Options configures a Handler.
+type Options struct {
+	// Filename is suggested to clients in the Content-Disposition
+	// header. Defaults to "raft.db".
+	Filename string
+
+	// Gzip compresses responses for clients that accept it. Compressed
+	// responses have no Content-Length, and range requests are always
+	// served uncompressed.
+	Gzip bool
+}
+
+// Handler implements http.Handler for a BoltStore.
+type Handler struct {
+	store *raftboltdb.BoltStore
+	opts  Options
+}
+
+// New returns a Handler that serves backups of store.
+func New(store *raftboltdb.BoltStore, opts Options) *Handler {
+	if opts.Filename == "" {
+		opts.Filename = "raft.db"
+	}
+	return &Handler{store: store, opts: opts}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tx, err := h.store.BeginBackup()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer tx.Close()
+
+	size := tx.Size()
+	etag := strconv.Quote(tx.ID())
+	header := w.Header()
+	header.Set("Content-Type", "application/octet-stream")
+	header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", h.opts.Filename))
+	header.Set("Accept-Ranges", "bytes")
+	header.Set("ETag", etag)
+
+	// A range is only honoured if it's for the backup the client already
+	// has part of
+	rangeHeader := r.Header.Get("Range")
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && ifRange != etag {
+		rangeHeader = ""
+	}
+	if rangeHeader != "" {
+		start, length, ok := parseRange(rangeHeader, size)
+		if !ok {
+			header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			http.Error(w, "invalid range", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if length >= 0 {
+			header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, size))
+			header.Set("Content-Length", strconv.FormatInt(length, 10))
+			w.WriteHeader(http.StatusPartialContent)
+			if r.Method == http.MethodGet {
+				// Bbolt doesn't always return the writer's error as is, so
+				// check whether the range was finished instead
+				rw := &rangeWriter{w: w, skip: start, remaining: length}
+				if _, err := tx.WriteTo(rw); err != nil && rw.remaining > 0 {
+					panic(http.ErrAbortHandler)
+				}
+			}
+			return
+		}
+	}
+
+	if h.opts.Gzip {
+		header.Add("Vary", "Accept-Encoding")
+	}
+	if h.opts.Gzip && acceptsGzip(r) {
+		header.Set("Content-Encoding", "gzip")
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodHead {
+			return
+		}
+		gz := gzip.NewWriter(w)
+		if _, err := tx.WriteTo(gz); err != nil {
+			panic(http.ErrAbortHandler)
+		}
+		gz.Close()
+		return
+	}
+
+	header.Set("Content-Length", strconv.FormatInt(size, 10))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		if _, err := tx.WriteTo(w); err != nil {
+			// The status has already been sent, so all we can do is
+			// make sure the client doesn't mistake this for a backup
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// parseRange parses a Range header for a backup of size bytes. It returns
+// a length of -1 if the header should be ignored, which is the case for
+// multiple ranges, and false if the range can't be satisfied.
+func parseRange(s string, size int64) (start, length int64, ok bool) {
+	spec, found := strings.CutPrefix(s, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, -1, true
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false
+	}
+
+	if first == "" {
+		// A suffix of the backup
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end - start + 1, true
+}
+
+// acceptsGzip returns true if the request allows a gzipped response.
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(enc), ";")
+		if strings.TrimSpace(name) == "gzip" && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
+
+// rangeWriter passes on remaining bytes after skipping the first skip,
+// and then stops the backup with errRangeDone.
+type rangeWriter struct {
+	w         io.Writer
+	skip      int64
+	remaining int64
+}
+
+func (rw *rangeWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if rw.skip >= int64(len(p)) {
+		rw.skip -= int64(len(p))
+		return n, nil
+	}
+	p = p[rw.skip:]
+	rw.skip = 0
+
+	if int64(len(p)) > rw.remaining {
+		p = p[:rw.remaining]
+	}
+	if _, err := rw.w.Write(p); err != nil {
+		return 0, err
+	}
+	rw.remaining -= int64(len(p))
+	if rw.remaining == 0 {
+		return n, errRangeDone
+	}
+	return n, nil
+}
@@ -0,0 +1,172 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package backuphttp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+)
+
+func testStore(t *testing.T) *raftboltdb.BoltStore {
+	fh, err := ioutil.TempFile("", "bolt")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	os.Remove(fh.Name())
+	t.Cleanup(func() { os.Remove(fh.Name()) })
+
+	store, err := raftboltdb.NewBoltStore(fh.Name())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	var logs []*raft.Log
+	for i := uint64(1); i <= 100; i++ {
+		logs = append(logs, &raft.Log{Index: i, Term: 1, Data: bytes.Repeat([]byte("x"), 100)})
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return store
+}
+
+func get(t *testing.T, h http.Handler, method string, headers map[string]string) *http.Response {
+	t.Helper()
+	req := httptest.NewRequest(method, "/debug/raft-db", nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Result()
+}
+
+func TestHandler(t *testing.T) {
+	store := testStore(t)
+	var expected bytes.Buffer
+	if _, err := store.Backup(&expected); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	h := New(store, Options{})
+
+	resp := get(t, h, http.MethodGet, nil)
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(body, expected.Bytes()) {
+		t.Fatalf("bad: %d with %d bytes", resp.StatusCode, len(body))
+	}
+	if resp.ContentLength != int64(expected.Len()) {
+		t.Fatalf("bad: %d", resp.ContentLength)
+	}
+	if cd := resp.Header.Get("Content-Disposition"); cd != `attachment; filename="raft.db"` {
+		t.Fatalf("bad: %s", cd)
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatalf("expected an ETag")
+	}
+
+	resp = get(t, h, http.MethodHead, nil)
+	body, _ = io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || len(body) != 0 || resp.ContentLength != int64(expected.Len()) {
+		t.Fatalf("bad: %d with %d bytes", resp.StatusCode, len(body))
+	}
+
+	resp = get(t, h, http.MethodPost, nil)
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("bad: %d", resp.StatusCode)
+	}
+}
+
+func TestHandler_Range(t *testing.T) {
+	store := testStore(t)
+	var expected bytes.Buffer
+	if _, err := store.Backup(&expected); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	size := expected.Len()
+	h := New(store, Options{})
+	etag := get(t, h, http.MethodHead, nil).Header.Get("ETag")
+
+	cases := []struct {
+		header     string
+		start, end int
+	}{
+		{"bytes=0-99", 0, 100},
+		{"bytes=5000-", 5000, size},
+		{"bytes=-10", size - 10, size},
+		{"bytes=100-99999999", 100, size},
+	}
+	for _, c := range cases {
+		resp := get(t, h, http.MethodGet, map[string]string{"Range": c.header, "If-Range": etag})
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusPartialContent {
+			t.Fatalf("bad: %d for %s", resp.StatusCode, c.header)
+		}
+		if !bytes.Equal(body, expected.Bytes()[c.start:c.end]) {
+			t.Fatalf("bad body for %s: %d bytes", c.header, len(body))
+		}
+	}
+
+	resp := get(t, h, http.MethodGet, map[string]string{"Range": "bytes=99999999-"})
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("bad: %d", resp.StatusCode)
+	}
+
+	// Once the store has changed a resumed download starts again
+	if err := store.StoreLog(&raft.Log{Index: 101, Term: 1}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	resp = get(t, h, http.MethodGet, map[string]string{"Range": "bytes=0-99", "If-Range": etag})
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") == etag {
+		t.Fatalf("bad: %d", resp.StatusCode)
+	}
+}
+
+func TestHandler_Gzip(t *testing.T) {
+	store := testStore(t)
+	var expected bytes.Buffer
+	if _, err := store.Backup(&expected); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Compression must be enabled and accepted
+	resp := get(t, New(store, Options{}), http.MethodGet, map[string]string{"Accept-Encoding": "gzip"})
+	if resp.Header.Get("Content-Encoding") != "" {
+		t.Fatalf("expected an uncompressed response")
+	}
+	h := New(store, Options{Gzip: true, Filename: "backup.db"})
+	resp = get(t, h, http.MethodGet, map[string]string{"Accept-Encoding": "br;q=1.0, gzip;q=0"})
+	if resp.Header.Get("Content-Encoding") != "" {
+		t.Fatalf("expected an uncompressed response")
+	}
+
+	resp = get(t, h, http.MethodGet, map[string]string{"Accept-Encoding": "deflate, gzip"})
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected a compressed response")
+	}
+	if cd := resp.Header.Get("Content-Disposition"); cd != `attachment; filename="backup.db"` {
+		t.Fatalf("bad: %s", cd)
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	body, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !bytes.Equal(body, expected.Bytes()) {
+		t.Fatalf("bad: %d bytes", len(body))
+	}
+}
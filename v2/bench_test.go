@@ -4,9 +4,12 @@
 package raftboltdb
 
 import (
+	"bytes"
+	"fmt"
 	"os"
 	"testing"
 
+	"github.com/hashicorp/raft"
 	"github.com/hashicorp/raft/bench"
 )
 
@@ -89,3 +92,104 @@ func BenchmarkBoltStore_GetUint64(b *testing.B) {
 
 	raftbench.GetUint64(b, store)
 }
+
+func BenchmarkEncodeMsgPack(b *testing.B) {
+	logs := benchLogBatch()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for _, log := range logs {
+			if _, err := encodeMsgPack(log, false); err != nil {
+				b.Fatalf("err: %s", err)
+			}
+			uint64ToBytes(log.Index)
+		}
+	}
+}
+
+func BenchmarkLogEncoder(b *testing.B) {
+	logs := benchLogBatch()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		enc := getLogEncoder(false)
+		enc.reset(len(logs))
+		for _, log := range logs {
+			if _, err := enc.encode(log); err != nil {
+				b.Fatalf("err: %s", err)
+			}
+			enc.key(log.Index)
+		}
+		enc.release()
+	}
+}
+
+func BenchmarkLogEncoder_Zstd(b *testing.B) {
+	logs := make([]*raft.Log, 256)
+	for i := range logs {
+		data := bytes.Repeat([]byte(fmt.Sprintf(`{"key":"service/%d","value":"node-%d"}`, i, i)), 512)
+		logs[i] = &raft.Log{Index: uint64(i + 1), Term: 1, Type: raft.LogCommand, Data: data}
+	}
+	run := func(b *testing.B, parallel bool) {
+		b.SetBytes(int64(dataSize(logs)))
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			enc := getLogEncoder(false)
+			enc.compressor = zstdCompressor
+			enc.compressThreshold = defaultCompressionThreshold
+			enc.reset(len(logs))
+			if parallel {
+				if _, err := enc.encodeParallel(logs, func(*raft.Log) bool { return false }); err != nil {
+					b.Fatalf("err: %s", err)
+				}
+			} else {
+				for _, log := range logs {
+					if _, err := enc.encode(log); err != nil {
+						b.Fatalf("err: %s", err)
+					}
+				}
+			}
+			enc.release()
+		}
+	}
+
+	// On a machine with several cores parallel should be faster by about
+	// as many times, as each worker gets an encoder of its own
+	b.Run("serial", func(b *testing.B) { run(b, false) })
+	b.Run("parallel", func(b *testing.B) { run(b, true) })
+}
+
+func benchLogBatch() []*raft.Log {
+	logs := make([]*raft.Log, 64)
+	for i := range logs {
+		logs[i] = &raft.Log{
+			Index: uint64(i + 1),
+			Term:  1,
+			Type:  raft.LogCommand,
+			Data:  make([]byte, 256),
+		}
+	}
+	return logs
+}
+
+func BenchmarkDecodeMsgPack(b *testing.B) {
+	logs := benchLogBatch()
+	var vals [][]byte
+	for _, log := range logs {
+		val, err := encodeMsgPack(log, false)
+		if err != nil {
+			b.Fatalf("err: %s", err)
+		}
+		vals = append(vals, val.Bytes())
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for _, val := range vals {
+			var log raft.Log
+			if err := decodeMsgPack(val, &log); err != nil {
+				b.Fatalf("err: %s", err)
+			}
+		}
+	}
+}
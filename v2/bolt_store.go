@@ -4,21 +4,34 @@
 package raftboltdb
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/armon/go-metrics"
-	v1 "github.com/boltdb/bolt"
+	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/raft"
 	"go.etcd.io/bbolt"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	// Permissions to use on the db file. This is only used if the
-	// database file does not exist and needs to be created.
+	// database file does not exist and needs to be created and
+	// Options.FileMode is not set.
 	dbFileMode = 0600
+
+	// Permissions to use on any parent directories created because
+	// of Options.CreateDir when Options.DirMode is not set.
+	dbDirMode = 0700
+
+	// The fill percent to use for the logs bucket when
+	// Options.LogsFillPercent is not set.
+	defaultLogsFillPercent = 0.95
 )
 
 var (
@@ -28,47 +41,229 @@ var (
 
 	// An error indicating a given key does not exist
 	ErrKeyNotFound = errors.New("not found")
+
+	// ErrInvalidValue is returned by GetUint64 for a value that isn't 8
+	// bytes long, so can't have been written by SetUint64.
+	ErrInvalidValue = errors.New("invalid value")
+
+	// ErrReadOnly is returned by any method that would modify a store
+	// that was opened read-only.
+	ErrReadOnly = errors.New("store is read-only")
+
+	// ErrClosed is returned by any method called after Close.
+	ErrClosed = errors.New("store is closed")
+
+	// ErrBucketMissing is returned when one of the buckets the store
+	// relies on doesn't exist, e.g. because the file was created by
+	// another tool. The returned error names the bucket and file.
+	ErrBucketMissing = errors.New("bucket missing")
+
+	// ErrLogCorrupt is returned when a stored log entry can't be decoded.
+	// The returned error includes the index of the entry.
+	ErrLogCorrupt = errors.New("log entry is corrupt")
+
+	// ErrReservedKey is returned when the stable store is asked to write
+	// or delete one of the store's internal keys, which start with
+	// "raftboltdb.".
+	ErrReservedKey = errors.New("key is reserved")
+
+	// ErrDiskFull is returned by writes that failed because the filesystem
+	// is out of space, or that weren't tried because it has less than
+	// Options.MinFreeBytes free. The returned error names the file.
+	ErrDiskFull = errors.New("disk full")
 )
 
 // BoltStore provides access to Bbolt for Raft to store and retrieve
 // log entries. It also provides key/value storage, and can be used as
 // a LogStore and StableStore.
 type BoltStore struct {
-	// conn is the underlying handle to the db.
-	conn *bbolt.DB
+	// conn is the underlying handle to the db. It's replaced by Compact,
+	// so it must only be used with connLock held, and transactions must
+	// be started with it held so Compact can wait for them.
+	conn     *bbolt.DB
+	connLock sync.RWMutex
 
 	// The path to the Bolt database file
 	path string
 
-	msgpackUseNewTimeFormat bool
-}
+	// stable is the file the stable store's keys are kept in, if
+	// Options.StablePath is set. It's nil if they're kept in conn.
+	stable *bbolt.DB
 
-// Options contains all the configuration used to open the Bbolt
-type Options struct {
-	// Path is the file path to the Bbolt to use
-	Path string
+	// boltOptions are the options conn was opened with, which are used
+	// again to reopen the file after it's compacted.
+	boltOptions *bbolt.Options
 
-	// BoltOptions contains any specific Bbolt options you might
-	// want to specify [e.g. open timeout]
-	BoltOptions *bbolt.Options
+	// readOnly is set if the store was opened read-only, in which case
+	// all mutating methods return ErrReadOnly.
+	readOnly bool
 
-	// NoSync causes the database to skip fsync calls after each
-	// write to the log. This is unsafe, so it should be used
-	// with caution.
-	NoSync bool
+	// metrics emits the store's metrics under the configured prefix.
+	metrics storeMetrics
 
-	// MsgpackUseNewTimeFormat when set to true, force the underlying msgpack
-	// codec to use the new format of time.Time when encoding (used in
-	// go-msgpack v1.1.5 by default). Decoding is not affected, as all
-	// go-msgpack v2.1.0+ decoders know how to decode both formats.
-	MsgpackUseNewTimeFormat bool
-}
+	// tracer creates spans around store operations, or is nil if
+	// tracing is disabled.
+	tracer trace.Tracer
+
+	// logger receives warnings about transactions that take longer
+	// than slowOpThreshold.
+	logger          hclog.Logger
+	slowOpThreshold time.Duration
+
+	// hooks are the embedder's callbacks from Options.Hooks.
+	hooks Hooks
+
+	// indexes caches the first and last index of the log. indexSeq
+	// numbers the write transactions that change the log, and is
+	// guarded by Bbolt's writer lock.
+	indexes  atomic.Pointer[indexCache]
+	indexSeq uint64
+
+	// cache holds recently read, and optionally written, entries and
+	// readAhead is how many entries GetLog prefetches into it once it
+	// sees sequential reads. cache is nil if neither CacheSize nor
+	// ReadAhead is set.
+	cache     *logCache
+	readAhead int
+
+	// syncPolicy is the policy from the options.
+	syncPolicy SyncPolicy
+
+	// batchWrites makes StoreLogs use Bbolt's Batch rather than a
+	// transaction of its own. It's set by Options.BatchWrites and by
+	// SyncInterval.
+	batchWrites bool
+
+	// logsFillPercent is set on the logs bucket in every transaction
+	// that writes to it.
+	logsFillPercent float64
+
+	// segmentSize is the number of indexes in each segment of the logs
+	// bucket, or zero if it's in the flat format. It's read from the
+	// file when the store is opened.
+	segmentSize uint64
+
+	// trimChunkSize and trimPause control how TrimPrefixAsync deletes
+	// entries, and trimLock makes sure only one trim runs at a time.
+	trimChunkSize int
+	trimPause     time.Duration
+	trimLock      sync.Mutex
+
+	// archiveFunc receives entries before they're deleted.
+	archiveFunc ArchiveFunc
+
+	// monotonic is returned by IsMonotonic.
+	monotonic bool
+
+	// strictAppend makes StoreLogs reject batches that would leave a gap
+	// in the log or go back a term.
+	strictAppend bool
 
-// readOnly returns true if the contained bolt options say to open
-// the DB in readOnly mode [this can be useful to tools that want
-// to examine the log]
-func (o *Options) readOnly() bool {
-	return o != nil && o.BoltOptions != nil && o.BoltOptions.ReadOnly
+	// rejectConflicts makes StoreLogs refuse to replace an entry with a
+	// different one from the same term.
+	rejectConflicts bool
+
+	// termIndex keeps the term index up to date, see Options.TermIndex.
+	termIndex bool
+
+	// wrapper unwraps the data keys of an encrypted store, which are
+	// held in keys, see Options.Wrapper. keys is nil if the store isn't
+	// encrypted. Both are replaced by Rewrap, which holds rewrapLock.
+	wrapper    Wrapper
+	keys       atomic.Pointer[keyring]
+	rewrapLock sync.Mutex
+
+	// autoMigrate marks the file as not needing migration from v1, see
+	// Options.AutoMigrate.
+	autoMigrate bool
+
+	// crypto supplies the AES-GCM implementation, see
+	// Options.CryptoProvider.
+	crypto CryptoProvider
+
+	// encryptConfOnly leaves log entries unencrypted when they're
+	// written, see Options.EncryptConfOnly.
+	encryptConfOnly bool
+
+	// checksums wraps each log entry written with a checksum, see
+	// Options.Checksums.
+	checksums bool
+
+	// compressor compresses log entries of at least compressThreshold
+	// bytes, see Options.Compression. It's nil if they aren't compressed.
+	compressor        *compressor
+	compressThreshold int
+
+	// overflowThreshold is the size of the smallest data stored in the
+	// overflow bucket, in chunks of overflowChunkSize bytes, or zero if
+	// none is, see Options.OverflowThreshold.
+	overflowThreshold int
+	overflowChunkSize int
+
+	// parallelEncodeBytes is Options.ParallelEncodeBytes.
+	parallelEncodeBytes int
+
+	// preGrownSize is the size the file was pre-grown to by
+	// Options.InitialSizeBytes or Grow, while Bbolt's AllocSize is raised
+	// to match, see raiseAllocSize. It's zero once AllocSize is restored.
+	preGrownSize atomic.Int64
+
+	// lastFileSize is the size of the file when metrics were last
+	// emitted or it was last grown, for the fileGrowth counter.
+	lastFileSize atomic.Int64
+
+	// writes counts the write transactions committed, so background
+	// maintenance can tell when the store is idle.
+	writes atomic.Uint64
+
+	// compactedSize is the size of the file when it was opened or last
+	// compacted, which AutoCompact.FileGrowthRatio is relative to.
+	compactedSize atomic.Int64
+
+	// minFreeBytes is Options.MinFreeBytes, set once the store is open.
+	minFreeBytes uint64
+
+	// maxSizeBytes is Options.MaxSizeBytes, the quota in bytes on the
+	// data in the file that log appends are checked against. Zero means
+	// there's no quota.
+	maxSizeBytes int64
+
+	// quotaFreed is how many bytes appends past the quota can still reuse,
+	// see checkQuota.
+	quotaFreed atomic.Int64
+
+	// health tracks commit latencies for Health, or is nil if
+	// Options.HealthPolicy isn't set.
+	health *commitTracker
+
+	// pinger holds the Ping in progress, so concurrent callers share it
+	// rather than piling writes up behind a hung disk.
+	pinger pinger
+
+	// limiter paces bulk writes, or is nil if Options.WriteLimiter isn't
+	// set.
+	limiter *writeLimiter
+
+	// reads are the open read transactions that can be kept open for a
+	// long time, see ReadTxWatchdog.
+	readsLock sync.Mutex
+	reads     map[*trackedRead]struct{}
+
+	// closed is set once Close has been called, guarded by closeOnce.
+	// closeCh is closed at the same time to stop background work,
+	// which is started with bgLock held and tracked by bg.
+	closed    atomic.Bool
+	closeOnce sync.Once
+	closeErr  error
+	closeCh   chan struct{}
+	bgLock    sync.Mutex
+	bg        sync.WaitGroup
+
+	// faults injects failures into commits in builds with the
+	// faultinject tag, and does nothing otherwise.
+	faults faults
+
+	msgpackUseNewTimeFormat bool
 }
 
 // NewBoltStore takes a file path and returns a connected Raft backend.
@@ -76,41 +271,233 @@ func NewBoltStore(path string) (*BoltStore, error) {
 	return New(Options{Path: path})
 }
 
+// NewReadOnlyStore opens an existing database at the given path for
+// inspection. The file is opened with a shared lock and every method
+// that would modify it returns ErrReadOnly.
+func NewReadOnlyStore(path string) (*BoltStore, error) {
+	return New(Options{Path: path, ReadOnly: true})
+}
+
 // New uses the supplied options to open the Bbolt and prepare it for use as a raft backend.
 func New(options Options) (*BoltStore, error) {
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+
+	// Bbolt always opens with O_CREATE, so make sure a read-only
+	// open doesn't leave an empty file behind
+	if options.readOnly() {
+		if _, err := os.Stat(options.Path); err != nil {
+			return nil, err
+		}
+	} else if options.CreateDir {
+		if err := os.MkdirAll(filepath.Dir(options.Path), options.dirMode()); err != nil {
+			return nil, err
+		}
+	}
+
+	if options.AutoMigrate && !options.readOnly() {
+		if err := autoMigrate(&options); err != nil {
+			return nil, err
+		}
+	}
+
 	// Try to connect
-	handle, err := bbolt.Open(options.Path, dbFileMode, options.BoltOptions)
+	boltOptions := options.boltOptions()
+	handle, err := bbolt.Open(options.Path, options.fileMode(), boltOptions)
 	if err != nil {
-		return nil, err
+		return nil, openError(options.Path, err)
+	}
+	var grownFrom int64
+	if options.InitialSizeBytes > 0 {
+		if grownFrom, err = preGrow(handle, options.InitialSizeBytes); err != nil {
+			handle.Close()
+			return nil, err
+		}
+	}
+	handle.NoSync = options.NoSync || options.SyncPolicy.noSync()
+	if options.MaxBatchSize != 0 {
+		handle.MaxBatchSize = options.MaxBatchSize
+	}
+	if options.MaxBatchDelay != 0 {
+		handle.MaxBatchDelay = options.MaxBatchDelay
+	}
+	if options.SyncPolicy.group {
+		handle.MaxBatchDelay = options.SyncPolicy.interval
 	}
-	handle.NoSync = options.NoSync
 
 	// Create the new store
 	store := &BoltStore{
 		conn:                    handle,
 		path:                    options.Path,
+		boltOptions:             boltOptions,
+		readOnly:                options.readOnly(),
+		metrics:                 storeMetrics{prefix: options.MetricsPrefix, sink: options.MetricSink},
+		logger:                  options.logger(),
+		slowOpThreshold:         options.slowOpThreshold(),
+		hooks:                   options.Hooks,
+		readAhead:               options.ReadAhead,
+		syncPolicy:              options.SyncPolicy,
+		batchWrites:             options.BatchWrites || options.SyncPolicy.group,
+		logsFillPercent:         options.logsFillPercent(),
+		trimChunkSize:           options.trimChunkSize(),
+		trimPause:               options.trimPause(),
+		archiveFunc:             options.ArchiveFunc,
+		monotonic:               options.Monotonic,
+		strictAppend:            options.StrictAppend,
+		rejectConflicts:         options.RejectConflictingOverwrites,
+		termIndex:               options.TermIndex,
+		checksums:               options.Checksums,
+		wrapper:                 options.Wrapper,
+		crypto:                  options.cryptoProvider(),
+		autoMigrate:             options.AutoMigrate,
+		encryptConfOnly:         options.EncryptConfOnly,
+		compressor:              options.Compression.compressor(),
+		compressThreshold:       options.compressionThreshold(),
+		overflowThreshold:       options.OverflowThreshold,
+		overflowChunkSize:       handle.Info().PageSize - overflowPageOverhead,
+		maxSizeBytes:            options.MaxSizeBytes,
+		parallelEncodeBytes:     options.ParallelEncodeBytes,
+		reads:                   make(map[*trackedRead]struct{}),
+		closeCh:                 make(chan struct{}),
 		msgpackUseNewTimeFormat: options.MsgpackUseNewTimeFormat,
 	}
+	if options.InitialSizeBytes > 0 && !store.readOnly {
+		store.preGrownSize.Store(options.InitialSizeBytes)
+		store.raiseAllocSize(handle)
+	}
+	if grownFrom != 0 {
+		store.grew(grownFrom, options.InitialSizeBytes)
+	}
+	if options.TracerProvider != nil {
+		store.tracer = options.TracerProvider.Tracer(tracerName)
+	}
+	if options.WriteLimiter != nil {
+		store.limiter = newWriteLimiter(*options.WriteLimiter)
+	}
+	if options.HealthPolicy != nil {
+		store.health = newCommitTracker(*options.HealthPolicy)
+	}
+	if options.CacheSize > 0 {
+		store.cache = newLogCache(options.CacheSize, true)
+	} else if options.ReadAhead > 0 {
+		store.cache = newLogCache(2*options.ReadAhead, false)
+	}
 
 	// If the store was opened read-only, don't try and create buckets
-	if !options.readOnly() {
+	if !store.readOnly {
 		// Set up our buckets
-		if err := store.initialize(); err != nil {
+		if err := store.initialize(uint64(options.LogSegmentSize)); err != nil {
+			store.Close()
+			return nil, err
+		}
+	} else if err := store.loadSegmentSize(); err != nil {
+		store.Close()
+		return nil, err
+	}
+	if err := store.initStable(&options); err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	// The store can still be opened when it's short of space, to be read
+	store.minFreeBytes = options.MinFreeBytes
+
+	if options.CheckOnOpen {
+		report, err := store.verify(VerifyOptions{})
+		if err == nil && !report.OK() {
+			err = &VerifyError{Path: options.Path, Report: report}
+		}
+		if err != nil {
 			store.Close()
 			return nil, err
 		}
 	}
+	store.loadIndexes()
+	if store.maxSizeBytes != 0 {
+		stats := handle.Stats()
+		store.quotaFreed.Store(int64(stats.FreePageN * handle.Info().PageSize))
+	}
+	if size, err := store.fileSize(); err == nil {
+		store.compactedSize.Store(size)
+	}
+	if options.AutoCompact != nil && !store.readOnly {
+		opts := *options.AutoCompact
+		store.background(func() { store.runAutoCompact(opts) })
+	}
+	if options.RetentionPolicy != nil && !store.readOnly {
+		policy := *options.RetentionPolicy
+		store.background(func() { store.runRetention(policy) })
+	}
+	if store.health != nil && !store.readOnly {
+		store.background(store.runHealthCheck)
+	}
+	if options.ReadTxWatchdog != nil {
+		watchdog := *options.ReadTxWatchdog
+		store.background(func() { store.runReadTxWatchdog(watchdog) })
+	}
 	return store, nil
 }
 
-// initialize is used to set up all of the buckets.
-func (b *BoltStore) initialize() error {
+// NewWithContext is like New, but gives up waiting for the database to
+// be opened once the context is done. Opening can block indefinitely on
+// the file lock or a hung filesystem and can't be interrupted, so the
+// store is closed in the background if it does eventually open.
+func NewWithContext(ctx context.Context, options Options) (*BoltStore, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Don't let a lock wait outlive the context
+	deadline, ok := ctx.Deadline()
+	lockUntilDeadline := ok && options.LockTimeout == 0
+	if lockUntilDeadline {
+		options.LockTimeout = time.Until(deadline)
+	}
+
+	type result struct {
+		store *BoltStore
+		err   error
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		store, err := New(options)
+		resultCh <- result{store, err}
+	}()
+
+	select {
+	case r := <-resultCh:
+		// Bbolt gives up on the lock slightly early, so a timeout we
+		// derived from the deadline is reported as the deadline passing
+		if lockUntilDeadline && errors.Is(r.err, ErrDatabaseLocked) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return r.store, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-resultCh; r.err == nil {
+				r.store.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// initialize is used to set up all of the buckets, and to convert the
+// logs bucket to segments of segmentSize indexes if it isn't already.
+func (b *BoltStore) initialize(segmentSize uint64) error {
 	tx, err := b.conn.Begin(true)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
+	// Nothing can be changed in a file that's newer than this package
+	if err := b.checkFormatVersion(tx); err != nil {
+		return err
+	}
+
 	// Create all the buckets
 	if _, err := tx.CreateBucketIfNotExists(dbLogs); err != nil {
 		return err
@@ -119,27 +506,278 @@ func (b *BoltStore) initialize() error {
 		return err
 	}
 
+	// Encryption can be turned on for an existing store, but not off, as
+	// nothing could then read what's already encrypted
+	keys, err := loadKeyring(tx, b.wrapper, b.crypto)
+	if err != nil {
+		return err
+	}
+	if keys == nil && b.wrapper != nil {
+		if keys, err = initEncryption(tx, b.wrapper, b.crypto); err != nil {
+			return err
+		}
+		b.logger.Info("store is now encrypted", "path", b.path)
+	}
+	b.keys.Store(keys)
+	if b.overflowThreshold > 0 {
+		if _, err := tx.CreateBucketIfNotExists(dbOverflow); err != nil {
+			return err
+		}
+	}
+
+	b.segmentSize = readSegmentSize(tx)
+	if segmentSize != 0 && b.segmentSize == 0 {
+		if b.segmentSize, err = initSegments(tx, segmentSize, b.logsFillPercent); err != nil {
+			return err
+		}
+		b.logger.Info("logs bucket is now segmented", "path", b.path, "segment_size", segmentSize)
+	} else if segmentSize != 0 && segmentSize != b.segmentSize {
+		b.logger.Warn("ignoring LogSegmentSize, the file already uses a different one",
+			"path", b.path, "segment_size", b.segmentSize, "requested", segmentSize)
+	}
+
+	// An index that isn't kept up to date is worse than none
+	hasTerms := tx.Bucket(dbTerms) != nil
+	if b.termIndex && !hasTerms {
+		if err := b.initTermIndex(tx); err != nil {
+			return err
+		}
+		b.logger.Info("created term index", "path", b.path)
+	} else if !b.termIndex && hasTerms {
+		if err := tx.DeleteBucket(dbTerms); err != nil {
+			return err
+		}
+		b.logger.Info("removed term index", "path", b.path)
+	}
+
+	if err := b.stampFormatVersion(tx); err != nil {
+		return err
+	}
+	if b.autoMigrate {
+		if err := markOpened(tx); err != nil {
+			return err
+		}
+	}
 	return tx.Commit()
 }
 
+// loadSegmentSize reads the format of the logs bucket for a store that
+// can't be initialized because it's read-only.
+func (b *BoltStore) loadSegmentSize() error {
+	tx, err := b.begin(false)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := b.checkFormatVersion(tx); err != nil {
+		return err
+	}
+	keys, err := loadKeyring(tx, b.wrapper, b.crypto)
+	if err != nil {
+		return err
+	}
+	b.keys.Store(keys)
+	b.segmentSize = readSegmentSize(tx)
+	return nil
+}
+
 func (b *BoltStore) Stats() bbolt.Stats {
-	return b.conn.Stats()
+	return b.db().Stats()
+}
+
+// db returns the current handle to the db, for callers that don't need
+// to start a transaction. It may be closed by Compact at any time.
+func (b *BoltStore) db() *bbolt.DB {
+	b.connLock.RLock()
+	defer b.connLock.RUnlock()
+	return b.conn
+}
+
+// Size returns the size of the database in bytes.
+func (b *BoltStore) Size() (int64, error) {
+	tx, err := b.begin(false)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	return tx.Size(), nil
+}
+
+// Path returns the path of the database file.
+func (b *BoltStore) Path() string {
+	return b.path
 }
 
-// Close is used to gracefully close the DB connection.
+// IsClosed returns true once Close has been called.
+func (b *BoltStore) IsClosed() bool {
+	return b.closed.Load()
+}
+
+// Close is used to gracefully close the DB connection. It is safe to
+// call more than once, and concurrently; later calls return the result
+// of the first. Every other method returns ErrClosed once the store has
+// been closed.
 func (b *BoltStore) Close() error {
-	return b.conn.Close()
+	b.closeOnce.Do(func() {
+		b.bgLock.Lock()
+		b.closed.Store(true)
+		b.bgLock.Unlock()
+		close(b.closeCh)
+		b.bg.Wait()
+
+		// Wait for any compaction to finish with the file
+		b.connLock.Lock()
+		defer b.connLock.Unlock()
+		b.endReads(ErrClosed)
+		if b.syncPolicy.noSync() && !b.readOnly {
+			b.closeErr = b.conn.Sync()
+		}
+		if err := b.conn.Close(); err != nil {
+			b.closeErr = err
+		}
+		if b.stable != nil {
+			if err := b.stable.Close(); err != nil {
+				b.closeErr = err
+			}
+		}
+	})
+	return b.closeErr
+}
+
+// begin starts a transaction, mapping the store's state onto our own
+// errors rather than leaving callers to deal with Bbolt's.
+func (b *BoltStore) begin(writable bool) (*bbolt.Tx, error) {
+	b.connLock.RLock()
+	defer b.connLock.RUnlock()
+	return b.beginLocked(writable)
+}
+
+// beginLocked is begin for callers already holding connLock.
+func (b *BoltStore) beginLocked(writable bool) (*bbolt.Tx, error) {
+	if b.closed.Load() {
+		return nil, ErrClosed
+	}
+	if writable && b.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	if writable {
+		if err := b.checkFreeSpace(b.path); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := b.conn.Begin(writable)
+	if err == bbolt.ErrDatabaseNotOpen {
+		return nil, ErrClosed
+	}
+	return tx, err
+}
+
+// bucket looks up one of the store's buckets, returning an error that
+// names it rather than a nil bucket for the caller to trip over.
+func (b *BoltStore) bucket(tx *bbolt.Tx, name []byte) (*bbolt.Bucket, error) {
+	bucket := tx.Bucket(name)
+	if bucket == nil {
+		return nil, fmt.Errorf("%w: %q in %s", ErrBucketMissing, name, b.path)
+	}
+	return bucket, nil
+}
+
+// update runs fn in a write transaction made by op, and commits it if fn
+// succeeds.
+func (b *BoltStore) update(op string, fn func(*bbolt.Tx) error) (time.Duration, error) {
+	tx, err := b.begin(true)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return 0, err
+	}
+	return b.commit(tx, op)
+}
+
+// batch is like update, but lets Bbolt merge fn into a transaction with
+// other concurrent callers, see bbolt.DB.Batch. fn may be called more
+// than once, so must only have side effects through tx. The returned
+// duration covers waiting for the batch as well as committing it.
+func (b *BoltStore) batch(op string, fn func(*bbolt.Tx) error) (time.Duration, error) {
+	if b.closed.Load() {
+		return 0, ErrClosed
+	}
+	if b.readOnly {
+		return 0, ErrReadOnly
+	}
+	if err := b.checkFreeSpace(b.path); err != nil {
+		return 0, err
+	}
+
+	crash, err := b.faults.beforeCommit(b.path)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	id := b.health.begin()
+	b.connLock.RLock()
+	err = b.faults.afterCommit(b.path, crash, b.diskError(b.path, b.conn.Batch(fn)))
+	b.connLock.RUnlock()
+	if err == bbolt.ErrDatabaseNotOpen {
+		err = ErrClosed
+	}
+	if err == nil {
+		b.writes.Add(1)
+		b.restoreAllocSize()
+	}
+	elapsed := time.Since(start)
+	b.health.end(id, elapsed, err)
+	b.hooks.commit(CommitInfo{Op: op, Duration: elapsed, Err: err})
+	return elapsed, err
+}
+
+// commit commits a write transaction made by op, returning how long the
+// commit took and reporting it to the OnCommit hook.
+func (b *BoltStore) commit(tx *bbolt.Tx, op string) (time.Duration, error) {
+	crash, err := b.faults.beforeCommit(b.path)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	id := b.health.begin()
+	err = b.faults.afterCommit(b.path, crash, b.diskError(b.path, tx.Commit()))
+	if err == nil {
+		b.writes.Add(1)
+		b.restoreAllocSize()
+	}
+	elapsed := time.Since(start)
+	b.health.end(id, elapsed, err)
+	b.hooks.commit(CommitInfo{Op: op, Duration: elapsed, Err: err})
+	return elapsed, err
 }
 
-// FirstIndex returns the first known index from the Raft log.
+// FirstIndex returns the first known index from the Raft log. This is
+// normally served from memory, and only reads the file if the cached
+// value couldn't be loaded.
 func (b *BoltStore) FirstIndex() (uint64, error) {
-	tx, err := b.conn.Begin(false)
+	if c, ok := b.cachedIndexes(); ok && !b.closed.Load() {
+		return c.first, nil
+	}
+
+	tx, err := b.begin(false)
 	if err != nil {
 		return 0, err
 	}
 	defer tx.Rollback()
 
-	curs := tx.Bucket(dbLogs).Cursor()
+	logs, err := b.logs(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	curs := logs.cursor()
 	if first, _ := curs.First(); first == nil {
 		return 0, nil
 	} else {
@@ -147,15 +785,25 @@ func (b *BoltStore) FirstIndex() (uint64, error) {
 	}
 }
 
-// LastIndex returns the last known index from the Raft log.
+// LastIndex returns the last known index from the Raft log. Like
+// FirstIndex it's normally served from memory.
 func (b *BoltStore) LastIndex() (uint64, error) {
-	tx, err := b.conn.Begin(false)
+	if c, ok := b.cachedIndexes(); ok && !b.closed.Load() {
+		return c.last, nil
+	}
+
+	tx, err := b.begin(false)
 	if err != nil {
 		return 0, err
 	}
 	defer tx.Rollback()
 
-	curs := tx.Bucket(dbLogs).Cursor()
+	logs, err := b.logs(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	curs := logs.cursor()
 	if last, _ := curs.Last(); last == nil {
 		return 0, nil
 	} else {
@@ -164,22 +812,150 @@ func (b *BoltStore) LastIndex() (uint64, error) {
 }
 
 // GetLog is used to retrieve a log from Bbolt at a given index.
-func (b *BoltStore) GetLog(idx uint64, log *raft.Log) error {
-	defer metrics.MeasureSince([]string{"raft", "boltdb", "getLog"}, time.Now())
+func (b *BoltStore) GetLog(idx uint64, log *raft.Log) (err error) {
+	start := time.Now()
+	defer b.metrics.measureSince([]string{"getLog"}, start)
+	span := b.startSpan("raftboltdb.GetLog", attrIndex.Int64(int64(idx)))
+	size := 0
+	cached := false
+	defer func() {
+		endSpan(span, err)
+		b.hooks.get(GetInfo{Op: "GetLog", Index: idx, Bytes: size, Cached: cached, Duration: time.Since(start), Err: err})
+	}()
+
+	if entry, ok := b.cache.get(idx); ok {
+		if b.closed.Load() {
+			return ErrClosed
+		}
+		b.metrics.incrCounter([]string{"logCache", "hit"}, 1)
+		*log = *entry
+		cached = true
+		return nil
+	}
+	gen := b.cache.generation()
 
-	tx, err := b.conn.Begin(false)
+	tx, err := b.begin(false)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	bucket := tx.Bucket(dbLogs)
-	val := bucket.Get(uint64ToBytes(idx))
+	logs, err := b.logs(tx)
+	if err != nil {
+		return err
+	}
+	// val points into the memory map and is only valid until the
+	// transaction ends, so it must be decoded before returning
+	val := logs.get(idx)
 
 	if val == nil {
 		return raft.ErrLogNotFound
 	}
-	return decodeMsgPack(val, log)
+	size = len(val)
+	if b.cache == nil {
+		if err := logs.decode(idx, val, log); err != nil {
+			return fmt.Errorf("%w at index %d: %w", ErrLogCorrupt, idx, err)
+		}
+		return nil
+	}
+
+	b.metrics.incrCounter([]string{"logCache", "miss"}, 1)
+	entry := new(raft.Log)
+	if err := logs.decode(idx, val, entry); err != nil {
+		return fmt.Errorf("%w at index %d: %w", ErrLogCorrupt, idx, err)
+	}
+	*log = *entry
+
+	// If the previous entry was read recently this is likely a follower
+	// catching up or a replay, so fetch what comes next while we have a
+	// transaction open
+	entries := []*raft.Log{entry}
+	if b.cache.contains(idx - 1) {
+		entries = append(entries, readAhead(logs, idx+1, b.readAhead)...)
+		b.metrics.addSample([]string{"readAhead"}, float32(len(entries)-1))
+	}
+	b.cache.add(gen, entries...)
+	return nil
+}
+
+// readAhead decodes up to n consecutive entries starting at idx. It
+// stops early at a gap or an entry that can't be decoded, leaving GetLog
+// to report the problem if it's ever asked for that index.
+func readAhead(bucket *logBucket, idx uint64, n int) []*raft.Log {
+	var logs []*raft.Log
+	curs := bucket.cursor()
+	for k, v := curs.Seek(uint64ToBytes(idx)); k != nil && len(logs) < n; k, v = curs.Next() {
+		if len(k) != 8 || bytesToUint64(k) != idx {
+			break
+		}
+		log := new(raft.Log)
+		if err := bucket.decode(idx, v, log); err != nil {
+			break
+		}
+		logs = append(logs, log)
+		idx++
+	}
+	return logs
+}
+
+// LogRangeReader is implemented by log stores that can read a range of
+// entries at once. Raft integrations can check for it to speed up
+// replication to followers that are far behind.
+type LogRangeReader interface {
+	GetLogs(min, max uint64, logs []*raft.Log) ([]*raft.Log, error)
+}
+
+var _ LogRangeReader = (*BoltStore)(nil)
+
+// GetLogs appends the logs from min to max inclusive to logs, reading
+// them all in a single transaction, and returns the extended slice. This
+// is much cheaper than calling GetLog for each index when a follower is
+// far behind. If any index in the range is missing raft.ErrLogNotFound
+// is returned.
+func (b *BoltStore) GetLogs(min, max uint64, logs []*raft.Log) (_ []*raft.Log, err error) {
+	start := time.Now()
+	defer b.metrics.measureSince([]string{"getLogs"}, start)
+	span := b.startSpan("raftboltdb.GetLogs",
+		attrMinIndex.Int64(int64(min)), attrMaxIndex.Int64(int64(max)))
+	defer func() { endSpan(span, err) }()
+
+	if min > max {
+		return logs, nil
+	}
+
+	tx, err := b.begin(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	bucket, err := b.logs(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	next := min
+	curs := bucket.cursor()
+	for k, v := curs.Seek(uint64ToBytes(min)); k != nil; k, v = curs.Next() {
+		idx := bytesToUint64(k)
+		if idx > max {
+			break
+		}
+		if idx != next {
+			return nil, raft.ErrLogNotFound
+		}
+
+		log := new(raft.Log)
+		if err := bucket.decode(idx, v, log); err != nil {
+			return nil, fmt.Errorf("%w at index %d: %w", ErrLogCorrupt, idx, err)
+		}
+		logs = append(logs, log)
+		next++
+	}
+	if next <= max {
+		return nil, raft.ErrLogNotFound
+	}
+	return logs, nil
 }
 
 // StoreLog is used to store a single raft log
@@ -189,104 +965,307 @@ func (b *BoltStore) StoreLog(log *raft.Log) error {
 
 // StoreLogs is used to store a set of raft logs
 func (b *BoltStore) StoreLogs(logs []*raft.Log) error {
+	if err := b.storeLogs(logs); err != nil {
+		return err
+	}
+	b.limiter.take(len(logs))
+	return nil
+}
+
+// storeLogs stores a set of raft logs without taking tokens from the
+// WriteLimiter, for bulk writes that have already waited for them.
+func (b *BoltStore) storeLogs(logs []*raft.Log) (err error) {
 	now := time.Now()
+	span := b.startSpan("raftboltdb.StoreLogs", attrBatchSize.Int(len(logs)))
+	batchSize := 0
+	var commitTime time.Duration
+	defer func() {
+		endSpan(span, err)
+		b.hooks.storeLogs(StoreLogsInfo{
+			Logs:       len(logs),
+			Bytes:      batchSize,
+			Duration:   time.Since(now),
+			CommitTime: commitTime,
+			Err:        err,
+		})
+	}()
 
-	tx, err := b.conn.Begin(true)
+	// The encoder's buffers back the keys and values until the
+	// transaction has finished, so it's only released afterwards
+	enc := getLogEncoder(b.msgpackUseNewTimeFormat)
+	defer enc.release()
+	put := func(tx *bbolt.Tx) error {
+		var err error
+		batchSize, err = b.putLogs(tx, enc, logs)
+		return err
+	}
+	if b.batchWrites {
+		commitTime, err = b.batch("StoreLogs", put)
+	} else {
+		commitTime, err = b.update("StoreLogs", put)
+	}
 	if err != nil {
 		return err
 	}
-	defer tx.Rollback()
+
+	b.metrics.addSample([]string{"logsPerBatch"}, float32(len(logs)))
+	b.metrics.addSample([]string{"logBatchSize"}, float32(batchSize))
+	b.metrics.addSample([]string{"writeCapacity"}, (float32(1_000_000_000)/float32(time.Since(now).Nanoseconds()))*float32(len(logs)))
+	b.metrics.measureSince([]string{"storeLogs"}, now)
+	span.SetAttributes(
+		attrBytesWritten.Int(batchSize),
+		attrCommitTime.Float64(float64(commitTime)/float64(time.Millisecond)))
+	b.warnIfSlow("StoreLogs", time.Since(now),
+		"logs", len(logs), "bytes_written", batchSize, "commit_time", commitTime)
+	return nil
+}
+
+// putLogs writes logs in tx using enc, returning their total encoded
+// size.
+func (b *BoltStore) putLogs(tx *bbolt.Tx, enc *logEncoder, logs []*raft.Log) (int, error) {
+	bucket, err := b.logs(tx)
+	if err != nil {
+		return 0, err
+	}
+	if b.strictAppend {
+		if err := checkAppend(bucket, logs); err != nil {
+			return 0, err
+		}
+	}
+	if err := b.checkOverwrites(tx, bucket, logs); err != nil {
+		return 0, err
+	}
+
+	// Appends can't affect anything that's cached, but overwrites can
+	var min, max uint64
+	overwrite := false
+	if b.cache != nil && len(logs) > 0 {
+		min, max = logs[0].Index, logs[0].Index
+		for _, log := range logs {
+			if log.Index < min {
+				min = log.Index
+			}
+			if log.Index > max {
+				max = log.Index
+			}
+		}
+		last, _ := bucket.cursor().Last()
+		overwrite = last != nil && bytesToUint64(last) >= min
+	}
+
+	// Overwritten entries may have left data in the overflow bucket
+	var lastIndex uint64
+	if bucket.overflow != nil {
+		if last, _ := bucket.cursor().Last(); last != nil {
+			lastIndex = bytesToUint64(last)
+		}
+	}
+
+	batchSize, err := b.writeEntries(bucket, enc, logs, lastIndex)
+	if err != nil {
+		return 0, err
+	}
+	if err := b.checkQuota(tx, batchSize); err != nil {
+		return 0, err
+	}
+	if err := bucket.indexTerms(logs); err != nil {
+		return 0, err
+	}
+	seq := b.trackIndexes(tx, bucket)
+	b.cache.cacheWrite(tx, seq, overwrite, min, max, logs)
+	return batchSize, nil
+}
+
+// writeEntries encodes and stores logs in bucket, moving large data into
+// the overflow bucket. Entries at or below lastIndex may already have
+// overflow chunks, which are cleared first.
+func (b *BoltStore) writeEntries(bucket *logBucket, enc *logEncoder, logs []*raft.Log, lastIndex uint64) (int, error) {
+	var err error
+	keys := bucket.keys
+	if b.encryptConfOnly {
+		keys = nil
+	}
+	enc.checksums = b.checksums
+	enc.compressor = b.compressor
+	enc.compressThreshold = b.compressThreshold
+	enc.dataKeys = keys
+	enc.reset(len(logs))
+
+	// Encoding dominates large batches, so they're encoded concurrently
+	// before any of them are written
+	var vals [][]byte
+	if b.parallelEncodeBytes > 0 && len(logs) > 1 && dataSize(logs) >= b.parallelEncodeBytes {
+		vals, err = enc.encodeParallel(logs, func(log *raft.Log) bool {
+			return b.overflowThreshold > 0 && len(log.Data) >= b.overflowThreshold
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
 
 	batchSize := 0
-	for _, log := range logs {
-		key := uint64ToBytes(log.Index)
-		val, err := encodeMsgPack(log, b.msgpackUseNewTimeFormat)
+	for i, log := range logs {
+		if log.Index <= lastIndex {
+			if err := bucket.deleteOverflow(log.Index, log.Index); err != nil {
+				return 0, err
+			}
+		}
+
+		key := enc.key(log.Index)
+		var val []byte
+		if b.overflowThreshold > 0 && len(log.Data) >= b.overflowThreshold {
+			data := log.Data
+			if keys != nil {
+				if data, err = keys.seal(nil, data, overflowAAD(log.Index)); err != nil {
+					return 0, err
+				}
+			}
+			if err := bucket.putOverflow(enc, log.Index, data); err != nil {
+				return 0, err
+			}
+			stripped := *log
+			stripped.Data = nil
+			val, err = enc.encodeEntry(&stripped, entryOverflow)
+			batchSize += len(log.Data)
+		} else if vals != nil {
+			val = vals[i]
+		} else {
+			val, err = enc.encode(log)
+		}
 		if err != nil {
-			return err
+			return 0, err
 		}
 
-		logLen := val.Len()
-		bucket := tx.Bucket(dbLogs)
-		if err := bucket.Put(key, val.Bytes()); err != nil {
-			return err
+		logLen := len(val)
+		if err := bucket.put(key, val); err != nil {
+			return 0, err
 		}
 		batchSize += logLen
-		metrics.AddSample([]string{"raft", "boltdb", "logSize"}, float32(logLen))
+		b.metrics.addSample([]string{"logSize"}, float32(logLen))
 	}
-
-	metrics.AddSample([]string{"raft", "boltdb", "logsPerBatch"}, float32(len(logs)))
-	metrics.AddSample([]string{"raft", "boltdb", "logBatchSize"}, float32(batchSize))
-	// Both the deferral and the inline function are important for this metrics
-	// accuracy. Deferral allows us to calculate the metric after the tx.Commit
-	// has finished and thus account for all the processing of the operation.
-	// The inlined function ensures that we do not calculate the time.Since(now)
-	// at the time of deferral but rather when the go runtime executes the
-	// deferred function.
-	defer func() {
-		metrics.AddSample([]string{"raft", "boltdb", "writeCapacity"}, (float32(1_000_000_000)/float32(time.Since(now).Nanoseconds()))*float32(len(logs)))
-		metrics.MeasureSince([]string{"raft", "boltdb", "storeLogs"}, now)
-	}()
-
-	return tx.Commit()
+	return batchSize, nil
 }
 
 // DeleteRange is used to delete logs within a given range inclusively.
 func (b *BoltStore) DeleteRange(min, max uint64) error {
-	minKey := uint64ToBytes(min)
-
-	tx, err := b.conn.Begin(true)
+	deleted, err := b.deleteRange(min, max)
 	if err != nil {
 		return err
 	}
-	defer tx.Rollback()
+	b.limiter.take(deleted)
+	return nil
+}
 
-	curs := tx.Bucket(dbLogs).Cursor()
-	for k, _ := curs.Seek(minKey); k != nil; k, _ = curs.Next() {
-		// Handle out-of-range log index
-		if bytesToUint64(k) > max {
-			break
-		}
+// deleteRange deletes logs within a given range inclusively without
+// taking tokens from the WriteLimiter, returning how many were deleted.
+func (b *BoltStore) deleteRange(min, max uint64) (deleted int, err error) {
+	start := time.Now()
+	defer b.metrics.measureSince([]string{"deleteRange"}, start)
+	span := b.startSpan("raftboltdb.DeleteRange",
+		attrMinIndex.Int64(int64(min)), attrMaxIndex.Int64(int64(max)))
+	bytesDeleted := 0
+	defer func() {
+		endSpan(span, err)
+		b.hooks.deleteRange(DeleteRangeInfo{
+			Min:      min,
+			Max:      max,
+			Logs:     deleted,
+			Bytes:    bytesDeleted,
+			Duration: time.Since(start),
+			Err:      err,
+		})
+	}()
 
-		// Delete in-range log index
-		if err := curs.Delete(); err != nil {
-			return err
-		}
+	tx, err := b.begin(true)
+	if err != nil {
+		return 0, err
 	}
+	defer tx.Rollback()
 
-	return tx.Commit()
+	bucket, err := b.logs(tx)
+	if err != nil {
+		return 0, err
+	}
+	if err := b.archiveRange(bucket, min, max); err != nil {
+		return 0, err
+	}
+	deleted, bytesDeleted, err = bucket.deleteRange(min, max)
+	if err != nil {
+		return 0, err
+	}
+	b.freed(tx, bytesDeleted)
+	seq := b.trackIndexes(tx, bucket)
+	b.cache.cacheWrite(tx, seq, true, min, max, nil)
+
+	_, err = b.commit(tx, "DeleteRange")
+	b.warnIfSlow("DeleteRange", time.Since(start),
+		"min", min, "max", max, "logs", deleted, "bytes_deleted", bytesDeleted)
+	return deleted, err
 }
 
-// Set is used to set a key/value set outside of the raft log
-func (b *BoltStore) Set(k, v []byte) error {
-	tx, err := b.conn.Begin(true)
+// IsMonotonic implements raft.MonotonicLogStore, returning true if
+// Options.Monotonic was set.
+func (b *BoltStore) IsMonotonic() bool {
+	return b.monotonic
+}
+
+// Set is used to set a key/value set outside of the raft log. Keys that
+// start with "raftboltdb." are reserved for the store's own use, and are
+// rejected with ErrInvalidOptions.
+func (b *BoltStore) Set(k, v []byte) (err error) {
+	defer b.metrics.measureSince([]string{"set"}, time.Now())
+	span := b.startSpan("raftboltdb.Set", attrKeySize.Int(len(k)), attrValueSize.Int(len(v)))
+	defer func() { endSpan(span, err) }()
+	if err = checkKey(k); err != nil {
+		return err
+	}
+
+	tx, err := b.beginConf(true)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	bucket := tx.Bucket(dbConf)
-	if err := bucket.Put(k, v); err != nil {
+	bucket, err := b.bucket(tx, dbConf)
+	if err != nil {
+		return err
+	}
+	if err := b.putConf(bucket, k, v); err != nil {
 		return err
 	}
 
-	return tx.Commit()
+	_, err = b.commitConf(tx, "Set")
+	return err
 }
 
 // Get is used to retrieve a value from the k/v store by key
-func (b *BoltStore) Get(k []byte) ([]byte, error) {
-	tx, err := b.conn.Begin(false)
+func (b *BoltStore) Get(k []byte) (_ []byte, err error) {
+	start := time.Now()
+	defer b.metrics.measureSince([]string{"get"}, start)
+	size := 0
+	defer func() {
+		b.hooks.get(GetInfo{Op: "Get", Key: k, Bytes: size, Duration: time.Since(start), Err: err})
+	}()
+
+	tx, err := b.beginConf(false)
 	if err != nil {
 		return nil, err
 	}
 	defer tx.Rollback()
 
-	bucket := tx.Bucket(dbConf)
-	val := bucket.Get(k)
-
+	bucket, err := b.bucket(tx, dbConf)
+	if err != nil {
+		return nil, err
+	}
+	val, err := b.getConf(bucket, k)
+	if err != nil {
+		return nil, err
+	}
 	if val == nil {
 		return nil, ErrKeyNotFound
 	}
-	return append([]byte(nil), val...), nil
+	size = len(val)
+	return val, nil
 }
 
 // SetUint64 is like Set, but handles uint64 values
@@ -300,76 +1279,46 @@ func (b *BoltStore) GetUint64(key []byte) (uint64, error) {
 	if err != nil {
 		return 0, err
 	}
-	return bytesToUint64(val), nil
+	return valueToUint64(key, val)
 }
 
 // Sync performs an fsync on the database file handle. This is not necessary
-// under normal operation unless NoSync is enabled, in which this forces the
-// database file to sync against the disk.
+// under normal operation unless NoSync is enabled or the SyncPolicy is
+// SyncOnDemand, in which this forces the database file to sync against the
+// disk.
 func (b *BoltStore) Sync() error {
+	b.connLock.RLock()
+	defer b.connLock.RUnlock()
+	if b.closed.Load() {
+		return ErrClosed
+	}
 	return b.conn.Sync()
 }
 
-// MigrateToV2 reads in the source file path of a BoltDB file
-// and outputs all the data migrated to a Bbolt destination file
-func MigrateToV2(source, destination string) (*BoltStore, error) {
-	_, err := os.Stat(destination)
-	if err == nil {
-		return nil, fmt.Errorf("file exists in destination %v", destination)
-	}
-
-	srcDb, err := v1.Open(source, dbFileMode, &v1.Options{
-		ReadOnly: true,
-		Timeout:  1 * time.Minute,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed opening source database: %v", err)
-	}
-
-	//Start a connection to the source
-	srctx, err := srcDb.Begin(false)
-	if err != nil {
-		return nil, fmt.Errorf("failed connecting to source database: %v", err)
+// SetNoSync turns NoSync on or off for an open store, e.g. to skip the
+// fsync after every commit during a bulk import. Turning it off syncs
+// everything written in the meantime before returning. It can't be used
+// if Options.SyncPolicy is set.
+func (b *BoltStore) SetNoSync(noSync bool) error {
+	if b.syncPolicy != SyncEveryWrite {
+		return fmt.Errorf("%w: can't change NoSync with SyncPolicy %s", ErrInvalidOptions, b.syncPolicy)
 	}
-	defer srctx.Rollback()
 
-	//Create the destination
-	destDb, err := New(Options{Path: destination})
-	if err != nil {
-		return nil, fmt.Errorf("failed creating destination database: %v", err)
-	}
-	//Start a connection to the new
-	desttx, err := destDb.conn.Begin(true)
+	// Bbolt reads NoSync when committing, so only change it while
+	// holding the writer lock
+	b.connLock.RLock()
+	defer b.connLock.RUnlock()
+	tx, err := b.beginLocked(true)
 	if err != nil {
-		destDb.Close()
-		os.Remove(destination)
-		return nil, fmt.Errorf("failed connecting to destination database: %v", err)
+		return err
 	}
-
-	defer desttx.Rollback()
-
-	//Loop over both old buckets and set them in the new
-	buckets := [][]byte{dbConf, dbLogs}
-	for _, b := range buckets {
-		srcB := srctx.Bucket(b)
-		destB := desttx.Bucket(b)
-		err = srcB.ForEach(func(k, v []byte) error {
-			return destB.Put(k, v)
-		})
-		if err != nil {
-			destDb.Close()
-			os.Remove(destination)
-			return nil, fmt.Errorf("failed to copy %v bucket: %v", string(b), err)
-		}
+	b.conn.NoSync = noSync
+	if err := tx.Rollback(); err != nil {
+		return err
 	}
 
-	//If the commit fails, clean up
-	if err := desttx.Commit(); err != nil {
-		destDb.Close()
-		os.Remove(destination)
-		return nil, fmt.Errorf("failed commiting data to destination: %v", err)
+	if !noSync {
+		return b.conn.Sync()
 	}
-
-	return destDb, nil
-
+	return nil
 }
@@ -5,10 +5,16 @@ package raftboltdb
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -48,6 +54,36 @@ func TestBoltStore_Implements(t *testing.T) {
 	if _, ok := store.(raft.LogStore); !ok {
 		t.Fatalf("BoltStore does not implement raft.LogStore")
 	}
+	if _, ok := store.(raft.MonotonicLogStore); !ok {
+		t.Fatalf("BoltStore does not implement raft.MonotonicLogStore")
+	}
+}
+
+func TestBoltStore_IsMonotonic(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+	if store.IsMonotonic() {
+		t.Fatalf("expected the store not to be monotonic by default")
+	}
+
+	monotonic, err := New(Options{Path: filepath.Join(t.TempDir(), "raft.db"), Monotonic: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer monotonic.Close()
+	if !monotonic.IsMonotonic() {
+		t.Fatalf("expected the store to be monotonic")
+	}
+
+	// Wrapping the store in raft's cache passes it through
+	cache, err := raft.NewLogCache(1, monotonic)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !cache.IsMonotonic() {
+		t.Fatalf("expected the cached store to be monotonic")
+	}
 }
 
 func TestBoltOptionsTimeout(t *testing.T) {
@@ -76,7 +112,7 @@ func TestBoltOptionsTimeout(t *testing.T) {
 	}()
 	select {
 	case err := <-doneCh:
-		if err == nil || err.Error() != "timeout" {
+		if !errors.Is(err, bbolt.ErrTimeout) {
 			t.Errorf("Expected timeout error but got %v", err)
 		}
 	case <-time.After(5 * time.Second):
@@ -84,6 +120,66 @@ func TestBoltOptionsTimeout(t *testing.T) {
 	}
 }
 
+func TestBoltOptionsLockTimeout(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	_, err := New(Options{Path: store.path, LockTimeout: time.Second / 10})
+	if !errors.Is(err, ErrDatabaseLocked) {
+		t.Fatalf("expected locked error, got: %v", err)
+	}
+	var lockErr *LockedError
+	if !errors.As(err, &lockErr) {
+		t.Fatalf("expected a *LockedError, got: %T", err)
+	}
+	if lockErr.Path != store.path {
+		t.Fatalf("bad path: %q", lockErr.Path)
+	}
+	if runtime.GOOS == "linux" && lockErr.PID != os.Getpid() {
+		t.Fatalf("expected pid %d, got %d", os.Getpid(), lockErr.PID)
+	}
+	if !strings.Contains(err.Error(), store.path) {
+		t.Fatalf("expected path in error: %v", err)
+	}
+}
+
+func TestNewWithContext(t *testing.T) {
+	store := testBoltStore(t)
+	defer os.Remove(store.path)
+
+	// An already canceled context never tries to open
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewWithContext(ctx, Options{Path: store.path}); err != context.Canceled {
+		t.Fatalf("expected canceled error, got: %v", err)
+	}
+
+	// Give up while another handle holds the lock
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second/10)
+	defer cancel()
+	start := time.Now()
+	if _, err := NewWithContext(ctx, Options{Path: store.path}); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded error, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("took too long to give up: %v", elapsed)
+	}
+
+	// Once the lock is released we can open as normal
+	store.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	store, err := NewWithContext(ctx, Options{Path: store.path})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	if _, err := store.LastIndex(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
 func TestBoltOptionsReadOnly(t *testing.T) {
 	fh, err := ioutil.TempFile("", "bolt")
 	if err != nil {
@@ -128,8 +224,62 @@ func TestBoltOptionsReadOnly(t *testing.T) {
 	}
 	// Attempt to store the log, should fail on a read-only store
 	err = roStore.StoreLog(log)
-	if err != bbolt.ErrDatabaseReadOnly {
-		t.Errorf("expecting error %v, but got %v", bbolt.ErrDatabaseReadOnly, err)
+	if err != ErrReadOnly {
+		t.Errorf("expecting error %v, but got %v", ErrReadOnly, err)
+	}
+}
+
+func TestNewReadOnlyStore(t *testing.T) {
+	store := testBoltStore(t)
+	defer os.Remove(store.path)
+
+	log := testRaftLog(1, "log1")
+	if err := store.StoreLog(log); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Set([]byte("hello"), []byte("world")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.Close()
+
+	roStore, err := NewReadOnlyStore(store.path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer roStore.Close()
+
+	// Reads work as normal
+	result := new(raft.Log)
+	if err := roStore.GetLog(1, result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(log, result) {
+		t.Fatalf("bad: %v", result)
+	}
+	if val, err := roStore.Get([]byte("hello")); err != nil || string(val) != "world" {
+		t.Fatalf("bad: %q %v", val, err)
+	}
+
+	// Every mutating method is rejected
+	if err := roStore.StoreLogs([]*raft.Log{testRaftLog(2, "log2")}); err != ErrReadOnly {
+		t.Fatalf("expected read-only error, got: %v", err)
+	}
+	if err := roStore.DeleteRange(1, 1); err != ErrReadOnly {
+		t.Fatalf("expected read-only error, got: %v", err)
+	}
+	if err := roStore.Set([]byte("hello"), []byte("there")); err != ErrReadOnly {
+		t.Fatalf("expected read-only error, got: %v", err)
+	}
+	if err := roStore.SetUint64([]byte("abc"), 1); err != ErrReadOnly {
+		t.Fatalf("expected read-only error, got: %v", err)
+	}
+
+	// Opening a file that doesn't exist must not create it
+	if _, err := NewReadOnlyStore(store.path + ".missing"); err == nil {
+		t.Fatalf("expected an error opening a missing file")
+	}
+	if _, err := os.Stat(store.path + ".missing"); !os.IsNotExist(err) {
+		t.Fatalf("expected no file to be created, got: %v", err)
 	}
 }
 
@@ -177,6 +327,98 @@ func TestNewBoltStore(t *testing.T) {
 	}
 }
 
+func TestBoltStore_Close(t *testing.T) {
+	store := testBoltStore(t)
+	defer os.Remove(store.path)
+
+	// Close is idempotent and safe to call concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := store.Close(); err != nil {
+				t.Errorf("err: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Every method returns ErrClosed rather than panicking
+	if _, err := store.FirstIndex(); err != ErrClosed {
+		t.Fatalf("expected closed error, got: %v", err)
+	}
+	if _, err := store.LastIndex(); err != ErrClosed {
+		t.Fatalf("expected closed error, got: %v", err)
+	}
+	if err := store.GetLog(1, new(raft.Log)); err != ErrClosed {
+		t.Fatalf("expected closed error, got: %v", err)
+	}
+	if err := store.StoreLog(testRaftLog(1, "log1")); err != ErrClosed {
+		t.Fatalf("expected closed error, got: %v", err)
+	}
+	if err := store.DeleteRange(1, 2); err != ErrClosed {
+		t.Fatalf("expected closed error, got: %v", err)
+	}
+	if err := store.Set([]byte("hello"), []byte("world")); err != ErrClosed {
+		t.Fatalf("expected closed error, got: %v", err)
+	}
+	if _, err := store.Get([]byte("hello")); err != ErrClosed {
+		t.Fatalf("expected closed error, got: %v", err)
+	}
+	if _, err := store.GetUint64([]byte("hello")); err != ErrClosed {
+		t.Fatalf("expected closed error, got: %v", err)
+	}
+	if err := store.Sync(); err != ErrClosed {
+		t.Fatalf("expected closed error, got: %v", err)
+	}
+}
+
+func TestBoltStore_MissingBuckets(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	// Simulate another tool dropping the buckets out from under us
+	err := store.conn.Update(func(tx *bbolt.Tx) error {
+		if err := tx.DeleteBucket(dbLogs); err != nil {
+			return err
+		}
+		return tx.DeleteBucket(dbConf)
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	// The index cache can't see changes made behind the store's back
+	store.indexes.Store(nil)
+
+	checkMissing := func(err error, bucket []byte) {
+		t.Helper()
+		if !errors.Is(err, ErrBucketMissing) {
+			t.Fatalf("expected missing bucket error, got: %v", err)
+		}
+		if !strings.Contains(err.Error(), string(bucket)) || !strings.Contains(err.Error(), store.path) {
+			t.Fatalf("expected bucket and path in error: %v", err)
+		}
+	}
+
+	_, err = store.FirstIndex()
+	checkMissing(err, dbLogs)
+	_, err = store.LastIndex()
+	checkMissing(err, dbLogs)
+	checkMissing(store.GetLog(1, new(raft.Log)), dbLogs)
+	checkMissing(store.StoreLog(testRaftLog(1, "log1")), dbLogs)
+	checkMissing(store.DeleteRange(1, 2), dbLogs)
+	checkMissing(store.Set([]byte("hello"), []byte("world")), dbConf)
+	_, err = store.Get([]byte("hello"))
+	checkMissing(err, dbConf)
+	_, err = store.GetUint64([]byte("hello"))
+	checkMissing(err, dbConf)
+}
+
 func TestBoltStore_FirstIndex(t *testing.T) {
 	store := testBoltStore(t)
 	defer store.Close()
@@ -276,6 +518,28 @@ func TestBoltStore_GetLog(t *testing.T) {
 	}
 }
 
+func TestBoltStore_GetLog_Corrupt(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	// Write something that isn't msgpack directly into the bucket
+	err := store.conn.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(dbLogs).Put(uint64ToBytes(5), []byte{0xc1, 0xc1, 0xc1})
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err = store.GetLog(5, new(raft.Log))
+	if !errors.Is(err, ErrLogCorrupt) {
+		t.Fatalf("expected corrupt log error, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "index 5") {
+		t.Fatalf("expected index in error: %v", err)
+	}
+}
+
 func TestBoltStore_SetLog(t *testing.T) {
 	store := testBoltStore(t)
 	defer store.Close()
@@ -474,3 +738,165 @@ func TestBoltStore_MigrateToV2(t *testing.T) {
 	}
 
 }
+
+func TestBoltStore_GetLogs(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	var logs []*raft.Log
+	for i := uint64(1); i <= 10; i++ {
+		logs = append(logs, testRaftLog(i, fmt.Sprintf("log%d", i)))
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	got, err := store.GetLogs(3, 7, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(got) != 5 {
+		t.Fatalf("bad: %d", len(got))
+	}
+	for i, log := range got {
+		if !reflect.DeepEqual(log, logs[i+2]) {
+			t.Fatalf("bad log %d: %#v", i, log)
+		}
+	}
+
+	// Results are appended to the given slice
+	got, err = store.GetLogs(9, 10, got[:1])
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(got) != 3 || got[0].Index != 3 || got[1].Index != 9 || got[2].Index != 10 {
+		t.Fatalf("bad: %v", got)
+	}
+
+	// An empty range is fine
+	if got, err := store.GetLogs(5, 4, nil); err != nil || len(got) != 0 {
+		t.Fatalf("bad: %v %v", got, err)
+	}
+
+	// Any missing index fails the whole read
+	if err := store.DeleteRange(5, 5); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for _, r := range [][2]uint64{{4, 6}, {5, 6}, {9, 11}, {0, 2}} {
+		if _, err := store.GetLogs(r[0], r[1], nil); err != raft.ErrLogNotFound {
+			t.Fatalf("expected not found error for %v, got: %v", r, err)
+		}
+	}
+}
+
+func TestBoltStore_BatchWrites(t *testing.T) {
+	fh, err := ioutil.TempFile("", "bolt")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	os.Remove(fh.Name())
+	defer os.Remove(fh.Name())
+
+	store, err := New(Options{
+		Path:          fh.Name(),
+		BatchWrites:   true,
+		MaxBatchSize:  8,
+		MaxBatchDelay: 50 * time.Millisecond,
+		CacheSize:     16,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	if store.conn.MaxBatchSize != 8 || store.conn.MaxBatchDelay != 50*time.Millisecond {
+		t.Fatalf("bad: %d %s", store.conn.MaxBatchSize, store.conn.MaxBatchDelay)
+	}
+
+	// Concurrent appends all land, and the caches see every one of them
+	var wg sync.WaitGroup
+	for i := uint64(1); i <= 8; i++ {
+		wg.Add(1)
+		go func(i uint64) {
+			defer wg.Done()
+			if err := store.StoreLog(testRaftLog(i, "data")); err != nil {
+				t.Errorf("err: %s", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	checkIndexes(t, store, 1, 8)
+	for i := uint64(1); i <= 8; i++ {
+		if !store.cache.contains(i) {
+			t.Fatalf("expected %d to be cached", i)
+		}
+	}
+
+	store.Close()
+	if err := store.StoreLog(testRaftLog(9, "data")); err != ErrClosed {
+		t.Fatalf("expected closed error, got: %v", err)
+	}
+}
+
+func TestBoltStore_LogsFillPercent(t *testing.T) {
+	leafPages := func(fillPercent float64) int {
+		fh, err := ioutil.TempFile("", "bolt")
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		os.Remove(fh.Name())
+		defer os.Remove(fh.Name())
+
+		store, err := New(Options{Path: fh.Name(), LogsFillPercent: fillPercent})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		defer store.Close()
+
+		// Append in small batches, the way raft does
+		for i := uint64(1); i <= 2000; i += 10 {
+			var logs []*raft.Log
+			for j := i; j < i+10; j++ {
+				logs = append(logs, testRaftLog(j, "some reasonably sized log data"))
+			}
+			if err := store.StoreLogs(logs); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+		}
+
+		var pages int
+		err = store.conn.View(func(tx *bbolt.Tx) error {
+			pages = tx.Bucket(dbLogs).Stats().LeafPageN
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		return pages
+	}
+
+	packed, halfFull := leafPages(0), leafPages(0.5)
+	if float64(packed) > 0.6*float64(halfFull) {
+		t.Fatalf("expected far fewer pages with the default fill percent: %d vs %d", packed, halfFull)
+	}
+}
+
+func TestBoltStore_GetUint64_InvalidValue(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	if err := store.Set([]byte("short"), []byte{1, 2, 3}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := store.GetUint64([]byte("short")); !errors.Is(err, ErrInvalidValue) {
+		t.Fatalf("err: %v", err)
+	}
+	if err := store.Set(keyLastVoteTerm, []byte{1}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, _, err := store.LastVote(); !errors.Is(err, ErrInvalidValue) {
+		t.Fatalf("err: %v", err)
+	}
+}
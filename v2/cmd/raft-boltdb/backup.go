@@ -0,0 +1,194 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+)
+
+const (
+	// checksumSuffix is added to the name of a backup file to name the
+	// file its checksum is written to.
+	checksumSuffix = ".sha256"
+)
+
+// errChecksumMismatch is returned when a backup doesn't match its
+// checksum.
+var errChecksumMismatch = errors.New("backup doesn't match its checksum")
+
+// backup writes a consistent copy of a store to a file or to stdout.
+func (c *cli) backup(args []string) error {
+	fs := c.flags("backup", "-out FILE|- PATH")
+	out := fs.String("out", "", "Where to write the backup, or - for stdout. Its SHA-256 checksum is written next to a file, with "+checksumSuffix+" added to its name, in the format sha256sum reads, or to stderr for stdout.")
+	paths, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		fmt.Fprintf(c.stderr, "-out is required\n")
+		fs.Usage()
+		return errUsage
+	}
+	if *out != "-" {
+		if _, err := os.Stat(*out); err == nil {
+			return fmt.Errorf("file exists in destination %v", *out)
+		}
+	}
+
+	store, err := openReadOnly(paths[0])
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	h := sha256.New()
+	if *out == "-" {
+		w := bufio.NewWriter(c.stdout)
+		n, err := store.Backup(io.MultiWriter(w, h))
+		if err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.stderr, "Wrote %d bytes, sha256 %x\n", n, h.Sum(nil))
+		return nil
+	}
+
+	// Checksum what ended up on disk rather than what was written, so the
+	// checksum covers the file as it was synced
+	if err := store.BackupToFile(*out); err != nil {
+		return err
+	}
+	n, err := hashFile(h, *out)
+	if err != nil {
+		return err
+	}
+	sum := fmt.Sprintf("%x  %s\n", h.Sum(nil), filepath.Base(*out))
+	if err := os.WriteFile(*out+checksumSuffix, []byte(sum), 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "Wrote %d bytes to %s, sha256 %x\n", n, *out, h.Sum(nil))
+	return nil
+}
+
+// hashFile writes the contents of the file at path to h.
+func hashFile(h hash.Hash, path string) (int64, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer fh.Close()
+	return io.Copy(h, fh)
+}
+
+// restore writes a backup to a new store, or over an existing one.
+func (c *cli) restore(args []string) error {
+	fs := c.flags("restore", "-in FILE|- PATH")
+	in := fs.String("in", "", "The backup to restore, or - for stdin.")
+	checksum := fs.String("sha256", "", "The SHA-256 checksum the backup must match, in hex. Defaults to the one backup wrote next to the file, if there is one.")
+	overwrite := fs.Bool("overwrite", false, "Replace the store at PATH if it exists. It's only replaced once the backup has been checked.")
+	yes := fs.Bool("yes", false, "Don't ask for confirmation before replacing a store.")
+	paths, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if *in == "" {
+		fmt.Fprintf(c.stderr, "-in is required\n")
+		fs.Usage()
+		return errUsage
+	}
+	path := paths[0]
+
+	r := c.stdin
+	if *in != "-" {
+		fh, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer fh.Close()
+		r = fh
+
+		if *checksum == "" {
+			if *checksum, err = readChecksum(*in + checksumSuffix); err != nil {
+				return err
+			}
+		}
+	}
+	var want []byte
+	if *checksum != "" {
+		if want, err = hex.DecodeString(*checksum); err != nil || len(want) != sha256.Size {
+			return fmt.Errorf("invalid sha256 checksum %q", *checksum)
+		}
+		r = &checksumReader{r: r, h: sha256.New(), want: want}
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		if !*overwrite {
+			return fmt.Errorf("file exists in destination %v, use -overwrite to replace it", path)
+		}
+		fmt.Fprintf(c.stdout, "This will replace %s with the backup.\n", path)
+		if err := c.confirm(*yes); err != nil {
+			return err
+		}
+	}
+
+	err = raftboltdb.Restore(path, r, raftboltdb.RestoreOptions{Overwrite: *overwrite, LockTimeout: lockTimeout})
+	if err != nil {
+		return err
+	}
+	if want != nil {
+		fmt.Fprintf(c.stdout, "Backup matches sha256 %x\n", want)
+	}
+	fmt.Fprintf(c.stdout, "Restored %s\n", path)
+	return nil
+}
+
+// readChecksum returns the checksum in a file written by backup, or an
+// empty string if there isn't one.
+func readChecksum(path string) (string, error) {
+	buf, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(buf))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("no checksum in %s", path)
+	}
+	return fields[0], nil
+}
+
+// checksumReader hashes what's read from r, and fails the read that
+// reaches the end of r if it doesn't match want. Restore then discards
+// what it has written rather than moving it into place.
+type checksumReader struct {
+	r    io.Reader
+	h    hash.Hash
+	want []byte
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.h.Write(p[:n])
+	if err == io.EOF {
+		if got := c.h.Sum(nil); !bytes.Equal(got, c.want) {
+			return n, fmt.Errorf("%w: got sha256 %x, expected %x", errChecksumMismatch, got, c.want)
+		}
+	}
+	return n, err
+}
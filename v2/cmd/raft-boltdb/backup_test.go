@@ -0,0 +1,107 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBackupRestore(t *testing.T) {
+	path := testStore(t, 10)
+	dir := t.TempDir()
+	out := filepath.Join(dir, "backup.db")
+
+	if code, _, stderr := runCLI("backup", path); code != 2 || !strings.Contains(stderr, "-out is required") {
+		t.Fatalf("bad: %d %s", code, stderr)
+	}
+	code, stdout, stderr := runCLI("backup", "-out", out, path)
+	if code != 0 || !strings.Contains(stdout, "Wrote") {
+		t.Fatalf("bad: %d %s %s", code, stdout, stderr)
+	}
+	buf, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	sum, err := os.ReadFile(out + checksumSuffix)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if want := fmt.Sprintf("%x  backup.db\n", sha256.Sum256(buf)); string(sum) != want {
+		t.Fatalf("bad: %q %q", sum, want)
+	}
+
+	// The checksum written next to the backup is checked
+	dst := filepath.Join(dir, "raft.db")
+	code, stdout, stderr = runCLI("restore", "-in", out, dst)
+	if code != 0 || !strings.Contains(stdout, "Backup matches sha256") {
+		t.Fatalf("bad: %d %s %s", code, stdout, stderr)
+	}
+	want, err := checkFile(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if got, err := checkFile(dst); err != nil || got != want {
+		t.Fatalf("bad: %v", err)
+	}
+
+	// Replacing an existing store needs -overwrite and confirmation
+	if code, _, stderr := runCLI("restore", "-in", out, dst); code != 1 || !strings.Contains(stderr, "use -overwrite") {
+		t.Fatalf("bad: %d %s", code, stderr)
+	}
+	if code, _, stderr := runCLI("restore", "-in", out, "-overwrite", dst); code != 1 || !strings.Contains(stderr, "aborted") {
+		t.Fatalf("bad: %d %s", code, stderr)
+	}
+	if code, stdout, stderr := runCLIInput("yes\n", "restore", "-in", out, "-overwrite", dst); code != 0 {
+		t.Fatalf("bad: %d %s %s", code, stdout, stderr)
+	}
+}
+
+func TestBackupRestore_Stream(t *testing.T) {
+	path := testStore(t, 10)
+
+	code, stdout, stderr := runCLI("backup", "-out", "-", path)
+	if code != 0 {
+		t.Fatalf("bad: %d %s", code, stderr)
+	}
+	sum := fmt.Sprintf("%x", sha256.Sum256([]byte(stdout)))
+	if !strings.Contains(stderr, "sha256 "+sum) {
+		t.Fatalf("bad: %s %s", stderr, sum)
+	}
+
+	dst := filepath.Join(t.TempDir(), "raft.db")
+	code, out, stderr := runCLIInput(stdout, "restore", "-in", "-", "-sha256", sum, dst)
+	if code != 0 || !strings.Contains(out, "Restored") {
+		t.Fatalf("bad: %d %s %s", code, out, stderr)
+	}
+	if _, err := checkFile(dst); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestRestore_ChecksumMismatch(t *testing.T) {
+	path := testStore(t, 10)
+	code, stdout, _ := runCLI("backup", "-out", "-", path)
+	if code != 0 {
+		t.Fatalf("bad: %d", code)
+	}
+
+	dst := filepath.Join(t.TempDir(), "raft.db")
+	wrong := fmt.Sprintf("%x", sha256.Sum256([]byte("nope")))
+	code, _, stderr := runCLIInput(stdout, "restore", "-in", "-", "-sha256", wrong, dst)
+	if code != 1 || !strings.Contains(stderr, "doesn't match its checksum") {
+		t.Fatalf("bad: %d %s", code, stderr)
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Fatalf("bad: %v", err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(dst))
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("bad: %v %v", entries, err)
+	}
+}
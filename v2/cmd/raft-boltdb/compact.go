@@ -0,0 +1,92 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+)
+
+// compact rewrites a store without its free space, either into a new file
+// or in place.
+func (c *cli) compact(args []string) error {
+	fs := c.flags("compact", "")
+	src := fs.String("src", "", "The store to compact.")
+	dst := fs.String("dst", "", "Where to write the compacted copy, which mustn't exist yet.")
+	inPlace := fs.Bool("in-place", false, "Replace the store with its compacted copy rather than writing it to -dst.")
+	verify := fs.Bool("verify", false, "Check the compacted file for corruption, and that it holds the same entries and stable store keys as the store did.")
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	if *src == "" || (*dst == "") == !*inPlace {
+		fmt.Fprintf(c.stderr, "-src and exactly one of -dst and -in-place are required\n")
+		fs.Usage()
+		return errUsage
+	}
+	fi, err := os.Stat(*src)
+	if err != nil {
+		return err
+	}
+
+	var sum [sha256.Size]byte
+	path := *dst
+	if *inPlace {
+		path = *src
+		store, err := raftboltdb.New(raftboltdb.Options{Path: *src, LockTimeout: lockTimeout})
+		if err != nil {
+			return err
+		}
+		if *verify {
+			if sum, err = canonicalSum(store); err != nil {
+				store.Close()
+				return err
+			}
+		}
+		if err := store.Compact(c.ctx); err != nil {
+			store.Close()
+			return err
+		}
+		if err := store.Close(); err != nil {
+			return err
+		}
+	} else {
+		store, err := openReadOnly(*src)
+		if err != nil {
+			return err
+		}
+		if *verify {
+			if sum, err = canonicalSum(store); err != nil {
+				store.Close()
+				return err
+			}
+		}
+		err = store.CompactTo(c.ctx, *dst)
+		store.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	after, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "Compacted %s into %s: %d bytes before, %d after (%.1f%% smaller)\n",
+		*src, path, fi.Size(), after.Size(), 100*(1-float64(after.Size())/float64(fi.Size())))
+
+	if *verify {
+		got, err := checkFile(path)
+		if err != nil {
+			return err
+		}
+		if got != sum {
+			return fmt.Errorf("%s doesn't hold the same entries and stable store keys as %s did", path, *src)
+		}
+		fmt.Fprintf(c.stdout, "Verified %s\n", path)
+	}
+	return nil
+}
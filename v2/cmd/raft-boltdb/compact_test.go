@@ -0,0 +1,51 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+)
+
+func TestCompact(t *testing.T) {
+	path := testStore(t, 500)
+	store, err := raftboltdb.New(raftboltdb.Options{Path: path})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.DeleteRange(1, 490); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.Close()
+
+	if code, _, stderr := runCLI("compact", "-src", path); code != 2 || !strings.Contains(stderr, "exactly one of -dst and -in-place") {
+		t.Fatalf("bad: %d %s", code, stderr)
+	}
+
+	dst := path + ".new"
+	code, stdout, stderr := runCLI("compact", "-src", path, "-dst", dst, "-verify")
+	if code != 0 || !strings.Contains(stdout, "smaller") || !strings.Contains(stdout, "Verified "+dst) {
+		t.Fatalf("bad: %d %s %s", code, stdout, stderr)
+	}
+	if code, _, stderr := runCLI("compact", "-src", path, "-dst", dst); code != 1 || !strings.Contains(stderr, "file exists") {
+		t.Fatalf("bad: %d %s", code, stderr)
+	}
+
+	before, _ := os.Stat(path)
+	code, stdout, stderr = runCLI("compact", "-src", path, "-in-place", "-verify")
+	if code != 0 || !strings.Contains(stdout, "Verified "+path) {
+		t.Fatalf("bad: %d %s %s", code, stdout, stderr)
+	}
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size() {
+		t.Fatalf("bad: %d >= %d", after.Size(), before.Size())
+	}
+	_, stdout, _ = runCLI("dump-logs", "-format", "text", path)
+	if strings.Count(stdout, "\n") != 10 {
+		t.Fatalf("bad: %s", stdout)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+)
+
+// internalKeyPrefix starts the stable store keys that BoltStore keeps its
+// own bookkeeping under.
+var internalKeyPrefix = []byte("raftboltdb.")
+
+// dumpLogs prints the log entries in a range.
+func (c *cli) dumpLogs(args []string) error {
+	fs := c.flags("dump-logs", "PATH")
+	min := fs.Uint64("min", 0, "The first index to print. Defaults to the first in the log.")
+	max := fs.Uint64("max", math.MaxUint64, "The last index to print. Defaults to the last in the log.")
+	format := fs.String("format", "text", "The output format: text, a line per entry with its data left out; json, a JSON object per line; or msgpack, as the entries are stored.")
+	paths, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if *min > *max {
+		return fmt.Errorf("-min %d is after -max %d", *min, *max)
+	}
+
+	store, err := openReadOnly(paths[0])
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	switch *format {
+	case "json":
+		return store.ExportRange(c.stdout, *min, *max, raftboltdb.FormatNDJSON)
+	case "msgpack":
+		return store.ExportRange(c.stdout, *min, *max, raftboltdb.FormatMsgpack)
+	case "text":
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	it, err := store.Iterator(*min, *max)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	w := bufio.NewWriter(c.stdout)
+	for it.Next() {
+		log := it.Log()
+		fmt.Fprintf(w, "index=%d term=%d type=%s data=%d extensions=%d", log.Index, log.Term, log.Type, len(log.Data), len(log.Extensions))
+		if !log.AppendedAt.IsZero() {
+			fmt.Fprintf(w, " appended_at=%s", log.AppendedAt.UTC().Format(time.RFC3339Nano))
+		}
+		fmt.Fprintln(w)
+	}
+	if err := it.Err(); err != nil {
+		w.Flush()
+		return err
+	}
+	return w.Flush()
+}
+
+// confEntry is how dump-conf prints a key in JSON.
+type confEntry struct {
+	Key    string  `json:"key"`
+	Value  []byte  `json:"value"`
+	Uint64 *uint64 `json:"uint64,omitempty"`
+}
+
+// dumpConf prints the keys in the stable store.
+func (c *cli) dumpConf(args []string) error {
+	fs := c.flags("dump-conf", "PATH")
+	all := fs.Bool("all", false, "Include the keys the store keeps its own bookkeeping under.")
+	asJSON := fs.Bool("json", false, "Print a JSON object per key, with its value base64 encoded.")
+	paths, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	store, err := openReadOnly(paths[0])
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	w := bufio.NewWriter(c.stdout)
+	enc := json.NewEncoder(w)
+	err = store.ForEach(nil, func(k, v []byte) error {
+		if !*all && bytes.HasPrefix(k, internalKeyPrefix) {
+			return nil
+		}
+
+		// Values of eight bytes are most likely set with SetUint64, like
+		// raft's current term
+		var num *uint64
+		if len(v) == 8 {
+			n := binary.BigEndian.Uint64(v)
+			num = &n
+		}
+		if *asJSON {
+			return enc.Encode(&confEntry{Key: string(k), Value: v, Uint64: num})
+		}
+		fmt.Fprintf(w, "%q = %q", k, v)
+		if num != nil {
+			fmt.Fprintf(w, " (uint64 %d)", *num)
+		}
+		_, err := fmt.Fprintln(w)
+		return err
+	})
+	if err != nil {
+		w.Flush()
+		return err
+	}
+	return w.Flush()
+}
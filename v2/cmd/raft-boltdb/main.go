@@ -0,0 +1,226 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+// Command raft-boltdb inspects and maintains the files written by
+// raftboltdb.BoltStore, so operators can see what's inside a node's
+// raft.db, and look after it, without writing a Go program:
+//
+//	raft-boltdb inspect raft.db
+//	raft-boltdb dump-logs -min 100 -max 200 -format json raft.db
+//	raft-boltdb dump-conf raft.db
+//	raft-boltdb compact -src raft.db -in-place
+//	raft-boltdb verify raft.db
+//	raft-boltdb truncate -after 1000 raft.db
+//	raft-boltdb backup -out - raft.db | gzip > raft.db.gz
+//	raft-boltdb stats -watch 5s raft.db
+//
+// Files are locked while a command runs, so it can't be run against a
+// file while the node that owns it is running, except for stats, which
+// only reads the pages Bbolt keeps its own bookkeeping in. Commands that
+// only read a file open it read-only. Run a command with -h for its options.
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+)
+
+const (
+	// lockTimeout is how long commands wait for the file lock before
+	// reporting that the file is in use.
+	lockTimeout = time.Second
+)
+
+// errUsage is returned by a command given invalid arguments, once it has
+// printed its usage.
+var errUsage = errors.New("usage")
+
+// errAborted is returned by a command that was declined at its
+// confirmation prompt.
+var errAborted = errors.New("aborted, nothing was changed")
+
+// cli is what a command runs with. ctx is cancelled on an interrupt, so
+// long running commands can stop cleanly.
+type cli struct {
+	ctx            context.Context
+	stdin          io.Reader
+	stdout, stderr io.Writer
+}
+
+// command is a subcommand of raft-boltdb.
+type command struct {
+	// synopsis describes the command in the list of commands.
+	synopsis string
+
+	// run runs the command with the arguments that follow its name.
+	run func(c *cli, args []string) error
+}
+
+// commands are the subcommands, by name.
+var commands = map[string]command{
+	"inspect":    {"Summarize what a store holds", (*cli).inspect},
+	"dump-logs":  {"Print the log entries in a store", (*cli).dumpLogs},
+	"dump-conf":  {"Print the stable store keys in a store", (*cli).dumpConf},
+	"compact":    {"Rewrite a store without its free space", (*cli).compact},
+	"migrate":    {"Convert a store into another format", (*cli).migrate},
+	"verify":     {"Check a store for corruption", (*cli).verify},
+	"repair":     {"Remove the corrupt entries from a store", (*cli).repair},
+	"truncate":   {"Delete the log entries after an index", (*cli).truncate},
+	"reset-logs": {"Delete every log entry, keeping the stable store", (*cli).resetLogs},
+	"backup":     {"Write a copy of a store to a file or stdout", (*cli).backup},
+	"restore":    {"Write a backup to a store", (*cli).restore},
+	"stats":      {"Show how a file's pages and commits change over time", (*cli).stats},
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	code := run(os.Args[1:], &cli{ctx: ctx, stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr})
+	stop()
+	os.Exit(code)
+}
+
+// run runs the command named by args[0], returning the exit code.
+func run(args []string, c *cli) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "help" {
+		c.usage()
+		if len(args) == 0 {
+			return 2
+		}
+		return 0
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(c.stderr, "raft-boltdb: unknown command %q\n", args[0])
+		c.usage()
+		return 2
+	}
+
+	err := cmd.run(c, args[1:])
+	switch {
+	case err == nil || errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.Is(err, errUsage):
+		return 2
+	default:
+		fmt.Fprintf(c.stderr, "raft-boltdb %s: %v\n", args[0], err)
+		return 1
+	}
+}
+
+// usage prints the list of commands.
+func (c *cli) usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(c.stderr, "Usage: raft-boltdb <command> [options] [args]\n\nCommands:\n")
+	for _, name := range names {
+		fmt.Fprintf(c.stderr, "  %-12s %s\n", name, commands[name].synopsis)
+	}
+}
+
+// flags returns a flag set for the command name, whose usage line is
+// name followed by args.
+func (c *cli) flags(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(c.stderr, "Usage: raft-boltdb %s [options] %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses args into fs and returns the n positional arguments that
+// follow the options.
+func parse(fs *flag.FlagSet, args []string, n int) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, err
+		}
+		return nil, errUsage
+	}
+	if fs.NArg() != n {
+		fs.Usage()
+		return nil, errUsage
+	}
+	return fs.Args(), nil
+}
+
+// confirm asks the operator to type yes before a command changes a file,
+// returning errAborted if they don't. Nothing is asked if yes is set.
+func (c *cli) confirm(yes bool) error {
+	if yes {
+		return nil
+	}
+	fmt.Fprintf(c.stdout, "Type yes to continue: ")
+	line, _ := bufio.NewReader(c.stdin).ReadString('\n')
+	if strings.TrimSpace(line) != "yes" {
+		return errAborted
+	}
+	return nil
+}
+
+// openReadOnly opens the store at path without writing to it.
+func openReadOnly(path string) (*raftboltdb.BoltStore, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	return raftboltdb.New(raftboltdb.Options{Path: path, ReadOnly: true, LockTimeout: lockTimeout})
+}
+
+// openWritable opens the store at path, which must already exist, for a
+// command that changes it.
+func openWritable(path string) (*raftboltdb.BoltStore, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	return raftboltdb.New(raftboltdb.Options{Path: path, LockTimeout: lockTimeout})
+}
+
+// canonicalSum returns a hash of what store holds, see
+// raftboltdb.BoltStore.ExportCanonical, for checking that a copy holds
+// the same.
+func canonicalSum(store *raftboltdb.BoltStore) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	h := sha256.New()
+	if err := store.ExportCanonical(h); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+// checkFile runs raftboltdb.Verify against the store at path, returning a
+// *raftboltdb.VerifyError if it finds any problems, and otherwise the
+// hash of what the store holds.
+func checkFile(path string) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	report, err := raftboltdb.Verify(path, raftboltdb.VerifyOptions{LockTimeout: lockTimeout})
+	if err != nil {
+		return sum, err
+	}
+	if !report.OK() {
+		return sum, &raftboltdb.VerifyError{Path: path, Report: report}
+	}
+	store, err := openReadOnly(path)
+	if err != nil {
+		return sum, err
+	}
+	defer store.Close()
+	return canonicalSum(store)
+}
@@ -0,0 +1,121 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+)
+
+// testStore writes a store holding entries 1 to n and raft's current
+// term, and returns its path.
+func testStore(t *testing.T, n uint64) string {
+	path := filepath.Join(t.TempDir(), "raft.db")
+	store, err := raftboltdb.New(raftboltdb.Options{Path: path})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	var logs []*raft.Log
+	for i := uint64(1); i <= n; i++ {
+		logs = append(logs, &raft.Log{Index: i, Term: 1, Type: raft.LogCommand, Data: []byte(fmt.Sprintf("data %d", i))})
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.SetUint64([]byte("CurrentTerm"), 2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return path
+}
+
+// runCLI runs raft-boltdb with args, returning its exit code and output.
+func runCLI(args ...string) (int, string, string) {
+	return runCLIInput("", args...)
+}
+
+// runCLIInput is runCLI with stdin reading input.
+func runCLIInput(input string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, &cli{ctx: context.Background(), stdin: strings.NewReader(input), stdout: &stdout, stderr: &stderr})
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRun_Usage(t *testing.T) {
+	if code, _, stderr := runCLI(); code != 2 || !strings.Contains(stderr, "dump-logs") {
+		t.Fatalf("bad: %d %s", code, stderr)
+	}
+	if code, _, stderr := runCLI("nope"); code != 2 || !strings.Contains(stderr, `unknown command "nope"`) {
+		t.Fatalf("bad: %d %s", code, stderr)
+	}
+	if code, _, stderr := runCLI("inspect"); code != 2 || !strings.Contains(stderr, "Usage: raft-boltdb inspect") {
+		t.Fatalf("bad: %d %s", code, stderr)
+	}
+	if code, _, stderr := runCLI("inspect", filepath.Join(t.TempDir(), "missing.db")); code != 1 || !strings.Contains(stderr, "no such file") {
+		t.Fatalf("bad: %d %s", code, stderr)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	path := testStore(t, 10)
+
+	code, stdout, stderr := runCLI("inspect", path)
+	if code != 0 {
+		t.Fatalf("bad: %d %s", code, stderr)
+	}
+	for _, want := range []string{"First index:        1", "Last index:         10", "Log entries:        10", "Last compaction:    never"} {
+		if !strings.Contains(stdout, want) {
+			t.Fatalf("missing %q: %s", want, stdout)
+		}
+	}
+
+	code, stdout, stderr = runCLI("inspect", "-json", path)
+	if code != 0 || !strings.Contains(stdout, `"last_index": 10`) {
+		t.Fatalf("bad: %d %s %s", code, stdout, stderr)
+	}
+}
+
+func TestDumpLogs(t *testing.T) {
+	path := testStore(t, 10)
+
+	code, stdout, stderr := runCLI("dump-logs", "-min", "3", "-max", "4", path)
+	if code != 0 {
+		t.Fatalf("bad: %d %s", code, stderr)
+	}
+	want := "index=3 term=1 type=LogCommand data=6 extensions=0\nindex=4 term=1 type=LogCommand data=6 extensions=0\n"
+	if stdout != want {
+		t.Fatalf("bad: %q", stdout)
+	}
+
+	code, stdout, _ = runCLI("dump-logs", "-min", "10", "-format", "json", path)
+	if code != 0 || stdout != `{"index":10,"term":1,"type":"LogCommand","data":"ZGF0YSAxMA=="}`+"\n" {
+		t.Fatalf("bad: %d %q", code, stdout)
+	}
+
+	if code, _, stderr := runCLI("dump-logs", "-format", "xml", path); code != 1 || !strings.Contains(stderr, `unknown format "xml"`) {
+		t.Fatalf("bad: %d %s", code, stderr)
+	}
+}
+
+func TestDumpConf(t *testing.T) {
+	path := testStore(t, 1)
+
+	code, stdout, stderr := runCLI("dump-conf", path)
+	if code != 0 || stdout != `"CurrentTerm" = "\x00\x00\x00\x00\x00\x00\x00\x02" (uint64 2)`+"\n" {
+		t.Fatalf("bad: %d %q %s", code, stdout, stderr)
+	}
+
+	code, stdout, _ = runCLI("dump-conf", "-json", path)
+	if code != 0 || stdout != `{"key":"CurrentTerm","value":"AAAAAAAAAAI=","uint64":2}`+"\n" {
+		t.Fatalf("bad: %d %q", code, stdout)
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+)
+
+const (
+	// progressWidth is how many characters wide the progress bar is.
+	progressWidth = 30
+)
+
+// storeFormat is a kind of store that migrate converts between.
+type storeFormat struct {
+	// describe returns a summary of what the store at path holds.
+	describe func(path string) (string, error)
+}
+
+// migration converts a store in one format into a new one in another.
+type migration struct {
+	// verify is set if the migration supports MigrateOptions.Verify.
+	verify bool
+
+	run func(c *cli, src, dst string, opts raftboltdb.MigrateOptions) error
+}
+
+// storeFormats are the formats migrate knows, by name.
+var storeFormats = map[string]storeFormat{
+	"v1": {describe: func(path string) (string, error) { return describeStore(raftboltdb.EngineBoltDB, path) }},
+	"v2": {describe: func(path string) (string, error) { return describeStore(raftboltdb.EngineBbolt, path) }},
+}
+
+// migrations are the conversions migrate can make, by the names of the
+// formats they convert from and to.
+var migrations = map[[2]string]migration{
+	{"v1", "v2"}: {verify: true, run: func(c *cli, src, dst string, opts raftboltdb.MigrateOptions) error {
+		store, err := raftboltdb.MigrateToV2WithOptions(c.ctx, src, dst, opts)
+		if err != nil {
+			return err
+		}
+		return store.Close()
+	}},
+	{"v2", "v1"}: {verify: true, run: func(c *cli, src, dst string, opts raftboltdb.MigrateOptions) error {
+		return raftboltdb.MigrateFromV2WithOptions(c.ctx, src, dst, opts)
+	}},
+}
+
+// describeStore summarizes the store at path, opened read-only with
+// engine.
+func describeStore(engine raftboltdb.Engine, path string) (string, error) {
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+	store, err := raftboltdb.Open(raftboltdb.Config{
+		Engine:  engine,
+		Options: raftboltdb.Options{Path: path, ReadOnly: true, LockTimeout: lockTimeout},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer store.Close()
+	stats, err := store.LogStats()
+	if err != nil {
+		return "", err
+	}
+	if stats.LastIndex == 0 {
+		return "no log entries", nil
+	}
+	return fmt.Sprintf("log entries %d to %d", stats.FirstIndex, stats.LastIndex), nil
+}
+
+// migrate converts a store from one format into another.
+func (c *cli) migrate(args []string) error {
+	var formats []string
+	for name := range storeFormats {
+		formats = append(formats, name)
+	}
+	sort.Strings(formats)
+
+	fs := c.flags("migrate", "")
+	from := fs.String("from", "v1", "The format of the source: "+strings.Join(formats, ", ")+".")
+	to := fs.String("to", "v2", "The format to convert the source into.")
+	src := fs.String("src", "", "The store to convert, which is left as it is.")
+	dst := fs.String("dst", "", "Where to write the converted store, which mustn't exist yet.")
+	verify := fs.Bool("verify", false, "Compare the converted store with the source once it's written.")
+	dryRun := fs.Bool("dry-run", false, "Check that the conversion can be made and describe it, without writing anything.")
+	resume := fs.Bool("resume", false, "Continue an interrupted conversion from v1 to v2 into -dst.")
+	chunkSize := fs.Int("chunk-size", 0, "The most keys copied in each transaction. Defaults to 10000.")
+	quiet := fs.Bool("quiet", false, "Don't show a progress bar.")
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	if *src == "" || *dst == "" {
+		fmt.Fprintf(c.stderr, "-src and -dst are required\n")
+		fs.Usage()
+		return errUsage
+	}
+
+	srcFormat, ok := storeFormats[*from]
+	if !ok {
+		return fmt.Errorf("unknown format %q, expected one of %s", *from, strings.Join(formats, ", "))
+	}
+	if _, ok := storeFormats[*to]; !ok {
+		return fmt.Errorf("unknown format %q, expected one of %s", *to, strings.Join(formats, ", "))
+	}
+	m, ok := migrations[[2]string{*from, *to}]
+	if !ok {
+		return fmt.Errorf("can't convert from %s to %s", *from, *to)
+	}
+	if *verify && !m.verify {
+		return fmt.Errorf("-verify isn't supported converting from %s to %s", *from, *to)
+	}
+	if *resume && (*from != "v1" || *to != "v2") {
+		return fmt.Errorf("-resume is only supported converting from v1 to v2")
+	}
+	if _, err := os.Stat(*dst); err == nil && !*resume {
+		return fmt.Errorf("file exists in destination %v", *dst)
+	}
+
+	desc, err := srcFormat.describe(*src)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		fmt.Fprintf(c.stdout, "Would convert %s (%s, %s) into %s (%s)\n", *src, *from, desc, *dst, *to)
+		return nil
+	}
+
+	opts := raftboltdb.MigrateOptions{ChunkSize: *chunkSize, Verify: *verify, Resume: *resume}
+	if !*quiet {
+		opts.Progress = func(p raftboltdb.MigrateProgress) { printProgress(c.stderr, p) }
+	}
+	start := time.Now()
+	err = m.run(c, *src, *dst, opts)
+	if !*quiet {
+		fmt.Fprintln(c.stderr)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "Converted %s (%s, %s) into %s (%s) in %s\n", *src, *from, desc, *dst, *to, time.Since(start).Round(time.Millisecond))
+	if *verify {
+		fmt.Fprintf(c.stdout, "Verified %s\n", *dst)
+	}
+	return nil
+}
+
+// printProgress redraws a progress bar for p on the current line of w.
+func printProgress(w io.Writer, p raftboltdb.MigrateProgress) {
+	done := 1.0
+	if p.TotalKeys > 0 && p.Keys < p.TotalKeys {
+		done = float64(p.Keys) / float64(p.TotalKeys)
+	}
+	n := int(done * progressWidth)
+	fmt.Fprintf(w, "\r[%s%s] %3.0f%% %d/%d keys, %.1f MiB, ETA %s ",
+		strings.Repeat("=", n), strings.Repeat(" ", progressWidth-n), 100*done,
+		p.Keys, p.TotalKeys, float64(p.Bytes)/(1<<20), p.ETA.Round(time.Second))
+}
@@ -0,0 +1,73 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	v1 "github.com/hashicorp/raft-boltdb"
+)
+
+// testV1Store writes a store in the v1 format holding entries 1 to n, and
+// returns its path.
+func testV1Store(t *testing.T, n uint64) string {
+	path := filepath.Join(t.TempDir(), "raft-v1.db")
+	store, err := v1.NewBoltStore(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	var logs []*raft.Log
+	for i := uint64(1); i <= n; i++ {
+		logs = append(logs, &raft.Log{Index: i, Term: 1, Data: []byte(fmt.Sprintf("data %d", i))})
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return path
+}
+
+func TestMigrate(t *testing.T) {
+	src := testV1Store(t, 100)
+	dst := filepath.Join(t.TempDir(), "raft.db")
+
+	code, stdout, stderr := runCLI("migrate", "-src", src, "-dst", dst, "-dry-run")
+	if code != 0 || !strings.Contains(stdout, "Would convert "+src+" (v1, log entries 1 to 100) into "+dst+" (v2)") {
+		t.Fatalf("bad: %d %s %s", code, stdout, stderr)
+	}
+	if code, _, _ := runCLI("inspect", dst); code != 1 {
+		t.Fatalf("dry run shouldn't write anything")
+	}
+
+	code, stdout, stderr = runCLI("migrate", "-src", src, "-dst", dst, "-verify", "-chunk-size", "30")
+	if code != 0 || !strings.Contains(stdout, "Verified "+dst) || !strings.Contains(stderr, "100% 100/100 keys") {
+		t.Fatalf("bad: %d %s %s", code, stdout, stderr)
+	}
+	if _, stdout, _ := runCLI("inspect", dst); !strings.Contains(stdout, "Log entries:        100") {
+		t.Fatalf("bad: %s", stdout)
+	}
+
+	// And back again
+	back := filepath.Join(t.TempDir(), "raft-v1.db")
+	code, _, stderr = runCLI("migrate", "-from", "v2", "-to", "v1", "-src", dst, "-dst", back, "-verify", "-quiet")
+	if code != 0 || stderr != "" {
+		t.Fatalf("bad: %d %s", code, stderr)
+	}
+
+	for args, want := range map[string]string{
+		"-dst " + dst:                           "-src and -dst are required",
+		"-from v3 -src x -dst y":                `unknown format "v3"`,
+		"-from v2 -to v2 -src x -dst y":         "can't convert from v2 to v2",
+		"-from v2 -to v1 -resume -src x -dst y": "-resume is only supported",
+		"-src " + src + " -dst " + dst:          "file exists in destination",
+	} {
+		if _, _, stderr := runCLI(append([]string{"migrate"}, strings.Fields(args)...)...); !strings.Contains(stderr, want) {
+			t.Fatalf("%s: bad: %s", args, stderr)
+		}
+	}
+}
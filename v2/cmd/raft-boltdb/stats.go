@@ -0,0 +1,137 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+)
+
+// statsSample is what stats prints as JSON for each sample.
+type statsSample struct {
+	Time          time.Time `json:"time"`
+	FileSize      int64     `json:"file_size"`
+	PageSize      int       `json:"page_size"`
+	TxID          uint64    `json:"tx_id"`
+	Pages         uint64    `json:"pages"`
+	FreePages     uint64    `json:"free_pages"`
+	FreelistBytes int64     `json:"freelist_bytes"`
+}
+
+// stats prints the stats in a file's meta and freelist pages, once or
+// every interval. The file isn't locked, so it can be watched while the
+// node that owns it is running.
+func (c *cli) stats(args []string) error {
+	fs := c.flags("stats", "PATH")
+	watch := fs.Duration("watch", 0, "Print the stats every interval, such as 5s, along with how much they changed by, until interrupted.")
+	count := fs.Int("count", 0, "Stop after printing this many samples. Defaults to no limit.")
+	asJSON := fs.Bool("json", false, "Print a JSON object per sample.")
+	paths, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if *watch < 0 {
+		return fmt.Errorf("-watch %s is negative", *watch)
+	}
+	path := paths[0]
+
+	var ticker *time.Ticker
+	if *watch > 0 {
+		ticker = time.NewTicker(*watch)
+		defer ticker.Stop()
+	}
+	var prev *raftboltdb.FileStats
+	var prevTime time.Time
+	for n := 1; ; n++ {
+		stats, err := raftboltdb.ReadFileStats(path)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+
+		switch {
+		case *asJSON:
+			err = json.NewEncoder(c.stdout).Encode(&statsSample{
+				Time:          now.UTC(),
+				FileSize:      stats.FileSize,
+				PageSize:      stats.PageSize,
+				TxID:          stats.TxID,
+				Pages:         stats.Pages,
+				FreePages:     stats.FreePages,
+				FreelistBytes: stats.FreelistBytes,
+			})
+		case ticker == nil:
+			err = c.printStats(path, stats)
+		default:
+			if prev != nil && stats.TxID < prev.TxID {
+				// The file was compacted or replaced, so start again
+				fmt.Fprintf(c.stdout, "%s was replaced\n", path)
+				prev = nil
+			}
+			c.printStatsRow(prev == nil, now, stats, prev, now.Sub(prevTime))
+		}
+		if err != nil {
+			return err
+		}
+		if ticker == nil || n == *count {
+			return nil
+		}
+		prev, prevTime = stats, now
+
+		select {
+		case <-ticker.C:
+		case <-c.ctx.Done():
+			return nil
+		}
+	}
+}
+
+// printStats prints a single sample of a file's stats.
+func (c *cli) printStats(path string, stats *raftboltdb.FileStats) error {
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Path:\t%s\n", path)
+	fmt.Fprintf(w, "File size:\t%d bytes\n", stats.FileSize)
+	fmt.Fprintf(w, "Page size:\t%d bytes\n", stats.PageSize)
+	fmt.Fprintf(w, "Commits:\t%d\n", stats.TxID)
+	fmt.Fprintf(w, "Pages:\t%d\n", stats.Pages)
+	if stats.FreelistBytes == 0 {
+		fmt.Fprintf(w, "Free pages:\tunknown, the freelist isn't synced\n")
+	} else {
+		fmt.Fprintf(w, "Free pages:\t%d\n", stats.FreePages)
+		fmt.Fprintf(w, "Freelist size:\t%d bytes\n", stats.FreelistBytes)
+	}
+	return w.Flush()
+}
+
+// printStatsRow prints a sample of a file's stats as a row of a table,
+// along with how much they changed since prev, which took elapsed.
+func (c *cli) printStatsRow(header bool, now time.Time, stats, prev *raftboltdb.FileStats, elapsed time.Duration) {
+	const format = "%-8s  %10s  %9s  %12s  %10s  %8s  %7s  %8s  %7s  %10s  %8s\n"
+	if header {
+		fmt.Fprintf(c.stdout, format, "TIME", "COMMITS", "COMMITS/S", "FILE SIZE", "+/-", "PAGES", "+/-", "FREE", "+/-", "FREELIST", "+/-")
+	}
+	if prev == nil {
+		prev, elapsed = stats, 0
+	}
+	rate := "-"
+	if elapsed > 0 {
+		rate = fmt.Sprintf("%.1f", float64(stats.TxID-prev.TxID)/elapsed.Seconds())
+	}
+	fmt.Fprintf(c.stdout, format,
+		now.Format("15:04:05"),
+		fmt.Sprint(stats.TxID), rate,
+		fmt.Sprint(stats.FileSize), delta(stats.FileSize, prev.FileSize),
+		fmt.Sprint(stats.Pages), delta(int64(stats.Pages), int64(prev.Pages)),
+		fmt.Sprint(stats.FreePages), delta(int64(stats.FreePages), int64(prev.FreePages)),
+		fmt.Sprint(stats.FreelistBytes), delta(stats.FreelistBytes, prev.FreelistBytes))
+}
+
+// delta formats the change from prev to cur with its sign.
+func delta(cur, prev int64) string {
+	return fmt.Sprintf("%+d", cur-prev)
+}
@@ -0,0 +1,45 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+)
+
+func TestStats(t *testing.T) {
+	path := testStore(t, 10)
+
+	code, stdout, stderr := runCLI("stats", path)
+	if code != 0 || !strings.Contains(stdout, "Page size:") || !strings.Contains(stdout, "Commits:") {
+		t.Fatalf("bad: %d %s %s", code, stdout, stderr)
+	}
+
+	code, stdout, stderr = runCLI("stats", "-json", path)
+	if code != 0 || !strings.Contains(stdout, `"tx_id":`) {
+		t.Fatalf("bad: %d %s %s", code, stdout, stderr)
+	}
+}
+
+func TestStats_Watch(t *testing.T) {
+	path := testStore(t, 10)
+
+	// The file can be watched while a store has it open
+	store, err := raftboltdb.New(raftboltdb.Options{Path: path})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	code, stdout, stderr := runCLI("stats", "-watch", "10ms", "-count", "3", path)
+	if code != 0 {
+		t.Fatalf("bad: %d %s", code, stderr)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "TIME") || !strings.Contains(lines[2], "+0") {
+		t.Fatalf("bad: %q", lines)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+// truncate deletes the log entries after an index, for when an entry
+// that crashes the FSM on every start has to be removed by hand.
+func (c *cli) truncate(args []string) error {
+	fs := c.flags("truncate", "-after INDEX PATH")
+	after := fs.Uint64("after", 0, "Delete every log entry after this index. Entries up to and including it are kept.")
+	yes := fs.Bool("yes", false, "Don't ask for confirmation before changing the store.")
+	paths, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	set := false
+	fs.Visit(func(f *flag.Flag) { set = set || f.Name == "after" })
+	if !set {
+		fmt.Fprintf(c.stderr, "-after is required\n")
+		fs.Usage()
+		return errUsage
+	}
+	path := paths[0]
+
+	store, err := openWritable(path)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	first, err := store.FirstIndex()
+	if err != nil {
+		return err
+	}
+	last, err := store.LastIndex()
+	if err != nil {
+		return err
+	}
+	if last <= *after {
+		fmt.Fprintf(c.stdout, "%s has no log entries after index %d, the last is %d\n", path, *after, last)
+		return nil
+	}
+	min := *after + 1
+	if min < first {
+		min = first
+	}
+
+	fmt.Fprintf(c.stdout, "This will permanently delete every log entry from index %d to %d (%d entries) from %s.\n", min, last, last-min+1, path)
+	fmt.Fprintf(c.stdout, "Entries that were committed are lost from this node, so only do this as part of a recovery, with the node stopped and a copy of the file taken.\n")
+	if err := c.confirm(*yes); err != nil {
+		return err
+	}
+	if err := store.DeleteRange(min, last); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "Deleted log entries %d to %d\n", min, last)
+	return nil
+}
+
+// resetLogs deletes every log entry but keeps the stable store, so raft's
+// term and vote survive and the node can't vote twice in a term.
+func (c *cli) resetLogs(args []string) error {
+	fs := c.flags("reset-logs", "-keep-stable PATH")
+	keepStable := fs.Bool("keep-stable", false, "Keep the stable store keys, such as raft's current term and last vote. This is the only mode, to wipe those too delete the file.")
+	yes := fs.Bool("yes", false, "Don't ask for confirmation before changing the store.")
+	paths, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if !*keepStable {
+		fmt.Fprintf(c.stderr, "-keep-stable is required\n")
+		fs.Usage()
+		return errUsage
+	}
+	path := paths[0]
+
+	store, err := openWritable(path)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	first, err := store.FirstIndex()
+	if err != nil {
+		return err
+	}
+	last, err := store.LastIndex()
+	if err != nil {
+		return err
+	}
+	term, err := store.CurrentTerm()
+	if err != nil {
+		return err
+	}
+	voteTerm, candidate, err := store.LastVote()
+	if err != nil {
+		return err
+	}
+
+	if last == 0 {
+		fmt.Fprintf(c.stdout, "%s has no log entries\n", path)
+		return nil
+	}
+	fmt.Fprintf(c.stdout, "This will permanently delete every log entry, indexes %d to %d (%d entries), from %s.\n", first, last, last-first+1, path)
+	fmt.Fprintf(c.stdout, "The stable store is kept, including current term %d and last vote %q in term %d.\n", term, candidate, voteTerm)
+	fmt.Fprintf(c.stdout, "The node must be stopped, and be given its log again from a snapshot or the leader.\n")
+	if err := c.confirm(*yes); err != nil {
+		return err
+	}
+	if err := store.DeleteRange(first, last); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "Deleted log entries %d to %d\n", first, last)
+	return nil
+}
@@ -0,0 +1,67 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTruncate(t *testing.T) {
+	path := testStore(t, 10)
+
+	if code, _, stderr := runCLI("truncate", path); code != 2 || !strings.Contains(stderr, "-after is required") {
+		t.Fatalf("bad: %d %s", code, stderr)
+	}
+	code, stdout, stderr := runCLI("truncate", "-after", "10", path)
+	if code != 0 || !strings.Contains(stdout, "no log entries after index 10") {
+		t.Fatalf("bad: %d %s %s", code, stdout, stderr)
+	}
+
+	code, stdout, stderr = runCLIInput("no\n", "truncate", "-after", "6", path)
+	if code != 1 || !strings.Contains(stdout, "from index 7 to 10 (4 entries)") || !strings.Contains(stderr, "aborted") {
+		t.Fatalf("bad: %d %s %s", code, stdout, stderr)
+	}
+
+	code, stdout, stderr = runCLIInput("yes\n", "truncate", "-after", "6", path)
+	if code != 0 || !strings.Contains(stdout, "Deleted log entries 7 to 10") {
+		t.Fatalf("bad: %d %s %s", code, stdout, stderr)
+	}
+
+	store, err := openReadOnly(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	first, _ := store.FirstIndex()
+	last, _ := store.LastIndex()
+	if first != 1 || last != 6 {
+		t.Fatalf("bad: %d %d", first, last)
+	}
+}
+
+func TestResetLogs(t *testing.T) {
+	path := testStore(t, 10)
+
+	if code, _, stderr := runCLI("reset-logs", path); code != 2 || !strings.Contains(stderr, "-keep-stable is required") {
+		t.Fatalf("bad: %d %s", code, stderr)
+	}
+
+	code, stdout, stderr := runCLI("reset-logs", "-keep-stable", "-yes", path)
+	if code != 0 || !strings.Contains(stdout, "current term 2") || !strings.Contains(stdout, "Deleted log entries 1 to 10") {
+		t.Fatalf("bad: %d %s %s", code, stdout, stderr)
+	}
+
+	store, err := openReadOnly(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	if last, _ := store.LastIndex(); last != 0 {
+		t.Fatalf("bad: %d", last)
+	}
+	if term, _ := store.CurrentTerm(); term != 2 {
+		t.Fatalf("bad: %d", term)
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+)
+
+// verifyResult is what verify prints as JSON.
+type verifyResult struct {
+	Path       string          `json:"path"`
+	OK         bool            `json:"ok"`
+	Logs       uint64          `json:"logs"`
+	FirstIndex uint64          `json:"first_index"`
+	LastIndex  uint64          `json:"last_index"`
+	Problems   []verifyProblem `json:"problems"`
+	Truncated  bool            `json:"truncated,omitempty"`
+}
+
+// verifyProblem is how verify prints a raftboltdb.Problem in JSON.
+type verifyProblem struct {
+	Kind  raftboltdb.ProblemKind `json:"kind"`
+	Index uint64                 `json:"index,omitempty"`
+	Error string                 `json:"error"`
+}
+
+// verify runs the integrity checks against a store.
+func (c *cli) verify(args []string) error {
+	fs := c.flags("verify", "PATH")
+	skipStructure := fs.Bool("skip-structure", false, "Skip the page level consistency check, which can be slow on very large files.")
+	maxProblems := fs.Int("max-problems", 0, "Stop once this many problems have been found. Defaults to no limit.")
+	asJSON := fs.Bool("json", false, "Print the report as JSON.")
+	paths, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	report, err := runVerify(paths[0], raftboltdb.VerifyOptions{
+		SkipStructureCheck: *skipStructure,
+		MaxProblems:        *maxProblems,
+	})
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		res := verifyResult{
+			Path:       paths[0],
+			OK:         report.OK(),
+			Logs:       report.Logs,
+			FirstIndex: report.FirstIndex,
+			LastIndex:  report.LastIndex,
+			Problems:   []verifyProblem{},
+			Truncated:  report.Truncated,
+		}
+		for _, p := range report.Problems {
+			res.Problems = append(res.Problems, verifyProblem{Kind: p.Kind, Index: p.Index, Error: p.Err.Error()})
+		}
+		enc := json.NewEncoder(c.stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(&res); err != nil {
+			return err
+		}
+	} else {
+		c.printReport(paths[0], report)
+	}
+	if !report.OK() {
+		return &raftboltdb.VerifyError{Path: paths[0], Report: report}
+	}
+	return nil
+}
+
+// runVerify runs raftboltdb.Verify against the store at path, which must
+// exist.
+func runVerify(path string, opts raftboltdb.VerifyOptions) (*raftboltdb.VerifyReport, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	opts.LockTimeout = lockTimeout
+	return raftboltdb.Verify(path, opts)
+}
+
+// printReport prints what an integrity check of the store at path found.
+func (c *cli) printReport(path string, report *raftboltdb.VerifyReport) {
+	if report.LastIndex == 0 {
+		fmt.Fprintf(c.stdout, "Checked %s: %d log entries\n", path, report.Logs)
+	} else {
+		fmt.Fprintf(c.stdout, "Checked %s: %d log entries, indexes %d to %d\n", path, report.Logs, report.FirstIndex, report.LastIndex)
+	}
+	if report.OK() {
+		fmt.Fprintf(c.stdout, "No problems found\n")
+		return
+	}
+	fmt.Fprintf(c.stdout, "Found %d problem(s):\n", len(report.Problems))
+	for _, p := range report.Problems {
+		fmt.Fprintf(c.stdout, "  %s\n", p)
+	}
+	if report.Truncated {
+		fmt.Fprintf(c.stdout, "  ... stopped after -max-problems, there may be more\n")
+	}
+}
+
+// repair fixes the problems in a store that can be fixed without the rest
+// of the cluster, after asking the operator to confirm.
+func (c *cli) repair(args []string) error {
+	fs := c.flags("repair", "-truncate-corrupt-tail PATH")
+	truncateTail := fs.Bool("truncate-corrupt-tail", false, "Delete the log from the first entry that can't be read onwards, along with keys that can't be log indexes. The stable store keys are kept. Raft restores the deleted entries from the leader once the node rejoins its cluster.")
+	yes := fs.Bool("yes", false, "Don't ask for confirmation before changing the store.")
+	paths, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if !*truncateTail {
+		fmt.Fprintf(c.stderr, "-truncate-corrupt-tail is required\n")
+		fs.Usage()
+		return errUsage
+	}
+	path := paths[0]
+
+	report, err := runVerify(path, raftboltdb.VerifyOptions{})
+	if err != nil {
+		return err
+	}
+	c.printReport(path, report)
+	if report.OK() {
+		return nil
+	}
+
+	// Work out what a salvage would remove, the same way it does, so the
+	// operator knows what they're agreeing to.
+	var truncateAt uint64
+	badKeys := 0
+	for _, p := range report.Problems {
+		switch p.Kind {
+		case raftboltdb.ProblemDecode, raftboltdb.ProblemChecksum, raftboltdb.ProblemIndexMismatch:
+			if truncateAt == 0 || p.Index < truncateAt {
+				truncateAt = p.Index
+			}
+		case raftboltdb.ProblemKeyLength:
+			badKeys++
+		}
+	}
+	if truncateAt == 0 && badKeys == 0 {
+		return fmt.Errorf("none of the problems can be repaired by truncating the log, restore %s from a backup or replace it with an empty store and let the node rejoin its cluster", path)
+	}
+
+	fmt.Fprintf(c.stdout, "\nThis will permanently delete from %s:\n", path)
+	if truncateAt != 0 {
+		fmt.Fprintf(c.stdout, "  every log entry from index %d to %d\n", truncateAt, report.LastIndex)
+	}
+	if badKeys != 0 {
+		fmt.Fprintf(c.stdout, "  %d key(s) that aren't log indexes\n", badKeys)
+	}
+	fmt.Fprintf(c.stdout, "Make sure the node is stopped and that you have a copy of the file before going ahead.\n")
+	if err := c.confirm(*yes); err != nil {
+		return err
+	}
+
+	report, err = runVerify(path, raftboltdb.VerifyOptions{Salvage: true})
+	if err != nil {
+		return err
+	}
+	if s := report.Salvage; s != nil {
+		fmt.Fprintf(c.stdout, "Removed %d log entries and %d bad key(s)\n", s.Logs, s.BadKeys)
+	}
+
+	// Check again, so anything the salvage couldn't fix is reported
+	report, err = runVerify(path, raftboltdb.VerifyOptions{})
+	if err != nil {
+		return err
+	}
+	if !report.OK() {
+		c.printReport(path, report)
+		return &raftboltdb.VerifyError{Path: path, Report: report}
+	}
+	fmt.Fprintf(c.stdout, "%s has no problems left\n", path)
+	return nil
+}
@@ -0,0 +1,107 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"go.etcd.io/bbolt"
+)
+
+// testCorruptStore writes a store holding entries 1 to n, with the entry
+// at index bad replaced by bytes that can't be decoded, and returns its
+// path.
+func testCorruptStore(t *testing.T, n, bad uint64) string {
+	path := testStore(t, n)
+	db, err := bbolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer db.Close()
+	err = db.Update(func(tx *bbolt.Tx) error {
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, bad)
+		return tx.Bucket([]byte("logs")).Put(key, []byte{0xc1})
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return path
+}
+
+func TestVerify(t *testing.T) {
+	path := testStore(t, 10)
+	code, stdout, stderr := runCLI("verify", path)
+	if code != 0 || !strings.Contains(stdout, "10 log entries, indexes 1 to 10") || !strings.Contains(stdout, "No problems found") {
+		t.Fatalf("bad: %d %s %s", code, stdout, stderr)
+	}
+
+	path = testCorruptStore(t, 10, 7)
+	code, stdout, stderr = runCLI("verify", path)
+	if code != 1 || !strings.Contains(stdout, "decode at index 7") || !strings.Contains(stderr, "integrity check failed") {
+		t.Fatalf("bad: %d %s %s", code, stdout, stderr)
+	}
+	code, stdout, stderr = runCLI("verify", "-json", path)
+	if code != 1 || !strings.Contains(stdout, `"ok": false`) || !strings.Contains(stdout, `"kind": "decode"`) {
+		t.Fatalf("bad: %d %s %s", code, stdout, stderr)
+	}
+}
+
+func TestRepair(t *testing.T) {
+	path := testCorruptStore(t, 10, 7)
+
+	if code, _, stderr := runCLI("repair", path); code != 2 || !strings.Contains(stderr, "-truncate-corrupt-tail is required") {
+		t.Fatalf("bad: %d %s", code, stderr)
+	}
+
+	// Anything but yes leaves the store alone
+	code, stdout, stderr := runCLIInput("no\n", "repair", "-truncate-corrupt-tail", path)
+	if code != 1 || !strings.Contains(stdout, "every log entry from index 7 to 10") || !strings.Contains(stderr, "aborted") {
+		t.Fatalf("bad: %d %s %s", code, stdout, stderr)
+	}
+	if code, _, _ := runCLI("verify", path); code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+
+	code, stdout, stderr = runCLIInput("yes\n", "repair", "-truncate-corrupt-tail", path)
+	if code != 0 || !strings.Contains(stdout, "Removed 4 log entries") || !strings.Contains(stdout, "no problems left") {
+		t.Fatalf("bad: %d %s %s", code, stdout, stderr)
+	}
+
+	store, err := openReadOnly(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	if last, _ := store.LastIndex(); last != 6 {
+		t.Fatalf("bad: %d", last)
+	}
+	if term, _ := store.GetUint64([]byte("CurrentTerm")); term != 2 {
+		t.Fatalf("bad: %d", term)
+	}
+	marker, err := store.SalvageMarker()
+	if err != nil || marker.TruncatedAt != 7 {
+		t.Fatalf("bad: %v %v", marker, err)
+	}
+}
+
+func TestRepair_Yes(t *testing.T) {
+	path := testCorruptStore(t, 10, 3)
+	code, stdout, stderr := runCLI("repair", "-truncate-corrupt-tail", "-yes", path)
+	if code != 0 || strings.Contains(stdout, "Type yes") || !strings.Contains(stdout, "Removed 8 log entries") {
+		t.Fatalf("bad: %d %s %s", code, stdout, stderr)
+	}
+
+	// A healthy store needs nothing doing
+	code, stdout, stderr = runCLI("repair", "-truncate-corrupt-tail", path)
+	if code != 0 || !strings.Contains(stdout, "No problems found") {
+		t.Fatalf("bad: %d %s %s", code, stdout, stderr)
+	}
+	if _, err := raftboltdb.Verify(path, raftboltdb.VerifyOptions{}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
@@ -0,0 +1,389 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+const (
+	// compactTxMaxSize is roughly how many bytes are copied into the new
+	// file in each transaction, which bounds the memory used by Compact.
+	compactTxMaxSize = 16 << 20
+
+	// compactSuffix is added to the store's path to name the file it's
+	// compacted into.
+	compactSuffix = ".compact"
+)
+
+// Compact rewrites the store into a new file holding only the live data,
+// and then atomically replaces the current file with it. Bbolt files
+// never shrink, and once the log has been truncated the freed pages stay
+// on the freelist, which is written on every commit. Compacting returns
+// that space to the filesystem and empties the freelist.
+//
+// The store stays open throughout, but other operations wait until
+// Compact has finished, as the file must not change while it's copied.
+// Compact gives up and leaves the store as it was if ctx is done before
+// the copy has finished.
+func (b *BoltStore) Compact(ctx context.Context) error {
+	if b.readOnly {
+		return ErrReadOnly
+	}
+	start := time.Now()
+
+	b.connLock.Lock()
+	defer b.connLock.Unlock()
+
+	// Start a write transaction so we wait for any that are in flight
+	// to commit. New ones are held up by connLock.
+	tx, err := b.beginLocked(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	oldSize := tx.Size()
+
+	// Anything left over from an earlier attempt is of no use
+	tmpPath := b.path + compactSuffix
+	if err := os.Remove(tmpPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	fi, err := os.Stat(b.path)
+	if err != nil {
+		return err
+	}
+	if err := b.compactTo(ctx, tx, tmpPath, fi.Mode().Perm()); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// Replace the file while we still hold the lock on the old one, so
+	// no other process can open it in between
+	if err := os.Rename(tmpPath, b.path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	tx.Rollback()
+	if err := b.reopen(); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(b.path)); err != nil {
+		return err
+	}
+
+	newSize, _ := b.fileSize()
+	b.compactedSize.Store(newSize)
+	b.metrics.measureSince([]string{"compact"}, start)
+	b.logger.Info("compacted bolt file", "path", b.path, "duration", time.Since(start),
+		"old_size", oldSize, "new_size", newSize)
+	return nil
+}
+
+// CompactTo writes a compacted copy of the store to a new file at path,
+// like Compact, but leaves the store's own file as it is, so it can be
+// used on a store opened read-only. The copy is read from a single read
+// transaction, so writes aren't held up and those made meanwhile aren't
+// included. If ctx is done before the copy has finished, nothing is left
+// at path.
+func (b *BoltStore) CompactTo(ctx context.Context, path string) error {
+	start := time.Now()
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("file exists in destination %v", path)
+	}
+	fi, err := os.Stat(b.path)
+	if err != nil {
+		return err
+	}
+
+	tx, err := b.begin(false)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := b.compactTo(ctx, tx, path, fi.Mode().Perm()); err != nil {
+		os.Remove(path)
+		return err
+	}
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	b.metrics.measureSince([]string{"compactTo"}, start)
+	return nil
+}
+
+// compactTo copies everything visible in tx to a new file at path, and
+// records the time of the compaction in it.
+func (b *BoltStore) compactTo(ctx context.Context, tx *bbolt.Tx, path string, mode os.FileMode) error {
+	opts := *b.boltOptions
+	opts.Timeout = 0
+	opts.ReadOnly = false
+	opts.NoSync = true
+	dst, err := bbolt.Open(path, mode, &opts)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	c := &compactor{ctx: ctx, dst: dst, fillPercent: b.logsFillPercent}
+	if err := c.next(); err != nil {
+		return err
+	}
+	defer func() {
+		if c.tx != nil {
+			c.tx.Rollback()
+		}
+	}()
+
+	err = tx.ForEach(func(name []byte, src *bbolt.Bucket) error {
+		return c.copyBucket(src, [][]byte{name})
+	})
+	if err != nil {
+		return err
+	}
+
+	conf := c.tx.Bucket(dbConf)
+	if conf == nil {
+		return fmt.Errorf("%w: %q in %s", ErrBucketMissing, dbConf, b.path)
+	}
+	if err := conf.Put(dbLastCompactionKey, uint64ToBytes(uint64(time.Now().UnixNano()))); err != nil {
+		return err
+	}
+	err = c.tx.Commit()
+	c.tx = nil
+	if err != nil {
+		return err
+	}
+	if err := dst.Sync(); err != nil {
+		return err
+	}
+	return dst.Close()
+}
+
+// reopen replaces conn with a handle on the file now at the store's
+// path, carrying over the settings that can be changed while it's open.
+// It must be called with connLock held. Open snapshots are ended with
+// ErrClosed, as the old handle can't be closed while they're reading it.
+// If the file can't be opened the store is left closed.
+func (b *BoltStore) reopen() error {
+	b.endReads(ErrClosed)
+	old := b.conn
+	if err := old.Close(); err != nil {
+		b.closed.Store(true)
+		return err
+	}
+	handle, err := bbolt.Open(b.path, dbFileMode, b.boltOptions)
+	if err != nil {
+		b.closed.Store(true)
+		return fmt.Errorf("failed reopening %s: %w", b.path, openError(b.path, err))
+	}
+	handle.NoSync = old.NoSync
+	handle.MaxBatchSize = old.MaxBatchSize
+	handle.MaxBatchDelay = old.MaxBatchDelay
+	b.raiseAllocSize(handle)
+	b.conn = handle
+	return nil
+}
+
+// fileSize returns the size of the store's file.
+func (b *BoltStore) fileSize() (int64, error) {
+	fi, err := os.Stat(b.path)
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// compactor copies buckets into a new file, committing every
+// compactTxMaxSize bytes so a large file doesn't have to fit in memory.
+type compactor struct {
+	ctx         context.Context
+	dst         *bbolt.DB
+	tx          *bbolt.Tx
+	size        int
+	fillPercent float64
+}
+
+// next commits the current transaction, if any, and starts another.
+func (c *compactor) next() error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+	if c.tx != nil {
+		err := c.tx.Commit()
+		c.tx = nil
+		if err != nil {
+			return err
+		}
+	}
+	tx, err := c.dst.Begin(true)
+	if err != nil {
+		return err
+	}
+	c.tx = tx
+	c.size = 0
+	return nil
+}
+
+// bucket returns the bucket at path in the current transaction,
+// creating it if needed. Buckets must be looked up again after next, as
+// they belong to the transaction.
+func (c *compactor) bucket(path [][]byte) (*bbolt.Bucket, error) {
+	b, err := c.tx.CreateBucketIfNotExists(path[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range path[1:] {
+		if b, err = b.CreateBucketIfNotExists(name); err != nil {
+			return nil, err
+		}
+	}
+
+	// The logs bucket and its segments are only ever appended to, so
+	// they're packed as tightly as the store packs them
+	if bytes.Equal(path[0], dbLogs) {
+		b.FillPercent = c.fillPercent
+	}
+	return b, nil
+}
+
+// copyBucket copies src and everything nested in it to path.
+func (c *compactor) copyBucket(src *bbolt.Bucket, path [][]byte) error {
+	dst, err := c.bucket(path)
+	if err != nil {
+		return err
+	}
+	if err := dst.SetSequence(src.Sequence()); err != nil {
+		return err
+	}
+	return src.ForEach(func(k, v []byte) error {
+		if v == nil {
+			nested := append(append([][]byte(nil), path...), k)
+			if err := c.copyBucket(src.Bucket(k), nested); err != nil {
+				return err
+			}
+			dst, err = c.bucket(path)
+			return err
+		}
+
+		if c.size+len(k)+len(v) > compactTxMaxSize {
+			if err := c.next(); err != nil {
+				return err
+			}
+			if dst, err = c.bucket(path); err != nil {
+				return err
+			}
+		}
+		c.size += len(k) + len(v)
+		return dst.Put(k, v)
+	})
+}
+
+// syncDir fsyncs a directory so a rename within it is durable. This
+// isn't possible on Windows, where it's skipped.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+const (
+	// Compaction is recommended once this much space could be reclaimed,
+	// as long as it's at least compactionMinRatio of the file.
+	compactionMinReclaimable = 64 << 20
+	compactionMinRatio       = 0.5
+
+	// Compaction is also recommended once the freelist is this large,
+	// unless it isn't being written on each commit.
+	compactionMaxFreelist = 1 << 20
+)
+
+// CompactionAdvice estimates how much Compact would achieve.
+type CompactionAdvice struct {
+	// FileSize is the current size of the file.
+	FileSize int64
+
+	// FreeBytes is the size of the pages that are free, or will be once
+	// no open transaction needs them.
+	FreeBytes int64
+
+	// FragmentedBytes is the space left unused inside the pages that are
+	// in use, which compaction packs together.
+	FragmentedBytes int64
+
+	// ReclaimableBytes is FreeBytes plus FragmentedBytes, roughly how
+	// much smaller the file would be after compaction.
+	ReclaimableBytes int64
+
+	// FreelistBytes is the size of the freelist, which is written on
+	// every commit unless Options.NoFreelistSync is set.
+	FreelistBytes int
+
+	// Recommended is set if compaction is worthwhile, and Reason says
+	// why.
+	Recommended bool
+	Reason      string
+}
+
+// CompactionAdvice estimates the space compaction would reclaim, and
+// whether it's recommended. It walks every page in use, so while it
+// doesn't block writers it's not meant to be called on a hot path.
+func (b *BoltStore) CompactionAdvice() (*CompactionAdvice, error) {
+	tx, err := b.begin(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stats := tx.DB().Stats()
+	advice := &CompactionAdvice{
+		FileSize:      tx.Size(),
+		FreeBytes:     int64(stats.FreeAlloc),
+		FreelistBytes: stats.FreelistInuse,
+	}
+	err = tx.ForEach(func(_ []byte, bucket *bbolt.Bucket) error {
+		s := bucket.Stats()
+		advice.FragmentedBytes += int64(s.BranchAlloc - s.BranchInuse + s.LeafAlloc - s.LeafInuse)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	advice.ReclaimableBytes = advice.FreeBytes + advice.FragmentedBytes
+
+	switch {
+	case advice.ReclaimableBytes >= compactionMinReclaimable &&
+		float64(advice.ReclaimableBytes) >= float64(advice.FileSize)*compactionMinRatio:
+		advice.Recommended = true
+		advice.Reason = fmt.Sprintf("%d of %d bytes are reclaimable", advice.ReclaimableBytes, advice.FileSize)
+	case advice.FreelistBytes >= compactionMaxFreelist && !b.boltOptions.NoFreelistSync:
+		advice.Recommended = true
+		advice.Reason = fmt.Sprintf("the freelist is %d bytes and is written on every commit", advice.FreelistBytes)
+	}
+	return advice, nil
+}
+
+// NeedsCompaction reports whether compacting the store is recommended,
+// see CompactionAdvice.
+func (b *BoltStore) NeedsCompaction() (bool, error) {
+	advice, err := b.CompactionAdvice()
+	if err != nil {
+		return false, err
+	}
+	return advice.Recommended, nil
+}
@@ -0,0 +1,255 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+// testFragmentedStore fills a store and then deletes most of the log,
+// leaving the file mostly free pages.
+func testFragmentedStore(t *testing.T, store *BoltStore) {
+	t.Helper()
+	data := string(bytes.Repeat([]byte("x"), 1024))
+	for i := uint64(1); i <= 2000; i += 100 {
+		var logs []*raft.Log
+		for j := i; j < i+100; j++ {
+			logs = append(logs, testRaftLog(j, data))
+		}
+		if err := store.StoreLogs(logs); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	if err := store.DeleteRange(1, 1900); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Set([]byte("foo"), []byte("bar")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestBoltStore_Compact(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+	testFragmentedStore(t, store)
+
+	before, err := store.fileSize()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Writes made while compacting wait for it, and aren't lost
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := uint64(2001); i <= 2050; i++ {
+			if err := store.StoreLog(testRaftLog(i, "data")); err != nil {
+				t.Errorf("err: %s", err)
+			}
+		}
+	}()
+	if err := store.Compact(context.Background()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	wg.Wait()
+
+	after, err := store.fileSize()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if after >= before/2 {
+		t.Fatalf("expected the file to shrink from %d bytes, got %d", before, after)
+	}
+	if _, err := os.Stat(store.path + compactSuffix); !os.IsNotExist(err) {
+		t.Fatalf("expected the temporary file to be gone, got: %v", err)
+	}
+
+	checkIndexes(t, store, 1901, 2050)
+	checkLogs(t, store, 1901, 2050)
+	if val, err := store.Get([]byte("foo")); err != nil || string(val) != "bar" {
+		t.Fatalf("bad: %q %v", val, err)
+	}
+	stats, err := store.LogStats()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if stats.LastCompaction.IsZero() {
+		t.Fatalf("expected the compaction time to be recorded")
+	}
+
+	// The replaced file is what's opened from now on
+	store.Close()
+	store, err = NewBoltStore(store.path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	store.indexes.Store(nil)
+	checkIndexes(t, store, 1901, 2050)
+}
+
+func TestBoltStore_CompactTo(t *testing.T) {
+	store := testBoltStore(t)
+	defer os.Remove(store.path)
+	testFragmentedStore(t, store)
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The copy can be made from a store opened read-only
+	ro, err := New(Options{Path: store.path, ReadOnly: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer ro.Close()
+	path := store.path + ".copy"
+	defer os.Remove(path)
+	if err := ro.CompactTo(context.Background(), path); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := ro.CompactTo(context.Background(), path); err == nil {
+		t.Fatalf("should fail when the destination exists")
+	}
+
+	before, _ := ro.fileSize()
+	copied, err := New(Options{Path: path, ReadOnly: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer copied.Close()
+	after, _ := copied.fileSize()
+	if after >= before/2 {
+		t.Fatalf("bad: %d >= %d / 2", after, before)
+	}
+	stats, err := copied.LogStats()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if stats.FirstIndex != 1901 || stats.LastIndex != 2000 || stats.LastCompaction.IsZero() {
+		t.Fatalf("bad: %+v", stats)
+	}
+	if val, err := copied.Get([]byte("foo")); err != nil || string(val) != "bar" {
+		t.Fatalf("bad: %q %v", val, err)
+	}
+}
+
+func TestBoltStore_Compact_Segments(t *testing.T) {
+	store := testSegmentedStore(t, 64)
+	defer store.Close()
+	defer os.Remove(store.path)
+	testFragmentedStore(t, store)
+
+	if err := store.Compact(context.Background()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	checkLogs(t, store, 1901, 2000)
+	report, err := store.verify(VerifyOptions{})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !report.OK() || report.Logs != 100 {
+		t.Fatalf("bad: %#v", report)
+	}
+	if starts := segmentStarts(t, store); len(starts) != 3 || starts[0] != 1856 {
+		t.Fatalf("bad: %v", starts)
+	}
+}
+
+func TestBoltStore_Compact_Cancelled(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+	testFragmentedStore(t, store)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := store.Compact(ctx); err != context.Canceled {
+		t.Fatalf("expected cancelled error, got: %v", err)
+	}
+	if _, err := os.Stat(store.path + compactSuffix); !os.IsNotExist(err) {
+		t.Fatalf("expected the temporary file to be gone, got: %v", err)
+	}
+
+	// The store carries on with the original file
+	if err := store.StoreLog(testRaftLog(2001, "data")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	checkLogs(t, store, 1901, 2001)
+
+	store.Close()
+	if err := store.Compact(context.Background()); err != ErrClosed {
+		t.Fatalf("expected closed error, got: %v", err)
+	}
+	readOnly, err := NewReadOnlyStore(store.path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer readOnly.Close()
+	if err := readOnly.Compact(context.Background()); err != ErrReadOnly {
+		t.Fatalf("expected read-only error, got: %v", err)
+	}
+}
+
+func TestBoltStore_CompactionAdvice(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	advice, err := store.CompactionAdvice()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if advice.Recommended || advice.FileSize == 0 {
+		t.Fatalf("bad: %#v", advice)
+	}
+
+	// Most of the file is free once the log has been truncated, but it's
+	// too small to be worth compacting
+	testFragmentedStore(t, store)
+	advice, err = store.CompactionAdvice()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if advice.Recommended || advice.FreeBytes < advice.FileSize/2 ||
+		advice.ReclaimableBytes != advice.FreeBytes+advice.FragmentedBytes {
+		t.Fatalf("bad: %#v", advice)
+	}
+
+	// A big enough log is
+	data := string(bytes.Repeat([]byte("x"), 64<<10))
+	for i := uint64(2001); i <= 3200; i += 100 {
+		var logs []*raft.Log
+		for j := i; j < i+100; j++ {
+			logs = append(logs, testRaftLog(j, data))
+		}
+		if err := store.StoreLogs(logs); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	if err := store.DeleteRange(1901, 3100); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	needed, err := store.NeedsCompaction()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !needed {
+		t.Fatalf("expected compaction to be recommended")
+	}
+
+	if err := store.Compact(context.Background()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if needed, err := store.NeedsCompaction(); err != nil || needed {
+		t.Fatalf("bad: %v %v", needed, err)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression selects how log entries are compressed before they're
+// stored.
+type Compression string
+
+const (
+	// CompressionNone stores entries as they are. This is the default.
+	CompressionNone Compression = "none"
+
+	// CompressionSnappy compresses entries with Snappy, which is very
+	// cheap but doesn't compress as well as zstd.
+	CompressionSnappy Compression = "snappy"
+
+	// CompressionZstd compresses entries with zstd at its default level.
+	CompressionZstd Compression = "zstd"
+)
+
+const (
+	// defaultCompressionThreshold is the smallest encoded entry that's
+	// compressed when Options.CompressionThreshold isn't set. Smaller
+	// entries rarely shrink by enough to be worth it.
+	defaultCompressionThreshold = 256
+)
+
+// compressor compresses entries with one algorithm.
+type compressor struct {
+	// flag marks entries compressed with this algorithm.
+	flag byte
+
+	compress   func(dst, src []byte) []byte
+	decompress func(src []byte) ([]byte, error)
+}
+
+var (
+	snappyCompressor = &compressor{
+		flag:     entrySnappy,
+		compress: s2.EncodeSnappy,
+		decompress: func(src []byte) ([]byte, error) {
+			return s2.Decode(nil, src)
+		},
+	}
+
+	zstdCompressor = &compressor{
+		flag: entryZstd,
+		compress: func(dst, src []byte) []byte {
+			return zstdEncoder().EncodeAll(src, dst)
+		},
+		decompress: func(src []byte) ([]byte, error) {
+			return zstdDecoder().DecodeAll(src, nil)
+		},
+	}
+
+	// The zstd encoder and decoder are safe for concurrent use, but
+	// expensive to create, so they're shared and only created once
+	// needed. The encoder can run as many EncodeAll calls at once as its
+	// concurrency, so it's allowed one per CPU for encodeParallel.
+	zstdEncoder = sync.OnceValue(func() *zstd.Encoder {
+		enc, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(runtime.GOMAXPROCS(0)))
+		if err != nil {
+			panic(err)
+		}
+		return enc
+	})
+	zstdDecoder = sync.OnceValue(func() *zstd.Decoder {
+		dec, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+		if err != nil {
+			panic(err)
+		}
+		return dec
+	})
+)
+
+// compressor returns the compressor for c, or nil if entries shouldn't
+// be compressed.
+func (c Compression) compressor() *compressor {
+	switch c {
+	case CompressionSnappy:
+		return snappyCompressor
+	case CompressionZstd:
+		return zstdCompressor
+	default:
+		return nil
+	}
+}
+
+// compressorFor returns the compressor that wrote an entry with the given
+// flags, or nil if it isn't compressed.
+func compressorFor(flags byte) *compressor {
+	switch flags & (entrySnappy | entryZstd) {
+	case entrySnappy:
+		return snappyCompressor
+	case entryZstd:
+		return zstdCompressor
+	default:
+		return nil
+	}
+}
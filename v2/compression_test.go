@@ -0,0 +1,108 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"go.etcd.io/bbolt"
+)
+
+func TestBoltStore_Compression(t *testing.T) {
+	for _, compression := range []Compression{CompressionSnappy, CompressionZstd} {
+		for _, checksums := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s checksums=%v", compression, checksums), func(t *testing.T) {
+				store := testBoltStore(t)
+				defer store.Close()
+				defer os.Remove(store.path)
+
+				// Entries written before compression was turned on
+				// are still read
+				large := string(bytes.Repeat([]byte("compressible "), 100))
+				if err := store.StoreLog(testRaftLog(1, large)); err != nil {
+					t.Fatalf("err: %s", err)
+				}
+
+				store.compressor = compression.compressor()
+				store.compressThreshold = defaultCompressionThreshold
+				store.checksums = checksums
+				logs := []*raft.Log{
+					testRaftLog(2, large),
+					testRaftLog(3, "small"),
+					testRaftLog(4, large),
+				}
+				if err := store.StoreLogs(logs); err != nil {
+					t.Fatalf("err: %s", err)
+				}
+
+				sizes := make(map[uint64]int)
+				err := store.conn.View(func(tx *bbolt.Tx) error {
+					return tx.Bucket(dbLogs).ForEach(func(k, v []byte) error {
+						sizes[bytesToUint64(k)] = len(v)
+						return nil
+					})
+				})
+				if err != nil {
+					t.Fatalf("err: %s", err)
+				}
+				if sizes[2] >= sizes[1]/4 || sizes[4] != sizes[2] {
+					t.Fatalf("bad: %v", sizes)
+				}
+
+				for i, expected := range append([]*raft.Log{testRaftLog(1, large)}, logs...) {
+					var log raft.Log
+					if err := store.GetLog(uint64(i+1), &log); err != nil {
+						t.Fatalf("err: %s", err)
+					}
+					if !reflect.DeepEqual(&log, expected) {
+						t.Fatalf("bad: %#v", log)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestLogEncoder_Compression(t *testing.T) {
+	enc := getLogEncoder(false)
+	defer enc.release()
+	enc.reset(2)
+	enc.compressor = zstdCompressor
+	enc.compressThreshold = 0
+
+	// Entries that don't shrink are stored as they are
+	val, err := enc.encode(testRaftLog(1, "x"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if val[0] == entryMarker {
+		t.Fatalf("bad: %x", val)
+	}
+	raw, err := encodeMsgPack(testRaftLog(1, "x"), false)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !bytes.Equal(val, raw.Bytes()) {
+		t.Fatalf("bad: %x", val)
+	}
+
+	// Corrupt compressed data is an error
+	val, err = enc.encode(testRaftLog(2, string(bytes.Repeat([]byte("a"), 1000))))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if val[0] != entryMarker || val[1] != entryZstd {
+		t.Fatalf("bad: %x", val)
+	}
+	val = append([]byte(nil), val[:len(val)-2]...)
+	var log raft.Log
+	if err := decodeLog(1, val, nil, &log); err == nil {
+		t.Fatalf("expected error")
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	// The keys raft keeps its persistent state under.
+	keyCurrentTerm  = []byte("CurrentTerm")
+	keyLastVoteTerm = []byte("LastVoteTerm")
+	keyLastVoteCand = []byte("LastVoteCand")
+
+	// internalKeyPrefix starts the keys the store keeps its own
+	// bookkeeping under in the conf bucket. They're written to the bucket
+	// directly, and can't be written through the stable store.
+	internalKeyPrefix = []byte("raftboltdb.")
+)
+
+// checkKey returns an error if k is one of the store's internal keys,
+// which mustn't be changed through the stable store.
+func checkKey(k []byte) error {
+	if bytes.HasPrefix(k, internalKeyPrefix) {
+		return fmt.Errorf("%w: %q is for the store's own use", ErrReservedKey, k)
+	}
+	return nil
+}
+
+// KV is a key and value in the stable store.
+type KV struct {
+	Key   []byte
+	Value []byte
+}
+
+// Delete removes a key from the stable store. Deleting a key that
+// doesn't exist isn't an error.
+func (b *BoltStore) Delete(k []byte) (err error) {
+	defer b.metrics.measureSince([]string{"delete"}, time.Now())
+	span := b.startSpan("raftboltdb.Delete", attrKeySize.Int(len(k)))
+	defer func() { endSpan(span, err) }()
+	if err = checkKey(k); err != nil {
+		return err
+	}
+
+	_, err = b.updateConf("Delete", func(tx *bbolt.Tx) error {
+		bucket, err := b.bucket(tx, dbConf)
+		if err != nil {
+			return err
+		}
+		return bucket.Delete(k)
+	})
+	return err
+}
+
+// SetMany sets several keys in the stable store in one transaction, so
+// either all of them are set or none are.
+func (b *BoltStore) SetMany(pairs []KV) (err error) {
+	defer b.metrics.measureSince([]string{"setMany"}, time.Now())
+	span := b.startSpan("raftboltdb.SetMany", attrBatchSize.Int(len(pairs)))
+	defer func() { endSpan(span, err) }()
+	for _, kv := range pairs {
+		if err = checkKey(kv.Key); err != nil {
+			return err
+		}
+	}
+
+	_, err = b.updateConf("SetMany", func(tx *bbolt.Tx) error {
+		bucket, err := b.bucket(tx, dbConf)
+		if err != nil {
+			return err
+		}
+		for _, kv := range pairs {
+			if err := b.putConf(bucket, kv.Key, kv.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// Keys returns the keys in the stable store that start with prefix, in
+// byte order. The keys the store uses for its own bookkeeping, which
+// start with "raftboltdb.", are left out.
+func (b *BoltStore) Keys(prefix []byte) ([][]byte, error) {
+	var keys [][]byte
+	err := b.ForEach(prefix, func(k, _ []byte) error {
+		if bytes.HasPrefix(k, internalKeyPrefix) {
+			return nil
+		}
+		keys = append(keys, append([]byte(nil), k...))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// ForEach calls fn with each key and value in the stable store that
+// starts with prefix, in byte order, stopping at the first error, which
+// is returned. Unlike Keys, it includes the store's internal keys, for
+// tools that dump everything. Every call sees the same consistent view of the store.
+// The slices are only valid until fn returns, so must be copied to be
+// kept, and fn mustn't write to the store or it will deadlock.
+func (b *BoltStore) ForEach(prefix []byte, fn func(k, v []byte) error) error {
+	tx, err := b.beginConf(false)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	bucket, err := b.bucket(tx, dbConf)
+	if err != nil {
+		return err
+	}
+	curs := bucket.Cursor()
+	for k, v := curs.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = curs.Next() {
+		if b.keys.Load() != nil {
+			if v, err = b.openConf(k, v); err != nil {
+				return err
+			}
+		}
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CAS sets key to new if its current value is old, returning whether it
+// was set. A nil old only matches a key that doesn't exist, and a nil new
+// deletes the key. The comparison and the write happen in one
+// transaction, so concurrent callers can use CAS to coordinate without a
+// lock of their own.
+func (b *BoltStore) CAS(key, old, new []byte) (swapped bool, err error) {
+	defer b.metrics.measureSince([]string{"cas"}, time.Now())
+	span := b.startSpan("raftboltdb.CAS", attrKeySize.Int(len(key)), attrValueSize.Int(len(new)))
+	defer func() { endSpan(span, err) }()
+	if err = checkKey(key); err != nil {
+		return false, err
+	}
+
+	_, err = b.updateConf("CAS", func(tx *bbolt.Tx) error {
+		bucket, err := b.bucket(tx, dbConf)
+		if err != nil {
+			return err
+		}
+		cur, err := b.getConf(bucket, key)
+		if err != nil {
+			return err
+		}
+		if (cur == nil) != (old == nil) || !bytes.Equal(cur, old) {
+			return nil
+		}
+
+		swapped = true
+		if new == nil {
+			return bucket.Delete(key)
+		}
+		return b.putConf(bucket, key, new)
+	})
+	if err != nil {
+		return false, err
+	}
+	return swapped, nil
+}
+
+// CASUint64 is like CAS, but handles uint64 values. A key that doesn't
+// exist is treated as zero, so counters can be started with an old value
+// of zero.
+func (b *BoltStore) CASUint64(key []byte, old, new uint64) (bool, error) {
+	swapped, err := b.CAS(key, uint64ToBytes(old), uint64ToBytes(new))
+	if err != nil || swapped || old != 0 {
+		return swapped, err
+	}
+	return b.CAS(key, nil, uint64ToBytes(new))
+}
+
+// getUint64OrZero is like GetUint64, but returns zero for a key that
+// doesn't exist, as raft does.
+func (b *BoltStore) getUint64OrZero(key []byte) (uint64, error) {
+	val, err := b.GetUint64(key)
+	if err == ErrKeyNotFound {
+		return 0, nil
+	}
+	return val, err
+}
+
+// CurrentTerm returns the current term raft has recorded in the stable
+// store, or zero if it hasn't recorded one.
+func (b *BoltStore) CurrentTerm() (uint64, error) {
+	return b.getUint64OrZero(keyCurrentTerm)
+}
+
+// SetCurrentTerm records the current term the way raft does, for
+// recovery tools.
+func (b *BoltStore) SetCurrentTerm(term uint64) error {
+	return b.SetUint64(keyCurrentTerm, term)
+}
+
+// LastVote returns the term of the last vote raft has recorded in the
+// stable store and the candidate it voted for, or zero and nil if it
+// hasn't voted.
+func (b *BoltStore) LastVote() (term uint64, candidate []byte, err error) {
+	tx, err := b.beginConf(false)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	bucket, err := b.bucket(tx, dbConf)
+	if err != nil {
+		return 0, nil, err
+	}
+	val, err := b.getConf(bucket, keyLastVoteTerm)
+	if err != nil {
+		return 0, nil, err
+	}
+	if val != nil {
+		if term, err = valueToUint64(keyLastVoteTerm, val); err != nil {
+			return 0, nil, err
+		}
+	}
+	if candidate, err = b.getConf(bucket, keyLastVoteCand); err != nil {
+		return 0, nil, err
+	}
+	return term, candidate, nil
+}
+
+// SetLastVote records a vote the way raft does, for recovery tools. The
+// term and candidate are written together.
+func (b *BoltStore) SetLastVote(term uint64, candidate []byte) error {
+	return b.SetMany([]KV{
+		{Key: keyLastVoteTerm, Value: uint64ToBytes(term)},
+		{Key: keyLastVoteCand, Value: candidate},
+	})
+}
@@ -0,0 +1,268 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestBoltStore_CAS(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	key := []byte("foo")
+	if swapped, err := store.CAS(key, []byte("bar"), []byte("baz")); err != nil || swapped {
+		t.Fatalf("bad: %v %v", swapped, err)
+	}
+	if swapped, err := store.CAS(key, nil, []byte("bar")); err != nil || !swapped {
+		t.Fatalf("bad: %v %v", swapped, err)
+	}
+	if swapped, err := store.CAS(key, nil, []byte("baz")); err != nil || swapped {
+		t.Fatalf("bad: %v %v", swapped, err)
+	}
+	if swapped, err := store.CAS(key, []byte("bar"), []byte("baz")); err != nil || !swapped {
+		t.Fatalf("bad: %v %v", swapped, err)
+	}
+	if val, err := store.Get(key); err != nil || string(val) != "baz" {
+		t.Fatalf("bad: %q %v", val, err)
+	}
+
+	// An empty value isn't the same as a missing key
+	if swapped, err := store.CAS(key, []byte("baz"), []byte{}); err != nil || !swapped {
+		t.Fatalf("bad: %v %v", swapped, err)
+	}
+	if swapped, err := store.CAS(key, nil, []byte("bar")); err != nil || swapped {
+		t.Fatalf("bad: %v %v", swapped, err)
+	}
+	if swapped, err := store.CAS(key, []byte{}, nil); err != nil || !swapped {
+		t.Fatalf("bad: %v %v", swapped, err)
+	}
+	if _, err := store.Get(key); err != ErrKeyNotFound {
+		t.Fatalf("expected not found error, got: %v", err)
+	}
+}
+
+func TestBoltStore_CASUint64(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	// Concurrent increments are never lost
+	key := []byte("counter")
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 25; n++ {
+				for {
+					cur, err := store.GetUint64(key)
+					if err != nil && err != ErrKeyNotFound {
+						t.Errorf("err: %s", err)
+						return
+					}
+					swapped, err := store.CASUint64(key, cur, cur+1)
+					if err != nil {
+						t.Errorf("err: %s", err)
+						return
+					}
+					if swapped {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if val, err := store.GetUint64(key); err != nil || val != 100 {
+		t.Fatalf("bad: %d %v", val, err)
+	}
+}
+
+func TestBoltStore_DeleteSetMany(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	err := store.SetMany([]KV{
+		{Key: []byte("a"), Value: []byte("1")},
+		{Key: []byte("b"), Value: []byte("2")},
+		{Key: []byte("c"), Value: []byte("3")},
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for k, v := range map[string]string{"a": "1", "b": "2", "c": "3"} {
+		if val, err := store.Get([]byte(k)); err != nil || string(val) != v {
+			t.Fatalf("bad: %s=%q %v", k, val, err)
+		}
+	}
+
+	if err := store.Delete([]byte("b")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := store.Get([]byte("b")); err != ErrKeyNotFound {
+		t.Fatalf("expected not found error, got: %v", err)
+	}
+	if err := store.Delete([]byte("missing")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Nothing is set if any key is invalid
+	err = store.SetMany([]KV{
+		{Key: []byte("d"), Value: []byte("4")},
+		{Key: nil, Value: []byte("5")},
+	})
+	if err == nil {
+		t.Fatalf("expected an error for an empty key")
+	}
+	if _, err := store.Get([]byte("d")); err != ErrKeyNotFound {
+		t.Fatalf("expected not found error, got: %v", err)
+	}
+}
+
+func TestBoltStore_Keys(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	err := store.SetMany([]KV{
+		{Key: []byte("CurrentTerm"), Value: uint64ToBytes(2)},
+		{Key: []byte("LastVoteCand"), Value: []byte("node1")},
+		{Key: []byte("LastVoteTerm"), Value: uint64ToBytes(2)},
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	keys, err := store.Keys([]byte("LastVote"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(keys) != 2 || string(keys[0]) != "LastVoteCand" || string(keys[1]) != "LastVoteTerm" {
+		t.Fatalf("bad: %q", keys)
+	}
+	if keys, err := store.Keys(nil); err != nil || len(keys) != 3 {
+		t.Fatalf("bad: %q %v", keys, err)
+	}
+	if keys, err := store.Keys([]byte("missing")); err != nil || len(keys) != 0 {
+		t.Fatalf("bad: %q %v", keys, err)
+	}
+
+	var seen []string
+	errStop := errors.New("stop")
+	err = store.ForEach(nil, func(k, v []byte) error {
+		seen = append(seen, string(k))
+		if string(k) == "LastVoteCand" {
+			if string(v) != "node1" {
+				t.Fatalf("bad: %q", v)
+			}
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop {
+		t.Fatalf("expected stop error, got: %v", err)
+	}
+	if len(seen) != 2 || seen[0] != "CurrentTerm" {
+		t.Fatalf("bad: %q", seen)
+	}
+}
+
+func TestBoltStore_RaftState(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	if term, err := store.CurrentTerm(); err != nil || term != 0 {
+		t.Fatalf("bad: %d %v", term, err)
+	}
+	if term, cand, err := store.LastVote(); err != nil || term != 0 || cand != nil {
+		t.Fatalf("bad: %d %q %v", term, cand, err)
+	}
+
+	// These are the keys raft itself uses
+	if err := store.SetUint64([]byte("CurrentTerm"), 7); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if term, err := store.CurrentTerm(); err != nil || term != 7 {
+		t.Fatalf("bad: %d %v", term, err)
+	}
+	if err := store.SetLastVote(6, []byte("node2")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if term, err := store.GetUint64([]byte("LastVoteTerm")); err != nil || term != 6 {
+		t.Fatalf("bad: %d %v", term, err)
+	}
+	if cand, err := store.Get([]byte("LastVoteCand")); err != nil || string(cand) != "node2" {
+		t.Fatalf("bad: %q %v", cand, err)
+	}
+	if err := store.SetCurrentTerm(8); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if term, err := store.CurrentTerm(); err != nil || term != 8 {
+		t.Fatalf("bad: %d %v", term, err)
+	}
+	if term, cand, err := store.LastVote(); err != nil || term != 6 || string(cand) != "node2" {
+		t.Fatalf("bad: %d %q %v", term, cand, err)
+	}
+}
+
+func TestBoltStore_InternalKeys(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	if err := store.SetUint64(keyCurrentTerm, 1); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	err := store.db().Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(dbConf).Put([]byte("raftboltdb.test"), []byte("internal"))
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Keys leaves internal keys out, but ForEach includes them
+	if keys, err := store.Keys(nil); err != nil || len(keys) != 1 || string(keys[0]) != "CurrentTerm" {
+		t.Fatalf("bad: %q %v", keys, err)
+	}
+	n := 0
+	if err := store.ForEach([]byte("raftboltdb.test"), func(k, v []byte) error {
+		n++
+		return nil
+	}); err != nil || n != 1 {
+		t.Fatalf("bad: %d %v", n, err)
+	}
+
+	// They can't be written through the stable store
+	key := []byte("raftboltdb.test")
+	if err := store.Set(key, []byte("bad")); !errors.Is(err, ErrReservedKey) {
+		t.Fatalf("err: %v", err)
+	}
+	if err := store.SetUint64(key, 1); !errors.Is(err, ErrReservedKey) {
+		t.Fatalf("err: %v", err)
+	}
+	if err := store.SetMany([]KV{{Key: []byte("ok"), Value: []byte("ok")}, {Key: key, Value: []byte("bad")}}); !errors.Is(err, ErrReservedKey) {
+		t.Fatalf("err: %v", err)
+	}
+	if err := store.Delete(key); !errors.Is(err, ErrReservedKey) {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := store.CAS(key, []byte("internal"), nil); !errors.Is(err, ErrReservedKey) {
+		t.Fatalf("err: %v", err)
+	}
+	if val, err := store.Get(key); err != nil || string(val) != "internal" {
+		t.Fatalf("bad: %q %v", val, err)
+	}
+	if _, err := store.Get([]byte("ok")); err != ErrKeyNotFound {
+		t.Fatalf("err: %v", err)
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build faultinject
+
+package raftboltdb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+const (
+	// crashSectorSize is the unit a crash can tear a write at. Disks
+	// write sectors atomically, so Bbolt's meta pages, whose contents fit
+	// in the first sector, are either written or not.
+	crashSectorSize = 512
+
+	// crashTornImages is how many images CrashTest checks for each crash
+	// with a random part of the data pages written.
+	crashTornImages = 8
+)
+
+// crashState is what CrashTest compares between stores.
+type crashState struct {
+	First, Last uint64
+	Digest      [sha256.Size]byte
+	Conf        map[string][]byte
+}
+
+// CrashTest checks that the store recovers from a crash at any point in
+// workload, which is run against a new store opened with options, apart
+// from Path, and must return the first error it gets from the store.
+// The workload is run once to count its writes, and then again for each
+// of them with a FaultInjector crashing the store at that write. Every
+// image of the file that the crash could have left on disk is then
+// opened: the one before the write, several with a random part of its
+// data pages written, one with all of them written but not the meta page
+// that commits them, and the one after it. Each must open with options,
+// pass the same checks as Verify, hold exactly what either the image
+// before or the one after the write holds, and accept a new entry.
+//
+// The images follow the order Bbolt writes in, syncing the data pages
+// before the meta page, so only describe a real crash with a SyncPolicy
+// that syncs every write and without NoSync, although the store is
+// checked the same way whatever the options.
+func CrashTest(t *testing.T, options Options, workload func(store *BoltStore) error) {
+	t.Helper()
+
+	dir := t.TempDir()
+	options.Path = filepath.Join(dir, "count.db")
+	store, err := New(options)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.InjectFaults(&FaultInjector{FailAfterWrites: math.MaxInt})
+	if err := workload(store); err != nil {
+		store.Close()
+		t.Fatalf("workload failed without a crash: %s", err)
+	}
+	writes := store.faults.writes
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	for n := 0; n < writes; n++ {
+		options.Path = filepath.Join(dir, fmt.Sprintf("crash-%d.db", n))
+		store, err := New(options)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		var before, after []byte
+		store.InjectFaults(&FaultInjector{
+			FailAfterWrites: n,
+			OnCrash: func(b, a []byte) {
+				before, after = b, a
+			},
+		})
+		err = workload(store)
+		store.connLock.RLock()
+		pageSize := store.conn.Info().PageSize
+		store.connLock.RUnlock()
+		store.Close()
+		if !errors.Is(err, ErrInjectedFault) {
+			t.Fatalf("crash at write %d: workload returned %v rather than ErrInjectedFault", n+1, err)
+		}
+
+		images := tornImages(before, after, pageSize, rand.New(rand.NewSource(int64(n))))
+		var states [2]*crashState
+		for i, image := range [][]byte{images[0], images[len(images)-1]} {
+			if states[i], err = checkCrashImage(options, filepath.Join(dir, "image.db"), image); err != nil {
+				t.Fatalf("crash at write %d: %s", n+1, err)
+			}
+		}
+		for i, image := range images[1 : len(images)-1] {
+			state, err := checkCrashImage(options, filepath.Join(dir, "image.db"), image)
+			if err != nil {
+				t.Fatalf("crash at write %d, image %d: %s", n+1, i+1, err)
+			}
+			if !reflect.DeepEqual(state, states[0]) && !reflect.DeepEqual(state, states[1]) {
+				t.Fatalf("crash at write %d, image %d: store holds neither what it did before the write nor after it", n+1, i+1)
+			}
+		}
+		os.Remove(options.Path)
+	}
+}
+
+// tornImages returns the images of a file that a crash while it was being
+// changed from before to after could leave, starting with before and
+// ending with after. Bbolt grows the file and syncs it before writing to
+// it, so every image is the size of after.
+func tornImages(before, after []byte, pageSize int, rng *rand.Rand) [][]byte {
+	base := make([]byte, len(after))
+	copy(base, before)
+
+	// Sectors of the data pages that the write changed, skipping the
+	// two meta pages at the start of the file
+	var sectors []int
+	for off := 2 * pageSize; off < len(after); off += crashSectorSize {
+		end := off + crashSectorSize
+		if end > len(after) {
+			end = len(after)
+		}
+		if !bytes.Equal(base[off:end], after[off:end]) {
+			sectors = append(sectors, off)
+		}
+	}
+	write := func(image []byte, off int) {
+		end := off + crashSectorSize
+		if end > len(after) {
+			end = len(after)
+		}
+		copy(image[off:end], after[off:end])
+	}
+
+	images := [][]byte{base}
+	for i := 0; i < crashTornImages; i++ {
+		image := bytes.Clone(base)
+		for _, off := range sectors {
+			if rng.Intn(2) == 0 {
+				write(image, off)
+			}
+		}
+		images = append(images, image)
+	}
+	image := bytes.Clone(base)
+	for _, off := range sectors {
+		write(image, off)
+	}
+	return append(images, image, after)
+}
+
+// checkCrashImage writes image to path and checks it as CrashTest
+// describes, returning what the store holds. Bbolt panics on some kinds
+// of corruption, which is returned as an error.
+func checkCrashImage(options Options, path string, image []byte) (state *crashState, err error) {
+	if err := os.WriteFile(path, image, dbFileMode); err != nil {
+		return nil, err
+	}
+	defer os.Remove(path)
+	defer func() {
+		if r := recover(); r != nil {
+			state, err = nil, fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	options.Path = path
+	store, err := New(options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open: %w", err)
+	}
+	defer store.Close()
+
+	report, err := store.verify(VerifyOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if !report.OK() {
+		return nil, &VerifyError{Path: path, Report: report}
+	}
+
+	state = &crashState{}
+	if state.First, err = store.FirstIndex(); err != nil {
+		return nil, err
+	}
+	if state.Last, err = store.LastIndex(); err != nil {
+		return nil, err
+	}
+	digest, err := store.Digest(state.First, state.Last)
+	if err != nil {
+		return nil, err
+	}
+	state.Digest = digest.Sum
+	if state.Conf, err = confValues(store); err != nil {
+		return nil, err
+	}
+
+	log := &raft.Log{Index: state.Last + 1, Term: 1, Type: raft.LogCommand, Data: []byte("after crash")}
+	if state.Last != 0 {
+		var last raft.Log
+		if err := store.GetLog(state.Last, &last); err != nil {
+			return nil, err
+		}
+		log.Term = last.Term
+	}
+	if err := store.StoreLog(log); err != nil {
+		return nil, fmt.Errorf("failed to write after recovering: %w", err)
+	}
+	return state, nil
+}
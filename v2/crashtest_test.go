@@ -0,0 +1,87 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build faultinject
+
+package raftboltdb
+
+import (
+	"os"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+// crashWorkload makes the kinds of writes raft does: appending, setting
+// the stable store keys, compacting the head of the log and truncating
+// its tail.
+func crashWorkload(store *BoltStore) error {
+	for i := uint64(0); i < 4; i++ {
+		var logs []*raft.Log
+		for idx := i*8 + 1; idx <= i*8+8; idx++ {
+			logs = append(logs, testRaftLog(idx, string(make([]byte, 100*idx))))
+		}
+		if err := store.StoreLogs(logs); err != nil {
+			return err
+		}
+	}
+	if err := store.SetUint64(keyCurrentTerm, 2); err != nil {
+		return err
+	}
+	if err := store.Set(keyLastVoteCand, []byte("node2")); err != nil {
+		return err
+	}
+	if err := store.DeleteRange(1, 10); err != nil {
+		return err
+	}
+	if err := store.DeleteRange(30, 32); err != nil {
+		return err
+	}
+	return store.StoreLogs([]*raft.Log{testRaftLog(30, "replaced")})
+}
+
+func TestFaultInjector(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	store.InjectFaults(&FaultInjector{FailAfterWrites: 1})
+	if err := store.StoreLog(testRaftLog(1, "a")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The write that crashes is made, but isn't acknowledged
+	if err := store.StoreLog(testRaftLog(2, "b")); err != ErrInjectedFault {
+		t.Fatalf("err: %v", err)
+	}
+	if err := store.Set([]byte("k"), []byte("v")); err != ErrInjectedFault {
+		t.Fatalf("err: %v", err)
+	}
+	if last, err := store.LastIndex(); err != nil || last != 2 {
+		t.Fatalf("bad: %d %v", last, err)
+	}
+	if _, err := store.Get([]byte("k")); err != ErrKeyNotFound {
+		t.Fatalf("err: %v", err)
+	}
+
+	store.InjectFaults(nil)
+	if err := store.Set([]byte("k"), []byte("v")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestCrashTest(t *testing.T) {
+	t.Run("Default", func(t *testing.T) {
+		CrashTest(t, Options{}, crashWorkload)
+	})
+	t.Run("Options", func(t *testing.T) {
+		CrashTest(t, Options{
+			Checksums:         true,
+			Compression:       CompressionSnappy,
+			OverflowThreshold: 2048,
+			LogSegmentSize:    8,
+			TermIndex:         true,
+			CacheSize:         16,
+		}, crashWorkload)
+	})
+}
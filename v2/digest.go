@@ -0,0 +1,177 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"math"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// RangeDigest is a hash over the log entries in a range, for comparing
+// the logs of two nodes without copying either of them.
+type RangeDigest struct {
+	// Min and Max are the indexes the digest covers, inclusive.
+	Min, Max uint64
+
+	// Count is how many entries were found in the range.
+	Count uint64
+
+	// Sum is a SHA-256 hash over the index, term, type, data and
+	// extensions of each entry in the range, in index order. AppendedAt
+	// isn't included, and neither is anything about how the entries
+	// happen to be stored, so nodes agree on the digest as long as they
+	// agree on the log.
+	Sum [sha256.Size]byte
+}
+
+// digester builds a RangeDigest one entry at a time.
+type digester struct {
+	h   hash.Hash
+	buf []byte
+}
+
+func newDigester() *digester {
+	return &digester{h: sha256.New()}
+}
+
+func (d *digester) add(log *raft.Log) {
+	d.buf = binary.BigEndian.AppendUint64(d.buf[:0], log.Index)
+	d.buf = binary.BigEndian.AppendUint64(d.buf, log.Term)
+	d.buf = append(d.buf, byte(log.Type))
+	d.buf = binary.BigEndian.AppendUint64(d.buf, uint64(len(log.Data)))
+	d.h.Write(d.buf)
+	d.h.Write(log.Data)
+	d.buf = binary.BigEndian.AppendUint64(d.buf[:0], uint64(len(log.Extensions)))
+	d.h.Write(d.buf)
+	d.h.Write(log.Extensions)
+}
+
+func (d *digester) sum(out *[sha256.Size]byte) {
+	d.h.Sum(out[:0])
+	d.h.Reset()
+}
+
+// Digest returns a hash over the log entries from min to max inclusive,
+// read from a single transaction. Two nodes whose digests for a range
+// match hold the same entries in it.
+func (b *BoltStore) Digest(min, max uint64) (*RangeDigest, error) {
+	if min > max {
+		return nil, fmt.Errorf("invalid range %d to %d", min, max)
+	}
+	digests, err := b.digest(min, max, max-min)
+	if err != nil {
+		return nil, err
+	}
+	if len(digests) == 0 {
+		digest := &RangeDigest{Min: min, Max: max}
+		newDigester().sum(&digest.Sum)
+		return digest, nil
+	}
+	return &digests[0], nil
+}
+
+// DigestChunks splits the range from min to max inclusive into chunks of
+// chunkSize indexes, starting from min, and returns a digest of each
+// chunk holding any entries, in index order. Nodes that disagree about a
+// range can compare its chunks with FirstDifference, then call
+// DigestChunks again on the first chunk that differs with a smaller
+// chunkSize, to find the first entry they disagree on without comparing
+// the whole log.
+func (b *BoltStore) DigestChunks(min, max, chunkSize uint64) ([]RangeDigest, error) {
+	if min > max {
+		return nil, fmt.Errorf("invalid range %d to %d", min, max)
+	}
+	if chunkSize == 0 {
+		return nil, fmt.Errorf("chunk size must be greater than zero")
+	}
+	return b.digest(min, max, chunkSize-1)
+}
+
+// digest returns the digests of each chunk of span+1 indexes between min
+// and max that holds any entries.
+func (b *BoltStore) digest(min, max, span uint64) ([]RangeDigest, error) {
+	start := time.Now()
+	tx, err := b.begin(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	bucket, err := b.logs(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	var digests []RangeDigest
+	var current *RangeDigest
+	d := newDigester()
+	curs := bucket.cursor()
+	for k, v := curs.Seek(uint64ToBytes(min)); k != nil; k, v = curs.Next() {
+		idx := bytesToUint64(k)
+		if idx > max {
+			break
+		}
+		if current != nil && idx > current.Max {
+			d.sum(&current.Sum)
+			current = nil
+		}
+		if current == nil {
+			// span+1 overflows when a single chunk covers every index
+			chunkMin := min
+			if span < math.MaxUint64 {
+				chunkMin = idx - (idx-min)%(span+1)
+			}
+			chunkMax := max
+			if max-chunkMin > span {
+				chunkMax = chunkMin + span
+			}
+			digests = append(digests, RangeDigest{Min: chunkMin, Max: chunkMax})
+			current = &digests[len(digests)-1]
+		}
+
+		var log raft.Log
+		if err := bucket.decode(idx, v, &log); err != nil {
+			return nil, fmt.Errorf("%w at index %d: %w", ErrLogCorrupt, idx, err)
+		}
+		d.add(&log)
+		current.Count++
+	}
+	if current != nil {
+		d.sum(&current.Sum)
+	}
+	b.metrics.measureSince([]string{"digest"}, start)
+	return digests, nil
+}
+
+// FirstDifference compares chunk digests from two nodes, as returned by
+// DigestChunks with the same range and chunk size, and returns the
+// range covered by the first chunk they disagree on, including one that
+// only one node has entries in. found is false if they match.
+func FirstDifference(a, b []RangeDigest) (min, max uint64, found bool) {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i].Min < b[j].Min:
+			return a[i].Min, a[i].Max, true
+		case b[j].Min < a[i].Min:
+			return b[j].Min, b[j].Max, true
+		case a[i].Count != b[j].Count || a[i].Sum != b[j].Sum:
+			return a[i].Min, a[i].Max, true
+		}
+		i++
+		j++
+	}
+	if i < len(a) {
+		return a[i].Min, a[i].Max, true
+	}
+	if j < len(b) {
+		return b[j].Min, b[j].Max, true
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,104 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"math"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestBoltStore_Digest(t *testing.T) {
+	a := testBoltStore(t)
+	defer a.Close()
+	defer os.Remove(a.path)
+	b := testBoltStore(t)
+	defer b.Close()
+	defer os.Remove(b.path)
+
+	// The same entries stored differently digest the same
+	b.checksums = true
+	var logs []*raft.Log
+	for i := uint64(1); i <= 100; i++ {
+		logs = append(logs, testRaftLog(i, "log"))
+	}
+	for _, store := range []*BoltStore{a, b} {
+		if err := store.StoreLogs(logs); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	da, err := a.Digest(0, math.MaxUint64)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	db, err := b.Digest(0, math.MaxUint64)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if *da != *db || da.Count != 100 {
+		t.Fatalf("bad: %v %v", da, db)
+	}
+
+	// Diverge at index 57
+	if err := b.StoreLog(&raft.Log{Index: 57, Term: 2, Data: []byte("log")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if db, err = b.Digest(0, math.MaxUint64); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if da.Sum == db.Sum {
+		t.Fatalf("expected digests to differ")
+	}
+	if da, err = a.Digest(1, 56); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if db, err = b.Digest(1, 56); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if *da != *db {
+		t.Fatalf("bad: %v %v", da, db)
+	}
+
+	// Narrow it down a chunk at a time
+	min, max := uint64(1), uint64(100)
+	for _, size := range []uint64{32, 4, 1} {
+		ca, err := a.DigestChunks(min, max, size)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		cb, err := b.DigestChunks(min, max, size)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		var found bool
+		min, max, found = FirstDifference(ca, cb)
+		if !found {
+			t.Fatalf("expected a difference at size %d", size)
+		}
+		if max-min+1 != size {
+			t.Fatalf("bad: %d %d", min, max)
+		}
+	}
+	if min != 57 {
+		t.Fatalf("bad: %d", min)
+	}
+
+	// Chunks without entries are left out, but still line up
+	if err := a.DeleteRange(1, 10); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	chunks, err := a.DigestChunks(1, 200, 50)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(chunks) != 2 || chunks[0].Min != 1 || chunks[0].Max != 50 || chunks[0].Count != 40 || chunks[1].Min != 51 || chunks[1].Max != 100 {
+		t.Fatalf("bad: %v", chunks)
+	}
+	if _, _, found := FirstDifference(chunks, chunks[:1]); !found {
+		t.Fatalf("expected a difference")
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+// checkFreeSpace returns an ErrDiskFull error if Options.MinFreeBytes is set
+// and the filesystem holding path has less free space than that.
+func (b *BoltStore) checkFreeSpace(path string) error {
+	if b.minFreeBytes == 0 {
+		return nil
+	}
+	free, ok := freeBytes(path)
+	if !ok || free >= b.minFreeBytes {
+		return nil
+	}
+	b.metrics.incrCounter([]string{"diskFull"}, 1)
+	return fmt.Errorf("%w: %d bytes free on the filesystem holding %s, MinFreeBytes is %d", ErrDiskFull, free, path, b.minFreeBytes)
+}
+
+// diskError returns err as an ErrDiskFull error if it was caused by the
+// filesystem holding path running out of space, and otherwise returns it
+// unchanged.
+func (b *BoltStore) diskError(path string, err error) error {
+	if err == nil || errors.Is(err, ErrDiskFull) {
+		return err
+	}
+
+	// Bbolt formats some errors, e.g. from growing the file, with %s
+	if !errors.Is(err, syscall.ENOSPC) && !strings.Contains(err.Error(), syscall.ENOSPC.Error()) {
+		return err
+	}
+	b.metrics.incrCounter([]string{"diskFull"}, 1)
+	b.logger.Error("filesystem is full", "path", path, "error", err)
+	return fmt.Errorf("%w: %s: %w", ErrDiskFull, path, err)
+}
@@ -0,0 +1,11 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !linux
+
+package raftboltdb
+
+// freeBytes isn't supported on this platform.
+func freeBytes(path string) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,75 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+)
+
+func TestBoltStore_MinFreeBytes(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("free space is only checked on Linux")
+	}
+	path := filepath.Join(t.TempDir(), "raft.db")
+	store, err := New(Options{Path: path})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.StoreLog(testRaftLog(1, "log1")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.Close()
+
+	// The store opens and can be read, but not written
+	store, err = New(Options{Path: path, MinFreeBytes: math.MaxUint64, BatchWrites: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	last, err := store.LastIndex()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if last != 1 {
+		t.Fatalf("bad: %d", last)
+	}
+	if err := store.StoreLog(testRaftLog(2, "log2")); !errors.Is(err, ErrDiskFull) {
+		t.Fatalf("err: %v", err)
+	}
+	if err := store.SetUint64(keyCurrentTerm, 1); !errors.Is(err, ErrDiskFull) {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestBoltStore_diskError(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	other := errors.New("other")
+	for _, c := range []struct {
+		err  error
+		full bool
+	}{
+		{nil, false},
+		{other, false},
+		{&os.PathError{Op: "write", Path: store.path, Err: syscall.ENOSPC}, true},
+		{fmt.Errorf("file resize error: %s", syscall.ENOSPC), true},
+	} {
+		err := store.diskError(store.path, c.err)
+		if errors.Is(err, ErrDiskFull) != c.full {
+			t.Fatalf("bad: %v", err)
+		}
+		if c.err != nil && !errors.Is(err, c.err) {
+			t.Fatalf("bad: %v", err)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	v1 "github.com/boltdb/bolt"
+	"github.com/hashicorp/raft"
+)
+
+// MigrateFromV2 copies the store at source into a new file at destination
+// in the format of the v1 store, which uses boltdb/bolt, so an upgrade can
+// be rolled back without wiping the node's state. See
+// MigrateFromV2WithOptions.
+func MigrateFromV2(source, destination string) error {
+	return MigrateFromV2WithOptions(context.Background(), source, destination, MigrateOptions{})
+}
+
+// MigrateFromV2WithOptions is like MigrateFromV2, but copies the store in
+// chunks of opts.ChunkSize keys and reports its progress, like
+// MigrateToV2WithOptions. Log entries are written as bare msgpack, however
+// they're stored in the source, and stable store values are decrypted, so
+// opts.Wrapper is needed for an encrypted source. The keys this package
+// keeps its own bookkeeping under aren't copied. If opts.Verify is set the
+// destination is compared with the source once it's written, as
+// VerifyMigration does.
+func MigrateFromV2WithOptions(ctx context.Context, source, destination string, opts MigrateOptions) error {
+	if opts.ChunkSize < 0 {
+		return fmt.Errorf("%w: ChunkSize must not be negative", ErrInvalidOptions)
+	}
+	if opts.ChunkSize == 0 {
+		opts.ChunkSize = defaultMigrateChunkSize
+	}
+	if _, err := os.Stat(destination); err == nil {
+		return fmt.Errorf("file exists in destination %v", destination)
+	}
+
+	src, err := New(Options{Path: source, ReadOnly: true, LockTimeout: time.Minute, Wrapper: opts.Wrapper})
+	if err != nil {
+		return fmt.Errorf("failed opening source database: %w", err)
+	}
+	defer src.Close()
+
+	dstDb, err := v1.Open(destination, dbFileMode, nil)
+	if err != nil {
+		return fmt.Errorf("failed creating destination database: %v", err)
+	}
+	if err := downgrade(ctx, src, dstDb, opts); err != nil {
+		dstDb.Close()
+		os.Remove(destination)
+		return err
+	}
+	if err := dstDb.Close(); err != nil {
+		os.Remove(destination)
+		return err
+	}
+
+	if opts.Verify {
+		report, err := verifyMigrationTo(destination, src)
+		if err == nil && !report.OK() {
+			err = &MigrationError{Source: source, Destination: destination, Report: report}
+		}
+		if err != nil {
+			os.Remove(destination)
+			return err
+		}
+	}
+	return nil
+}
+
+// downgrade copies everything in src into dst.
+func downgrade(ctx context.Context, src *BoltStore, dst *v1.DB, opts MigrateOptions) error {
+	start := time.Now()
+	conf, err := confValues(src)
+	if err != nil {
+		return err
+	}
+	stats, err := src.LogStats()
+	if err != nil {
+		return err
+	}
+	progress := MigrateProgress{TotalKeys: stats.Logs + uint64(len(conf))}
+	report := func() {
+		if opts.Progress == nil {
+			return
+		}
+		p := progress
+		p.Elapsed = time.Since(start)
+		if p.Keys > 0 && p.Keys < p.TotalKeys {
+			p.ETA = time.Duration(float64(p.Elapsed) * float64(p.TotalKeys-p.Keys) / float64(p.Keys))
+		}
+		opts.Progress(p)
+	}
+
+	err = dst.Update(func(tx *v1.Tx) error {
+		if _, err := tx.CreateBucket(dbLogs); err != nil {
+			return err
+		}
+		bucket, err := tx.CreateBucket(dbConf)
+		if err != nil {
+			return err
+		}
+		for _, k := range sortedKeys(conf) {
+			if err := bucket.Put([]byte(k), conf[k]); err != nil {
+				return err
+			}
+			progress.Keys++
+			progress.Bytes += uint64(len(k) + len(conf[k]))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	report()
+
+	tx, err := src.begin(false)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	bucket, err := src.logs(tx)
+	if err != nil {
+		return err
+	}
+
+	curs := bucket.cursor()
+	k, v := curs.First()
+	for k != nil {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := dst.Update(func(dstTx *v1.Tx) error {
+			logs := dstTx.Bucket(dbLogs)
+			logs.FillPercent = src.logsFillPercent
+			for n := 0; k != nil && n < opts.ChunkSize; n++ {
+				var log raft.Log
+				if err := bucket.decode(bytesToUint64(k), v, &log); err != nil {
+					return fmt.Errorf("%w at index %d: %w", ErrLogCorrupt, bytesToUint64(k), err)
+				}
+				val, err := encodeMsgPack(&log, src.msgpackUseNewTimeFormat)
+				if err != nil {
+					return err
+				}
+				if err := logs.Put(bytes.Clone(k), val.Bytes()); err != nil {
+					return err
+				}
+				progress.Keys++
+				progress.Bytes += uint64(len(k) + val.Len())
+				k, v = curs.Next()
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		report()
+	}
+	return nil
+}
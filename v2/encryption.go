@@ -0,0 +1,272 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"go.etcd.io/bbolt"
+)
+
+// Wrapper protects the data keys the store encrypts values with. Any KMS
+// or HSM supported by go-kms-wrapping can be used through the Wrapper in
+// the kmswrapping module, which serializes the wrapper's BlobInfo to and
+// from bytes. Both methods are only called when a store is opened or its
+// keys are changed, never per value.
+type Wrapper interface {
+	// Encrypt returns plaintext encrypted under the wrapper's key.
+	Encrypt(ctx context.Context, plaintext []byte) ([]byte, error)
+
+	// Decrypt returns the plaintext of a value returned by Encrypt.
+	Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error)
+}
+
+var (
+	// dbDataKeyPrefix starts the keys in the conf bucket holding the
+	// store's data keys, each wrapped by the Wrapper and keyed by its
+	// 4-byte ID following the prefix.
+	dbDataKeyPrefix = []byte("raftboltdb.dataKey.")
+
+	// ErrEncrypted is returned when an encrypted store is opened without
+	// a Wrapper.
+	ErrEncrypted = errors.New("store is encrypted")
+)
+
+const (
+	// dataKeySize is the size of the AES-256 data keys.
+	dataKeySize = 32
+
+	// keyIDSize is the size of the ID of the data key that starts every
+	// encrypted value.
+	keyIDSize = 4
+
+	// gcmStandardNonceSize and gcmTagSize are the sizes of the nonce and
+	// tag in every encrypted value.
+	gcmStandardNonceSize = 12
+	gcmTagSize           = 16
+)
+
+// keyring holds the store's unwrapped data keys. Values are encrypted
+// with AES-GCM under the active key, and start with its ID so they can
+// still be decrypted once another key is active.
+type keyring struct {
+	active uint32
+	aeads  map[uint32]cipher.AEAD
+}
+
+// seal appends the encryption of plaintext under the active key to dst.
+// additional is authenticated but not encrypted, binding the value to
+// where it's stored.
+func (k *keyring) seal(dst, plaintext, additional []byte) ([]byte, error) {
+	aead := k.aeads[k.active]
+	dst = binary.BigEndian.AppendUint32(dst, k.active)
+	start := len(dst)
+	dst = append(dst, make([]byte, aead.NonceSize())...)
+	nonce := dst[start:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(dst, nonce, plaintext, additional), nil
+}
+
+// open returns the plaintext of a value returned by seal.
+func (k *keyring) open(sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < keyIDSize {
+		return nil, fmt.Errorf("encrypted value is only %d bytes", len(sealed))
+	}
+	id := binary.BigEndian.Uint32(sealed)
+	aead, ok := k.aeads[id]
+	if !ok {
+		return nil, fmt.Errorf("data key %d is missing", id)
+	}
+	sealed = sealed[keyIDSize:]
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("encrypted value is too short for its nonce")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(make([]byte, 0, len(ciphertext)), nonce, ciphertext, additional)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt value: %w", err)
+	}
+	return plaintext, nil
+}
+
+// CryptoProvider supplies the AES-GCM implementation used to encrypt
+// values, so builds that must use a particular validated module, such as
+// a FIPS 140 one, can provide their own. The default uses the standard
+// library's crypto/aes and crypto/cipher, which are already FIPS
+// validated when built with GOEXPERIMENT=boringcrypto or GOFIPS140.
+type CryptoProvider interface {
+	// NewGCM returns an AES-GCM cipher for a 32-byte key, using the
+	// standard nonce and tag sizes so files can be read with any provider.
+	NewGCM(key []byte) (cipher.AEAD, error)
+}
+
+// stdCrypto is the default CryptoProvider.
+type stdCrypto struct{}
+
+func (stdCrypto) NewGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// newAEAD returns the AES-GCM cipher for a data key from provider.
+func newAEAD(provider CryptoProvider, key []byte) (cipher.AEAD, error) {
+	aead, err := provider.NewGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if aead.NonceSize() != gcmStandardNonceSize || aead.Overhead() != gcmTagSize {
+		return nil, fmt.Errorf("crypto provider returned a cipher with a %d byte nonce and %d byte tag, not %d and %d",
+			aead.NonceSize(), aead.Overhead(), gcmStandardNonceSize, gcmTagSize)
+	}
+	return aead, nil
+}
+
+// dataKeyID returns the ID of the data key stored under k, and whether
+// k is a data key at all.
+func dataKeyID(k []byte) (uint32, bool) {
+	if !bytes.HasPrefix(k, dbDataKeyPrefix) || len(k) != len(dbDataKeyPrefix)+keyIDSize {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(k[len(dbDataKeyPrefix):]), true
+}
+
+// hasDataKeys returns whether the file in tx is encrypted.
+func hasDataKeys(tx *bbolt.Tx) bool {
+	conf := tx.Bucket(dbConf)
+	if conf == nil {
+		return false
+	}
+	k, _ := conf.Cursor().Seek(dbDataKeyPrefix)
+	return k != nil && bytes.HasPrefix(k, dbDataKeyPrefix)
+}
+
+// loadKeyring unwraps the data keys stored in tx. It returns nil if the
+// store isn't encrypted, or ErrEncrypted if it is and wrapper is nil.
+func loadKeyring(tx *bbolt.Tx, wrapper Wrapper, provider CryptoProvider) (*keyring, error) {
+	if !hasDataKeys(tx) {
+		return nil, nil
+	}
+	if wrapper == nil {
+		return nil, fmt.Errorf("%w, but no Wrapper was given", ErrEncrypted)
+	}
+
+	keys := &keyring{aeads: make(map[uint32]cipher.AEAD)}
+	curs := tx.Bucket(dbConf).Cursor()
+	for k, v := curs.Seek(dbDataKeyPrefix); k != nil && bytes.HasPrefix(k, dbDataKeyPrefix); k, v = curs.Next() {
+		id, ok := dataKeyID(k)
+		if !ok {
+			continue
+		}
+		key, err := wrapper.Decrypt(context.Background(), v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unwrap data key %d: %w", id, err)
+		}
+		aead, err := newAEAD(provider, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load data key %d: %w", id, err)
+		}
+		keys.aeads[id] = aead
+		if id > keys.active {
+			keys.active = id
+		}
+	}
+	return keys, nil
+}
+
+// initEncryption creates the store's first data key, wrapped by wrapper,
+// and encrypts the stable store values already in tx with it. Log
+// entries already stored are left as they are, and still read.
+func initEncryption(tx *bbolt.Tx, wrapper Wrapper, provider CryptoProvider) (*keyring, error) {
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	wrapped, err := wrapper.Encrypt(context.Background(), key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	aead, err := newAEAD(provider, key)
+	if err != nil {
+		return nil, err
+	}
+	const id = 1
+	keys := &keyring{active: id, aeads: map[uint32]cipher.AEAD{id: aead}}
+
+	conf := tx.Bucket(dbConf)
+	var pairs []KV
+	err = conf.ForEach(func(k, v []byte) error {
+		if !bytes.HasPrefix(k, internalKeyPrefix) {
+			pairs = append(pairs, KV{Key: k, Value: v})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range pairs {
+		sealed, err := keys.seal(nil, kv.Value, kv.Key)
+		if err != nil {
+			return nil, err
+		}
+		if err := conf.Put(append([]byte(nil), kv.Key...), sealed); err != nil {
+			return nil, err
+		}
+	}
+
+	dataKey := binary.BigEndian.AppendUint32(append([]byte(nil), dbDataKeyPrefix...), id)
+	if err := conf.Put(dataKey, wrapped); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// getConf returns a copy of the value of k in the conf bucket, decrypted
+// if the store is encrypted, or nil if it isn't set.
+func (b *BoltStore) getConf(bucket *bbolt.Bucket, k []byte) ([]byte, error) {
+	return b.openConf(k, bucket.Get(k))
+}
+
+// openConf returns a copy of v, the value of k in the conf bucket,
+// decrypted if the store is encrypted. Empty values stay non-nil, so
+// they can be told apart from missing ones.
+func (b *BoltStore) openConf(k, v []byte) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+	keys := b.keys.Load()
+	if keys == nil || bytes.HasPrefix(k, internalKeyPrefix) {
+		return bytes.Clone(v), nil
+	}
+	plaintext, err := keys.open(v, k)
+	if err != nil {
+		return nil, fmt.Errorf("key %q: %w", k, err)
+	}
+	return plaintext, nil
+}
+
+// putConf sets k to v in the conf bucket, encrypting v if the store is
+// encrypted. The keys the store keeps its own bookkeeping under are never
+// encrypted, as some are needed to open it.
+func (b *BoltStore) putConf(bucket *bbolt.Bucket, k, v []byte) error {
+	if keys := b.keys.Load(); keys != nil && !bytes.HasPrefix(k, internalKeyPrefix) {
+		sealed, err := keys.seal(nil, v, k)
+		if err != nil {
+			return err
+		}
+		v = sealed
+	}
+	return bucket.Put(k, v)
+}
@@ -0,0 +1,297 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"go.etcd.io/bbolt"
+)
+
+// testWrapper wraps keys by XORing them with a byte, after a prefix that
+// identifies the wrapper so the wrong one fails to unwrap.
+type testWrapper struct {
+	id byte
+}
+
+func (w testWrapper) Encrypt(_ context.Context, plaintext []byte) ([]byte, error) {
+	out := []byte{'w', w.id}
+	for _, b := range plaintext {
+		out = append(out, b^w.id)
+	}
+	return out, nil
+}
+
+func (w testWrapper) Decrypt(_ context.Context, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < 2 || ciphertext[0] != 'w' || ciphertext[1] != w.id {
+		return nil, errors.New("wrong wrapper")
+	}
+	var out []byte
+	for _, b := range ciphertext[2:] {
+		out = append(out, b^w.id)
+	}
+	return out, nil
+}
+
+// rawContains returns whether any key or value in the file contains s.
+func rawContains(t *testing.T, store *BoltStore, s string) bool {
+	t.Helper()
+	found := false
+	err := store.conn.View(func(tx *bbolt.Tx) error {
+		var walk func(b *bbolt.Bucket) error
+		walk = func(b *bbolt.Bucket) error {
+			return b.ForEach(func(k, v []byte) error {
+				if v == nil {
+					return walk(b.Bucket(k))
+				}
+				if bytes.Contains(v, []byte(s)) {
+					found = true
+				}
+				return nil
+			})
+		}
+		return tx.ForEach(func(_ []byte, b *bbolt.Bucket) error { return walk(b) })
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return found
+}
+
+func TestBoltStore_Encryption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "raft.db")
+	store, err := New(Options{Path: path})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.StoreLog(testRaftLog(1, "plain-log")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Set([]byte("vote"), []byte("plain-conf")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.Close()
+
+	// Turning encryption on encrypts the stable store straight away, and
+	// entries as they're written
+	wrapper := testWrapper{id: 1}
+	options := Options{
+		Path:              path,
+		Wrapper:           wrapper,
+		Checksums:         true,
+		Compression:       CompressionSnappy,
+		OverflowThreshold: 4096,
+		LogSegmentSize:    8,
+	}
+	store, err = New(options)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	large := string(bytes.Repeat([]byte("secret-data"), 1000))
+	logs := []*raft.Log{testRaftLog(2, "secret-log"), testRaftLog(3, large)}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.SetMany([]KV{{Key: []byte("term"), Value: []byte("secret-conf")}}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if rawContains(t, store, "plain-conf") || rawContains(t, store, "secret") {
+		t.Fatalf("found plaintext")
+	}
+	if !rawContains(t, store, "plain-log") {
+		t.Fatalf("expected the old entry to be left alone")
+	}
+	store.Close()
+
+	// It can only be opened with the right wrapper
+	for _, w := range []Wrapper{nil, testWrapper{id: 2}} {
+		_, err := New(Options{Path: path, Wrapper: w, ReadOnly: true})
+		if err == nil {
+			t.Fatalf("expected error")
+		}
+		if w == nil && !errors.Is(err, ErrEncrypted) {
+			t.Fatalf("expected encrypted error, got: %v", err)
+		}
+	}
+
+	store, err = New(options)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	got, err := store.GetLogs(1, 3, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(got, append([]*raft.Log{testRaftLog(1, "plain-log")}, logs...)) {
+		t.Fatalf("bad: %v", got)
+	}
+	for k, v := range map[string]string{"vote": "plain-conf", "term": "secret-conf"} {
+		val, err := store.Get([]byte(k))
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if string(val) != v {
+			t.Fatalf("bad: %q", val)
+		}
+	}
+	if swapped, err := store.CAS([]byte("term"), []byte("secret-conf"), []byte("new")); err != nil || !swapped {
+		t.Fatalf("bad: %v %v", swapped, err)
+	}
+	report, err := store.VerifyAll()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !report.OK() {
+		t.Fatalf("bad: %v", report.Problems)
+	}
+
+	// Values can't be moved to another key
+	err = store.conn.Update(func(tx *bbolt.Tx) error {
+		conf := tx.Bucket(dbConf)
+		return conf.Put([]byte("vote"), append([]byte(nil), conf.Get([]byte("term"))...))
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := store.Get([]byte("vote")); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestBoltStore_Encryption_Swap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "raft.db")
+	store, err := New(Options{Path: path, Wrapper: testWrapper{id: 1}, OverflowThreshold: 4096})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	big := func(s string) string { return s + string(bytes.Repeat([]byte("x"), 10000)) }
+	logs := []*raft.Log{testRaftLog(1, "log1"), testRaftLog(2, "log2"), testRaftLog(3, big("log3")), testRaftLog(4, big("log4"))}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Swap the values of two entries, and the first overflow chunks of
+	// two others, as someone with access to the file could
+	swap := func(bucket []byte, a, b []byte) {
+		err := store.conn.Update(func(tx *bbolt.Tx) error {
+			bkt := tx.Bucket(bucket)
+			va, vb := bytes.Clone(bkt.Get(a)), bytes.Clone(bkt.Get(b))
+			if va == nil || vb == nil {
+				t.Fatalf("missing key in %s", bucket)
+			}
+			if err := bkt.Put(a, vb); err != nil {
+				return err
+			}
+			return bkt.Put(b, va)
+		})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	swap(dbLogs, uint64ToBytes(1), uint64ToBytes(2))
+	swap(dbOverflow, overflowAAD(3), overflowAAD(4))
+
+	// Neither decrypts under its new index
+	for _, idx := range []uint64{1, 2, 3, 4} {
+		var log raft.Log
+		if err := store.GetLog(idx, &log); !errors.Is(err, ErrLogCorrupt) {
+			t.Fatalf("index %d: err: %v", idx, err)
+		}
+	}
+}
+
+func TestBoltStore_EncryptConfOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "raft.db")
+	store, err := New(Options{Path: path, Wrapper: testWrapper{id: 1}, EncryptConfOnly: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	if err := store.StoreLog(testRaftLog(1, "plain-log")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Set([]byte("vote"), []byte("secret-vote")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !rawContains(t, store, "plain-log") {
+		t.Fatalf("log entry was encrypted")
+	}
+	if rawContains(t, store, "secret-vote") {
+		t.Fatalf("stable store value wasn't encrypted")
+	}
+
+	var log raft.Log
+	if err := store.GetLog(1, &log); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(log.Data) != "plain-log" {
+		t.Fatalf("bad: %q", log.Data)
+	}
+	store.Close()
+
+	if _, err := New(Options{Path: path}); !errors.Is(err, ErrEncrypted) {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+// countingCrypto is a CryptoProvider that counts the ciphers it makes.
+type countingCrypto struct {
+	calls *int
+	wrong bool
+}
+
+func (c countingCrypto) NewGCM(key []byte) (cipher.AEAD, error) {
+	*c.calls++
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if c.wrong {
+		return cipher.NewGCMWithNonceSize(block, 16)
+	}
+	return cipher.NewGCM(block)
+}
+
+func TestBoltStore_CryptoProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "raft.db")
+	var calls int
+	store, err := New(Options{Path: path, Wrapper: testWrapper{id: 1}, CryptoProvider: countingCrypto{calls: &calls}})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.StoreLog(testRaftLog(1, "log")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.Close()
+	if calls != 1 {
+		t.Fatalf("bad: %d", calls)
+	}
+
+	// Files are interchangeable with the default provider
+	store, err = New(Options{Path: path, Wrapper: testWrapper{id: 1}})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	var log raft.Log
+	if err := store.GetLog(1, &log); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.Close()
+
+	_, err = New(Options{Path: path, Wrapper: testWrapper{id: 1}, CryptoProvider: countingCrypto{calls: &calls, wrong: true}})
+	if err == nil {
+		t.Fatalf("should reject a non-standard nonce size")
+	}
+}
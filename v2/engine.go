@@ -0,0 +1,160 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+
+	"github.com/boltdb/bolt"
+	v1 "github.com/hashicorp/raft-boltdb"
+	"go.etcd.io/bbolt"
+)
+
+// Store is what every engine Open can return provides: a raft log and
+// stable store that can be closed and report on its log.
+type Store interface {
+	LogStableStore
+	io.Closer
+
+	// LogStats returns a summary of the log, see BoltStore.LogStats.
+	LogStats() (*LogStoreStats, error)
+}
+
+var _ Store = (*BoltStore)(nil)
+
+// Engine selects the implementation behind a Store.
+type Engine string
+
+const (
+	// EngineBbolt is this package's BoltStore, backed by bbolt.
+	EngineBbolt Engine = "bbolt"
+
+	// EngineBoltDB is the v1 store from github.com/hashicorp/raft-boltdb,
+	// backed by boltdb/bolt, for applications that haven't migrated yet.
+	EngineBoltDB Engine = "boltdb"
+
+	// EngineInmem is InmemStore, which keeps everything in memory.
+	EngineInmem Engine = "inmem"
+)
+
+// Config configures Open.
+type Config struct {
+	// Engine selects the implementation. Defaults to EngineBbolt.
+	Engine Engine
+
+	// Options configures the store. EngineBoltDB only supports Path,
+	// NoSync, ReadOnly and LockTimeout, and EngineInmem ignores Path and
+	// NoSync and supports nothing else. Open fails if anything unsupported
+	// is set, rather than ignoring it.
+	Options Options
+}
+
+// engineOptions are the Options that each engine other than EngineBbolt
+// supports.
+var engineOptions = map[Engine]map[string]bool{
+	EngineBoltDB: {"Path": true, "NoSync": true, "ReadOnly": true, "LockTimeout": true},
+	EngineInmem:  {"Path": true, "NoSync": true},
+}
+
+// checkEngineOptions returns an error if options sets anything engine
+// doesn't support.
+func checkEngineOptions(engine Engine, options Options) error {
+	opts := reflect.ValueOf(options)
+	for i := 0; i < opts.NumField(); i++ {
+		if name := opts.Type().Field(i).Name; !engineOptions[engine][name] && !opts.Field(i).IsZero() {
+			return fmt.Errorf("%w: %s isn't supported by the %s engine", ErrInvalidOptions, name, engine)
+		}
+	}
+	return nil
+}
+
+// Open opens a store with the engine chosen by config, so applications
+// can switch engines through configuration and tests can run against
+// every engine alike.
+func Open(config Config) (Store, error) {
+	switch config.Engine {
+	case "", EngineBbolt:
+		return New(config.Options)
+	case EngineBoltDB:
+		return openV1(config.Options)
+	case EngineInmem:
+		if err := checkEngineOptions(EngineInmem, config.Options); err != nil {
+			return nil, err
+		}
+		return NewInmemStore(), nil
+	default:
+		return nil, fmt.Errorf("%w: unknown engine %q", ErrInvalidOptions, config.Engine)
+	}
+}
+
+// v1Store adapts the v1 store to Store.
+type v1Store struct {
+	*v1.BoltStore
+	path string
+}
+
+func openV1(options Options) (*v1Store, error) {
+	if err := checkEngineOptions(EngineBoltDB, options); err != nil {
+		return nil, err
+	}
+	if options.LockTimeout < 0 {
+		return nil, fmt.Errorf("%w: LockTimeout must not be negative", ErrInvalidOptions)
+	}
+
+	// Bolt always opens with O_CREATE, so make sure a read-only open
+	// doesn't leave an empty file behind
+	if options.ReadOnly {
+		if _, err := os.Stat(options.Path); err != nil {
+			return nil, err
+		}
+	}
+	store, err := v1.New(v1.Options{
+		Path:        options.Path,
+		NoSync:      options.NoSync,
+		BoltOptions: &bolt.Options{ReadOnly: options.ReadOnly, Timeout: options.LockTimeout},
+	})
+	if err == bolt.ErrTimeout {
+		err = openError(options.Path, bbolt.ErrTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &v1Store{BoltStore: store, path: options.Path}, nil
+}
+
+// GetUint64 is like the v1 store's, but returns ErrInvalidValue for a
+// value that isn't 8 bytes long rather than panicking.
+func (s *v1Store) GetUint64(key []byte) (uint64, error) {
+	val, err := s.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return valueToUint64(key, val)
+}
+
+// LogStats returns what the v1 store can report cheaply. Logs assumes the
+// log has no gaps, and LogBytes, ConfKeys, FreelistBytes and
+// LastCompaction are always zero.
+func (s *v1Store) LogStats() (*LogStoreStats, error) {
+	stats := &LogStoreStats{}
+	var err error
+	if stats.FirstIndex, err = s.FirstIndex(); err != nil {
+		return nil, err
+	}
+	if stats.LastIndex, err = s.LastIndex(); err != nil {
+		return nil, err
+	}
+	if stats.LastIndex != 0 {
+		stats.Logs = stats.LastIndex - stats.FirstIndex + 1
+	}
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		return nil, err
+	}
+	stats.FileSize = fi.Size()
+	return stats, nil
+}
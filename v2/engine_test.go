@@ -0,0 +1,55 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestOpen(t *testing.T) {
+	for _, engine := range []Engine{EngineBbolt, EngineBoltDB, EngineInmem} {
+		t.Run(string(engine), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "raft.db")
+			store, err := Open(Config{Engine: engine, Options: Options{Path: path, NoSync: true}})
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			defer store.Close()
+
+			if err := store.StoreLogs([]*raft.Log{testRaftLog(1, "a"), testRaftLog(2, "b")}); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			var log raft.Log
+			if err := store.GetLog(2, &log); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if err := store.GetLog(3, &log); err != raft.ErrLogNotFound {
+				t.Fatalf("err: %v", err)
+			}
+			if _, err := store.Get([]byte("missing")); err == nil || err.Error() != "not found" {
+				t.Fatalf("err: %v", err)
+			}
+
+			stats, err := store.LogStats()
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if stats.Logs != 2 || stats.FirstIndex != 1 || stats.LastIndex != 2 || (stats.FileSize == 0) != (engine == EngineInmem) {
+				t.Fatalf("bad: %#v", stats)
+			}
+		})
+	}
+
+	if _, err := Open(Config{Engine: "leveldb"}); !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("err: %v", err)
+	}
+	_, err := Open(Config{Engine: EngineBoltDB, Options: Options{Path: filepath.Join(t.TempDir(), "raft.db"), Checksums: true}})
+	if !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("err: %v", err)
+	}
+}
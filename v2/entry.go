@@ -0,0 +1,131 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/hashicorp/raft"
+)
+
+// Log entries are stored either as a bare msgpack encoded raft.Log, or
+// wrapped in an envelope that starts with entryMarker, which msgpack
+// never uses, followed by a byte of flags saying how the rest of the
+// value is laid out. Both can be mixed in one file, so options that
+// change the layout only apply to entries written after they're set.
+const (
+	entryMarker byte = 0xc1
+
+	// entryChecksum is set if the value ends with a big-endian CRC32C of
+	// the bytes between the flags and the checksum.
+	entryChecksum byte = 1 << 0
+
+	// entrySnappy and entryZstd are set if the encoded raft.Log is
+	// compressed with the named algorithm. The checksum, if there is one,
+	// covers the compressed bytes.
+	entrySnappy byte = 1 << 1
+	entryZstd   byte = 1 << 2
+
+	// entryOverflow is set if the entry's data was left out of the
+	// encoded raft.Log and stored in the overflow bucket.
+	entryOverflow byte = 1 << 3
+
+	// entryEncrypted is set if the encoded raft.Log, after compression,
+	// is encrypted with one of the store's data keys, authenticating the
+	// entry's 8-byte key so it can't be moved to another index. If the
+	// entry is overflowed, its data is encrypted separately, see
+	// overflowAAD.
+	entryEncrypted byte = 1 << 4
+
+	entryKnownFlags = entryChecksum | entrySnappy | entryZstd | entryOverflow | entryEncrypted
+
+	entryHeaderSize   = 2
+	entryChecksumSize = 4
+)
+
+var (
+	// ErrChecksumMismatch is wrapped by the errors returned when a log
+	// entry doesn't match its checksum, see Options.Checksums.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+
+	crc32c = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// unwrapEntry returns the msgpack encoded raft.Log in the value stored
+// under idx, checking it against its checksum, then decrypting it with
+// keys and decompressing it as needed.
+func unwrapEntry(idx uint64, val []byte, keys *keyring) ([]byte, error) {
+	if len(val) == 0 || val[0] != entryMarker {
+		return val, nil
+	}
+	if len(val) < entryHeaderSize {
+		return nil, fmt.Errorf("entry is only %d bytes", len(val))
+	}
+	flags, payload := val[1], val[entryHeaderSize:]
+	if flags&^entryKnownFlags != 0 || flags&entrySnappy != 0 && flags&entryZstd != 0 {
+		return nil, fmt.Errorf("entry has unknown flags %#x", flags)
+	}
+
+	if flags&entryChecksum != 0 {
+		if len(payload) < entryChecksumSize {
+			return nil, fmt.Errorf("entry is too short for its checksum")
+		}
+		split := len(payload) - entryChecksumSize
+		sum := binary.BigEndian.Uint32(payload[split:])
+		payload = payload[:split]
+		if actual := crc32.Checksum(payload, crc32c); actual != sum {
+			return nil, fmt.Errorf("%w: stored %08x, computed %08x", ErrChecksumMismatch, sum, actual)
+		}
+	}
+	if flags&entryEncrypted != 0 {
+		if keys == nil {
+			return nil, ErrEncrypted
+		}
+		plaintext, err := keys.open(payload, uint64ToBytes(idx))
+		if err != nil {
+			return nil, err
+		}
+		payload = plaintext
+	}
+	if c := compressorFor(flags); c != nil {
+		decompressed, err := c.decompress(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress entry: %w", err)
+		}
+		return decompressed, nil
+	}
+	return payload, nil
+}
+
+// entryFlags returns the flags of the stored entry val.
+func entryFlags(val []byte) byte {
+	if len(val) < entryHeaderSize || val[0] != entryMarker {
+		return 0
+	}
+	return val[1]
+}
+
+// decodeLog decodes the log entry stored under idx into log. Like
+// decodeMsgPack, it
+// leaves nothing in log pointing into val. The data of entries stored in
+// the overflow bucket is left empty, which is fine for callers that only
+// need the other fields, but everything else should use logBucket.decode.
+func decodeLog(idx uint64, val []byte, keys *keyring, log *raft.Log) error {
+	payload, err := unwrapEntry(idx, val, keys)
+	if err != nil {
+		return err
+	}
+	return decodeMsgPack(payload, log)
+}
+
+// VerifyAll runs the same checks as Verify against the open store,
+// without Bbolt's page level check, decoding every entry in the log and
+// checking it against its checksum if it has one. Readers and writers
+// carry on while it runs.
+func (b *BoltStore) VerifyAll() (*VerifyReport, error) {
+	return b.verify(VerifyOptions{SkipStructureCheck: true})
+}
@@ -0,0 +1,100 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"go.etcd.io/bbolt"
+)
+
+func TestBoltStore_Checksums(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	// Entries written before checksums were turned on are still read
+	if err := store.StoreLogs([]*raft.Log{testRaftLog(1, "log1"), testRaftLog(2, "log2")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.checksums = true
+	if err := store.StoreLogs([]*raft.Log{testRaftLog(3, "log3"), testRaftLog(4, "log4")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Update(func(tx *StoreTx) error {
+		return tx.StoreLog(testRaftLog(5, "log5"))
+	}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err := store.conn.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(dbLogs)
+		for idx, marked := range map[uint64]bool{1: false, 3: true, 5: true} {
+			val := bucket.Get(uint64ToBytes(idx))
+			if (val[0] == entryMarker) != marked {
+				t.Fatalf("bad: %d %x", idx, val)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for idx := uint64(1); idx <= 5; idx++ {
+		var log raft.Log
+		if err := store.GetLog(idx, &log); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if log.Index != idx {
+			t.Fatalf("bad: %#v", log)
+		}
+	}
+
+	// Flip a bit in the data of a checksummed entry
+	err = store.conn.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(dbLogs)
+		val := append([]byte(nil), bucket.Get(uint64ToBytes(4))...)
+		val[len(val)-entryChecksumSize-1] ^= 1
+		return bucket.Put(uint64ToBytes(4), val)
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var log raft.Log
+	err = store.GetLog(4, &log)
+	if !errors.Is(err, ErrLogCorrupt) || !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected checksum mismatch, got: %v", err)
+	}
+	if _, err := store.GetLogs(3, 5, nil); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected checksum mismatch, got: %v", err)
+	}
+
+	report, err := store.VerifyAll()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if report.OK() || report.Logs != 5 || len(report.Problems) != 1 {
+		t.Fatalf("bad: %#v", report)
+	}
+	if p := report.Problems[0]; p.Kind != ProblemChecksum || p.Index != 4 {
+		t.Fatalf("bad: %#v", p)
+	}
+}
+
+func TestUnwrapEntry(t *testing.T) {
+	cases := map[string][]byte{
+		"truncated":     {entryMarker},
+		"unknown flags": {entryMarker, 0x80, 0x90},
+		"short sum":     {entryMarker, entryChecksum, 0x00, 0x00},
+	}
+	for name, val := range cases {
+		if _, err := unwrapEntry(1, val, nil); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
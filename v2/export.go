@@ -0,0 +1,199 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/hashicorp/go-msgpack/v2/codec"
+	"github.com/hashicorp/raft"
+)
+
+// Format is an encoding for exported log entries.
+type Format string
+
+const (
+	// FormatMsgpack writes each entry as a msgpack encoded raft.Log, one
+	// after another, as they're stored.
+	FormatMsgpack Format = "msgpack"
+
+	// FormatNDJSON writes each entry as a JSON object on its own line, for
+	// reading with standard tools. Data and Extensions are base64
+	// encoded.
+	FormatNDJSON Format = "ndjson"
+)
+
+// jsonLog is how a raft.Log is written in FormatNDJSON.
+type jsonLog struct {
+	Index      uint64     `json:"index"`
+	Term       uint64     `json:"term"`
+	Type       string     `json:"type"`
+	Data       []byte     `json:"data,omitempty"`
+	Extensions []byte     `json:"extensions,omitempty"`
+	AppendedAt *time.Time `json:"appended_at,omitempty"`
+}
+
+// ExportRange writes the log entries from min to max inclusive to w in
+// the given format, for archiving or attaching to a bug report. The
+// entries are read from a single transaction, so they're a consistent
+// view of the log. Entries in the range that aren't in the store are
+// skipped.
+func (b *BoltStore) ExportRange(w io.Writer, min, max uint64, format Format) error {
+	start := time.Now()
+	if min > max {
+		return fmt.Errorf("invalid range %d to %d", min, max)
+	}
+
+	var encode func(log *raft.Log) error
+	bw := bufio.NewWriter(w)
+	switch format {
+	case FormatMsgpack:
+		enc := codec.NewEncoder(bw, msgpackHandles[timeFormatIndex(b.msgpackUseNewTimeFormat)])
+		encode = func(log *raft.Log) error { return enc.Encode(log) }
+	case FormatNDJSON:
+		enc := json.NewEncoder(bw)
+		encode = func(log *raft.Log) error {
+			out := jsonLog{
+				Index:      log.Index,
+				Term:       log.Term,
+				Type:       log.Type.String(),
+				Data:       log.Data,
+				Extensions: log.Extensions,
+			}
+			if !log.AppendedAt.IsZero() {
+				out.AppendedAt = &log.AppendedAt
+			}
+			return enc.Encode(&out)
+		}
+	default:
+		return fmt.Errorf("unknown export format %q", format)
+	}
+
+	tx, err := b.begin(false)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	bucket, err := b.logs(tx)
+	if err != nil {
+		return err
+	}
+
+	curs := bucket.cursor()
+	for k, v := curs.Seek(uint64ToBytes(min)); k != nil; k, v = curs.Next() {
+		idx := bytesToUint64(k)
+		if idx > max {
+			break
+		}
+		log := new(raft.Log)
+		if err := bucket.decode(idx, v, log); err != nil {
+			return fmt.Errorf("%w at index %d: %w", ErrLogCorrupt, idx, err)
+		}
+		if err := encode(log); err != nil {
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	b.metrics.measureSince([]string{"exportRange"}, start)
+	return nil
+}
+
+// canonicalHeader is the first line written by ExportCanonical, naming
+// the format in case it ever has to change.
+const canonicalHeader = "# raft-boltdb canonical v1\n"
+
+// ExportCanonical writes a normalized text listing of the store to w, so
+// two stores can be compared with diff or a checksum. Each log entry is
+// written, in index order, as a line of
+//
+//	log <index> <term> <type> <data sha256> <extensions sha256>
+//
+// followed by each stable store key, in key order, as a line of
+//
+//	conf <quoted key> <hex value>
+//
+// Hashes are hex encoded, with "-" for empty data or extensions. Nothing
+// that depends on how the store was written is included, such as
+// AppendedAt, the options the entries were encoded with, or the keys the
+// store keeps its own bookkeeping under, so stores holding the same
+// state export the same bytes. Everything is read from a single
+// transaction, or one for each file if Options.StablePath is set.
+func (b *BoltStore) ExportCanonical(w io.Writer) error {
+	start := time.Now()
+	tx, err := b.begin(false)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	logs, err := b.logs(tx)
+	if err != nil {
+		return err
+	}
+	confTx, done, err := b.viewConf(tx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	conf, err := b.bucket(confTx, dbConf)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(canonicalHeader); err != nil {
+		return err
+	}
+
+	curs := logs.cursor()
+	for k, v := curs.First(); k != nil; k, v = curs.Next() {
+		idx := bytesToUint64(k)
+		var log raft.Log
+		if err := logs.decode(idx, v, &log); err != nil {
+			return fmt.Errorf("%w at index %d: %w", ErrLogCorrupt, idx, err)
+		}
+		_, err := fmt.Fprintf(bw, "log %d %d %s %s %s\n", idx, log.Term, log.Type, canonicalHash(log.Data), canonicalHash(log.Extensions))
+		if err != nil {
+			return err
+		}
+	}
+
+	confCurs := conf.Cursor()
+	for k, v := confCurs.First(); k != nil; k, v = confCurs.Next() {
+		if bytes.HasPrefix(k, internalKeyPrefix) {
+			continue
+		}
+		v, err := b.openConf(k, v)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(bw, "conf %q %x\n", k, v); err != nil {
+			return err
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	b.metrics.measureSince([]string{"exportCanonical"}, start)
+	return nil
+}
+
+// canonicalHash returns the hex encoded SHA-256 hash of data, or "-" if
+// it's empty.
+func canonicalHash(data []byte) string {
+	if len(data) == 0 {
+		return "-"
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,263 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-msgpack/v2/codec"
+	"github.com/hashicorp/raft"
+)
+
+func TestBoltStore_ExportRange(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	var logs []*raft.Log
+	for i := uint64(1); i <= 20; i++ {
+		log := testRaftLog(i, "data")
+		log.Extensions = []byte("ext")
+		logs = append(logs, log)
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err := store.ExportRange(&buf, 5, 9, FormatMsgpack); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	dec := codec.NewDecoder(&buf, &codec.MsgpackHandle{})
+	var got []*raft.Log
+	for {
+		log := new(raft.Log)
+		if err := dec.Decode(log); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		got = append(got, log)
+	}
+	if len(got) != 5 {
+		t.Fatalf("bad: %d entries", len(got))
+	}
+	for i, log := range got {
+		if !reflect.DeepEqual(log, logs[i+4]) {
+			t.Fatalf("bad: %#v, expected %#v", log, logs[i+4])
+		}
+	}
+
+	// Entries outside the log are skipped
+	buf.Reset()
+	if err := store.ExportRange(&buf, 18, 30, FormatNDJSON); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	var lines []jsonLog
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var line jsonLog
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 3 || lines[0].Index != 18 || lines[2].Index != 20 {
+		t.Fatalf("bad: %#v", lines)
+	}
+	if line := lines[0]; line.Type != "LogCommand" || string(line.Data) != "data" ||
+		string(line.Extensions) != "ext" || line.AppendedAt != nil {
+		t.Fatalf("bad: %#v", line)
+	}
+
+	if err := store.ExportRange(&buf, 1, 20, Format("xml")); err == nil {
+		t.Fatalf("expected an error for an unknown format")
+	}
+	if err := store.ExportRange(&buf, 10, 1, FormatNDJSON); err == nil {
+		t.Fatalf("expected an error for an invalid range")
+	}
+}
+
+func TestBoltStore_ImportLogs(t *testing.T) {
+	src := testBoltStore(t)
+	defer src.Close()
+	defer os.Remove(src.path)
+
+	var logs []*raft.Log
+	for i := uint64(1); i <= 25; i++ {
+		log := testRaftLog(i, "data")
+		log.Type = raft.LogConfiguration
+		logs = append(logs, log)
+	}
+	if err := src.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	for _, format := range []Format{FormatMsgpack, FormatNDJSON} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := src.ExportRange(&buf, 1, 25, format); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			export := buf.Bytes()
+
+			store := testBoltStore(t)
+			defer store.Close()
+			defer os.Remove(store.path)
+			n, err := store.ImportLogs(bytes.NewReader(export), ImportOptions{Format: format, ChunkSize: 10})
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if n != 25 {
+				t.Fatalf("bad: %d", n)
+			}
+			checkIndexes(t, store, 1, 25)
+			got, err := store.GetLogs(1, 25, nil)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if !reflect.DeepEqual(got, logs) {
+				t.Fatalf("bad: %#v", got)
+			}
+
+			// Importing again would overwrite everything
+			_, err = store.ImportLogs(bytes.NewReader(export), ImportOptions{Format: format})
+			if !errors.Is(err, ErrInvalidImport) {
+				t.Fatalf("expected invalid import error, got: %v", err)
+			}
+			n, err = store.ImportLogs(bytes.NewReader(export), ImportOptions{Format: format, Overwrite: true})
+			if err != nil || n != 25 {
+				t.Fatalf("bad: %d %v", n, err)
+			}
+
+			// Overwriting with a shorter stream deletes the entries after it
+			buf.Reset()
+			if err := src.ExportRange(&buf, 5, 15, format); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			n, err = store.ImportLogs(&buf, ImportOptions{Format: format, Overwrite: true, ChunkSize: 4})
+			if err != nil || n != 11 {
+				t.Fatalf("bad: %d %v", n, err)
+			}
+			checkIndexes(t, store, 1, 15)
+			if err := store.GetLog(16, new(raft.Log)); err != raft.ErrLogNotFound {
+				t.Fatalf("expected not found error, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestBoltStore_ImportLogs_Invalid(t *testing.T) {
+	src := testBoltStore(t)
+	defer src.Close()
+	defer os.Remove(src.path)
+	for i := uint64(1); i <= 10; i++ {
+		if err := src.StoreLog(testRaftLog(i, "data")); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	if err := src.DeleteRange(5, 5); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	var gap bytes.Buffer
+	if err := src.ExportRange(&gap, 1, 10, FormatMsgpack); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	var tail bytes.Buffer
+	if err := src.ExportRange(&tail, 8, 10, FormatMsgpack); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	// Everything before the gap is imported, in chunks
+	n, err := store.ImportLogs(&gap, ImportOptions{ChunkSize: 2})
+	if !errors.Is(err, ErrInvalidImport) {
+		t.Fatalf("expected invalid import error, got: %v", err)
+	}
+	if n != 4 {
+		t.Fatalf("bad: %d", n)
+	}
+	checkIndexes(t, store, 1, 4)
+
+	// A stream has to carry on from the end of the log
+	if _, err := store.ImportLogs(&tail, ImportOptions{}); !errors.Is(err, ErrInvalidImport) {
+		t.Fatalf("expected invalid import error, got: %v", err)
+	}
+	if _, err := store.ImportLogs(bytes.NewReader([]byte("{}")), ImportOptions{Format: FormatNDJSON}); err == nil {
+		t.Fatalf("expected an error for an invalid entry")
+	}
+	checkIndexes(t, store, 1, 4)
+}
+
+func TestBoltStore_ExportCanonical(t *testing.T) {
+	a := testBoltStore(t)
+	defer a.Close()
+	defer os.Remove(a.path)
+	b, err := New(Options{
+		Path:                    filepath.Join(t.TempDir(), "raft.db"),
+		Checksums:               true,
+		LogSegmentSize:          16,
+		MsgpackUseNewTimeFormat: true,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer b.Close()
+
+	for i, store := range []*BoltStore{a, b} {
+		log1 := testRaftLog(1, "")
+		log2 := testRaftLog(2, "data")
+		log2.Type = raft.LogConfiguration
+		log2.AppendedAt = time.Unix(int64(i), 0)
+		if err := store.StoreLogs([]*raft.Log{log1, log2}); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := store.SetUint64([]byte("CurrentTerm"), 3); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	var bufA, bufB bytes.Buffer
+	if err := a.ExportCanonical(&bufA); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := b.ExportCanonical(&bufB); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !bytes.Equal(bufA.Bytes(), bufB.Bytes()) {
+		t.Fatalf("exports differ:\n%s\n%s", bufA.String(), bufB.String())
+	}
+
+	expected := canonicalHeader +
+		"log 1 0 LogCommand - -\n" +
+		"log 2 0 LogConfiguration 3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7 -\n" +
+		"conf \"CurrentTerm\" 0000000000000003\n"
+	if bufA.String() != expected {
+		t.Fatalf("bad: %s", bufA.String())
+	}
+
+	// Any difference in state shows up
+	if err := b.StoreLog(testRaftLog(3, "more")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	bufB.Reset()
+	if err := b.ExportCanonical(&bufB); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if bytes.Equal(bufA.Bytes(), bufB.Bytes()) {
+		t.Fatalf("expected exports to differ")
+	}
+}
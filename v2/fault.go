@@ -0,0 +1,18 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !faultinject
+
+package raftboltdb
+
+// faults injects nothing outside of builds with the faultinject tag, see
+// fault_inject.go.
+type faults struct{}
+
+func (*faults) beforeCommit(path string) (bool, error) {
+	return false, nil
+}
+
+func (*faults) afterCommit(path string, crash bool, err error) error {
+	return err
+}
@@ -0,0 +1,105 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build faultinject
+
+package raftboltdb
+
+import (
+	"errors"
+	"os"
+	"sync"
+)
+
+var (
+	// ErrInjectedFault is returned by writes to a store that has crashed
+	// through its FaultInjector.
+	ErrInjectedFault = errors.New("injected fault")
+)
+
+// FaultInjector makes a store crash part way through its writes, for
+// testing how it recovers. It's only available in builds with the
+// faultinject tag, see BoltStore.InjectFaults.
+type FaultInjector struct {
+	// FailAfterWrites is how many write transactions commit normally.
+	// The next one is committed, but fails with ErrInjectedFault as if
+	// the process had crashed before the write returned, and every write
+	// after it fails with ErrInjectedFault without being made.
+	FailAfterWrites int
+
+	// OnCrash, if not nil, is called with the contents of the store's
+	// file from just before and just after the write that crashed, from
+	// which CrashTest builds what the crash could have left on disk.
+	OnCrash func(before, after []byte)
+}
+
+// faults holds the FaultInjector given to InjectFaults and how far the
+// store has got through it.
+type faults struct {
+	lock     sync.Mutex
+	injector *FaultInjector
+	writes   int
+	crashed  bool
+	before   []byte
+}
+
+// InjectFaults makes the store's writes fail as injector describes,
+// counting from this call. A nil injector stops injecting faults, and
+// lets a crashed store write again.
+func (b *BoltStore) InjectFaults(injector *FaultInjector) {
+	b.faults.lock.Lock()
+	defer b.faults.lock.Unlock()
+	b.faults.injector = injector
+	b.faults.writes = 0
+	b.faults.crashed = false
+	b.faults.before = nil
+}
+
+// beforeCommit is called before a write transaction to the file at path
+// commits. It returns whether the write is the one that crashes, or
+// ErrInjectedFault if the store has already crashed.
+func (f *faults) beforeCommit(path string) (bool, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.injector == nil {
+		return false, nil
+	}
+	if f.crashed {
+		return false, ErrInjectedFault
+	}
+	f.writes++
+	if f.writes <= f.injector.FailAfterWrites {
+		return false, nil
+	}
+	f.crashed = true
+	if f.injector.OnCrash != nil {
+		before, err := os.ReadFile(path)
+		if err != nil {
+			return false, err
+		}
+		f.before = before
+	}
+	return true, nil
+}
+
+// afterCommit is called with the result of the commit that beforeCommit
+// was called for, and returns the error the write should fail with.
+func (f *faults) afterCommit(path string, crash bool, err error) error {
+	if !crash {
+		return err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.injector.OnCrash != nil {
+		after, readErr := os.ReadFile(path)
+		if readErr != nil {
+			return readErr
+		}
+		f.injector.OnCrash(f.before, after)
+		f.before = nil
+	}
+	if err != nil {
+		return err
+	}
+	return ErrInjectedFault
+}
@@ -0,0 +1,189 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+)
+
+// The layout of Bbolt's pages on disk, as of format version 2. Bbolt
+// writes its structs in the machine's byte order, which is little endian
+// on every platform it's commonly run on.
+const (
+	bboltMagic          = 0xED0CDAED
+	bboltVersion        = 2
+	bboltPageHeaderSize = 16
+	bboltMetaSize       = 64
+	bboltFreelistPage   = 0x10
+	bboltNoFreelist     = 0xffffffffffffffff
+
+	// fileStatsRetries is how many times ReadFileStats re-reads a file
+	// whose freelist changed while it was being read.
+	fileStatsRetries = 5
+)
+
+var (
+	// ErrNotBoltFile is returned by ReadFileStats for a file that doesn't
+	// have a valid Bbolt meta page.
+	ErrNotBoltFile = errors.New("not a valid bbolt file")
+)
+
+// FileStats is what can be told about a database file from its meta and
+// freelist pages, without opening it.
+type FileStats struct {
+	// FileSize is the size of the file in bytes, which includes space
+	// Bbolt has allocated ahead of need.
+	FileSize int64
+
+	// PageSize is the size of the file's pages in bytes.
+	PageSize int
+
+	// TxID is the ID of the last write transaction committed. Bbolt
+	// counts up from one, so it's the number of commits the file has
+	// seen since it was created or last compacted.
+	TxID uint64
+
+	// Pages is the number of pages in the file that have been used,
+	// including free ones.
+	Pages uint64
+
+	// FreePages is the number of pages on the freelist, including ones
+	// that are pending until the transactions reading them finish.
+	FreePages uint64
+
+	// FreelistBytes is the size of the freelist, which Bbolt writes on
+	// every commit. It's zero if the freelist isn't synced, see
+	// Options.NoFreelistSync, and FreePages is then unknown.
+	FreelistBytes int64
+}
+
+// ReadFileStats reads the meta and freelist pages of the database file at
+// path directly, without locking the file, so it can be used to watch a
+// file that a running store has open. Every other page is left unread.
+// The stats are those of the last committed transaction.
+func ReadFileStats(path string) (*FileStats, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	// The freelist can be freed and rewritten between reading the meta
+	// page and reading it, so retry until both agree
+	for i := 0; ; i++ {
+		stats, err := readFileStats(fh)
+		if err == nil || !errors.Is(err, errTornRead) || i == fileStatsRetries {
+			return stats, err
+		}
+	}
+}
+
+// errTornRead is returned by readFileStats when the file changed while
+// it was being read.
+var errTornRead = errors.New("file changed while it was being read")
+
+func readFileStats(fh *os.File) (*FileStats, error) {
+	fi, err := fh.Stat()
+	if err != nil {
+		return nil, err
+	}
+	stats, freelist, err := readMeta(fh)
+	if err != nil {
+		return nil, err
+	}
+	stats.FileSize = fi.Size()
+	if freelist == bboltNoFreelist {
+		return stats, nil
+	}
+
+	pageSize := int64(stats.PageSize)
+	hdr := make([]byte, bboltPageHeaderSize+8)
+	if _, err := fh.ReadAt(hdr, int64(freelist)*pageSize); err != nil {
+		return nil, errTornRead
+	}
+	id := binary.LittleEndian.Uint64(hdr[0:])
+	flags := binary.LittleEndian.Uint16(hdr[8:])
+	count := uint64(binary.LittleEndian.Uint16(hdr[10:]))
+	overflow := binary.LittleEndian.Uint32(hdr[12:])
+	if id != freelist || flags != bboltFreelistPage {
+		return nil, errTornRead
+	}
+	// A count that doesn't fit the header is stored in the first element
+	if count == 0xffff {
+		count = binary.LittleEndian.Uint64(hdr[bboltPageHeaderSize:])
+	}
+	stats.FreePages = count
+	stats.FreelistBytes = int64(overflow+1) * pageSize
+
+	// The freelist page can only be reused once a later transaction has
+	// committed, so if none has it was read intact
+	again, _, err := readMeta(fh)
+	if err != nil || again.TxID != stats.TxID {
+		return nil, errTornRead
+	}
+	return stats, nil
+}
+
+// readMeta reads the current meta page, returning the stats it holds and
+// the page ID of the freelist. Bbolt writes the two meta pages at the
+// start of the file alternately, so the current one is the valid one
+// with the highest transaction ID.
+func readMeta(fh *os.File) (*FileStats, uint64, error) {
+	buf := make([]byte, bboltPageHeaderSize+bboltMetaSize)
+	if _, err := fh.ReadAt(buf, 0); err != nil {
+		if err == io.EOF {
+			return nil, 0, ErrNotBoltFile
+		}
+		return nil, 0, err
+	}
+	// The page size can be taken from a torn first meta page, as it never
+	// changes
+	if binary.LittleEndian.Uint32(buf[bboltPageHeaderSize:]) != bboltMagic {
+		return nil, 0, ErrNotBoltFile
+	}
+	pageSize := int64(binary.LittleEndian.Uint32(buf[bboltPageHeaderSize+8:]))
+	if pageSize < bboltPageHeaderSize+bboltMetaSize {
+		return nil, 0, ErrNotBoltFile
+	}
+	cur, curFreelist, curErr := parseBoltMeta(buf[bboltPageHeaderSize:])
+
+	if _, err := fh.ReadAt(buf, pageSize); err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+	meta, freelist, err := parseBoltMeta(buf[bboltPageHeaderSize:])
+	switch {
+	case err != nil && curErr != nil:
+		return nil, 0, curErr
+	case err == nil && (curErr != nil || meta.TxID > cur.TxID):
+		return meta, freelist, nil
+	}
+	return cur, curFreelist, nil
+}
+
+// parseBoltMeta decodes a meta page, returning the stats it holds and the
+// page ID of the freelist.
+func parseBoltMeta(buf []byte) (*FileStats, uint64, error) {
+	le := binary.LittleEndian
+	if le.Uint32(buf[0:]) != bboltMagic {
+		return nil, 0, ErrNotBoltFile
+	}
+	if v := le.Uint32(buf[4:]); v != bboltVersion {
+		return nil, 0, fmt.Errorf("%w: unsupported version %d", ErrNotBoltFile, v)
+	}
+	h := fnv.New64a()
+	h.Write(buf[:56])
+	if h.Sum64() != le.Uint64(buf[56:]) {
+		return nil, 0, fmt.Errorf("%w: meta page checksum mismatch", ErrNotBoltFile)
+	}
+	return &FileStats{
+		PageSize: int(le.Uint32(buf[8:])),
+		Pages:    le.Uint64(buf[40:]),
+		TxID:     le.Uint64(buf[48:]),
+	}, le.Uint64(buf[32:]), nil
+}
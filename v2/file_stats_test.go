@@ -0,0 +1,126 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"go.etcd.io/bbolt"
+)
+
+func TestReadFileStats(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	var logs []*raft.Log
+	for i := uint64(1); i <= 2000; i++ {
+		logs = append(logs, testRaftLog(i, fmt.Sprintf("log%d", i)))
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.DeleteRange(1, 1500); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The file can be read while the store has it locked
+	stats, err := ReadFileStats(store.path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if stats.FreePages == 0 || stats.FreelistBytes == 0 {
+		t.Fatalf("bad: %#v", stats)
+	}
+
+	// And matches what Bbolt reports once it's closed
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	db, err := bbolt.Open(store.path, dbFileMode, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer db.Close()
+	tx, err := db.Begin(false)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer tx.Rollback()
+	fi, err := os.Stat(store.path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	dbStats := db.Stats()
+	expected := FileStats{
+		FileSize:      fi.Size(),
+		PageSize:      db.Info().PageSize,
+		TxID:          uint64(tx.ID()),
+		Pages:         uint64(tx.Size()) / uint64(db.Info().PageSize),
+		FreePages:     uint64(dbStats.FreePageN + dbStats.PendingPageN),
+		FreelistBytes: stats.FreelistBytes,
+	}
+	if *stats != expected {
+		t.Fatalf("bad: %#v %#v", stats, expected)
+	}
+}
+
+func TestReadFileStats_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "raft.db")
+	if err := os.WriteFile(path, []byte("not a database"), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := ReadFileStats(path); !errors.Is(err, ErrNotBoltFile) {
+		t.Fatalf("bad: %v", err)
+	}
+}
+
+func TestReadFileStats_Concurrent(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	done := make(chan error, 1)
+	go func() {
+		for i := uint64(1); i <= 500; i++ {
+			if err := store.StoreLog(testRaftLog(i, "log")); err != nil {
+				done <- err
+				return
+			}
+			if i > 10 {
+				if err := store.DeleteRange(i-10, i-10); err != nil {
+					done <- err
+					return
+				}
+			}
+		}
+		done <- nil
+	}()
+
+	var last uint64
+	for {
+		stats, err := ReadFileStats(store.path)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if stats.TxID < last {
+			t.Fatalf("bad: %d %d", stats.TxID, last)
+		}
+		last = stats.TxID
+
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			return
+		default:
+		}
+	}
+}
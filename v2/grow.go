@@ -0,0 +1,153 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"os"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// Grow makes the store's file at least n bytes long, and its memory map
+// large enough to cover that, so the store can grow to n bytes without
+// Bbolt remapping the file, which makes writes wait for every open read
+// transaction and holds up reads while it runs. Once the file is full,
+// Bbolt goes back to extending it in its usual steps.
+//
+// The store is reopened to enlarge the memory map, so like Compact, Grow
+// waits for the write in progress and holds up other operations until
+// it's done. It does nothing if the file is already n bytes long and the
+// memory map has already been made that large.
+func (b *BoltStore) Grow(n int64) error {
+	if b.readOnly {
+		return ErrReadOnly
+	}
+	start := time.Now()
+
+	b.connLock.Lock()
+	defer b.connLock.Unlock()
+
+	// Start a write transaction so we wait for any that are in flight
+	tx, err := b.beginLocked(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	oldSize, err := b.fileSize()
+	if err != nil {
+		return err
+	}
+	if n <= oldSize && int(n) <= b.boltOptions.InitialMmapSize {
+		return nil
+	}
+	if n > oldSize {
+		if err := truncateFile(b.path, n); err != nil {
+			return b.diskError(b.path, err)
+		}
+		b.preGrownSize.Store(n)
+	}
+	b.boltOptions.InitialMmapSize = int(n)
+	tx.Rollback()
+	if err := b.reopen(); err != nil {
+		return err
+	}
+	b.grew(oldSize, n)
+
+	b.metrics.measureSince([]string{"grow"}, start)
+	b.logger.Info("grew bolt file", "path", b.path, "duration", time.Since(start),
+		"old_size", oldSize, "new_size", max(oldSize, n))
+	return nil
+}
+
+// preGrow grows the file of handle to n bytes if Bbolt has only just
+// created it, returning the size it was grown from, or zero if it wasn't
+// grown.
+func preGrow(handle *bbolt.DB, n int64) (int64, error) {
+	// A file Bbolt has only just created has had no commits yet
+	created := false
+	if err := handle.View(func(tx *bbolt.Tx) error {
+		created = tx.ID() <= 1
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	if !created || handle.IsReadOnly() {
+		return 0, nil
+	}
+	fi, err := os.Stat(handle.Path())
+	if err != nil {
+		return 0, err
+	}
+	if fi.Size() >= n {
+		return 0, nil
+	}
+	if err := truncateFile(handle.Path(), n); err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// raiseAllocSize keeps Bbolt from cutting a pre-grown file back. Bbolt
+// doesn't know the size of the file it opened, so the first time it
+// extends it, it truncates it to what it needs plus AllocSize. Until
+// then, AllocSize is raised to the size the file was grown to, and it's
+// restored by restoreAllocSize once Bbolt has extended the file past it.
+// It must be called with connLock held, or before the store is shared.
+func (b *BoltStore) raiseAllocSize(handle *bbolt.DB) {
+	if n := b.preGrownSize.Load(); n != 0 {
+		handle.AllocSize = max(handle.AllocSize, int(n))
+	}
+}
+
+// restoreAllocSize puts Bbolt's AllocSize back to its default once the
+// file has been extended past the size it was pre-grown to, so it
+// carries on growing in the usual steps. It's called after each commit.
+func (b *BoltStore) restoreAllocSize() {
+	n := b.preGrownSize.Load()
+	if n == 0 {
+		return
+	}
+	if size, err := b.fileSize(); err != nil || size <= n {
+		return
+	}
+
+	// Commits read AllocSize, so it's only changed while no other
+	// transaction can be writing
+	b.connLock.RLock()
+	defer b.connLock.RUnlock()
+	tx, err := b.beginLocked(true)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	if b.preGrownSize.CompareAndSwap(n, 0) {
+		b.conn.AllocSize = bbolt.DefaultAllocSize
+	}
+}
+
+// grew counts the file growing to newSize in the fileGrowth metric as
+// soon as it's grown, rather than the next time metrics are emitted.
+func (b *BoltStore) grew(oldSize, newSize int64) {
+	if last := b.lastFileSize.Swap(newSize); last != 0 {
+		oldSize = last
+	}
+	if newSize > oldSize {
+		b.metrics.incrCounter([]string{"fileGrowth"}, float32(newSize-oldSize))
+	}
+}
+
+// truncateFile sets the size of the file at path to n, and syncs it so
+// the new size is durable.
+func truncateFile(path string, n int64) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := f.Truncate(n); err != nil {
+		return err
+	}
+	return f.Sync()
+}
@@ -0,0 +1,95 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"go.etcd.io/bbolt"
+)
+
+func TestBoltStore_InitialSizeBytes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "raft.db")
+	sink := metrics.NewInmemSink(time.Minute, time.Minute)
+	store, err := New(Options{Path: path, InitialSizeBytes: 4 << 20, MetricSink: sink})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	size, err := store.fileSize()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if size < 4<<20 {
+		t.Fatalf("bad: %d", size)
+	}
+	if counter := sink.Data()[0].Counters["raft.boltdb.fileGrowth"]; counter.Sum < 4<<20-64<<10 {
+		t.Fatalf("bad: %#v", counter)
+	}
+
+	// Growing into the space doesn't shrink the file back
+	for i := uint64(1); i <= 10; i++ {
+		if err := store.StoreLog(testRaftLog(i, string(make([]byte, 64<<10)))); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	if size, _ := store.fileSize(); size < 4<<20 {
+		t.Fatalf("bad: %d", size)
+	}
+
+	// Once Bbolt has extended the file, it goes back to its usual steps
+	for i := uint64(11); i <= 100; i++ {
+		if err := store.StoreLog(testRaftLog(i, string(make([]byte, 64<<10)))); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	if n := store.db().AllocSize; n != bbolt.DefaultAllocSize {
+		t.Fatalf("bad: %d", n)
+	}
+}
+
+func TestBoltStore_Grow(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	if err := store.StoreLog(testRaftLog(1, "log1")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Grow(32 << 20); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if size, _ := store.fileSize(); size != 32<<20 {
+		t.Fatalf("bad: %d", size)
+	}
+	if store.boltOptions.InitialMmapSize != 32<<20 || store.db().AllocSize != 32<<20 {
+		t.Fatalf("bad: %d %d", store.boltOptions.InitialMmapSize, store.db().AllocSize)
+	}
+
+	// Nothing is lost, and growing again to the same size does nothing
+	if err := store.Grow(32 << 20); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.StoreLog(testRaftLog(2, "log2")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	last, err := store.LastIndex()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if last != 2 {
+		t.Fatalf("bad: %d", last)
+	}
+
+	store.Close()
+	if err := store.Grow(64 << 20); !errors.Is(err, ErrClosed) {
+		t.Fatalf("err: %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultHealthWindow is used when HealthPolicy.Window isn't set.
+	defaultHealthWindow = 1000
+
+	// defaultHealthCheckInterval is used when HealthPolicy.CheckInterval
+	// isn't set.
+	defaultHealthCheckInterval = time.Second
+)
+
+// HealthStatus is the state of the store's writes, see Health.
+type HealthStatus int
+
+const (
+	// HealthOK means writes are within the HealthPolicy's thresholds.
+	HealthOK HealthStatus = iota
+
+	// HealthDegraded means writes are slower than
+	// HealthPolicy.DegradedP99.
+	HealthDegraded
+
+	// HealthFailed means writes are slower than HealthPolicy.FailedP99,
+	// or the last commit failed.
+	HealthFailed
+)
+
+// String returns the status as a word.
+func (s HealthStatus) String() string {
+	switch s {
+	case HealthOK:
+		return "ok"
+	case HealthDegraded:
+		return "degraded"
+	default:
+		return "failed"
+	}
+}
+
+// HealthPolicy makes the store keep track of how long its commits take,
+// so a failing disk can be flagged by Health before raft notices. The p99
+// latency of the last Window commits is compared with the thresholds, as
+// is the time taken so far by a commit that's still running, so a write
+// that has stalled altogether is caught too. Commits only record their
+// latency, and the p99 is worked out when Health is called and every
+// CheckInterval, so it costs nothing on the write path. At least one
+// threshold must be set.
+type HealthPolicy struct {
+	// DegradedP99 and FailedP99 are the commit latencies at which the
+	// store is degraded and failed. Zero disables either. With
+	// Options.BatchWrites a commit's latency includes the time spent
+	// waiting for the batch, up to MaxBatchDelay.
+	DegradedP99 time.Duration
+	FailedP99   time.Duration
+
+	// Window is how many of the most recent commits the p99 is taken
+	// over. Defaults to 1000.
+	Window int
+
+	// CheckInterval is how often the health is worked out, and the
+	// health gauge emitted. Defaults to one second.
+	CheckInterval time.Duration
+
+	// OnChange is called with the new health whenever the status
+	// changes. It's called synchronously from the check or call to
+	// Health that noticed, possibly from several goroutines at once, so
+	// must not block or use the store.
+	OnChange func(Health)
+}
+
+// window returns how many commits the p99 is taken over.
+func (p *HealthPolicy) window() int {
+	if p.Window == 0 {
+		return defaultHealthWindow
+	}
+	return p.Window
+}
+
+// checkInterval returns how often the health is worked out.
+func (p *HealthPolicy) checkInterval() time.Duration {
+	if p.CheckInterval == 0 {
+		return defaultHealthCheckInterval
+	}
+	return p.CheckInterval
+}
+
+// Health is the state of the store's writes, returned by
+// BoltStore.Health.
+type Health struct {
+	Status HealthStatus
+
+	// P99 is the p99 latency of the commits in the window, and Commits
+	// how many of them there are.
+	P99     time.Duration
+	Commits int
+
+	// Stall is how long the oldest commit that's still running has taken
+	// so far, or zero if none is.
+	Stall time.Duration
+
+	// Err is the error from the last commit, if it failed.
+	Err error
+}
+
+// commitTracker keeps the latencies of the most recent commits for
+// Health. Its methods do nothing on a nil tracker, which is used when
+// Options.HealthPolicy isn't set.
+type commitTracker struct {
+	policy HealthPolicy
+
+	lock    sync.Mutex
+	samples []time.Duration
+	next    int
+	sorted  []time.Duration
+	running map[uint64]time.Time
+	seq     uint64
+	lastErr error
+	status  HealthStatus
+}
+
+// newCommitTracker returns a tracker for the given policy.
+func newCommitTracker(policy HealthPolicy) *commitTracker {
+	return &commitTracker{
+		policy:  policy,
+		samples: make([]time.Duration, 0, policy.window()),
+		running: make(map[uint64]time.Time),
+	}
+}
+
+// begin records that a commit has started, returning an id to pass to
+// end once it has finished.
+func (c *commitTracker) begin() uint64 {
+	if c == nil {
+		return 0
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.seq++
+	c.running[c.seq] = time.Now()
+	return c.seq
+}
+
+// end records that the commit with the given id took elapsed and
+// returned err.
+func (c *commitTracker) end(id uint64, elapsed time.Duration, err error) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	delete(c.running, id)
+	if len(c.samples) < cap(c.samples) {
+		c.samples = append(c.samples, elapsed)
+	} else {
+		c.samples[c.next] = elapsed
+		c.next = (c.next + 1) % len(c.samples)
+	}
+	c.lastErr = err
+	c.lock.Unlock()
+}
+
+// check works out the health at the given time, calling OnChange if the
+// status has changed.
+func (c *commitTracker) check(now time.Time) Health {
+	c.lock.Lock()
+	h, changed := c.update(now)
+	c.lock.Unlock()
+	if changed {
+		c.notify(h)
+	}
+	return h
+}
+
+// update works out the health at the given time, returning true if the
+// status has changed. It must be called with lock held.
+func (c *commitTracker) update(now time.Time) (Health, bool) {
+	h := Health{Commits: len(c.samples), Err: c.lastErr}
+	if len(c.samples) > 0 {
+		c.sorted = append(c.sorted[:0], c.samples...)
+		sort.Slice(c.sorted, func(i, j int) bool { return c.sorted[i] < c.sorted[j] })
+		h.P99 = c.sorted[(len(c.sorted)*99-1)/100]
+	}
+	for _, start := range c.running {
+		h.Stall = max(h.Stall, now.Sub(start))
+	}
+
+	worst := max(h.P99, h.Stall)
+	switch {
+	case h.Err != nil, over(worst, c.policy.FailedP99):
+		h.Status = HealthFailed
+	case over(worst, c.policy.DegradedP99):
+		h.Status = HealthDegraded
+	}
+	changed := h.Status != c.status
+	c.status = h.Status
+	return h, changed
+}
+
+// notify calls the policy's OnChange, if it's set.
+func (c *commitTracker) notify(h Health) {
+	if c.policy.OnChange != nil {
+		c.policy.OnChange(h)
+	}
+}
+
+// over returns true if d has reached threshold, which is disabled if zero.
+func over(d, threshold time.Duration) bool {
+	return threshold > 0 && d >= threshold
+}
+
+// Health reports whether writes to the log file are keeping within
+// Options.HealthPolicy, see HealthPolicy. It always returns HealthOK if
+// no policy is set.
+func (b *BoltStore) Health() Health {
+	if b.health == nil {
+		return Health{Status: HealthOK}
+	}
+	return b.health.check(time.Now())
+}
+
+// runHealthCheck works out the health every interval until the store is
+// closed.
+func (b *BoltStore) runHealthCheck() {
+	ticker := time.NewTicker(b.health.policy.checkInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-b.closeCh:
+			return
+		}
+		h := b.health.check(time.Now())
+		b.metrics.setGauge([]string{"health"}, float32(h.Status))
+		b.metrics.setGauge([]string{"commitP99"}, float32(h.P99.Milliseconds()))
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBoltStore_Health(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "raft.db")
+
+	// Without a policy the store is always healthy
+	store, err := New(Options{Path: path})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if h := store.Health(); h.Status != HealthOK || h.Commits != 0 {
+		t.Fatalf("bad: %+v", h)
+	}
+	store.Close()
+
+	// Every commit takes longer than a nanosecond
+	var changes []HealthStatus
+	store, err = New(Options{Path: path, HealthPolicy: &HealthPolicy{
+		DegradedP99:   time.Nanosecond,
+		FailedP99:     time.Hour,
+		CheckInterval: time.Hour,
+		OnChange:      func(h Health) { changes = append(changes, h.Status) },
+	}})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	if h := store.Health(); h.Status != HealthOK {
+		t.Fatalf("bad: %+v", h)
+	}
+	for i := uint64(1); i <= 10; i++ {
+		if err := store.StoreLog(testRaftLog(i, "log")); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	// Commits only record their latency, and the status changes once
+	// it's checked
+	if len(changes) != 0 {
+		t.Fatalf("bad: %v", changes)
+	}
+	h := store.Health()
+	if h.Status != HealthDegraded || h.Commits != 10 || h.P99 <= 0 {
+		t.Fatalf("bad: %+v", h)
+	}
+	if len(changes) != 1 || changes[0] != HealthDegraded {
+		t.Fatalf("bad: %v", changes)
+	}
+}
+
+func TestCommitTracker(t *testing.T) {
+	c := newCommitTracker(HealthPolicy{DegradedP99: 10 * time.Millisecond, FailedP99: time.Second, Window: 100})
+
+	// The p99 ignores the slowest 1%
+	for i := 0; i < 200; i++ {
+		c.end(c.begin(), time.Millisecond, nil)
+	}
+	c.end(c.begin(), time.Minute, nil)
+	if h := c.check(time.Now()); h.Status != HealthOK || h.P99 != time.Millisecond || h.Commits != 100 {
+		t.Fatalf("bad: %+v", h)
+	}
+	c.end(c.begin(), 20*time.Millisecond, nil)
+	if h := c.check(time.Now()); h.Status != HealthDegraded {
+		t.Fatalf("bad: %+v", h)
+	}
+
+	// A stalled commit fails the store until it finishes
+	id := c.begin()
+	h := c.check(time.Now().Add(time.Minute))
+	if h.Status != HealthFailed || h.Stall < time.Minute {
+		t.Fatalf("bad: %+v", h)
+	}
+	c.end(id, time.Millisecond, nil)
+	if h := c.check(time.Now()); h.Status != HealthDegraded || h.Stall != 0 {
+		t.Fatalf("bad: %+v", h)
+	}
+
+	// So does a commit that failed
+	errDisk := errors.New("disk")
+	c.end(c.begin(), time.Millisecond, errDisk)
+	if h := c.check(time.Now()); h.Status != HealthFailed || h.Err != errDisk {
+		t.Fatalf("bad: %+v", h)
+	}
+	if HealthFailed.String() != "failed" {
+		t.Fatalf("bad: %s", HealthFailed)
+	}
+}
+
+func TestBoltStore_HealthPolicy_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "raft.db")
+	for _, p := range []*HealthPolicy{
+		{},
+		{DegradedP99: time.Second, FailedP99: time.Millisecond},
+		{FailedP99: time.Second, Window: -1},
+	} {
+		if _, err := New(Options{Path: path, HealthPolicy: p}); !errors.Is(err, ErrInvalidOptions) {
+			t.Fatalf("err: %v", err)
+		}
+	}
+}
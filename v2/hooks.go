@@ -0,0 +1,124 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"time"
+)
+
+// Hooks are callbacks invoked after store operations, so embedders can
+// feed their own telemetry, rate limiters or audit systems. Any of them
+// may be nil. Hooks run synchronously on the goroutine that called the
+// store, after the operation's transaction has finished, so they must be
+// quick and must not block.
+type Hooks struct {
+	// OnStoreLogs is called after every StoreLogs and StoreLog call.
+	OnStoreLogs func(StoreLogsInfo)
+
+	// OnDeleteRange is called after every DeleteRange call.
+	OnDeleteRange func(DeleteRangeInfo)
+
+	// OnGet is called after every GetLog and Get call, including those
+	// made through GetUint64.
+	OnGet func(GetInfo)
+
+	// OnCommit is called after every write transaction made by
+	// StoreLogs, DeleteRange and Set is committed.
+	OnCommit func(CommitInfo)
+}
+
+// StoreLogsInfo describes a StoreLogs call.
+type StoreLogsInfo struct {
+	// Logs is the number of entries in the batch.
+	Logs int
+
+	// Bytes is the encoded size of the entries that were written.
+	Bytes int
+
+	// Duration is the time taken by the whole call, and CommitTime the
+	// part of that spent committing the transaction.
+	Duration   time.Duration
+	CommitTime time.Duration
+
+	// Err is the error returned to the caller, if any.
+	Err error
+}
+
+// DeleteRangeInfo describes a DeleteRange call.
+type DeleteRangeInfo struct {
+	// Min and Max are the requested range.
+	Min, Max uint64
+
+	// Logs is the number of entries that were deleted, and Bytes their
+	// encoded size. Bytes doesn't include segments that were dropped as
+	// a whole when Options.LogSegmentSize is set.
+	Logs  int
+	Bytes int
+
+	// Duration is the time taken by the whole call.
+	Duration time.Duration
+
+	// Err is the error returned to the caller, if any.
+	Err error
+}
+
+// GetInfo describes a GetLog or Get call.
+type GetInfo struct {
+	// Op is either "GetLog" or "Get".
+	Op string
+
+	// Index is the requested log index for GetLog, and Key the requested
+	// key for Get.
+	Index uint64
+	Key   []byte
+
+	// Bytes is the size of the stored value, or zero if it wasn't found
+	// or was served from the cache.
+	Bytes int
+
+	// Cached is set if GetLog was served from the in-memory cache.
+	Cached bool
+
+	// Duration is the time taken by the whole call.
+	Duration time.Duration
+
+	// Err is the error returned to the caller, if any.
+	Err error
+}
+
+// CommitInfo describes the commit of a write transaction.
+type CommitInfo struct {
+	// Op is the operation that made the transaction, e.g. "StoreLogs".
+	Op string
+
+	// Duration is the time taken to commit, including the fsync.
+	Duration time.Duration
+
+	// Err is the error returned by the commit, if any.
+	Err error
+}
+
+func (h *Hooks) storeLogs(info StoreLogsInfo) {
+	if h.OnStoreLogs != nil {
+		h.OnStoreLogs(info)
+	}
+}
+
+func (h *Hooks) deleteRange(info DeleteRangeInfo) {
+	if h.OnDeleteRange != nil {
+		h.OnDeleteRange(info)
+	}
+}
+
+func (h *Hooks) get(info GetInfo) {
+	if h.OnGet != nil {
+		h.OnGet(info)
+	}
+}
+
+func (h *Hooks) commit(info CommitInfo) {
+	if h.OnCommit != nil {
+		h.OnCommit(info)
+	}
+}
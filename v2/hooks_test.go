@@ -0,0 +1,114 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestBoltStore_Hooks(t *testing.T) {
+	fh, err := ioutil.TempFile("", "bolt")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	os.Remove(fh.Name())
+	defer os.Remove(fh.Name())
+
+	var (
+		stores  []StoreLogsInfo
+		deletes []DeleteRangeInfo
+		gets    []GetInfo
+		commits []CommitInfo
+	)
+	store, err := New(Options{
+		Path: fh.Name(),
+		Hooks: Hooks{
+			OnStoreLogs:   func(info StoreLogsInfo) { stores = append(stores, info) },
+			OnDeleteRange: func(info DeleteRangeInfo) { deletes = append(deletes, info) },
+			OnGet:         func(info GetInfo) { gets = append(gets, info) },
+			OnCommit:      func(info CommitInfo) { commits = append(commits, info) },
+		},
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	logs := []*raft.Log{
+		testRaftLog(1, "log1"),
+		testRaftLog(2, "log2"),
+		testRaftLog(3, "log3"),
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(stores) != 1 {
+		t.Fatalf("bad: %v", stores)
+	}
+	if info := stores[0]; info.Logs != 3 || info.Bytes <= 0 || info.Duration < info.CommitTime || info.Err != nil {
+		t.Fatalf("bad: %#v", info)
+	}
+
+	var log raft.Log
+	if err := store.GetLog(2, &log); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.GetLog(4, &log); err != raft.ErrLogNotFound {
+		t.Fatalf("expected not found error, got: %v", err)
+	}
+	if err := store.SetUint64([]byte("CurrentTerm"), 2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := store.GetUint64([]byte("CurrentTerm")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(gets) != 3 {
+		t.Fatalf("bad: %v", gets)
+	}
+	if info := gets[0]; info.Op != "GetLog" || info.Index != 2 || info.Bytes <= 0 || info.Err != nil {
+		t.Fatalf("bad: %#v", info)
+	}
+	if info := gets[1]; info.Index != 4 || info.Bytes != 0 || info.Err != raft.ErrLogNotFound {
+		t.Fatalf("bad: %#v", info)
+	}
+	if info := gets[2]; info.Op != "Get" || string(info.Key) != "CurrentTerm" || info.Bytes != 8 {
+		t.Fatalf("bad: %#v", info)
+	}
+
+	if err := store.DeleteRange(1, 2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(deletes) != 1 {
+		t.Fatalf("bad: %v", deletes)
+	}
+	if info := deletes[0]; info.Min != 1 || info.Max != 2 || info.Logs != 2 || info.Bytes <= 0 {
+		t.Fatalf("bad: %#v", info)
+	}
+
+	expected := []string{"StoreLogs", "Set", "DeleteRange"}
+	if len(commits) != len(expected) {
+		t.Fatalf("bad: %v", commits)
+	}
+	for i, op := range expected {
+		if commits[i].Op != op || commits[i].Err != nil {
+			t.Fatalf("bad commit %d: %#v", i, commits[i])
+		}
+	}
+
+	// Failed calls are reported too
+	store.Close()
+	if err := store.StoreLogs(logs); err != ErrClosed {
+		t.Fatalf("expected closed error, got: %v", err)
+	}
+	if info := stores[len(stores)-1]; info.Err != ErrClosed || info.Bytes != 0 {
+		t.Fatalf("bad: %#v", info)
+	}
+	if len(commits) != len(expected) {
+		t.Fatalf("bad: %v", commits)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/hashicorp/go-msgpack/v2/codec"
+	"github.com/hashicorp/raft"
+)
+
+const (
+	// defaultImportChunkSize is used when ImportOptions.ChunkSize isn't
+	// set.
+	defaultImportChunkSize = 1000
+)
+
+var (
+	// ErrInvalidImport is returned when an import stream can't be
+	// appended to the store, either because its indexes aren't
+	// contiguous or because it would overwrite entries without
+	// ImportOptions.Overwrite.
+	ErrInvalidImport = errors.New("invalid import")
+
+	// logTypes maps the names used by FormatNDJSON back to log types.
+	logTypes     map[string]raft.LogType
+	logTypesOnce sync.Once
+)
+
+// ImportOptions controls how ImportLogs reads a stream.
+type ImportOptions struct {
+	// Format is the stream's format. Defaults to FormatMsgpack.
+	Format Format
+
+	// Overwrite allows the stream to start at or before the store's last
+	// index, replacing the entries it has in common with the store. Any
+	// entries after the end of the stream are deleted before the first
+	// chunk is written, as raft does when it replaces a conflicting
+	// suffix of the log, so none of them are left after the imported ones.
+	Overwrite bool
+
+	// ChunkSize is the number of entries written in each transaction.
+	// Defaults to 1000.
+	ChunkSize int
+}
+
+// ImportLogs appends the log entries in a stream written by ExportRange
+// to the store, returning how many were imported. The indexes in the
+// stream must be contiguous, and must carry on from the store's last
+// index unless the store is empty or Overwrite is set. Entries are
+// written in chunks, so if the stream turns out to be invalid part way
+// through, the chunks before it have already been imported.
+func (b *BoltStore) ImportLogs(r io.Reader, opts ImportOptions) (int, error) {
+	if opts.ChunkSize < 0 {
+		return 0, fmt.Errorf("%w: ChunkSize must not be negative", ErrInvalidOptions)
+	}
+	if opts.ChunkSize == 0 {
+		opts.ChunkSize = defaultImportChunkSize
+	}
+
+	var decode func(log *raft.Log) error
+	br := bufio.NewReader(r)
+	switch opts.Format {
+	case FormatMsgpack, "":
+		dec := codec.NewDecoder(br, msgpackDecodeHandle)
+		decode = func(log *raft.Log) error { return dec.Decode(log) }
+	case FormatNDJSON:
+		dec := json.NewDecoder(br)
+		decode = func(log *raft.Log) error {
+			var in jsonLog
+			if err := dec.Decode(&in); err != nil {
+				return err
+			}
+			typ, ok := parseLogType(in.Type)
+			if !ok {
+				return fmt.Errorf("unknown log type %q at index %d", in.Type, in.Index)
+			}
+			*log = raft.Log{
+				Index:      in.Index,
+				Term:       in.Term,
+				Type:       typ,
+				Data:       in.Data,
+				Extensions: in.Extensions,
+			}
+			if in.AppendedAt != nil {
+				log.AppendedAt = *in.AppendedAt
+			}
+			return nil
+		}
+	default:
+		return 0, fmt.Errorf("unknown import format %q", opts.Format)
+	}
+
+	last, err := b.LastIndex()
+	if err != nil {
+		return 0, err
+	}
+
+	imported := 0
+	write := func(chunk []*raft.Log) error {
+		// Only the first chunk of an overwrite can end before the log does
+		if end := chunk[len(chunk)-1].Index; imported == 0 && end < last {
+			if err := b.waitWrite(int(last - end)); err != nil {
+				return err
+			}
+			if _, err := b.deleteRange(end+1, last); err != nil {
+				return err
+			}
+		}
+		if err := b.waitWrite(len(chunk)); err != nil {
+			return err
+		}
+		return b.storeLogs(chunk)
+	}
+
+	var prev uint64
+	chunk := make([]*raft.Log, 0, opts.ChunkSize)
+	for {
+		log := new(raft.Log)
+		if err := decode(log); err == io.EOF {
+			break
+		} else if err != nil {
+			return imported, fmt.Errorf("failed reading entry %d: %w", imported+len(chunk)+1, err)
+		}
+
+		switch {
+		case prev != 0 && log.Index != prev+1:
+			return imported, fmt.Errorf("%w: index %d follows %d", ErrInvalidImport, log.Index, prev)
+		case prev == 0 && last != 0 && log.Index > last+1:
+			return imported, fmt.Errorf("%w: index %d would leave a gap after the store's last index %d",
+				ErrInvalidImport, log.Index, last)
+		case prev == 0 && last != 0 && log.Index <= last && !opts.Overwrite:
+			return imported, fmt.Errorf("%w: index %d is already in the store", ErrInvalidImport, log.Index)
+		}
+		prev = log.Index
+
+		chunk = append(chunk, log)
+		if len(chunk) == opts.ChunkSize {
+			if err := write(chunk); err != nil {
+				return imported, err
+			}
+			imported += len(chunk)
+			chunk = chunk[:0]
+		}
+	}
+	if len(chunk) > 0 {
+		if err := write(chunk); err != nil {
+			return imported, err
+		}
+		imported += len(chunk)
+	}
+	return imported, nil
+}
+
+// parseLogType returns the log type with the given name, as written by
+// FormatNDJSON.
+func parseLogType(name string) (raft.LogType, bool) {
+	logTypesOnce.Do(func() {
+		logTypes = make(map[string]raft.LogType)
+		for i := 0; i < 256; i++ {
+			typ := raft.LogType(i)
+			logTypes[typ.String()] = typ
+		}
+	})
+	typ, ok := logTypes[name]
+	return typ, ok
+}
@@ -0,0 +1,90 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"go.etcd.io/bbolt"
+)
+
+// indexCache holds the first and last index of the log as of the write
+// transaction numbered seq, so FirstIndex and LastIndex don't need a
+// transaction of their own. If valid is false the range couldn't be
+// read and callers must fall back to a transaction.
+type indexCache struct {
+	seq         uint64
+	first, last uint64
+	valid       bool
+}
+
+// logRange returns the first and last index in the logs bucket. It
+// returns false if either key isn't a valid index, in which case the
+// range can't be cached.
+func logRange(bucket *logBucket) (first, last uint64, ok bool) {
+	curs := bucket.cursor()
+	firstKey, _ := curs.First()
+	lastKey, _ := curs.Last()
+	if firstKey == nil {
+		return 0, 0, true
+	}
+	if len(firstKey) != 8 || len(lastKey) != 8 {
+		return 0, 0, false
+	}
+	return bytesToUint64(firstKey), bytesToUint64(lastKey), true
+}
+
+// loadIndexes fills the cache from a read transaction when the store is
+// opened. The cache is left empty if the logs bucket is missing or has
+// invalid keys, so the slow path reports the problem instead.
+func (b *BoltStore) loadIndexes() {
+	tx, err := b.begin(false)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	bucket, err := b.logs(tx)
+	if err != nil {
+		return
+	}
+	if first, last, ok := logRange(bucket); ok {
+		b.indexes.Store(&indexCache{first: first, last: last, valid: true})
+	}
+}
+
+// trackIndexes must be called by every write transaction that changes
+// the logs bucket, once it has made its changes. The new range is
+// published to the cache only if tx commits. The returned sequence
+// number orders tx against other writes.
+func (b *BoltStore) trackIndexes(tx *bbolt.Tx, bucket *logBucket) uint64 {
+	// The sequence is only touched with Bbolt's writer lock held, so
+	// it orders commits even though the handlers run after it's released.
+	b.indexSeq++
+	seq := b.indexSeq
+
+	first, last, ok := logRange(bucket)
+	tx.OnCommit(func() {
+		b.publishIndexes(&indexCache{seq: seq, first: first, last: last, valid: ok})
+	})
+	return seq
+}
+
+// publishIndexes replaces the cached range unless a later transaction
+// has already published its own.
+func (b *BoltStore) publishIndexes(c *indexCache) {
+	for {
+		cur := b.indexes.Load()
+		if cur != nil && cur.seq > c.seq {
+			return
+		}
+		if b.indexes.CompareAndSwap(cur, c) {
+			return
+		}
+	}
+}
+
+// cachedIndexes returns the cached range, if there is a valid one.
+func (b *BoltStore) cachedIndexes() (*indexCache, bool) {
+	c := b.indexes.Load()
+	return c, c != nil && c.valid
+}
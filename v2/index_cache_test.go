@@ -0,0 +1,151 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"go.etcd.io/bbolt"
+)
+
+func checkIndexes(t *testing.T, store *BoltStore, first, last uint64) {
+	t.Helper()
+	idx, err := store.FirstIndex()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if idx != first {
+		t.Fatalf("bad first index: %d, expected %d", idx, first)
+	}
+	idx, err = store.LastIndex()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if idx != last {
+		t.Fatalf("bad last index: %d, expected %d", idx, last)
+	}
+}
+
+func TestBoltStore_IndexCache(t *testing.T) {
+	store := testBoltStore(t)
+	defer os.Remove(store.path)
+
+	if _, ok := store.cachedIndexes(); !ok {
+		t.Fatalf("expected the cache to be loaded on open")
+	}
+	checkIndexes(t, store, 0, 0)
+
+	var logs []*raft.Log
+	for i := uint64(1); i <= 10; i++ {
+		logs = append(logs, testRaftLog(i, "data"))
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	checkIndexes(t, store, 1, 10)
+
+	// Trimming the head and then the tail
+	if err := store.DeleteRange(1, 3); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	checkIndexes(t, store, 4, 10)
+	if err := store.DeleteRange(8, 20); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	checkIndexes(t, store, 4, 7)
+
+	// Overwriting the tail after a conflict
+	if err := store.StoreLogs([]*raft.Log{testRaftLog(7, "new"), testRaftLog(8, "new")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	checkIndexes(t, store, 4, 8)
+
+	// A failed write leaves the cache alone
+	err := store.conn.Update(func(tx *bbolt.Tx) error {
+		bucket, err := store.logs(tx)
+		if err != nil {
+			return err
+		}
+		if err := bucket.put(uint64ToBytes(100), []byte("x")); err != nil {
+			return err
+		}
+		store.trackIndexes(tx, bucket)
+		return os.ErrInvalid
+	})
+	if err != os.ErrInvalid {
+		t.Fatalf("err: %v", err)
+	}
+	checkIndexes(t, store, 4, 8)
+
+	// The cache is loaded from the file when the store is reopened
+	store.Close()
+	store, err = NewBoltStore(store.path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	checkIndexes(t, store, 4, 8)
+
+	if err := store.DeleteRange(0, 100); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	checkIndexes(t, store, 0, 0)
+
+	store.Close()
+	if _, err := store.FirstIndex(); err != ErrClosed {
+		t.Fatalf("expected closed error, got: %v", err)
+	}
+}
+
+func TestBoltStore_IndexCache_InvalidKeys(t *testing.T) {
+	store := testCorruptStore(t)
+	defer os.Remove(store.path)
+	store.Close()
+
+	// The "bad" key sorts last, so the cache can't be loaded and the slow
+	// path is used instead
+	store, err := NewBoltStore(store.path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	if _, ok := store.cachedIndexes(); ok {
+		t.Fatalf("expected no cached indexes")
+	}
+	if idx, err := store.FirstIndex(); err != nil || idx != 1 {
+		t.Fatalf("bad: %d %v", idx, err)
+	}
+}
+
+func TestBoltStore_IndexCache_Concurrent(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	var wg sync.WaitGroup
+	for w := uint64(0); w < 4; w++ {
+		wg.Add(1)
+		go func(w uint64) {
+			defer wg.Done()
+			for i := uint64(1); i <= 25; i++ {
+				idx := w*25 + i
+				if err := store.StoreLog(testRaftLog(idx, "data")); err != nil {
+					t.Errorf("err: %s", err)
+					return
+				}
+				if _, err := store.LastIndex(); err != nil {
+					t.Errorf("err: %s", err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// Whatever order the commits landed in, the cache must match the file
+	checkIndexes(t, store, 1, 100)
+}
@@ -0,0 +1,214 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/hashicorp/raft"
+)
+
+// InmemStore is a Store that keeps everything in memory, with the same
+// semantics as BoltStore: missing keys return ErrKeyNotFound, missing
+// entries raft.ErrLogNotFound, uint64 values share the key space of the
+// others, entries and values are copied in and out, and every method
+// returns ErrClosed once it's closed. It's meant for the unit tests of
+// applications built on raft, which then don't need temporary files.
+type InmemStore struct {
+	lock        sync.RWMutex
+	closed      bool
+	logs        map[uint64]*raft.Log
+	first, last uint64
+	conf        map[string][]byte
+}
+
+var _ Store = (*InmemStore)(nil)
+
+// NewInmemStore returns an empty InmemStore.
+func NewInmemStore() *InmemStore {
+	return &InmemStore{
+		logs: make(map[uint64]*raft.Log),
+		conf: make(map[string][]byte),
+	}
+}
+
+// copyLog returns a copy of log that shares nothing with it.
+func copyLog(log *raft.Log) *raft.Log {
+	out := *log
+	out.Data = bytes.Clone(log.Data)
+	out.Extensions = bytes.Clone(log.Extensions)
+	return &out
+}
+
+// FirstIndex returns the first index written. 0 for no entries.
+func (s *InmemStore) FirstIndex() (uint64, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.closed {
+		return 0, ErrClosed
+	}
+	return s.first, nil
+}
+
+// LastIndex returns the last index written. 0 for no entries.
+func (s *InmemStore) LastIndex() (uint64, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.closed {
+		return 0, ErrClosed
+	}
+	return s.last, nil
+}
+
+// GetLog is used to retrieve a log at a given index.
+func (s *InmemStore) GetLog(idx uint64, log *raft.Log) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.closed {
+		return ErrClosed
+	}
+	stored, ok := s.logs[idx]
+	if !ok {
+		return raft.ErrLogNotFound
+	}
+	*log = *copyLog(stored)
+	return nil
+}
+
+// StoreLog is used to store a single raft log
+func (s *InmemStore) StoreLog(log *raft.Log) error {
+	return s.StoreLogs([]*raft.Log{log})
+}
+
+// StoreLogs is used to store a set of raft logs
+func (s *InmemStore) StoreLogs(logs []*raft.Log) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	for _, log := range logs {
+		s.logs[log.Index] = copyLog(log)
+		if s.first == 0 || log.Index < s.first {
+			s.first = log.Index
+		}
+		if log.Index > s.last {
+			s.last = log.Index
+		}
+	}
+	return nil
+}
+
+// DeleteRange is used to delete logs within a given range inclusively.
+func (s *InmemStore) DeleteRange(min, max uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if min > max || len(s.logs) == 0 {
+		return nil
+	}
+
+	// Walk whichever is smaller, the range or the log
+	if max-min < uint64(len(s.logs)) {
+		for idx := min; ; idx++ {
+			delete(s.logs, idx)
+			if idx == max {
+				break
+			}
+		}
+	} else {
+		for idx := range s.logs {
+			if idx >= min && idx <= max {
+				delete(s.logs, idx)
+			}
+		}
+	}
+
+	s.first, s.last = 0, 0
+	for idx := range s.logs {
+		if s.first == 0 || idx < s.first {
+			s.first = idx
+		}
+		if idx > s.last {
+			s.last = idx
+		}
+	}
+	return nil
+}
+
+// Set is used to set a key/value set outside of the raft log
+func (s *InmemStore) Set(k, v []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	s.conf[string(k)] = append([]byte{}, v...)
+	return nil
+}
+
+// Get is used to retrieve a value from the k/v store by key
+func (s *InmemStore) Get(k []byte) ([]byte, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	v, ok := s.conf[string(k)]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return append([]byte{}, v...), nil
+}
+
+// SetUint64 is like Set, but handles uint64 values
+func (s *InmemStore) SetUint64(key []byte, val uint64) error {
+	return s.Set(key, uint64ToBytes(val))
+}
+
+// GetUint64 is like Get, but handles uint64 values
+func (s *InmemStore) GetUint64(key []byte) (uint64, error) {
+	val, err := s.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return valueToUint64(key, val)
+}
+
+// LogStats returns a summary of the store's contents. LogBytes is what
+// the entries would take up in a BoltStore without any options, and
+// FileSize, FreelistBytes and LastCompaction are always zero.
+func (s *InmemStore) LogStats() (*LogStoreStats, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	stats := &LogStoreStats{
+		Logs:       uint64(len(s.logs)),
+		FirstIndex: s.first,
+		LastIndex:  s.last,
+		ConfKeys:   uint64(len(s.conf)),
+	}
+	for _, log := range s.logs {
+		val, err := encodeMsgPack(log, false)
+		if err != nil {
+			return nil, err
+		}
+		stats.LogBytes += uint64(val.Len())
+	}
+	return stats, nil
+}
+
+// Close discards the store's contents.
+func (s *InmemStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	s.logs, s.conf = nil, nil
+	return nil
+}
@@ -0,0 +1,62 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestInmemStore(t *testing.T) {
+	store := NewInmemStore()
+
+	if _, err := store.GetUint64([]byte("missing")); err != ErrKeyNotFound {
+		t.Fatalf("err: %v", err)
+	}
+	if err := store.Set([]byte("empty"), nil); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if val, err := store.Get([]byte("empty")); err != nil || val == nil || len(val) != 0 {
+		t.Fatalf("bad: %#v %v", val, err)
+	}
+
+	// Entries are copied in and out
+	log := testRaftLog(5, "data")
+	if err := store.StoreLogs([]*raft.Log{log, testRaftLog(6, "b"), testRaftLog(7, "c")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	log.Data[0] = 'X'
+	var got raft.Log
+	if err := store.GetLog(5, &got); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(got.Data) != "data" {
+		t.Fatalf("bad: %q", got.Data)
+	}
+	got.Data[0] = 'Y'
+	if err := store.GetLog(5, &got); err != nil || string(got.Data) != "data" {
+		t.Fatalf("bad: %q %v", got.Data, err)
+	}
+
+	if err := store.DeleteRange(0, 5); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.DeleteRange(7, 1<<63); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	first, _ := store.FirstIndex()
+	last, _ := store.LastIndex()
+	if first != 6 || last != 6 {
+		t.Fatalf("bad: %d %d", first, last)
+	}
+	if err := store.GetLog(5, &got); err != raft.ErrLogNotFound {
+		t.Fatalf("err: %v", err)
+	}
+
+	store.Close()
+	if _, err := store.LastIndex(); err != ErrClosed {
+		t.Fatalf("err: %v", err)
+	}
+}
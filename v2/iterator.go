@@ -0,0 +1,200 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/hashicorp/raft"
+	"go.etcd.io/bbolt"
+)
+
+// LogIterator steps through the entries in a range of the log from a
+// single read transaction, which is much cheaper than calling GetLog for
+// each one. It sees a consistent view of the log, however long it's
+// used, but stops the pages it refers to from being reused while it's
+// open, so it must always be closed. Bbolt can't grow the memory map
+// while a read transaction is open, so a write that needs to waits for
+// open iterators to be closed; Options.InitialMmapSize makes that less
+// likely. Closing the store, Compact and Grow end any iterators that are
+// open, which then stop with ErrClosed. An iterator mustn't be used from
+// more than one goroutine at a time.
+//
+//	it, err := store.Iterator(min, max)
+//	if err != nil {
+//		return err
+//	}
+//	defer it.Close()
+//	for it.Next() {
+//		log := it.Log()
+//		...
+//	}
+//	return it.Err()
+type LogIterator struct {
+	// lock is held while the iterator is used, so that the store can end
+	// its transaction from another goroutine.
+	lock sync.Mutex
+
+	tx       *bbolt.Tx
+	logs     *logBucket
+	curs     *logCursor
+	min, max uint64
+	reverse  bool
+	started  bool
+	log      *raft.Log
+	err      error
+
+	// snap is the snapshot the iterator reads, if any, which owns tx.
+	snap *StoreSnapshot
+
+	// untrack stops the store tracking tx, see ReadTxWatchdog.
+	untrack func()
+}
+
+// Iterator returns an iterator over the entries from min to max
+// inclusive, in index order. Entries in the range that aren't in the
+// store are skipped.
+func (b *BoltStore) Iterator(min, max uint64) (*LogIterator, error) {
+	return b.iterator(min, max, false)
+}
+
+// ReverseIterator is like Iterator, but starts at max and works back.
+func (b *BoltStore) ReverseIterator(min, max uint64) (*LogIterator, error) {
+	return b.iterator(min, max, true)
+}
+
+func (b *BoltStore) iterator(min, max uint64, reverse bool) (*LogIterator, error) {
+	tx, err := b.begin(false)
+	if err != nil {
+		return nil, err
+	}
+	bucket, err := b.logs(tx)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	op := "Iterator"
+	if reverse {
+		op = "ReverseIterator"
+	}
+	it := &LogIterator{
+		tx:      tx,
+		logs:    bucket,
+		curs:    bucket.cursor(),
+		min:     min,
+		max:     max,
+		reverse: reverse,
+	}
+	it.untrack = b.trackRead(op, nil, it.end)
+	return it, nil
+}
+
+// Next moves to the next entry, returning false once there are no more
+// or an error has occurred, which is then returned by Err.
+func (it *LogIterator) Next() bool {
+	it.lock.Lock()
+	defer it.lock.Unlock()
+	it.log = nil
+	if it.err != nil || it.tx == nil {
+		return false
+	}
+	if it.snap != nil {
+		it.snap.lock.RLock()
+		defer it.snap.lock.RUnlock()
+		if it.snap.err != nil {
+			it.err = it.snap.err
+			return false
+		}
+	}
+
+	var k, v []byte
+	switch {
+	case it.started && it.reverse:
+		k, v = it.curs.Prev()
+	case it.started:
+		k, v = it.curs.Next()
+	case it.reverse:
+		k, v = it.seekLast()
+	default:
+		k, v = it.curs.Seek(uint64ToBytes(it.min))
+	}
+	it.started = true
+	if k == nil {
+		return false
+	}
+	idx := bytesToUint64(k)
+	if idx < it.min || idx > it.max {
+		return false
+	}
+
+	log := new(raft.Log)
+	if err := it.logs.decode(idx, v, log); err != nil {
+		it.err = fmt.Errorf("%w at index %d: %w", ErrLogCorrupt, idx, err)
+		return false
+	}
+	it.log = log
+	return true
+}
+
+// seekLast moves to the last entry at or before max.
+func (it *LogIterator) seekLast() ([]byte, []byte) {
+	if it.max == math.MaxUint64 {
+		return it.curs.Last()
+	}
+	if k, _ := it.curs.Seek(uint64ToBytes(it.max + 1)); k == nil {
+		return it.curs.Last()
+	}
+	return it.curs.Prev()
+}
+
+// Log returns the current entry, which the caller may keep.
+func (it *LogIterator) Log() *raft.Log {
+	return it.log
+}
+
+// Err returns the error that stopped the iterator, if any.
+func (it *LogIterator) Err() error {
+	it.lock.Lock()
+	defer it.lock.Unlock()
+	return it.err
+}
+
+// Close ends the iterator's transaction, unless it's iterating over a
+// StoreSnapshot, which stays open. It's safe to call more than once.
+func (it *LogIterator) Close() error {
+	it.lock.Lock()
+	defer it.lock.Unlock()
+	if it.snap != nil {
+		it.tx = nil
+		return nil
+	}
+	return it.rollback()
+}
+
+// end ends the iterator's transaction, after which it stops with err,
+// returning false if it had already ended.
+func (it *LogIterator) end(err error) bool {
+	it.lock.Lock()
+	defer it.lock.Unlock()
+	if it.tx == nil {
+		return false
+	}
+	it.rollback()
+	it.err = err
+	return true
+}
+
+// rollback ends the iterator's own transaction, if it hasn't already. It
+// must be called with lock held.
+func (it *LogIterator) rollback() error {
+	if it.tx == nil {
+		return nil
+	}
+	err := it.tx.Rollback()
+	it.tx = nil
+	it.untrack()
+	return err
+}
@@ -0,0 +1,161 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestBoltStore_Iterator(t *testing.T) {
+	for _, segmentSize := range []int{0, 8} {
+		// The map mustn't need to grow while the iterator is open
+		store, err := New(Options{
+			Path:            filepath.Join(t.TempDir(), "raft.db"),
+			LogSegmentSize:  segmentSize,
+			InitialMmapSize: 16 << 20,
+		})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		defer store.Close()
+
+		var logs []*raft.Log
+		for i := uint64(1); i <= 50; i++ {
+			logs = append(logs, testRaftLog(i, "data"))
+		}
+		if err := store.StoreLogs(logs); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := store.DeleteRange(20, 29); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		collect := func(it *LogIterator, err error) []uint64 {
+			t.Helper()
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			defer it.Close()
+			var idxs []uint64
+			for it.Next() {
+				idxs = append(idxs, it.Log().Index)
+			}
+			if err := it.Err(); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			return idxs
+		}
+
+		cases := []struct {
+			min, max uint64
+			reverse  bool
+			first    uint64
+			last     uint64
+			n        int
+		}{
+			{5, 15, false, 5, 15, 11},
+			{15, 35, false, 15, 35, 11},
+			{0, math.MaxUint64, false, 1, 50, 40},
+			{45, 100, false, 45, 50, 6},
+			{15, 35, true, 35, 15, 11},
+			{0, math.MaxUint64, true, 50, 1, 40},
+			{45, 100, true, 50, 45, 6},
+			{1, 25, true, 19, 1, 19},
+		}
+		for _, c := range cases {
+			var idxs []uint64
+			if c.reverse {
+				idxs = collect(store.ReverseIterator(c.min, c.max))
+			} else {
+				idxs = collect(store.Iterator(c.min, c.max))
+			}
+			if len(idxs) != c.n || idxs[0] != c.first || idxs[len(idxs)-1] != c.last {
+				t.Fatalf("bad: %v for %+v with segment size %d", idxs, c, segmentSize)
+			}
+		}
+
+		if idxs := collect(store.Iterator(20, 29)); len(idxs) != 0 {
+			t.Fatalf("bad: %v", idxs)
+		}
+		if idxs := collect(store.ReverseIterator(20, 29)); len(idxs) != 0 {
+			t.Fatalf("bad: %v", idxs)
+		}
+
+		// The iterator keeps its view of the log
+		it, err := store.Iterator(40, 50)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if !it.Next() {
+			t.Fatalf("expected an entry")
+		}
+		if err := store.DeleteRange(41, 50); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		n := 1
+		for it.Next() {
+			n++
+		}
+		it.Close()
+		if n != 11 {
+			t.Fatalf("bad: %d", n)
+		}
+		if it.Next() {
+			t.Fatalf("expected a closed iterator to stop")
+		}
+	}
+}
+
+func TestBoltStore_FindLogsByType(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	if _, err := store.LatestConfigurationEntry(); err != raft.ErrLogNotFound {
+		t.Fatalf("expected not found error, got: %v", err)
+	}
+
+	var logs []*raft.Log
+	for i := uint64(1); i <= 100; i++ {
+		log := testRaftLog(i, "data")
+		if i%10 == 0 {
+			log.Type = raft.LogConfiguration
+			log.Data = raft.EncodeConfiguration(raft.Configuration{Servers: []raft.Server{
+				{ID: raft.ServerID(fmt.Sprintf("node%d", i)), Address: "127.0.0.1:8300"},
+			}})
+		}
+		logs = append(logs, log)
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	found, err := store.FindLogsByType(raft.LogConfiguration, 3)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(found) != 3 || found[0].Index != 80 || found[2].Index != 100 {
+		t.Fatalf("bad: %v", found)
+	}
+	if found, err := store.FindLogsByType(raft.LogConfiguration, 0); err != nil || len(found) != 10 {
+		t.Fatalf("bad: %d %v", len(found), err)
+	}
+	if found, err := store.FindLogsByType(raft.LogBarrier, 0); err != nil || len(found) != 0 {
+		t.Fatalf("bad: %d %v", len(found), err)
+	}
+
+	latest, err := store.LatestConfigurationEntry()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if conf := raft.DecodeConfiguration(latest.Data); conf.Servers[0].ID != "node100" {
+		t.Fatalf("bad: %#v", conf)
+	}
+}
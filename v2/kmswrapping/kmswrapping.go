@@ -0,0 +1,59 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package kmswrapping lets a go-kms-wrapping wrapper, for any KMS or HSM
+// supported there, protect the data keys of an encrypted
+// raftboltdb.BoltStore:
+//
+//	store, err := raftboltdb.New(raftboltdb.Options{
+//		Path:    "raft/raft.db",
+//		Wrapper: kmswrapping.NewWrapper(awskmsWrapper),
+//	})
+//
+// It's a module of its own, so go-kms-wrapping isn't a dependency of
+// raft-boltdb.
+package kmswrapping
+
+import (
+	"context"
+	"fmt"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping/v2"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"google.golang.org/protobuf/proto"
+)
+
+var _ raftboltdb.Wrapper = (*Wrapper)(nil)
+
+// Wrapper is a raftboltdb.Wrapper that wraps data keys with a
+// go-kms-wrapping wrapper. Each wrapped key is stored as the wrapper's
+// BlobInfo, serialized with protobuf, so it carries the ID of the KMS key
+// that wrapped it, as it does in Vault.
+type Wrapper struct {
+	wrapper wrapping.Wrapper
+}
+
+// NewWrapper returns a Wrapper that wraps data keys with w, which must
+// already be configured.
+func NewWrapper(w wrapping.Wrapper) *Wrapper {
+	return &Wrapper{wrapper: w}
+}
+
+// Encrypt returns plaintext encrypted by the go-kms-wrapping wrapper, as
+// a serialized BlobInfo.
+func (w *Wrapper) Encrypt(ctx context.Context, plaintext []byte) ([]byte, error) {
+	blob, err := w.wrapper.Encrypt(ctx, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(blob)
+}
+
+// Decrypt returns the plaintext of a value returned by Encrypt.
+func (w *Wrapper) Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	blob := new(wrapping.BlobInfo)
+	if err := proto.Unmarshal(ciphertext, blob); err != nil {
+		return nil, fmt.Errorf("failed decoding wrapped data key: %w", err)
+	}
+	return w.wrapper.Decrypt(ctx, blob)
+}
@@ -0,0 +1,89 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package kmswrapping
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-kms-wrapping/wrappers/aead/v2"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+)
+
+// testWrapper returns an AEAD wrapper with a random key.
+func testWrapper(t *testing.T) *aead.Wrapper {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	w := aead.NewWrapper()
+	if err := w.SetAesGcmKeyBytes(key); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return w
+}
+
+func TestWrapper(t *testing.T) {
+	w := NewWrapper(testWrapper(t))
+	ciphertext, err := w.Encrypt(context.Background(), []byte("data key"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	plaintext, err := w.Decrypt(context.Background(), ciphertext)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !bytes.Equal(plaintext, []byte("data key")) {
+		t.Fatalf("bad: %q", plaintext)
+	}
+
+	// Another key can't unwrap it
+	if _, err := NewWrapper(testWrapper(t)).Decrypt(context.Background(), ciphertext); err == nil {
+		t.Fatalf("should fail with another key")
+	}
+	if _, err := w.Decrypt(context.Background(), []byte("garbage")); err == nil {
+		t.Fatalf("should fail to decode")
+	}
+}
+
+func TestWrapper_BoltStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "raft.db")
+	kms := testWrapper(t)
+	store, err := raftboltdb.New(raftboltdb.Options{Path: path, Wrapper: NewWrapper(kms)})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.StoreLog(&raft.Log{Index: 1, Term: 1, Data: []byte("secret")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.SetUint64([]byte("CurrentTerm"), 1); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.Close()
+
+	// The store can only be opened with the same KMS key
+	if _, err := raftboltdb.New(raftboltdb.Options{Path: path, Wrapper: NewWrapper(testWrapper(t))}); err == nil {
+		t.Fatalf("should fail with another key")
+	}
+	store, err = raftboltdb.New(raftboltdb.Options{Path: path, Wrapper: NewWrapper(kms)})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	var log raft.Log
+	if err := store.GetLog(1, &log); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(log.Data) != "secret" {
+		t.Fatalf("bad: %q", log.Data)
+	}
+	term, err := store.GetUint64([]byte("CurrentTerm"))
+	if err != nil || term != 1 {
+		t.Fatalf("bad: %d %v", term, err)
+	}
+}
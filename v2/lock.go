@@ -0,0 +1,52 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"fmt"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	// ErrDatabaseLocked is returned when the database file lock could not
+	// be acquired within Options.LockTimeout. This almost always means a
+	// second agent is running against the same data directory. The error
+	// returned by New is a *LockedError which can be inspected for details.
+	ErrDatabaseLocked = errors.New("database is locked by another process")
+)
+
+// LockedError describes a failure to acquire the database file lock.
+type LockedError struct {
+	// Path is the database file that could not be locked.
+	Path string
+
+	// PID is the process holding the lock, or zero if it couldn't be
+	// determined on this platform.
+	PID int
+}
+
+// Error implements the error interface.
+func (e *LockedError) Error() string {
+	if e.PID > 0 {
+		return fmt.Sprintf("%v: %s is locked by pid %d", ErrDatabaseLocked, e.Path, e.PID)
+	}
+	return fmt.Sprintf("%v: %s", ErrDatabaseLocked, e.Path)
+}
+
+// Is allows errors.Is to match both ErrDatabaseLocked and the underlying
+// bbolt.ErrTimeout that callers may already be checking for.
+func (e *LockedError) Is(target error) bool {
+	return target == ErrDatabaseLocked || target == bbolt.ErrTimeout
+}
+
+// openError converts errors from bbolt.Open into something more
+// descriptive where we can.
+func openError(path string, err error) error {
+	if err == bbolt.ErrTimeout {
+		return &LockedError{Path: path, PID: lockHolderPID(path)}
+	}
+	return err
+}
@@ -0,0 +1,11 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !linux
+
+package raftboltdb
+
+// lockHolderPID isn't supported on this platform.
+func lockHolderPID(path string) int {
+	return 0
+}
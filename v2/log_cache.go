@@ -0,0 +1,144 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"sync"
+
+	"github.com/hashicorp/golang-lru/v2/simplelru"
+	"github.com/hashicorp/raft"
+	"go.etcd.io/bbolt"
+)
+
+// logCache holds decoded log entries in memory. Entries are read from a
+// transaction's snapshot and only added afterwards, so gen is bumped
+// whenever entries already in the log change. Anything read before the
+// change is then dropped rather than cached. Written entries are added
+// once their transaction commits, which happens outside Bbolt's writer
+// lock, so lastWrite makes sure an earlier write can't replace entries
+// from a later one. A nil *logCache caches nothing.
+type logCache struct {
+	lock      sync.Mutex
+	gen       uint64
+	lastWrite uint64
+	lru       *simplelru.LRU[uint64, *raft.Log]
+
+	// writes is set if written entries should be cached, rather than
+	// only those that are read.
+	writes bool
+}
+
+func newLogCache(size int, writes bool) *logCache {
+	lru, err := simplelru.NewLRU[uint64, *raft.Log](size, nil)
+	if err != nil {
+		// Only possible with a non-positive size, which the options
+		// don't allow
+		panic(err)
+	}
+	return &logCache{lru: lru, writes: writes}
+}
+
+// get returns the cached entry at idx. The entry is shared, so it must
+// not be modified.
+func (c *logCache) get(idx uint64) (*raft.Log, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.lru.Get(idx)
+}
+
+// contains reports whether idx is cached, without affecting its recency.
+func (c *logCache) contains(idx uint64) bool {
+	if c == nil {
+		return false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.lru.Contains(idx)
+}
+
+// generation must be read before starting the transaction that entries
+// passed to add are read from.
+func (c *logCache) generation() uint64 {
+	if c == nil {
+		return 0
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.gen
+}
+
+// add caches logs read in a transaction that started at generation gen.
+// They are discarded if the log has been changed since.
+func (c *logCache) add(gen uint64, logs ...*raft.Log) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if gen != c.gen {
+		return
+	}
+	for _, log := range logs {
+		c.lru.Add(log.Index, log)
+	}
+}
+
+// invalidate drops any cached entries from min to max inclusive, and
+// stops entries read before now from being added.
+func (c *logCache) invalidate(min, max uint64) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.invalidateLocked(min, max)
+}
+
+func (c *logCache) invalidateLocked(min, max uint64) {
+	c.gen++
+	for _, idx := range c.lru.Keys() {
+		if idx >= min && idx <= max {
+			c.lru.Remove(idx)
+		}
+	}
+}
+
+// cacheWrite updates the cache once tx commits. seq is the write's
+// sequence number from trackIndexes. If overwrite is set min to max is
+// invalidated, as those entries may have been cached before. logs are the
+// entries written, if any, which are cached unless a later write has
+// already updated the cache.
+func (c *logCache) cacheWrite(tx *bbolt.Tx, seq uint64, overwrite bool, min, max uint64, logs []*raft.Log) {
+	if c == nil {
+		return
+	}
+	var entries []*raft.Log
+	if c.writes {
+		// Copy the raft.Log structs so the caller can reuse them. Data,
+		// Extensions and the other byte slices are still shared, so
+		// they mustn't be changed after StoreLogs, as raft never does
+		entries = make([]*raft.Log, len(logs))
+		for i, log := range logs {
+			entry := *log
+			entries[i] = &entry
+		}
+	}
+	tx.OnCommit(func() {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		if overwrite {
+			c.invalidateLocked(min, max)
+		}
+		if seq < c.lastWrite {
+			return
+		}
+		c.lastWrite = seq
+		for _, entry := range entries {
+			c.lru.Add(entry.Index, entry)
+		}
+	})
+}
@@ -0,0 +1,236 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func testReadAheadStore(t *testing.T, readAhead int) *BoltStore {
+	fh, err := ioutil.TempFile("", "bolt")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	os.Remove(fh.Name())
+
+	store, err := New(Options{Path: fh.Name(), ReadAhead: readAhead})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return store
+}
+
+func TestBoltStore_ReadAhead(t *testing.T) {
+	store := testReadAheadStore(t, 4)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	var logs []*raft.Log
+	for i := uint64(1); i <= 20; i++ {
+		logs = append(logs, testRaftLog(i, fmt.Sprintf("log%d", i)))
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// A single read doesn't trigger read-ahead
+	var log raft.Log
+	if err := store.GetLog(10, &log); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if store.cache.contains(11) {
+		t.Fatalf("unexpected read-ahead")
+	}
+
+	// The next one does, and the prefetched entries are served from memory
+	if err := store.GetLog(11, &log); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for i := uint64(12); i <= 15; i++ {
+		if !store.cache.contains(i) {
+			t.Fatalf("expected %d to be prefetched", i)
+		}
+	}
+	if store.cache.contains(16) {
+		t.Fatalf("read too far ahead")
+	}
+	for i := uint64(12); i <= 20; i++ {
+		if err := store.GetLog(i, &log); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if !reflect.DeepEqual(&log, logs[i-1]) {
+			t.Fatalf("bad log %d: %#v", i, log)
+		}
+	}
+
+	// Read-ahead stops at the end of the log
+	if store.cache.contains(21) {
+		t.Fatalf("unexpected entry")
+	}
+	if err := store.GetLog(21, &log); err != raft.ErrLogNotFound {
+		t.Fatalf("expected not found error, got: %v", err)
+	}
+}
+
+func TestBoltStore_ReadAhead_Invalidation(t *testing.T) {
+	store := testReadAheadStore(t, 8)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	var logs []*raft.Log
+	for i := uint64(1); i <= 10; i++ {
+		logs = append(logs, testRaftLog(i, "old"))
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var log raft.Log
+	for _, idx := range []uint64{1, 2} {
+		if err := store.GetLog(idx, &log); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	if !store.cache.contains(8) {
+		t.Fatalf("expected read-ahead")
+	}
+
+	// Deleted entries are no longer served
+	if err := store.DeleteRange(7, 10); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.GetLog(8, &log); err != raft.ErrLogNotFound {
+		t.Fatalf("expected not found error, got: %v", err)
+	}
+
+	// Overwritten entries are read back from the file
+	if err := store.StoreLogs([]*raft.Log{testRaftLog(5, "new"), testRaftLog(6, "new")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for _, idx := range []uint64{5, 6} {
+		if err := store.GetLog(idx, &log); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if string(log.Data) != "new" {
+			t.Fatalf("bad data at %d: %q", idx, log.Data)
+		}
+	}
+
+	// Appends leave the cache alone
+	if !store.cache.contains(4) {
+		t.Fatalf("expected 4 to still be cached")
+	}
+	if err := store.StoreLogs([]*raft.Log{testRaftLog(7, "new")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !store.cache.contains(4) {
+		t.Fatalf("expected 4 to still be cached")
+	}
+
+	// Reads from before a change are not cached
+	gen := store.cache.generation()
+	store.cache.invalidate(0, 0)
+	store.cache.add(gen, testRaftLog(100, "stale"))
+	if store.cache.contains(100) {
+		t.Fatalf("stale entry was cached")
+	}
+
+	store.Close()
+	if err := store.GetLog(4, &log); err != ErrClosed {
+		t.Fatalf("expected closed error, got: %v", err)
+	}
+}
+
+func TestBoltStore_CacheSize(t *testing.T) {
+	fh, err := ioutil.TempFile("", "bolt")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	os.Remove(fh.Name())
+	defer os.Remove(fh.Name())
+
+	store, err := New(Options{Path: fh.Name(), CacheSize: 4})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	var logs []*raft.Log
+	for i := uint64(1); i <= 6; i++ {
+		logs = append(logs, testRaftLog(i, fmt.Sprintf("log%d", i)))
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The most recently stored entries are cached
+	for i := uint64(1); i <= 6; i++ {
+		if cached := store.cache.contains(i); cached != (i > 2) {
+			t.Fatalf("bad cache state for %d: %v", i, cached)
+		}
+	}
+
+	// The cache holds copies, so callers can't change what it returns
+	logs[5].Data = []byte("changed")
+	var log raft.Log
+	if err := store.GetLog(6, &log); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(log.Data) != "log6" {
+		t.Fatalf("bad: %q", log.Data)
+	}
+
+	// Reads are cached too
+	if err := store.GetLog(1, &log); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !store.cache.contains(1) {
+		t.Fatalf("expected 1 to be cached")
+	}
+
+	// Truncating the tail and writing new entries replaces what's cached
+	if err := store.DeleteRange(5, 6); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.GetLog(6, &log); err != raft.ErrLogNotFound {
+		t.Fatalf("expected not found error, got: %v", err)
+	}
+	if err := store.StoreLogs([]*raft.Log{testRaftLog(5, "new5"), testRaftLog(6, "new6")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.GetLog(6, &log); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(log.Data) != "new6" {
+		t.Fatalf("bad: %q", log.Data)
+	}
+
+	// Overwriting without a DeleteRange first does the same
+	if err := store.StoreLog(testRaftLog(6, "newer6")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.GetLog(6, &log); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(log.Data) != "newer6" {
+		t.Fatalf("bad: %q", log.Data)
+	}
+
+	// An earlier write committing late doesn't replace a later one
+	store.cache.lock.Lock()
+	store.cache.lastWrite += 10
+	store.cache.lock.Unlock()
+	if err := store.StoreLog(testRaftLog(7, "log7")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if store.cache.contains(7) {
+		t.Fatalf("stale write was cached")
+	}
+}
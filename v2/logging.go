@@ -0,0 +1,37 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"time"
+)
+
+const (
+	// defaultSlowOpThreshold is used when Options.SlowOpThreshold isn't
+	// set. Raft's default heartbeat timeout is one second, so anything
+	// approaching this is already worth knowing about.
+	defaultSlowOpThreshold = 100 * time.Millisecond
+)
+
+// warnIfSlow logs a warning if a write transaction took longer than the
+// configured threshold. The freelist size is included since a large
+// freelist is the most common reason for slow commits.
+func (b *BoltStore) warnIfSlow(op string, elapsed time.Duration, args ...interface{}) {
+	if elapsed < b.slowOpThreshold {
+		return
+	}
+	stats := b.db().Stats()
+	args = append([]interface{}{
+		"op", op,
+		"duration", elapsed,
+		"threshold", b.slowOpThreshold,
+	}, args...)
+	args = append(args,
+		"free_pages", stats.FreePageN,
+		"pending_pages", stats.PendingPageN,
+		"freelist_bytes", stats.FreelistInuse,
+		"path", b.path,
+	)
+	b.logger.Warn("slow bolt transaction", args...)
+}
@@ -0,0 +1,70 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+)
+
+func TestBoltStore_SlowOpWarnings(t *testing.T) {
+	fh, err := ioutil.TempFile("", "bolt")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	os.Remove(fh.Name())
+	defer os.Remove(fh.Name())
+
+	var buf bytes.Buffer
+	logger := hclog.New(&hclog.LoggerOptions{Output: &buf, Level: hclog.Warn})
+
+	store, err := New(Options{Path: fh.Name(), Logger: logger, SlowOpThreshold: time.Hour})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Nothing is logged for fast transactions
+	if err := store.StoreLogs([]*raft.Log{testRaftLog(1, "log1")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("unexpected log output: %s", buf.String())
+	}
+	store.Close()
+
+	// With a tiny threshold every write is slow
+	store, err = New(Options{Path: fh.Name(), Logger: logger, SlowOpThreshold: time.Nanosecond})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	if err := store.StoreLogs([]*raft.Log{testRaftLog(2, "log2"), testRaftLog(3, "log3")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	out := buf.String()
+	for _, s := range []string{"slow bolt transaction", "op=StoreLogs", "logs=2", "bytes_written=", "freelist_bytes="} {
+		if !strings.Contains(out, s) {
+			t.Fatalf("expected %q in output: %s", s, out)
+		}
+	}
+
+	buf.Reset()
+	if err := store.DeleteRange(1, 2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	out = buf.String()
+	for _, s := range []string{"op=DeleteRange", "logs=2", "bytes_deleted="} {
+		if !strings.Contains(out, s) {
+			t.Fatalf("expected %q in output: %s", s, out)
+		}
+	}
+}
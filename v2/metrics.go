@@ -5,6 +5,7 @@ package raftboltdb
 
 import (
 	"context"
+	"os"
 	"time"
 
 	metrics "github.com/armon/go-metrics"
@@ -15,8 +16,67 @@ const (
 	defaultMetricsInterval = 5 * time.Second
 )
 
+var (
+	// defaultMetricsPrefix is used for all metric names when
+	// Options.MetricsPrefix isn't set.
+	defaultMetricsPrefix = []string{"raft", "boltdb"}
+)
+
+// storeMetrics emits metrics under the store's prefix, either to the
+// global go-metrics instance or to the sink given in the options.
+type storeMetrics struct {
+	prefix []string
+	sink   metrics.MetricSink
+}
+
+func (m storeMetrics) key(name []string) []string {
+	prefix := m.prefix
+	if len(prefix) == 0 {
+		prefix = defaultMetricsPrefix
+	}
+	key := make([]string, 0, len(prefix)+len(name))
+	key = append(key, prefix...)
+	return append(key, name...)
+}
+
+func (m storeMetrics) addSample(name []string, val float32) {
+	if m.sink != nil {
+		m.sink.AddSample(m.key(name), val)
+		return
+	}
+	metrics.AddSample(m.key(name), val)
+}
+
+// measureSince records the time since start in milliseconds, matching
+// go-metrics' default timer granularity.
+func (m storeMetrics) measureSince(name []string, start time.Time) {
+	if m.sink != nil {
+		elapsed := time.Since(start)
+		m.sink.AddSample(m.key(name), float32(elapsed)/float32(time.Millisecond))
+		return
+	}
+	metrics.MeasureSince(m.key(name), start)
+}
+
+func (m storeMetrics) setGauge(name []string, val float32) {
+	if m.sink != nil {
+		m.sink.SetGauge(m.key(name), val)
+		return
+	}
+	metrics.SetGauge(m.key(name), val)
+}
+
+func (m storeMetrics) incrCounter(name []string, val float32) {
+	if m.sink != nil {
+		m.sink.IncrCounter(m.key(name), val)
+		return
+	}
+	metrics.IncrCounter(m.key(name), val)
+}
+
 // RunMetrics should be executed in a go routine and will periodically emit
-// metrics on the given interval until the context has been cancelled.
+// metrics on the given interval until the context has been cancelled or
+// the store has been closed.
 func (b *BoltStore) RunMetrics(ctx context.Context, interval time.Duration) {
 	if interval == 0 {
 		interval = defaultMetricsInterval
@@ -31,41 +91,53 @@ func (b *BoltStore) RunMetrics(ctx context.Context, interval time.Duration) {
 		case <-ctx.Done():
 			return
 		case <-tick.C:
+			if b.closed.Load() {
+				return
+			}
 			stats = b.emitMetrics(stats)
 		}
 	}
 }
 
 func (b *BoltStore) emitMetrics(prev *bbolt.Stats) *bbolt.Stats {
-	newStats := b.conn.Stats()
+	newStats := b.db().Stats()
 
+	// Compact replaces the db, which resets its counters
 	stats := newStats
-	if prev != nil {
+	if prev != nil && newStats.TxN >= prev.TxN {
 		stats = newStats.Sub(prev)
 	}
 
 	// freelist metrics
-	metrics.SetGauge([]string{"raft", "boltdb", "numFreePages"}, float32(newStats.FreePageN))
-	metrics.SetGauge([]string{"raft", "boltdb", "numPendingPages"}, float32(newStats.PendingPageN))
-	metrics.SetGauge([]string{"raft", "boltdb", "freePageBytes"}, float32(newStats.FreeAlloc))
-	metrics.SetGauge([]string{"raft", "boltdb", "freelistBytes"}, float32(newStats.FreelistInuse))
+	b.metrics.setGauge([]string{"numFreePages"}, float32(newStats.FreePageN))
+	b.metrics.setGauge([]string{"numPendingPages"}, float32(newStats.PendingPageN))
+	b.metrics.setGauge([]string{"freePageBytes"}, float32(newStats.FreeAlloc))
+	b.metrics.setGauge([]string{"freelistBytes"}, float32(newStats.FreelistInuse))
+
+	// file metrics
+	if fi, err := os.Stat(b.path); err == nil {
+		b.metrics.setGauge([]string{"fileSize"}, float32(fi.Size()))
+		if last := b.lastFileSize.Swap(fi.Size()); last != 0 && fi.Size() > last {
+			b.metrics.incrCounter([]string{"fileGrowth"}, float32(fi.Size()-last))
+		}
+	}
 
 	// txn metrics
-	metrics.IncrCounter([]string{"raft", "boltdb", "totalReadTxn"}, float32(stats.TxN))
-	metrics.SetGauge([]string{"raft", "boltdb", "openReadTxn"}, float32(newStats.OpenTxN))
+	b.metrics.incrCounter([]string{"totalReadTxn"}, float32(stats.TxN))
+	b.metrics.setGauge([]string{"openReadTxn"}, float32(newStats.OpenTxN))
 
 	// tx stats
-	metrics.SetGauge([]string{"raft", "boltdb", "txstats", "pageCount"}, float32(newStats.TxStats.PageCount))
-	metrics.SetGauge([]string{"raft", "boltdb", "txstats", "pageAlloc"}, float32(newStats.TxStats.PageAlloc))
-	metrics.IncrCounter([]string{"raft", "boltdb", "txstats", "cursorCount"}, float32(stats.TxStats.CursorCount))
-	metrics.IncrCounter([]string{"raft", "boltdb", "txstats", "nodeCount"}, float32(stats.TxStats.NodeCount))
-	metrics.IncrCounter([]string{"raft", "boltdb", "txstats", "nodeDeref"}, float32(stats.TxStats.NodeDeref))
-	metrics.IncrCounter([]string{"raft", "boltdb", "txstats", "rebalance"}, float32(stats.TxStats.Rebalance))
-	metrics.AddSample([]string{"raft", "boltdb", "txstats", "rebalanceTime"}, float32(stats.TxStats.RebalanceTime.Nanoseconds())/1000000)
-	metrics.IncrCounter([]string{"raft", "boltdb", "txstats", "split"}, float32(stats.TxStats.Split))
-	metrics.IncrCounter([]string{"raft", "boltdb", "txstats", "spill"}, float32(stats.TxStats.Spill))
-	metrics.AddSample([]string{"raft", "boltdb", "txstats", "spillTime"}, float32(stats.TxStats.SpillTime.Nanoseconds())/1000000)
-	metrics.IncrCounter([]string{"raft", "boltdb", "txstats", "write"}, float32(stats.TxStats.Write))
-	metrics.AddSample([]string{"raft", "boltdb", "txstats", "writeTime"}, float32(stats.TxStats.WriteTime.Nanoseconds())/1000000)
+	b.metrics.setGauge([]string{"txstats", "pageCount"}, float32(newStats.TxStats.PageCount))
+	b.metrics.setGauge([]string{"txstats", "pageAlloc"}, float32(newStats.TxStats.PageAlloc))
+	b.metrics.incrCounter([]string{"txstats", "cursorCount"}, float32(stats.TxStats.CursorCount))
+	b.metrics.incrCounter([]string{"txstats", "nodeCount"}, float32(stats.TxStats.NodeCount))
+	b.metrics.incrCounter([]string{"txstats", "nodeDeref"}, float32(stats.TxStats.NodeDeref))
+	b.metrics.incrCounter([]string{"txstats", "rebalance"}, float32(stats.TxStats.Rebalance))
+	b.metrics.addSample([]string{"txstats", "rebalanceTime"}, float32(stats.TxStats.RebalanceTime.Nanoseconds())/1000000)
+	b.metrics.incrCounter([]string{"txstats", "split"}, float32(stats.TxStats.Split))
+	b.metrics.incrCounter([]string{"txstats", "spill"}, float32(stats.TxStats.Spill))
+	b.metrics.addSample([]string{"txstats", "spillTime"}, float32(stats.TxStats.SpillTime.Nanoseconds())/1000000)
+	b.metrics.incrCounter([]string{"txstats", "write"}, float32(stats.TxStats.Write))
+	b.metrics.addSample([]string{"txstats", "writeTime"}, float32(stats.TxStats.WriteTime.Nanoseconds())/1000000)
 	return &newStats
 }
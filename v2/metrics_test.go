@@ -0,0 +1,122 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/raft"
+)
+
+func TestBoltStore_MetricSink(t *testing.T) {
+	fh, err := ioutil.TempFile("", "bolt")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	os.Remove(fh.Name())
+	defer os.Remove(fh.Name())
+
+	sink := metrics.NewInmemSink(time.Minute, time.Minute)
+	store, err := New(Options{
+		Path:          fh.Name(),
+		MetricsPrefix: []string{"test", "store"},
+		MetricSink:    sink,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	if err := store.StoreLogs([]*raft.Log{testRaftLog(1, "log1"), testRaftLog(2, "log2")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.GetLog(1, new(raft.Log)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.DeleteRange(1, 1); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Set([]byte("hello"), []byte("world")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := store.Get([]byte("hello")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.emitMetrics(nil)
+
+	data := sink.Data()
+	if len(data) == 0 {
+		t.Fatalf("no metrics collected")
+	}
+	samples, gauges := data[0].Samples, data[0].Gauges
+
+	for _, name := range []string{"storeLogs", "getLog", "deleteRange", "set", "get", "logSize", "logsPerBatch", "logBatchSize"} {
+		if _, ok := samples["test.store."+name]; !ok {
+			t.Fatalf("missing sample %q in %v", name, samples)
+		}
+	}
+	if s := samples["test.store.logsPerBatch"]; s.Max != 2 {
+		t.Fatalf("bad: %#v", s)
+	}
+	for _, name := range []string{"numFreePages", "numPendingPages", "freePageBytes", "freelistBytes", "openReadTxn"} {
+		if _, ok := gauges["test.store."+name]; !ok {
+			t.Fatalf("missing gauge %q in %v", name, gauges)
+		}
+	}
+	if g := gauges["test.store.fileSize"]; g.Value <= 0 {
+		t.Fatalf("bad: %#v", g)
+	}
+	for key := range samples {
+		if strings.HasPrefix(key, "raft.boltdb") {
+			t.Fatalf("unexpected default prefix: %q", key)
+		}
+	}
+}
+
+func TestBoltStore_RunMetrics(t *testing.T) {
+	fh, err := ioutil.TempFile("", "bolt")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	os.Remove(fh.Name())
+	defer os.Remove(fh.Name())
+
+	sink := metrics.NewInmemSink(time.Minute, time.Minute)
+	store, err := New(Options{Path: fh.Name(), MetricSink: sink})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		store.RunMetrics(context.Background(), time.Millisecond)
+	}()
+
+	// Metrics are emitted straight away
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if data := sink.Data(); len(data) > 0 && len(data[0].Gauges) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no metrics emitted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Closing the store stops the loop
+	store.Close()
+	select {
+	case <-doneCh:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("RunMetrics didn't stop after Close")
+	}
+}
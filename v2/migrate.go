@@ -0,0 +1,641 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	v1 "github.com/boltdb/bolt"
+	"go.etcd.io/bbolt"
+)
+
+const (
+	// defaultMigrateChunkSize is how many keys MigrateToV2 copies in each
+	// transaction by default.
+	defaultMigrateChunkSize = 10000
+)
+
+// MigrateOptions configures MigrateToV2WithOptions and
+// MigrateFromV2WithOptions.
+type MigrateOptions struct {
+	// ChunkSize is the most keys copied in each destination transaction,
+	// which bounds the memory used by the migration. Defaults to 10000.
+	ChunkSize int
+
+	// Progress, if not nil, is called after each chunk is committed.
+	Progress func(MigrateProgress)
+
+	// Verify compares the destination with the source once the copy has
+	// finished, as VerifyMigration does. If they differ the destination
+	// is removed and a *MigrationError returned.
+	Verify bool
+
+	// Wrapper unwraps the data keys of an encrypted source for
+	// MigrateFromV2WithOptions, see Options.Wrapper.
+	Wrapper Wrapper
+
+	// LogsBucket and ConfBucket are the names of the source's logs and
+	// stable store buckets for MigrateToV2WithOptions, if it doesn't use
+	// the defaults of "logs" and "conf". They're always copied into the
+	// default buckets of the destination.
+	LogsBucket []byte
+	ConfBucket []byte
+
+	// ExtraBuckets are other buckets in the source that
+	// MigrateToV2WithOptions copies as they are, including any nested
+	// buckets, for embedders that keep their own data in the same file.
+	// The migration fails if the source holds any bucket that isn't
+	// copied, rather than silently losing it.
+	ExtraBuckets [][]byte
+
+	// Resume makes MigrateToV2WithOptions continue an interrupted
+	// migration into an existing destination from the checkpoint it
+	// records with each chunk, rather than refusing to overwrite it, and
+	// leave the destination in place if it fails again so it can be
+	// resumed later. The source and options must be the same as before.
+	Resume bool
+}
+
+// MigrateProgress reports how far a migration has got.
+type MigrateProgress struct {
+	// Keys is the number of keys copied so far, out of TotalKeys.
+	Keys      uint64
+	TotalKeys uint64
+
+	// Bytes is the size of the keys and values copied so far.
+	Bytes uint64
+
+	// Elapsed is how long the migration has been running, and ETA an
+	// estimate of how much longer it will take, based on the rate so far.
+	Elapsed time.Duration
+	ETA     time.Duration
+}
+
+// MigrateToV2 reads in the source file path of a BoltDB file
+// and outputs all the data migrated to a Bbolt destination file
+func MigrateToV2(source, destination string) (*BoltStore, error) {
+	return MigrateToV2WithOptions(context.Background(), source, destination, MigrateOptions{})
+}
+
+// MigrateToV2WithOptions is like MigrateToV2, but copies the file in
+// chunks of opts.ChunkSize keys, each committed in its own transaction,
+// so very large files can be migrated without holding everything in one
+// transaction, and reports its progress to opts.Progress. If ctx is done
+// before the copy has finished the destination file is removed, unless
+// opts.Resume is set, and ctx.Err() returned.
+func MigrateToV2WithOptions(ctx context.Context, source, destination string, opts MigrateOptions) (*BoltStore, error) {
+	if opts.ChunkSize < 0 {
+		return nil, fmt.Errorf("%w: ChunkSize must not be negative", ErrInvalidOptions)
+	}
+	if opts.ChunkSize == 0 {
+		opts.ChunkSize = defaultMigrateChunkSize
+	}
+	if opts.LogsBucket == nil {
+		opts.LogsBucket = dbLogs
+	}
+	if opts.ConfBucket == nil {
+		opts.ConfBucket = dbConf
+	}
+	customNames := !bytes.Equal(opts.LogsBucket, dbLogs) || !bytes.Equal(opts.ConfBucket, dbConf)
+	if opts.Verify && customNames {
+		return nil, fmt.Errorf("%w: Verify can't be used with custom bucket names", ErrInvalidOptions)
+	}
+	for _, name := range opts.ExtraBuckets {
+		if isStoreBucket(name) {
+			return nil, fmt.Errorf("%w: extra bucket %q is used by the store", ErrInvalidOptions, name)
+		}
+	}
+
+	_, err := os.Stat(destination)
+	resuming := err == nil && opts.Resume
+	if err == nil && !resuming {
+		return nil, fmt.Errorf("file exists in destination %v", destination)
+	}
+
+	srcDb, err := v1.Open(source, dbFileMode, &v1.Options{
+		ReadOnly: true,
+		Timeout:  1 * time.Minute,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed opening source database: %v", err)
+	}
+	defer srcDb.Close()
+
+	//Start a connection to the source
+	srctx, err := srcDb.Begin(false)
+	if err != nil {
+		return nil, fmt.Errorf("failed connecting to source database: %v", err)
+	}
+	defer srctx.Rollback()
+
+	//Create the destination
+	destDb, err := New(Options{Path: destination})
+	if err != nil {
+		return nil, fmt.Errorf("failed creating destination database: %v", err)
+	}
+	m := &migration{ctx: ctx, dest: destDb, opts: opts, start: time.Now()}
+	if resuming {
+		if m.resume, err = readMigrateCheckpoint(destDb); err != nil {
+			destDb.Close()
+			return nil, err
+		}
+	}
+	if err := m.run(source, srctx); err != nil {
+		if m.tx != nil {
+			m.tx.Rollback()
+		}
+		destDb.Close()
+		if !opts.Resume {
+			os.Remove(destination)
+		}
+		return nil, err
+	}
+
+	if opts.Verify {
+		srctx.Rollback()
+		srcDb.Close()
+		report, err := verifyMigrationTo(source, destDb)
+		if err == nil && !report.OK() {
+			err = &MigrationError{Source: source, Destination: destination, Report: report}
+		}
+		if err != nil {
+			destDb.Close()
+			os.Remove(destination)
+			return nil, err
+		}
+	}
+	return destDb, nil
+}
+
+// migration copies a v1 file into a new store in chunks.
+type migration struct {
+	ctx      context.Context
+	dest     *BoltStore
+	opts     MigrateOptions
+	start    time.Time
+	tx       *bbolt.Tx
+	inTx     int
+	progress MigrateProgress
+
+	// resume is where an interrupted migration got to, if it's being
+	// resumed. copying and pos track where this one has got to, as the
+	// index of the bucket being copied and the path of keys within it.
+	resume      *migrateCheckpoint
+	resumedKeys uint64
+	copying     int
+	pos         [][]byte
+}
+
+func (m *migration) run(source string, srctx *v1.Tx) error {
+	// The source's logs and conf buckets always end up in the default
+	// ones, and extra buckets keep their names
+	type copy struct{ from, to []byte }
+	copies := []copy{{m.opts.ConfBucket, dbConf}, {m.opts.LogsBucket, dbLogs}}
+	for _, name := range m.opts.ExtraBuckets {
+		copies = append(copies, copy{name, name})
+	}
+	for _, c := range copies {
+		srcB := srctx.Bucket(c.from)
+		if srcB == nil {
+			return fmt.Errorf("%w: %q in %s", ErrBucketMissing, c.from, source)
+		}
+		m.progress.TotalKeys += uint64(srcB.Stats().KeyN)
+	}
+	err := srctx.ForEach(func(name []byte, _ *v1.Bucket) error {
+		for _, c := range copies {
+			if bytes.Equal(name, c.from) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s holds bucket %q, which would be lost; list it in MigrateOptions.ExtraBuckets to copy it", source, name)
+	})
+	if err != nil {
+		return err
+	}
+
+	var resumePath [][]byte
+	if m.resume != nil {
+		m.progress.Keys, m.progress.Bytes = m.resume.keys, m.resume.bytes
+		m.resumedKeys = m.resume.keys
+		resumePath = m.resume.path
+	}
+
+	if err := m.begin(); err != nil {
+		return err
+	}
+	for i, c := range copies {
+		if m.resume != nil && i < m.resume.copying {
+			continue
+		}
+		m.copying = i
+		if _, err := m.tx.CreateBucketIfNotExists(c.to); err != nil {
+			return err
+		}
+		if err := m.copyBucket(srctx.Bucket(c.from), [][]byte{c.to}, resumePath); err != nil {
+			return fmt.Errorf("failed to copy %v bucket: %w", string(c.from), err)
+		}
+		resumePath = nil
+	}
+
+	destLogs, err := m.dest.logs(m.tx)
+	if err != nil {
+		return err
+	}
+	m.dest.trackIndexes(m.tx, destLogs)
+	if err := m.tx.Bucket(dbConf).Delete(dbMigrateCheckpointKey); err != nil {
+		return err
+	}
+	return m.commit(false)
+}
+
+// copyBucket copies src into the bucket at path in the destination,
+// recursing into nested buckets, and committing whenever a chunk is full.
+// If resume is set, keys up to and including the one it leads to were
+// already copied.
+func (m *migration) copyBucket(src *v1.Bucket, path [][]byte, resume [][]byte) error {
+	depth := len(path) - 1
+	curs := src.Cursor()
+	k, v := curs.First()
+	if len(resume) > 0 {
+		k, v = curs.Seek(resume[0])
+	}
+	for ; k != nil; k, v = curs.Next() {
+		resumed := len(resume) > 0 && bytes.Equal(k, resume[0])
+		if resumed && v != nil {
+			resume = nil
+			continue
+		}
+		if m.inTx >= m.opts.ChunkSize {
+			if err := m.commit(true); err != nil {
+				return err
+			}
+			if err := m.begin(); err != nil {
+				return err
+			}
+		}
+		m.pos = append(m.pos[:depth], k)
+		destB := m.tx.Bucket(path[0])
+		for _, name := range path[1:] {
+			destB = destB.Bucket(name)
+		}
+		if bytes.Equal(path[0], dbLogs) {
+			destB.FillPercent = m.dest.logsFillPercent
+		}
+
+		// Nested buckets have no value
+		if v == nil {
+			if _, err := destB.CreateBucketIfNotExists(k); err != nil {
+				return err
+			}
+			var nestedResume [][]byte
+			if resumed {
+				nestedResume = resume[1:]
+			} else {
+				m.progress.Keys++
+			}
+			resume = nil
+			nested := append(append([][]byte(nil), path...), bytes.Clone(k))
+			if err := m.copyBucket(src.Bucket(k), nested, nestedResume); err != nil {
+				return err
+			}
+			continue
+		}
+		resume = nil
+		if err := destB.Put(k, v); err != nil {
+			return err
+		}
+		m.inTx++
+		m.progress.Keys++
+		m.progress.Bytes += uint64(len(k) + len(v))
+	}
+	return nil
+}
+
+// isStoreBucket returns whether the store keeps its own data in the
+// top-level bucket called name.
+func isStoreBucket(name []byte) bool {
+	for _, b := range [][]byte{dbLogs, dbConf, dbOverflow, dbTerms, dbLogsMigrating} {
+		if bytes.Equal(name, b) {
+			return true
+		}
+	}
+	return false
+}
+
+// begin starts the transaction for the next chunk, unless ctx is done.
+func (m *migration) begin() error {
+	if err := m.ctx.Err(); err != nil {
+		return err
+	}
+	tx, err := m.dest.begin(true)
+	if err != nil {
+		return fmt.Errorf("failed connecting to destination database: %v", err)
+	}
+	m.tx, m.inTx = tx, 0
+	return nil
+}
+
+// commit commits the current chunk, along with a checkpoint of where it
+// got to if there's more to copy, and reports progress.
+func (m *migration) commit(checkpoint bool) error {
+	tx := m.tx
+	m.tx = nil
+	if checkpoint {
+		cp := migrateCheckpoint{copying: m.copying, path: m.pos, keys: m.progress.Keys, bytes: m.progress.Bytes}
+		if err := tx.Bucket(dbConf).Put(dbMigrateCheckpointKey, cp.encode()); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	//If the commit fails, clean up
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed commiting data to destination: %v", err)
+	}
+
+	if m.opts.Progress != nil {
+		p := m.progress
+		p.Elapsed = time.Since(m.start)
+		if copied := p.Keys - m.resumedKeys; copied > 0 && p.Keys < p.TotalKeys {
+			p.ETA = time.Duration(float64(p.Elapsed) * float64(p.TotalKeys-p.Keys) / float64(copied))
+		}
+		m.opts.Progress(p)
+	}
+	return nil
+}
+
+const (
+	// migrationDigestChunkSize is how many indexes VerifyMigration
+	// compares the digests of at a time.
+	migrationDigestChunkSize = 10000
+)
+
+var (
+	// ErrMigrationMismatch is returned when a migrated file doesn't match
+	// its source. The returned error is a *MigrationError.
+	ErrMigrationMismatch = errors.New("migrated store doesn't match its source")
+)
+
+// MigrationReport is the result of comparing a migrated store with its
+// source.
+type MigrationReport struct {
+	// SourceLogs and DestinationLogs are the number of log entries in
+	// each file.
+	SourceLogs      uint64
+	DestinationLogs uint64
+
+	// SourceConfKeys and DestinationConfKeys are the number of stable
+	// store keys in each file, not counting those the store keeps its own
+	// bookkeeping under.
+	SourceConfKeys      uint64
+	DestinationConfKeys uint64
+
+	// FirstIndex and LastIndex are the range of the source's log, or
+	// zero if it's empty.
+	FirstIndex uint64
+	LastIndex  uint64
+
+	// Differences describes everything that doesn't match, in the order
+	// it was found.
+	Differences []string
+}
+
+// OK returns true if the files match.
+func (r *MigrationReport) OK() bool {
+	return len(r.Differences) == 0
+}
+
+func (r *MigrationReport) add(format string, args ...any) {
+	r.Differences = append(r.Differences, fmt.Sprintf(format, args...))
+}
+
+// MigrationError is returned when a migrated store doesn't match its
+// source.
+type MigrationError struct {
+	// Source and Destination are the files that were compared.
+	Source      string
+	Destination string
+
+	// Report holds everything that was found.
+	Report *MigrationReport
+}
+
+// Error implements the error interface.
+func (e *MigrationError) Error() string {
+	return fmt.Sprintf("%v: %s has %d difference(s) from %s, first: %s",
+		ErrMigrationMismatch, e.Destination, len(e.Report.Differences), e.Source, e.Report.Differences[0])
+}
+
+// Unwrap allows errors.Is to match ErrMigrationMismatch.
+func (e *MigrationError) Unwrap() error {
+	return ErrMigrationMismatch
+}
+
+// VerifyMigration opens the v1 file at source and the file MigrateToV2
+// copied it to at destination, both read-only, and compares their log
+// and stable store key counts, first and last indexes, digests of each
+// range of the log, and stable store values, returning a report of any
+// differences. It's meant to prove a copy is complete before the
+// original is deleted. An error is only returned if the comparison itself
+// could not be run.
+func VerifyMigration(source, destination string) (*MigrationReport, error) {
+	dest, err := New(Options{Path: destination, ReadOnly: true, LockTimeout: defaultVerifyLockTimeout})
+	if err != nil {
+		return nil, err
+	}
+	defer dest.Close()
+	return verifyMigrationTo(source, dest)
+}
+
+// verifyMigrationTo compares the v1 file at source with dest.
+func verifyMigrationTo(source string, dest *BoltStore) (*MigrationReport, error) {
+	// Bbolt reads the files boltdb/bolt writes, and a v1 file is just an
+	// unversioned file to this package, so both can be read the same way
+	src, err := New(Options{Path: source, ReadOnly: true, LockTimeout: defaultVerifyLockTimeout})
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	report := &MigrationReport{}
+	srcStats, err := src.LogStats()
+	if err != nil {
+		return nil, err
+	}
+	destStats, err := dest.LogStats()
+	if err != nil {
+		return nil, err
+	}
+	report.SourceLogs, report.DestinationLogs = srcStats.Logs, destStats.Logs
+	report.FirstIndex, report.LastIndex = srcStats.FirstIndex, srcStats.LastIndex
+	if report.SourceLogs != report.DestinationLogs {
+		report.add("source has %d log entries, destination has %d", report.SourceLogs, report.DestinationLogs)
+	}
+	if srcStats.FirstIndex != destStats.FirstIndex || srcStats.LastIndex != destStats.LastIndex {
+		report.add("source log is %d to %d, destination log is %d to %d",
+			srcStats.FirstIndex, srcStats.LastIndex, destStats.FirstIndex, destStats.LastIndex)
+	}
+
+	if srcStats.LastIndex != 0 || destStats.LastIndex != 0 {
+		min := min(srcStats.FirstIndex, destStats.FirstIndex)
+		max := max(srcStats.LastIndex, destStats.LastIndex)
+		srcDigests, err := src.DigestChunks(min, max, migrationDigestChunkSize)
+		if err != nil {
+			return nil, err
+		}
+		destDigests, err := dest.DigestChunks(min, max, migrationDigestChunkSize)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			lo, hi, found := FirstDifference(srcDigests, destDigests)
+			if !found {
+				break
+			}
+			report.add("log entries %d to %d differ", lo, hi)
+			srcDigests = digestsAfter(srcDigests, hi)
+			destDigests = digestsAfter(destDigests, hi)
+		}
+	}
+
+	srcConf, err := confValues(src)
+	if err != nil {
+		return nil, err
+	}
+	destConf, err := confValues(dest)
+	if err != nil {
+		return nil, err
+	}
+	report.SourceConfKeys, report.DestinationConfKeys = uint64(len(srcConf)), uint64(len(destConf))
+	for _, k := range sortedKeys(srcConf) {
+		v, ok := destConf[k]
+		switch {
+		case !ok:
+			report.add("stable store key %q is missing", k)
+		case !bytes.Equal(v, srcConf[k]):
+			report.add("stable store key %q has a different value", k)
+		}
+	}
+	for _, k := range sortedKeys(destConf) {
+		if _, ok := srcConf[k]; !ok {
+			report.add("stable store key %q isn't in the source", k)
+		}
+	}
+	return report, nil
+}
+
+// digestsAfter drops the digests covering indexes up to max.
+func digestsAfter(digests []RangeDigest, max uint64) []RangeDigest {
+	for len(digests) > 0 && digests[0].Min <= max {
+		digests = digests[1:]
+	}
+	return digests
+}
+
+// sortedKeys returns the keys of values in order.
+func sortedKeys(values map[string][]byte) []string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// confValues returns the stable store values in store, leaving out the
+// keys it keeps its own bookkeeping under.
+func confValues(store *BoltStore) (map[string][]byte, error) {
+	values := make(map[string][]byte)
+	err := store.ForEach(nil, func(k, v []byte) error {
+		if !bytes.HasPrefix(k, internalKeyPrefix) {
+			values[string(k)] = bytes.Clone(v)
+		}
+		return nil
+	})
+	return values, err
+}
+
+var (
+	// dbMigrateCheckpointKey is the key in the conf bucket of a
+	// destination holding how far MigrateToV2WithOptions has got, while
+	// the migration is unfinished.
+	dbMigrateCheckpointKey = []byte("raftboltdb.migrateCheckpoint")
+)
+
+// migrateCheckpoint records where a migration got to, so it can be
+// resumed. copying is the index of the bucket being copied, and path the
+// keys leading to the last key copied within it, through any nested
+// buckets.
+type migrateCheckpoint struct {
+	copying int
+	path    [][]byte
+	keys    uint64
+	bytes   uint64
+}
+
+func (c *migrateCheckpoint) encode() []byte {
+	buf := binary.BigEndian.AppendUint64(nil, c.keys)
+	buf = binary.BigEndian.AppendUint64(buf, c.bytes)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(c.copying))
+	for _, k := range c.path {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(k)))
+		buf = append(buf, k...)
+	}
+	return buf
+}
+
+// readMigrateCheckpoint returns the checkpoint of the unfinished
+// migration into store. A store with no checkpoint is only resumed if
+// it's still empty, as the migration may have been interrupted before the
+// first chunk was committed.
+func readMigrateCheckpoint(store *BoltStore) (*migrateCheckpoint, error) {
+	var v []byte
+	empty := true
+	err := store.conn.View(func(tx *bbolt.Tx) error {
+		v = bytes.Clone(tx.Bucket(dbConf).Get(dbMigrateCheckpointKey))
+		return tx.Bucket(dbConf).ForEach(func(k, _ []byte) error {
+			if !bytes.HasPrefix(k, internalKeyPrefix) {
+				empty = false
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		last, err := store.LastIndex()
+		if err != nil {
+			return nil, err
+		}
+		if empty && last == 0 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%s has no migration checkpoint, so it can't be resumed", store.path)
+	}
+
+	if len(v) < 20 {
+		return nil, fmt.Errorf("migration checkpoint is only %d bytes", len(v))
+	}
+	c := &migrateCheckpoint{
+		keys:    binary.BigEndian.Uint64(v),
+		bytes:   binary.BigEndian.Uint64(v[8:]),
+		copying: int(binary.BigEndian.Uint32(v[16:])),
+	}
+	for v = v[20:]; len(v) > 0; {
+		if len(v) < 4 || uint64(len(v)-4) < uint64(binary.BigEndian.Uint32(v)) {
+			return nil, fmt.Errorf("migration checkpoint is corrupt")
+		}
+		n := binary.BigEndian.Uint32(v)
+		c.path = append(c.path, v[4:4+n])
+		v = v[4+n:]
+	}
+	return c, nil
+}
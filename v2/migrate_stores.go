@@ -0,0 +1,160 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+const (
+	// defaultStoreMigrateBatchSize is how many entries MigrateStores
+	// copies in each call to StoreLogs by default.
+	defaultStoreMigrateBatchSize = 1000
+)
+
+// LogStableStore is a raft log store that's also its stable store, as
+// BoltStore, raft-wal and raft.InmemStore are.
+type LogStableStore interface {
+	raft.LogStore
+	raft.StableStore
+}
+
+// StoreMigrateOptions configures MigrateStores.
+type StoreMigrateOptions struct {
+	// BatchSize is how many entries are read and then written with a
+	// single StoreLogs call. Defaults to 1000.
+	BatchSize int
+
+	// Uint64Keys are the stable store keys copied with GetUint64 and
+	// SetUint64. Defaults to the keys raft keeps its current term and
+	// the term of its last vote under.
+	Uint64Keys [][]byte
+
+	// Keys are the stable store keys copied with Get and Set. Defaults to
+	// the key raft keeps the candidate it last voted for under.
+	Keys [][]byte
+
+	// Progress, if not nil, is called after each batch is written, with
+	// Keys counting the entries copied so far.
+	Progress func(MigrateProgress)
+}
+
+// MigrateStores copies the log and the known stable store keys from src to
+// dst, which can be any pair of stores, such as one from this package, the
+// v1 store or raft-wal, in either direction. Entries are streamed in
+// batches, so the log never has to fit in memory, and gaps in it are
+// preserved. Stable store keys that aren't set in src are left alone. dst
+// must not already hold any entries. If ctx is done before the copy has
+// finished, ctx.Err() is returned and dst is left partly written.
+func MigrateStores(ctx context.Context, src, dst LogStableStore, opts StoreMigrateOptions) error {
+	if opts.BatchSize < 0 {
+		return fmt.Errorf("%w: BatchSize must not be negative", ErrInvalidOptions)
+	}
+	if opts.BatchSize == 0 {
+		opts.BatchSize = defaultStoreMigrateBatchSize
+	}
+	if opts.Uint64Keys == nil {
+		opts.Uint64Keys = [][]byte{keyCurrentTerm, keyLastVoteTerm}
+	}
+	if opts.Keys == nil {
+		opts.Keys = [][]byte{keyLastVoteCand}
+	}
+
+	dstLast, err := dst.LastIndex()
+	if err != nil {
+		return err
+	}
+	if dstLast != 0 {
+		return fmt.Errorf("destination already holds entries up to index %d", dstLast)
+	}
+
+	for _, k := range opts.Uint64Keys {
+		v, err := src.GetUint64(k)
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %q: %w", k, err)
+		}
+		if err := dst.SetUint64(k, v); err != nil {
+			return fmt.Errorf("failed to write %q: %w", k, err)
+		}
+	}
+	for _, k := range opts.Keys {
+		v, err := src.Get(k)
+		if isNotFound(err) || (err == nil && v == nil) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %q: %w", k, err)
+		}
+		if err := dst.Set(k, v); err != nil {
+			return fmt.Errorf("failed to write %q: %w", k, err)
+		}
+	}
+
+	first, err := src.FirstIndex()
+	if err != nil {
+		return err
+	}
+	last, err := src.LastIndex()
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	progress := MigrateProgress{}
+	if last != 0 {
+		progress.TotalKeys = last - first + 1
+	}
+
+	batch := make([]*raft.Log, 0, opts.BatchSize)
+	for idx := first; last != 0 && idx <= last; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		batch = batch[:0]
+		for ; idx <= last && len(batch) < opts.BatchSize; idx++ {
+			log := new(raft.Log)
+			err := src.GetLog(idx, log)
+			if errors.Is(err, raft.ErrLogNotFound) {
+				progress.TotalKeys--
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read entry %d: %w", idx, err)
+			}
+			batch = append(batch, log)
+			progress.Bytes += uint64(len(log.Data) + len(log.Extensions))
+		}
+		if len(batch) == 0 {
+			continue
+		}
+		if err := dst.StoreLogs(batch); err != nil {
+			return fmt.Errorf("failed to write entries %d to %d: %w", batch[0].Index, batch[len(batch)-1].Index, err)
+		}
+		progress.Keys += uint64(len(batch))
+
+		if opts.Progress != nil {
+			p := progress
+			p.Elapsed = time.Since(start)
+			if p.Keys < p.TotalKeys {
+				p.ETA = time.Duration(float64(p.Elapsed) * float64(p.TotalKeys-p.Keys) / float64(p.Keys))
+			}
+			opts.Progress(p)
+		}
+	}
+	return nil
+}
+
+// isNotFound returns whether err is a stable store's error for a missing
+// key. Stores report it in different ways, but raft itself relies on the
+// message being "not found".
+func isNotFound(err error) bool {
+	return err != nil && (errors.Is(err, ErrKeyNotFound) || err.Error() == "not found")
+}
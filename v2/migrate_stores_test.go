@@ -0,0 +1,81 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestMigrateStores(t *testing.T) {
+	src := testBoltStore(t)
+	defer src.Close()
+	defer os.Remove(src.path)
+
+	var logs []*raft.Log
+	for i := uint64(1); i <= 25; i++ {
+		logs = append(logs, testRaftLog(i, fmt.Sprintf("log%d", i)))
+	}
+	if err := src.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := src.SetUint64(keyCurrentTerm, 5); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := src.Set(keyLastVoteCand, []byte("node1")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Through another kind of store and back again
+	mem := raft.NewInmemStore()
+	var calls int
+	err := MigrateStores(context.Background(), src, mem, StoreMigrateOptions{
+		BatchSize: 10,
+		Progress:  func(MigrateProgress) { calls++ },
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if calls != 3 {
+		t.Fatalf("bad: %d", calls)
+	}
+
+	dst := testBoltStore(t)
+	defer dst.Close()
+	defer os.Remove(dst.path)
+	if err := MigrateStores(context.Background(), mem, dst, StoreMigrateOptions{}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	got, err := dst.GetLogs(1, 25, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(got, logs) {
+		t.Fatalf("bad: %v", got)
+	}
+	term, err := dst.GetUint64(keyCurrentTerm)
+	if err != nil || term != 5 {
+		t.Fatalf("bad: %d %v", term, err)
+	}
+	cand, err := dst.Get(keyLastVoteCand)
+	if err != nil || string(cand) != "node1" {
+		t.Fatalf("bad: %q %v", cand, err)
+	}
+
+	// InmemStore returns zero for a missing uint64 key
+	if term, err := dst.GetUint64(keyLastVoteTerm); err != nil || term != 0 {
+		t.Fatalf("bad: %d %v", term, err)
+	}
+
+	// The destination must be empty
+	if err := MigrateStores(context.Background(), src, dst, StoreMigrateOptions{}); err == nil {
+		t.Fatalf("should fail")
+	}
+}
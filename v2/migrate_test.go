@@ -0,0 +1,343 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/hashicorp/raft"
+	v1 "github.com/hashicorp/raft-boltdb"
+	"go.etcd.io/bbolt"
+)
+
+// testV1Store creates a v1 store at path holding entries 1 to n.
+func testV1Store(t *testing.T, path string, n int) {
+	t.Helper()
+	srcDb, err := v1.NewBoltStore(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer srcDb.Close()
+	var logs []*raft.Log
+	for i := 1; i <= n; i++ {
+		logs = append(logs, testRaftLog(uint64(i), fmt.Sprintf("log%d", i)))
+	}
+	if err := srcDb.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := srcDb.SetUint64([]byte("CurrentTerm"), 2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestMigrateToV2WithOptions(t *testing.T) {
+	dir := t.TempDir()
+	srcFile := filepath.Join(dir, "source")
+	testV1Store(t, srcFile, 25)
+
+	var reports []MigrateProgress
+	destDb, err := MigrateToV2WithOptions(context.Background(), srcFile, filepath.Join(dir, "dest"), MigrateOptions{
+		ChunkSize: 10,
+		Progress:  func(p MigrateProgress) { reports = append(reports, p) },
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer destDb.Close()
+
+	if len(reports) != 3 {
+		t.Fatalf("bad: %v", reports)
+	}
+	last := reports[len(reports)-1]
+	if last.Keys != 26 || last.TotalKeys != 26 || last.Bytes == 0 || last.ETA != 0 {
+		t.Fatalf("bad: %+v", last)
+	}
+	if reports[0].Keys != 10 {
+		t.Fatalf("bad: %+v", reports[0])
+	}
+
+	lastIndex, err := destDb.LastIndex()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if lastIndex != 25 {
+		t.Fatalf("bad: %d", lastIndex)
+	}
+	var log raft.Log
+	if err := destDb.GetLog(17, &log); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(log.Data) != "log17" {
+		t.Fatalf("bad: %q", log.Data)
+	}
+}
+
+func TestMigrateToV2WithOptions_Cancel(t *testing.T) {
+	dir := t.TempDir()
+	srcFile := filepath.Join(dir, "source")
+	destFile := filepath.Join(dir, "dest")
+	testV1Store(t, srcFile, 25)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := MigrateToV2WithOptions(ctx, srcFile, destFile, MigrateOptions{
+		ChunkSize: 10,
+		Progress:  func(MigrateProgress) { cancel() },
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := os.Stat(destFile); !os.IsNotExist(err) {
+		t.Fatalf("destination left behind: %v", err)
+	}
+}
+
+func TestVerifyMigration(t *testing.T) {
+	dir := t.TempDir()
+	srcFile := filepath.Join(dir, "source")
+	destFile := filepath.Join(dir, "dest")
+	testV1Store(t, srcFile, 25)
+
+	destDb, err := MigrateToV2WithOptions(context.Background(), srcFile, destFile, MigrateOptions{Verify: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	destDb.Close()
+
+	report, err := VerifyMigration(srcFile, destFile)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !report.OK() || report.SourceLogs != 25 || report.DestinationLogs != 25 ||
+		report.SourceConfKeys != 1 || report.FirstIndex != 1 || report.LastIndex != 25 {
+		t.Fatalf("bad: %+v", report)
+	}
+
+	// Change an entry and a value in the copy
+	destDb, err = New(Options{Path: destFile})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := destDb.StoreLog(testRaftLog(7, "changed")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := destDb.SetUint64([]byte("CurrentTerm"), 3); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	destDb.Close()
+
+	report, err = VerifyMigration(srcFile, destFile)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := []string{
+		"log entries 1 to 25 differ",
+		`stable store key "CurrentTerm" has a different value`,
+	}
+	if !reflect.DeepEqual(report.Differences, expected) {
+		t.Fatalf("bad: %q", report.Differences)
+	}
+}
+
+func TestMigrateFromV2(t *testing.T) {
+	dir := t.TempDir()
+	srcFile := filepath.Join(dir, "source")
+	destFile := filepath.Join(dir, "dest")
+
+	// Use features the v1 store can't read
+	store, err := New(Options{
+		Path:           srcFile,
+		LogSegmentSize: 16,
+		Checksums:      true,
+		Compression:    CompressionSnappy,
+		Wrapper:        testWrapper{id: 1},
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	var logs []*raft.Log
+	for i := uint64(1); i <= 40; i++ {
+		logs = append(logs, testRaftLog(i, string(bytes.Repeat([]byte{byte('a' + i%26)}, 300))))
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.SetUint64([]byte("CurrentTerm"), 4); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.Close()
+
+	err = MigrateFromV2WithOptions(context.Background(), srcFile, destFile, MigrateOptions{
+		ChunkSize: 16,
+		Wrapper:   testWrapper{id: 1},
+		Verify:    true,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	dstDb, err := v1.NewBoltStore(destFile)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer dstDb.Close()
+	for _, expected := range logs {
+		var log raft.Log
+		if err := dstDb.GetLog(expected.Index, &log); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if !bytes.Equal(log.Data, expected.Data) {
+			t.Fatalf("bad %d: %q", expected.Index, log.Data)
+		}
+	}
+	term, err := dstDb.GetUint64([]byte("CurrentTerm"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if term != 4 {
+		t.Fatalf("bad: %d", term)
+	}
+
+	if err := MigrateFromV2(srcFile, filepath.Join(dir, "other")); !errors.Is(err, ErrEncrypted) {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestMigrateToV2WithOptions_Buckets(t *testing.T) {
+	dir := t.TempDir()
+	srcFile := filepath.Join(dir, "source")
+
+	db, err := bolt.Open(srcFile, 0600, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		logs, err := tx.CreateBucket([]byte("raftlogs"))
+		if err != nil {
+			return err
+		}
+		val, err := encodeMsgPack(testRaftLog(1, "log1"), false)
+		if err != nil {
+			return err
+		}
+		if err := logs.Put(uint64ToBytes(1), val.Bytes()); err != nil {
+			return err
+		}
+		conf, err := tx.CreateBucket([]byte("raftconf"))
+		if err != nil {
+			return err
+		}
+		if err := conf.Put([]byte("CurrentTerm"), uint64ToBytes(2)); err != nil {
+			return err
+		}
+		app, err := tx.CreateBucket([]byte("app"))
+		if err != nil {
+			return err
+		}
+		if err := app.Put([]byte("k"), []byte("v")); err != nil {
+			return err
+		}
+		inner, err := app.CreateBucket([]byte("inner"))
+		if err != nil {
+			return err
+		}
+		return inner.Put([]byte("k2"), []byte("v2"))
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	db.Close()
+
+	// Buckets that wouldn't be copied are an error
+	opts := MigrateOptions{LogsBucket: []byte("raftlogs"), ConfBucket: []byte("raftconf")}
+	if _, err := MigrateToV2WithOptions(context.Background(), srcFile, filepath.Join(dir, "dest1"), opts); err == nil {
+		t.Fatalf("should fail without the app bucket")
+	}
+	opts.ExtraBuckets = [][]byte{[]byte("terms")}
+	if _, err := MigrateToV2WithOptions(context.Background(), srcFile, filepath.Join(dir, "dest1"), opts); !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("err: %v", err)
+	}
+
+	opts.ExtraBuckets = [][]byte{[]byte("app")}
+	opts.ChunkSize = 1
+	destDb, err := MigrateToV2WithOptions(context.Background(), srcFile, filepath.Join(dir, "dest2"), opts)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer destDb.Close()
+
+	var log raft.Log
+	if err := destDb.GetLog(1, &log); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if term, err := destDb.GetUint64([]byte("CurrentTerm")); err != nil || term != 2 {
+		t.Fatalf("bad: %d %v", term, err)
+	}
+	err = destDb.conn.View(func(tx *bbolt.Tx) error {
+		app := tx.Bucket([]byte("app"))
+		if app == nil || string(app.Get([]byte("k"))) != "v" {
+			return fmt.Errorf("app bucket wasn't copied")
+		}
+		if inner := app.Bucket([]byte("inner")); inner == nil || string(inner.Get([]byte("k2"))) != "v2" {
+			return fmt.Errorf("nested bucket wasn't copied")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestMigrateToV2WithOptions_Resume(t *testing.T) {
+	dir := t.TempDir()
+	srcFile := filepath.Join(dir, "source")
+	destFile := filepath.Join(dir, "dest")
+	testV1Store(t, srcFile, 25)
+
+	// Interrupt the migration after the first chunk
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := MigrateToV2WithOptions(ctx, srcFile, destFile, MigrateOptions{
+		ChunkSize: 10,
+		Resume:    true,
+		Progress:  func(MigrateProgress) { cancel() },
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := os.Stat(destFile); err != nil {
+		t.Fatalf("destination should be kept: %v", err)
+	}
+
+	var reports []MigrateProgress
+	destDb, err := MigrateToV2WithOptions(context.Background(), srcFile, destFile, MigrateOptions{
+		ChunkSize: 10,
+		Resume:    true,
+		Verify:    true,
+		Progress:  func(p MigrateProgress) { reports = append(reports, p) },
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer destDb.Close()
+	if len(reports) != 2 || reports[0].Keys != 20 || reports[1].Keys != 26 {
+		t.Fatalf("bad: %+v", reports)
+	}
+	if _, err := destDb.Get(dbMigrateCheckpointKey); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("checkpoint left behind: %v", err)
+	}
+	destDb.Close()
+
+	// A finished migration can't be resumed
+	_, err = MigrateToV2WithOptions(context.Background(), srcFile, destFile, MigrateOptions{Resume: true})
+	if err == nil {
+		t.Fatalf("should fail")
+	}
+}
@@ -0,0 +1,576 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"go.etcd.io/bbolt"
+)
+
+var (
+	// dbGroups holds a bucket for each raft group in a MultiStore, named
+	// after the group, which holds the group's own logs, conf and
+	// overflow buckets.
+	dbGroups = []byte("groups")
+
+	// ErrGroupNotFound is returned for a group that doesn't exist in a
+	// MultiStore, including by the GroupStore of a group that has since
+	// been dropped.
+	ErrGroupNotFound = errors.New("group not found")
+
+	// ErrGroupExists is returned by MultiStore.CreateGroup for a group
+	// that already exists.
+	ErrGroupExists = errors.New("group already exists")
+)
+
+// MultiStore keeps the logs and stable stores of many raft groups in one
+// file, for systems that run a raft group per shard or tenant and would
+// otherwise open a file, with its own memory map, lock and syncs, for
+// each of them. Each group's buckets are nested in a bucket of its own,
+// and every group shares the file's transactions, so with
+// Options.BatchWrites the writes of groups appending concurrently are
+// committed, and synced, together.
+type MultiStore struct {
+	store *BoltStore
+}
+
+// GroupStore is the Store of one raft group in a MultiStore, returned by
+// MultiStore.Group. Its log and stable store are independent of every
+// other group's. Closing it only closes the handle, the file stays open
+// until the MultiStore is closed.
+type GroupStore struct {
+	multi  *MultiStore
+	name   []byte
+	closed atomic.Bool
+}
+
+var _ Store = (*GroupStore)(nil)
+
+// GroupStats is a summary of the contents of one raft group in a
+// MultiStore.
+type GroupStats struct {
+	LogStoreStats
+
+	// Bytes is the space the group's pages take up in the file, including
+	// the unused space on them.
+	Bytes int64
+}
+
+// NewMultiStore opens the file at path, creating it if needed, to keep the
+// stores of many raft groups in. See Group.
+func NewMultiStore(path string) (*MultiStore, error) {
+	return NewMultiStoreWithOptions(Options{Path: path})
+}
+
+// NewMultiStoreWithOptions is like NewMultiStore, but configures the file
+// with options. Options that only make sense for a single log can't be
+// used: StablePath, LogSegmentSize, TermIndex, ArchiveFunc,
+// RetentionPolicy, CacheSize, ReadAhead and Wrapper.
+func NewMultiStoreWithOptions(options Options) (*MultiStore, error) {
+	if err := options.validateMulti(); err != nil {
+		return nil, err
+	}
+	store, err := New(options)
+	if err != nil {
+		return nil, err
+	}
+	if !store.readOnly {
+		_, err := store.update("MultiInit", func(tx *bbolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(dbGroups)
+			return err
+		})
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
+	}
+	return &MultiStore{store: store}, nil
+}
+
+// validateMulti checks that the options can be used with a MultiStore.
+func (o *Options) validateMulti() error {
+	switch {
+	case o.StablePath != "":
+		return fmt.Errorf("%w: StablePath can't be used with a MultiStore", ErrInvalidOptions)
+	case o.LogSegmentSize != 0:
+		return fmt.Errorf("%w: LogSegmentSize can't be used with a MultiStore", ErrInvalidOptions)
+	case o.TermIndex:
+		return fmt.Errorf("%w: TermIndex can't be used with a MultiStore", ErrInvalidOptions)
+	case o.ArchiveFunc != nil:
+		return fmt.Errorf("%w: ArchiveFunc can't be used with a MultiStore", ErrInvalidOptions)
+	case o.RetentionPolicy != nil:
+		return fmt.Errorf("%w: RetentionPolicy can't be used with a MultiStore", ErrInvalidOptions)
+	case o.CacheSize != 0 || o.ReadAhead != 0:
+		return fmt.Errorf("%w: CacheSize and ReadAhead can't be used with a MultiStore", ErrInvalidOptions)
+	case o.Wrapper != nil:
+		return fmt.Errorf("%w: Wrapper can't be used with a MultiStore", ErrInvalidOptions)
+	}
+	return nil
+}
+
+// Close closes the file. The GroupStores returned by Group return
+// ErrClosed once it's closed.
+func (m *MultiStore) Close() error {
+	return m.store.Close()
+}
+
+// Sync syncs the file, see BoltStore.Sync.
+func (m *MultiStore) Sync() error {
+	return m.store.Sync()
+}
+
+// Group returns the store of the raft group name, creating the group if
+// it doesn't exist yet. A store opened read-only returns ErrGroupNotFound
+// instead.
+func (m *MultiStore) Group(name string) (*GroupStore, error) {
+	if name == "" {
+		return nil, fmt.Errorf("%w: group name must not be empty", ErrInvalidOptions)
+	}
+	g := &GroupStore{multi: m, name: []byte(name)}
+
+	tx, err := m.store.begin(false)
+	if err != nil {
+		return nil, err
+	}
+	_, err = g.root(tx)
+	tx.Rollback()
+	switch {
+	case err == nil:
+		return g, nil
+	case !errors.Is(err, ErrGroupNotFound) || m.store.readOnly:
+		return nil, err
+	}
+
+	_, err = m.store.update("CreateGroup", func(tx *bbolt.Tx) error {
+		return createGroup(tx, g.name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// CreateGroup creates the raft group name, returning its store, or
+// ErrGroupExists if it already exists.
+func (m *MultiStore) CreateGroup(name string) (*GroupStore, error) {
+	if name == "" {
+		return nil, fmt.Errorf("%w: group name must not be empty", ErrInvalidOptions)
+	}
+	g := &GroupStore{multi: m, name: []byte(name)}
+	_, err := m.store.update("CreateGroup", func(tx *bbolt.Tx) error {
+		if _, err := g.root(tx); err == nil {
+			return fmt.Errorf("%w: %q in %s", ErrGroupExists, name, m.store.path)
+		}
+		return createGroup(tx, g.name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// DropGroup deletes the raft group name, with its log and stable store,
+// by deleting its bucket in a single transaction, so it takes the same
+// time however long the log is. The group's pages are freed for reuse
+// within the file, see BoltStore.Compact to shrink it. The GroupStores of
+// the group return ErrGroupNotFound afterwards.
+func (m *MultiStore) DropGroup(name string) error {
+	_, err := m.store.update("DropGroup", func(tx *bbolt.Tx) error {
+		groups := tx.Bucket(dbGroups)
+		if groups == nil || groups.Bucket([]byte(name)) == nil {
+			return fmt.Errorf("%w: %q in %s", ErrGroupNotFound, name, m.store.path)
+		}
+		return groups.DeleteBucket([]byte(name))
+	})
+	return err
+}
+
+// ListGroups returns the names of the raft groups in the file, in byte
+// order.
+func (m *MultiStore) ListGroups() ([]string, error) {
+	tx, err := m.store.begin(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var names []string
+	groups := tx.Bucket(dbGroups)
+	if groups == nil {
+		return names, nil
+	}
+	err = groups.ForEach(func(k, v []byte) error {
+		if v == nil {
+			names = append(names, string(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// GroupStats returns the stats of every raft group in the file, by name,
+// all read from a single transaction. It reads every entry of every
+// group's log, so it's not meant to be called on a hot path.
+func (m *MultiStore) GroupStats() (map[string]*GroupStats, error) {
+	tx, err := m.store.begin(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stats := make(map[string]*GroupStats)
+	groups := tx.Bucket(dbGroups)
+	if groups == nil {
+		return stats, nil
+	}
+	err = groups.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return nil
+		}
+		g := &GroupStore{multi: m, name: k}
+		gs, err := g.stats(tx)
+		if err != nil {
+			return err
+		}
+		stats[string(k)] = gs
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// createGroup creates the buckets of the group name in tx, leaving any
+// that already exist.
+func createGroup(tx *bbolt.Tx, name []byte) error {
+	groups, err := tx.CreateBucketIfNotExists(dbGroups)
+	if err != nil {
+		return err
+	}
+	root, err := groups.CreateBucketIfNotExists(name)
+	if err != nil {
+		return err
+	}
+	for _, b := range [][]byte{dbLogs, dbConf, dbOverflow} {
+		if _, err := root.CreateBucketIfNotExists(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Name returns the name of the group.
+func (g *GroupStore) Name() string {
+	return string(g.name)
+}
+
+// root returns the bucket holding the group's buckets in tx.
+func (g *GroupStore) root(tx *bbolt.Tx) (*bbolt.Bucket, error) {
+	if g.closed.Load() {
+		return nil, ErrClosed
+	}
+	var root *bbolt.Bucket
+	if groups := tx.Bucket(dbGroups); groups != nil {
+		root = groups.Bucket(g.name)
+	}
+	if root == nil {
+		return nil, fmt.Errorf("%w: %q in %s", ErrGroupNotFound, g.name, g.multi.store.path)
+	}
+	return root, nil
+}
+
+// bucket returns the group's bucket called name in tx.
+func (g *GroupStore) bucket(tx *bbolt.Tx, name []byte) (*bbolt.Bucket, error) {
+	root, err := g.root(tx)
+	if err != nil {
+		return nil, err
+	}
+	bucket := root.Bucket(name)
+	if bucket == nil {
+		return nil, fmt.Errorf("%w: %q in group %q in %s", ErrBucketMissing, name, g.name, g.multi.store.path)
+	}
+	return bucket, nil
+}
+
+// logs returns the group's log in tx. Groups always use the flat format,
+// without a term index.
+func (g *GroupStore) logs(tx *bbolt.Tx) (*logBucket, error) {
+	b := g.multi.store
+	bucket, err := g.bucket(tx, dbLogs)
+	if err != nil {
+		return nil, err
+	}
+	bucket.FillPercent = b.logsFillPercent
+	overflow, err := g.bucket(tx, dbOverflow)
+	if err != nil {
+		return nil, err
+	}
+	return &logBucket{
+		root:              bucket,
+		fillPercent:       b.logsFillPercent,
+		overflow:          overflow,
+		overflowChunkSize: b.overflowChunkSize,
+	}, nil
+}
+
+// view runs fn in a read transaction.
+func (g *GroupStore) view(fn func(*bbolt.Tx) error) error {
+	if g.closed.Load() {
+		return ErrClosed
+	}
+	tx, err := g.multi.store.begin(false)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	return fn(tx)
+}
+
+// update runs fn in a write transaction made by op, which is shared with
+// other groups if batch is set and Options.BatchWrites is.
+func (g *GroupStore) update(op string, batch bool, fn func(*bbolt.Tx) error) error {
+	if g.closed.Load() {
+		return ErrClosed
+	}
+	b := g.multi.store
+	var err error
+	if batch && b.batchWrites {
+		_, err = b.batch(op, fn)
+	} else {
+		_, err = b.update(op, fn)
+	}
+	return err
+}
+
+// FirstIndex returns the first index written to the group's log. 0 for
+// no entries.
+func (g *GroupStore) FirstIndex() (first uint64, err error) {
+	err = g.view(func(tx *bbolt.Tx) error {
+		logs, err := g.logs(tx)
+		if err != nil {
+			return err
+		}
+		if k, _ := logs.cursor().First(); k != nil {
+			first = bytesToUint64(k)
+		}
+		return nil
+	})
+	return first, err
+}
+
+// LastIndex returns the last index written to the group's log. 0 for no
+// entries.
+func (g *GroupStore) LastIndex() (last uint64, err error) {
+	err = g.view(func(tx *bbolt.Tx) error {
+		logs, err := g.logs(tx)
+		if err != nil {
+			return err
+		}
+		if k, _ := logs.cursor().Last(); k != nil {
+			last = bytesToUint64(k)
+		}
+		return nil
+	})
+	return last, err
+}
+
+// GetLog is used to retrieve a log from the group at a given index.
+func (g *GroupStore) GetLog(idx uint64, log *raft.Log) error {
+	return g.view(func(tx *bbolt.Tx) error {
+		logs, err := g.logs(tx)
+		if err != nil {
+			return err
+		}
+		val := logs.get(idx)
+		if val == nil {
+			return raft.ErrLogNotFound
+		}
+		if err := logs.decode(idx, val, log); err != nil {
+			return fmt.Errorf("%w at index %d: %w", ErrLogCorrupt, idx, err)
+		}
+		return nil
+	})
+}
+
+// StoreLog is used to store a single raft log in the group.
+func (g *GroupStore) StoreLog(log *raft.Log) error {
+	return g.StoreLogs([]*raft.Log{log})
+}
+
+// StoreLogs is used to store a set of raft logs in the group. With
+// Options.BatchWrites it shares a commit with the writes of other groups.
+func (g *GroupStore) StoreLogs(logs []*raft.Log) error {
+	b := g.multi.store
+	enc := getLogEncoder(b.msgpackUseNewTimeFormat)
+	defer enc.release()
+	return g.update("StoreLogs", true, func(tx *bbolt.Tx) error {
+		bucket, err := g.logs(tx)
+		if err != nil {
+			return err
+		}
+		if b.strictAppend {
+			if err := checkAppend(bucket, logs); err != nil {
+				return err
+			}
+		}
+		if err := b.checkOverwrites(tx, bucket, logs); err != nil {
+			return err
+		}
+
+		// Overwritten entries may have left data in the overflow bucket
+		var lastIndex uint64
+		if last, _ := bucket.cursor().Last(); last != nil {
+			lastIndex = bytesToUint64(last)
+		}
+		written, err := b.writeEntries(bucket, enc, logs, lastIndex)
+		if err != nil {
+			return err
+		}
+		return b.checkQuota(tx, written)
+	})
+}
+
+// DeleteRange is used to delete the group's logs within a given range
+// inclusively.
+func (g *GroupStore) DeleteRange(min, max uint64) error {
+	return g.update("DeleteRange", false, func(tx *bbolt.Tx) error {
+		bucket, err := g.logs(tx)
+		if err != nil {
+			return err
+		}
+		_, bytesDeleted, err := bucket.deleteRange(min, max)
+		if err != nil {
+			return err
+		}
+		g.multi.store.freed(tx, bytesDeleted)
+		return nil
+	})
+}
+
+// Set is used to set a key/value in the group's stable store. Like
+// BoltStore.Set, it returns ErrReservedKey for keys starting with
+// "raftboltdb.".
+func (g *GroupStore) Set(k, v []byte) error {
+	if err := checkKey(k); err != nil {
+		return err
+	}
+	return g.update("Set", false, func(tx *bbolt.Tx) error {
+		conf, err := g.bucket(tx, dbConf)
+		if err != nil {
+			return err
+		}
+		return g.multi.store.putConf(conf, k, v)
+	})
+}
+
+// Get is used to retrieve a value from the group's stable store by key.
+func (g *GroupStore) Get(k []byte) (val []byte, err error) {
+	err = g.view(func(tx *bbolt.Tx) error {
+		conf, err := g.bucket(tx, dbConf)
+		if err != nil {
+			return err
+		}
+		if val, err = g.multi.store.getConf(conf, k); err != nil {
+			return err
+		}
+		if val == nil {
+			return ErrKeyNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return val, nil
+}
+
+// SetUint64 is like Set, but handles uint64 values
+func (g *GroupStore) SetUint64(key []byte, val uint64) error {
+	return g.Set(key, uint64ToBytes(val))
+}
+
+// GetUint64 returns the uint64 value for key, or 0 if key was not found.
+func (g *GroupStore) GetUint64(key []byte) (uint64, error) {
+	val, err := g.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return valueToUint64(key, val)
+}
+
+// LogStats returns a summary of the group's contents, see
+// BoltStore.LogStats. The file stats are those of the whole file.
+func (g *GroupStore) LogStats() (stats *LogStoreStats, err error) {
+	err = g.view(func(tx *bbolt.Tx) error {
+		gs, err := g.stats(tx)
+		if err != nil {
+			return err
+		}
+		stats = &gs.LogStoreStats
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// stats returns the stats of the group in tx.
+func (g *GroupStore) stats(tx *bbolt.Tx) (*GroupStats, error) {
+	root, err := g.root(tx)
+	if err != nil {
+		return nil, err
+	}
+	logs, err := g.logs(tx)
+	if err != nil {
+		return nil, err
+	}
+	conf, err := g.bucket(tx, dbConf)
+	if err != nil {
+		return nil, err
+	}
+
+	bs := root.Stats()
+	stats := &GroupStats{
+		LogStoreStats: LogStoreStats{
+			FileSize:      tx.Size(),
+			FreelistBytes: tx.DB().Stats().FreelistInuse,
+			ConfKeys:      uint64(conf.Stats().KeyN),
+		},
+		Bytes: int64(bs.BranchAlloc + bs.LeafAlloc),
+	}
+	// A group small enough is stored inline in its parent's page
+	if stats.Bytes == 0 {
+		stats.Bytes = int64(bs.InlineBucketInuse)
+	}
+	curs := logs.cursor()
+	for k, v := curs.First(); k != nil; k, v = curs.Next() {
+		if stats.Logs == 0 {
+			stats.FirstIndex = bytesToUint64(k)
+		}
+		stats.LastIndex = bytesToUint64(k)
+		stats.Logs++
+		stats.LogBytes += uint64(len(v))
+	}
+	if v := tx.Bucket(dbConf).Get(dbLastCompactionKey); len(v) == 8 {
+		stats.LastCompaction = time.Unix(0, int64(bytesToUint64(v)))
+	}
+	return stats, nil
+}
+
+// Close closes the handle, after which its methods return ErrClosed. The
+// group and the file are left as they are.
+func (g *GroupStore) Close() error {
+	g.closed.Store(true)
+	return nil
+}
@@ -0,0 +1,214 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestMultiStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "raft.db")
+	multi, err := NewMultiStoreWithOptions(Options{Path: path, BatchWrites: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Groups writing concurrently each see only their own entries
+	var wg sync.WaitGroup
+	errCh := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			group, err := multi.Group(fmt.Sprintf("shard-%d", i))
+			if err != nil {
+				errCh <- err
+				return
+			}
+			for idx := uint64(1); idx <= 10; idx++ {
+				if err := group.StoreLog(testRaftLog(idx, fmt.Sprintf("%d-%d", i, idx))); err != nil {
+					errCh <- err
+					return
+				}
+			}
+			if err := group.SetUint64(keyCurrentTerm, uint64(i)); err != nil {
+				errCh <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		t.Fatalf("err: %s", err)
+	}
+	if err := multi.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	multi, err = NewMultiStoreWithOptions(Options{Path: path, ReadOnly: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer multi.Close()
+	for i := 0; i < 8; i++ {
+		group, err := multi.Group(fmt.Sprintf("shard-%d", i))
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		stats, err := group.LogStats()
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if stats.Logs != 10 || stats.FirstIndex != 1 || stats.LastIndex != 10 || stats.ConfKeys != 1 {
+			t.Fatalf("bad: %+v", stats)
+		}
+		var log raft.Log
+		if err := group.GetLog(10, &log); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if string(log.Data) != fmt.Sprintf("%d-10", i) {
+			t.Fatalf("bad: %q", log.Data)
+		}
+		term, err := group.GetUint64(keyCurrentTerm)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if term != uint64(i) {
+			t.Fatalf("bad: %d", term)
+		}
+
+		group.Close()
+		if _, err := group.LastIndex(); !errors.Is(err, ErrClosed) {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// A read-only store can't create groups
+	if _, err := multi.Group("missing"); !errors.Is(err, ErrGroupNotFound) {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestMultiStore_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "raft.db")
+	for _, options := range []Options{
+		{Path: path, LogSegmentSize: 16},
+		{Path: path, TermIndex: true},
+		{Path: path, CacheSize: 8},
+		{Path: path, Wrapper: &testWrapper{id: 1}},
+	} {
+		if _, err := NewMultiStoreWithOptions(options); !errors.Is(err, ErrInvalidOptions) {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	multi, err := NewMultiStore(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := multi.Group(""); !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("err: %v", err)
+	}
+	group, err := multi.Group("shard-1")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := group.Set([]byte("raftboltdb.test"), []byte("bad")); !errors.Is(err, ErrReservedKey) {
+		t.Fatalf("err: %v", err)
+	}
+	multi.Close()
+	if err := group.Set([]byte("foo"), []byte("bar")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestMultiStore_Groups(t *testing.T) {
+	multi, err := NewMultiStore(filepath.Join(t.TempDir(), "raft.db"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer multi.Close()
+
+	names, err := multi.ListGroups()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(names) != 0 {
+		t.Fatalf("bad: %v", names)
+	}
+
+	for _, name := range []string{"shard-2", "shard-1"} {
+		group, err := multi.CreateGroup(name)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		for idx := uint64(1); idx <= 100; idx++ {
+			if err := group.StoreLog(testRaftLog(idx, "data")); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+		}
+	}
+	if _, err := multi.CreateGroup("shard-1"); !errors.Is(err, ErrGroupExists) {
+		t.Fatalf("err: %v", err)
+	}
+	names, err = multi.ListGroups()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(names) != 2 || names[0] != "shard-1" || names[1] != "shard-2" {
+		t.Fatalf("bad: %v", names)
+	}
+
+	stats, err := multi.GroupStats()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("bad: %v", stats)
+	}
+	for name, s := range stats {
+		if s.Logs != 100 || s.LastIndex != 100 || s.Bytes <= int64(s.LogBytes) {
+			t.Fatalf("bad: %s %+v", name, s)
+		}
+	}
+
+	// Dropping a group leaves the others alone
+	dropped, err := multi.Group("shard-1")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := multi.DropGroup("shard-1"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := multi.DropGroup("shard-1"); !errors.Is(err, ErrGroupNotFound) {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := dropped.LastIndex(); !errors.Is(err, ErrGroupNotFound) {
+		t.Fatalf("err: %v", err)
+	}
+	names, err = multi.ListGroups()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(names) != 1 || names[0] != "shard-2" {
+		t.Fatalf("bad: %v", names)
+	}
+	group, err := multi.Group("shard-2")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	last, err := group.LastIndex()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if last != 100 {
+		t.Fatalf("bad: %d", last)
+	}
+}
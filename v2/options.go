@@ -0,0 +1,644 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/go-hclog"
+	"go.etcd.io/bbolt"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// FreelistType selects the data structure Bbolt uses to track free pages.
+type FreelistType string
+
+const (
+	// FreelistArray keeps free pages in a sorted array. This is Bbolt's
+	// default, but it degrades badly once the freelist grows large.
+	FreelistArray FreelistType = "array"
+
+	// FreelistMap keeps free pages in a hashmap, which is faster in almost
+	// all cases. It's the default here, because raft truncates the log
+	// from the front, which leaves a large freelist behind.
+	FreelistMap FreelistType = "hashmap"
+)
+
+const (
+	// The range of fill percents Bbolt accepts; it clamps anything else.
+	minFillPercent = 0.1
+	maxFillPercent = 1.0
+)
+
+var (
+	// ErrInvalidOptions is returned when the supplied Options can not be
+	// used to open the store.
+	ErrInvalidOptions = errors.New("invalid options")
+)
+
+// Options contains all the configuration used to open the Bbolt
+type Options struct {
+	// Path is the file path to the Bbolt to use
+	Path string
+
+	// StablePath keeps the stable store's keys, which hold raft's current
+	// term and vote, in a second Bbolt file at this path, so writing them
+	// doesn't wait behind large log commits. Keys already in Path are
+	// moved the first time it's used, after which the store can't be
+	// opened without it. The stable file is synced on every write, and
+	// none of the options for tuning the log file apply to it. Backup,
+	// Compact, Verify and Migrate only cover the log file. Can't be
+	// combined with Wrapper.
+	StablePath string
+
+	// BoltOptions contains any specific Bbolt options you might
+	// want to specify [e.g. open timeout]. Any of the first-class
+	// fields below that are set take precedence over these.
+	BoltOptions *bbolt.Options
+
+	// NoSync causes the database to skip fsync calls after each
+	// write to the log. This is unsafe, so it should be used
+	// with caution.
+	NoSync bool
+
+	// SyncPolicy controls when writes are flushed to disk. Defaults to
+	// SyncEveryWrite. Setting NoSync is the same as SyncOnDemand, except
+	// that the store isn't synced when it's closed.
+	SyncPolicy SyncPolicy
+
+	// BatchWrites makes StoreLogs calls from concurrent goroutines share
+	// a transaction, and so a single commit, using Bbolt's Batch. A call
+	// may wait up to MaxBatchDelay for others to join it, so this only
+	// helps when there are several writers.
+	BatchWrites bool
+
+	// MaxBatchSize is the most calls that will share a transaction when
+	// BatchWrites is set. Defaults to Bbolt's default of 1000.
+	MaxBatchSize int
+
+	// MaxBatchDelay is how long a call will wait for others to join its
+	// transaction when BatchWrites is set. Defaults to Bbolt's default of
+	// 10ms.
+	MaxBatchDelay time.Duration
+
+	// LogsFillPercent is how full Bbolt packs pages in the logs bucket
+	// before splitting them. Log indexes only ever increase, so pages
+	// behind the tail are never written again and can be packed almost
+	// full, rather than half full as Bbolt does by default. Must be
+	// between 0.1 and 1.0. Defaults to 0.95.
+	LogsFillPercent float64
+
+	// TrimChunkSize is the most entries TrimPrefixAsync deletes in one
+	// transaction. Defaults to 1000.
+	TrimChunkSize int
+
+	// TrimPause is how long TrimPrefixAsync waits between transactions,
+	// which limits how much of the writer's time it takes. Defaults to
+	// 10ms.
+	TrimPause time.Duration
+
+	// LogSegmentSize splits the logs bucket into nested buckets that each
+	// hold this many indexes, so deleting a prefix of the log drops whole
+	// buckets rather than deleting millions of entries one at a time,
+	// which keeps DeleteRange and TrimPrefixAsync fast for large logs.
+	// 16384 is a reasonable size. An existing flat log is converted when
+	// it's opened, which takes a single transaction copying every entry.
+	// This is a format change: once converted the file can't be read by
+	// earlier versions, and the size can't be changed. Zero keeps the
+	// flat format, unless the file is already segmented.
+	LogSegmentSize int
+
+	// AutoCompact makes the store compact itself in the background when
+	// it's idle and the file has grown too large or fragmented. Automatic
+	// compaction is disabled if it's nil.
+	AutoCompact *AutoCompact
+
+	// ArchiveFunc is given every log entry before it's deleted by
+	// DeleteRange or TrimPrefixAsync, and can abort the deletion by
+	// returning an error. Entries are deleted without being archived if
+	// it's nil.
+	ArchiveFunc ArchiveFunc
+
+	// RetentionPolicy makes the store truncate old entries from the start
+	// of the log in the background. Nothing is truncated if it's nil.
+	RetentionPolicy *RetentionPolicy
+
+	// ReadTxWatchdog makes the store report the read transactions of
+	// iterators, snapshots and View that have been open for too long, and
+	// optionally end stale snapshots. Nothing is watched if it's nil.
+	ReadTxWatchdog *ReadTxWatchdog
+
+	// MinFreeBytes makes writes fail early with ErrDiskFull, rather than
+	// part way through a commit, once the filesystem holding the store has
+	// less than this many bytes free. Free space is checked before every
+	// write transaction, which costs a statfs call. It's only checked on
+	// Linux, and zero disables the check. Writes that run out of space
+	// return ErrDiskFull either way.
+	MinFreeBytes uint64
+
+	// MaxSizeBytes is a quota on the size of the data in the file. Log
+	// appends that are estimated to take it past this fail with a
+	// *QuotaError, before anything is committed, so the application can
+	// take a snapshot and compact the log rather than let the file fill
+	// the disk. Appends that fit in the space freed by deleting logs are
+	// always allowed, and deletes, compaction and the stable store aren't
+	// limited. Zero disables the quota.
+	MaxSizeBytes int64
+
+	// HealthPolicy makes the store track how long commits to the log
+	// file take, and report when they're slow enough to suggest a failing
+	// disk through Health. Health always reports HealthOK if it's nil.
+	HealthPolicy *HealthPolicy
+
+	// WriteLimiter paces ImportLogs, TrimPrefixAsync and the
+	// RetentionPolicy, so they leave the disk to raft's own writes, see
+	// WriteLimiter. Bulk writes aren't paced if it's nil.
+	WriteLimiter *WriteLimiter
+
+	// Monotonic reports the store as a raft.MonotonicLogStore, telling raft
+	// that the log must not have gaps. Raft then deletes the whole log
+	// with DeleteRange after restoring a user snapshot, rather than
+	// leaving a gap before the entries that follow it, which keeps
+	// FirstIndex meaningful but means that DeleteRange may be called on a
+	// very large range.
+	Monotonic bool
+
+	// StrictAppend makes StoreLogs check that each batch is contiguous,
+	// starts no later than the entry after the log's last index, and
+	// never goes back a term, returning ErrNonContiguous otherwise. Raft
+	// leaves a gap in the log after restoring a snapshot unless the store
+	// is Monotonic, so the two should be set together.
+	StrictAppend bool
+
+	// RejectConflictingOverwrites makes StoreLogs return
+	// ErrConflictingOverwrite instead of replacing an entry with a
+	// different one from the same term. Either way, replaced entries are
+	// counted in the overwrite metrics.
+	RejectConflictingOverwrites bool
+
+	// TermIndex keeps an index from each term to the range of log indexes
+	// holding its entries, updated in the same transactions as the log,
+	// so TermRange and Terms can answer without scanning the log. It's
+	// built when the store is opened with it set, and removed when the
+	// store is opened without it, as it would otherwise go stale.
+	TermIndex bool
+
+	// Checksums stores a CRC32C checksum with each log entry written, and
+	// checks it whenever the entry is read, so corruption on disk comes
+	// back as an error wrapping ErrLogCorrupt and ErrChecksumMismatch
+	// rather than as a silently wrong entry. Entries written without it
+	// are still read, just without the check, so it can be turned on for
+	// an existing store. VerifyAll checks every entry at once.
+	Checksums bool
+
+	// Compression compresses log entries before they're stored, and
+	// decompresses them when they're read. Entries smaller than
+	// CompressionThreshold, or that don't get any smaller, are stored as
+	// they are. Each entry records how it was stored, so this can be
+	// changed on an existing store, but files holding compressed entries
+	// can't be read by versions of this package without compression.
+	// Defaults to CompressionNone.
+	Compression Compression
+
+	// CompressionThreshold is the size in bytes, once encoded, of the
+	// smallest entries that are compressed. Defaults to 256.
+	CompressionThreshold int
+
+	// OverflowThreshold stores the Data of log entries of at least this
+	// many bytes outside the logs bucket, split into chunks that each fit
+	// in a page, so very large entries don't need huge contiguous page
+	// allocations. Entries are read back whole, and the option can be
+	// changed on an existing store, though files holding overflowed
+	// entries can't be read by versions of this package without it. Data
+	// stored this way isn't compressed or covered by checksums. Zero, the
+	// default, disables it.
+	OverflowThreshold int
+
+	// ParallelEncodeBytes encodes the entries of StoreLogs batches whose
+	// Data adds up to at least this many bytes on up to GOMAXPROCS
+	// goroutines, before writing them all in one transaction. Encoding
+	// takes most of the CPU time of the multi-megabyte batches written
+	// while a follower catches up, so 1 MiB is a reasonable value. Zero,
+	// the default, encodes every batch on the calling goroutine.
+	ParallelEncodeBytes int
+
+	// Wrapper turns on encryption at rest. Log entries, including any
+	// overflowed data, and stable store values are encrypted with AES-GCM
+	// under a data key that's generated when encryption is turned on, and
+	// stored in the file wrapped by Wrapper, which is typically backed by
+	// a KMS. Keys, and the values the store keeps its own bookkeeping
+	// under, aren't encrypted. Encryption can be turned on for an existing
+	// store, which encrypts its stable store values straight away and
+	// log entries as they're written, but can't be turned off. An
+	// encrypted store can't be opened without its Wrapper.
+	Wrapper Wrapper
+
+	// EncryptConfOnly limits encryption to the stable store values, such
+	// as raft's current term and vote, and leaves log entries written
+	// from then on unencrypted, for when only identity and vote material
+	// needs protecting and the cost of encrypting every entry isn't
+	// wanted. Entries already encrypted are still read. Requires Wrapper.
+	EncryptConfOnly bool
+
+	// CryptoProvider supplies the AES-GCM implementation used when
+	// Wrapper is set, for builds that must use a particular validated
+	// module. Defaults to the standard library's.
+	CryptoProvider CryptoProvider
+
+	// MsgpackUseNewTimeFormat when set to true, force the underlying msgpack
+	// codec to use the new format of time.Time when encoding (used in
+	// go-msgpack v1.1.5 by default). Decoding is not affected, as all
+	// go-msgpack v2.1.0+ decoders know how to decode both formats.
+	MsgpackUseNewTimeFormat bool
+
+	// AutoMigrate converts a file written by the v1 store, which uses
+	// boltdb/bolt, when it's opened, rather than requiring MigrateToV2 to
+	// be run beforehand with a second path. The file is copied with
+	// MigrateToV2 into a sibling file that then atomically replaces it.
+	// Files are recognised as v1 if they've never been opened with
+	// AutoMigrate, so turning it on for a file this package has already
+	// written copies that once too, which is harmless. Files using any
+	// format version 2 feature are never copied, and files holding
+	// buckets of their own make New fail rather than lose them, see
+	// MigrateOptions.ExtraBuckets. Ignored if ReadOnly is set.
+	AutoMigrate bool
+
+	// ReadOnly opens the database with a shared lock and without
+	// creating any buckets. All methods that would modify the store
+	// return ErrReadOnly.
+	ReadOnly bool
+
+	// FileMode is used for the database file if it has to be created.
+	// Defaults to 0600.
+	FileMode os.FileMode
+
+	// CreateDir creates any missing parent directories of Path before
+	// opening the database, using DirMode for their permissions.
+	CreateDir bool
+
+	// DirMode is used for directories created because of CreateDir.
+	// Defaults to 0700.
+	DirMode os.FileMode
+
+	// CheckOnOpen runs the same integrity check as Verify when the store
+	// is opened, covering the Bbolt page structure and every entry in the
+	// log. If any problems are found New fails with a *VerifyError
+	// describing them. This reads the entire file, so can be slow for
+	// large logs.
+	CheckOnOpen bool
+
+	// MetricsPrefix replaces the default "raft.boltdb" prefix used for
+	// all metric names, e.g. to tell apart several stores in one process.
+	MetricsPrefix []string
+
+	// MetricSink receives the store's metrics. Defaults to the global
+	// go-metrics instance.
+	MetricSink metrics.MetricSink
+
+	// TracerProvider enables OpenTelemetry spans around StoreLogs,
+	// GetLog, DeleteRange and Set. Tracing is disabled if it's nil.
+	TracerProvider trace.TracerProvider
+
+	// Logger receives warnings about slow transactions. Nothing is logged
+	// if it's nil.
+	Logger hclog.Logger
+
+	// SlowOpThreshold is how long a StoreLogs or DeleteRange transaction
+	// can take before a warning is logged, including the bytes written
+	// and the size of the freelist. Defaults to 100ms.
+	SlowOpThreshold time.Duration
+
+	// Hooks are called after store operations with timing and size
+	// information.
+	Hooks Hooks
+
+	// ReadAhead is the number of entries GetLog prefetches, in the same
+	// transaction, once it sees consecutive indexes being read, e.g. by
+	// a follower catching up. Prefetched entries are kept in an LRU cache
+	// holding twice this many entries, unless CacheSize is set. Zero
+	// disables read-ahead.
+	ReadAhead int
+
+	// CacheSize keeps up to this many recently stored and read entries in
+	// memory, so they can be returned by GetLog without a transaction.
+	// Unlike wrapping the store with raft.NewLogCache, entries removed or
+	// overwritten by DeleteRange and StoreLogs are always dropped from the
+	// cache. Entries returned from the cache share their Data with it, so
+	// must not be modified, and nor must the Data of entries passed to
+	// StoreLogs once it returns. Zero disables the cache.
+	CacheSize int
+
+	// LockTimeout is the amount of time to wait to obtain the file lock
+	// when opening the database. Zero waits indefinitely. If the lock
+	// can't be acquired in time New returns an ErrDatabaseLocked error.
+	LockTimeout time.Duration
+
+	// NoFreelistSync skips writing the freelist to disk on every commit.
+	// This greatly reduces write amplification for large raft logs, at
+	// the cost of a full freelist rebuild when the file is opened.
+	NoFreelistSync bool
+
+	// FreelistType sets the freelist backend. Defaults to FreelistMap,
+	// unlike Bbolt, unless BoltOptions sets one. It only affects how free
+	// pages are tracked in memory, so files can be opened with either.
+	FreelistType FreelistType
+
+	// InitialMmapSize is the initial size of the memory map in bytes.
+	// Setting this larger than the expected database size stops read
+	// transactions from blocking writers while the file is remapped.
+	InitialMmapSize int
+
+	// InitialSizeBytes pre-grows a new file to this size, and makes the
+	// memory map at least this large, so large deployments don't pause
+	// while the file is remapped as it grows, see BoltStore.Grow. Once
+	// the file is full, Bbolt extends it in its usual steps. Zero leaves
+	// the file to grow as it's written.
+	InitialSizeBytes int64
+
+	// PageSize overrides the page size used when creating a new
+	// database. It must be a power of two, and is ignored for
+	// existing files. Defaults to the OS page size.
+	PageSize int
+
+	// MmapFlags are passed through to mmap(2) when mapping the file,
+	// e.g. syscall.MAP_POPULATE on Linux.
+	MmapFlags int
+}
+
+// readOnly returns true if the options say to open the DB in
+// readOnly mode [this can be useful to tools that want to examine
+// the log]
+func (o *Options) readOnly() bool {
+	if o == nil {
+		return false
+	}
+	return o.ReadOnly || (o.BoltOptions != nil && o.BoltOptions.ReadOnly)
+}
+
+// fileMode returns the permissions to create the database file with.
+func (o *Options) fileMode() os.FileMode {
+	if o.FileMode == 0 {
+		return dbFileMode
+	}
+	return o.FileMode
+}
+
+// dirMode returns the permissions to create missing directories with.
+func (o *Options) dirMode() os.FileMode {
+	if o.DirMode == 0 {
+		return dbDirMode
+	}
+	return o.DirMode
+}
+
+// logger returns the logger to use, which discards everything if one
+// wasn't given.
+func (o *Options) logger() hclog.Logger {
+	if o.Logger == nil {
+		return hclog.NewNullLogger()
+	}
+	return o.Logger
+}
+
+// slowOpThreshold returns the duration after which a transaction is
+// considered slow.
+func (o *Options) slowOpThreshold() time.Duration {
+	if o.SlowOpThreshold == 0 {
+		return defaultSlowOpThreshold
+	}
+	return o.SlowOpThreshold
+}
+
+// logsFillPercent returns the fill percent to use for the logs bucket.
+func (o *Options) logsFillPercent() float64 {
+	if o.LogsFillPercent == 0 {
+		return defaultLogsFillPercent
+	}
+	return o.LogsFillPercent
+}
+
+// trimChunkSize returns the number of entries to delete per transaction
+// when trimming.
+func (o *Options) trimChunkSize() int {
+	if o.TrimChunkSize == 0 {
+		return defaultTrimChunkSize
+	}
+	return o.TrimChunkSize
+}
+
+// trimPause returns the pause between transactions when trimming.
+func (o *Options) trimPause() time.Duration {
+	if o.TrimPause == 0 {
+		return defaultTrimPause
+	}
+	return o.TrimPause
+}
+
+// cryptoProvider returns the AES-GCM implementation to encrypt with.
+func (o *Options) cryptoProvider() CryptoProvider {
+	if o.CryptoProvider == nil {
+		return stdCrypto{}
+	}
+	return o.CryptoProvider
+}
+
+// compressionThreshold returns the size of the smallest entries that
+// are compressed.
+func (o *Options) compressionThreshold() int {
+	if o.CompressionThreshold == 0 {
+		return defaultCompressionThreshold
+	}
+	return o.CompressionThreshold
+}
+
+// validate checks the first-class fields for values Bbolt would
+// either reject or silently misbehave with.
+func (o *Options) validate() error {
+	if o.FileMode&^os.ModePerm != 0 {
+		return fmt.Errorf("%w: FileMode %v must only contain permission bits", ErrInvalidOptions, o.FileMode)
+	}
+	if o.DirMode&^os.ModePerm != 0 {
+		return fmt.Errorf("%w: DirMode %v must only contain permission bits", ErrInvalidOptions, o.DirMode)
+	}
+	if o.SyncPolicy.group && o.SyncPolicy.interval <= 0 {
+		return fmt.Errorf("%w: SyncInterval must be positive", ErrInvalidOptions)
+	}
+	if o.SyncPolicy.group && o.MaxBatchDelay != 0 {
+		return fmt.Errorf("%w: MaxBatchDelay can't be combined with SyncInterval, which sets it", ErrInvalidOptions)
+	}
+	if o.NoSync && o.SyncPolicy != SyncEveryWrite {
+		return fmt.Errorf("%w: NoSync can't be combined with SyncPolicy %s", ErrInvalidOptions, o.SyncPolicy)
+	}
+	if o.MaxBatchSize < 0 {
+		return fmt.Errorf("%w: MaxBatchSize must not be negative", ErrInvalidOptions)
+	}
+	if o.MaxBatchDelay < 0 {
+		return fmt.Errorf("%w: MaxBatchDelay must not be negative", ErrInvalidOptions)
+	}
+	if o.LogsFillPercent != 0 && (o.LogsFillPercent < minFillPercent || o.LogsFillPercent > maxFillPercent) {
+		return fmt.Errorf("%w: LogsFillPercent %v must be between %v and %v",
+			ErrInvalidOptions, o.LogsFillPercent, minFillPercent, maxFillPercent)
+	}
+	if o.TrimChunkSize < 0 {
+		return fmt.Errorf("%w: TrimChunkSize must not be negative", ErrInvalidOptions)
+	}
+	if o.TrimPause < 0 {
+		return fmt.Errorf("%w: TrimPause must not be negative", ErrInvalidOptions)
+	}
+	if o.LogSegmentSize < 0 {
+		return fmt.Errorf("%w: LogSegmentSize must not be negative", ErrInvalidOptions)
+	}
+	if a := o.AutoCompact; a != nil {
+		if a.FreelistBytesThreshold == 0 && a.FileGrowthRatio == 0 {
+			return fmt.Errorf("%w: AutoCompact needs a threshold", ErrInvalidOptions)
+		}
+		if a.FreelistBytesThreshold < 0 {
+			return fmt.Errorf("%w: AutoCompact.FreelistBytesThreshold must not be negative", ErrInvalidOptions)
+		}
+		if a.FileGrowthRatio != 0 && a.FileGrowthRatio <= 1 {
+			return fmt.Errorf("%w: AutoCompact.FileGrowthRatio must be more than 1", ErrInvalidOptions)
+		}
+		if a.CheckInterval < 0 {
+			return fmt.Errorf("%w: AutoCompact.CheckInterval must not be negative", ErrInvalidOptions)
+		}
+	}
+	if p := o.RetentionPolicy; p != nil {
+		if p.KeepLast == 0 && p.MaxAge == 0 {
+			return fmt.Errorf("%w: RetentionPolicy needs a rule", ErrInvalidOptions)
+		}
+		if p.MaxAge < 0 {
+			return fmt.Errorf("%w: RetentionPolicy.MaxAge must not be negative", ErrInvalidOptions)
+		}
+		if p.CheckInterval < 0 {
+			return fmt.Errorf("%w: RetentionPolicy.CheckInterval must not be negative", ErrInvalidOptions)
+		}
+	}
+	if o.MaxSizeBytes < 0 {
+		return fmt.Errorf("%w: MaxSizeBytes must not be negative", ErrInvalidOptions)
+	}
+	if l := o.WriteLimiter; l != nil {
+		if !(l.EntriesPerSecond > 0) || math.IsInf(l.EntriesPerSecond, 1) {
+			return fmt.Errorf("%w: WriteLimiter.EntriesPerSecond must be positive", ErrInvalidOptions)
+		}
+		if l.Burst < 0 {
+			return fmt.Errorf("%w: WriteLimiter.Burst must not be negative", ErrInvalidOptions)
+		}
+	}
+	if p := o.HealthPolicy; p != nil {
+		if p.DegradedP99 <= 0 && p.FailedP99 <= 0 {
+			return fmt.Errorf("%w: HealthPolicy must set DegradedP99 or FailedP99", ErrInvalidOptions)
+		}
+		if p.DegradedP99 < 0 || p.FailedP99 < 0 || p.Window < 0 || p.CheckInterval < 0 {
+			return fmt.Errorf("%w: HealthPolicy values must not be negative", ErrInvalidOptions)
+		}
+		if p.DegradedP99 > 0 && p.FailedP99 > 0 && p.FailedP99 < p.DegradedP99 {
+			return fmt.Errorf("%w: HealthPolicy.FailedP99 must not be less than DegradedP99", ErrInvalidOptions)
+		}
+	}
+	if w := o.ReadTxWatchdog; w != nil {
+		if w.MaxAge <= 0 {
+			return fmt.Errorf("%w: ReadTxWatchdog.MaxAge must be positive", ErrInvalidOptions)
+		}
+		if w.CheckInterval < 0 {
+			return fmt.Errorf("%w: ReadTxWatchdog.CheckInterval must not be negative", ErrInvalidOptions)
+		}
+	}
+	if o.LockTimeout < 0 {
+		return fmt.Errorf("%w: LockTimeout must not be negative", ErrInvalidOptions)
+	}
+	if o.StablePath != "" {
+		if filepath.Clean(o.StablePath) == filepath.Clean(o.Path) {
+			return fmt.Errorf("%w: StablePath must not be the same file as Path", ErrInvalidOptions)
+		}
+		if o.Wrapper != nil {
+			return fmt.Errorf("%w: StablePath can't be combined with Wrapper", ErrInvalidOptions)
+		}
+	}
+	switch o.FreelistType {
+	case "", FreelistArray, FreelistMap:
+	default:
+		return fmt.Errorf("%w: unknown FreelistType %q", ErrInvalidOptions, o.FreelistType)
+	}
+	switch o.Compression {
+	case "", CompressionNone, CompressionSnappy, CompressionZstd:
+	default:
+		return fmt.Errorf("%w: unknown Compression %q", ErrInvalidOptions, o.Compression)
+	}
+	if o.CompressionThreshold < 0 {
+		return fmt.Errorf("%w: CompressionThreshold must not be negative", ErrInvalidOptions)
+	}
+	if o.EncryptConfOnly && o.Wrapper == nil {
+		return fmt.Errorf("%w: EncryptConfOnly requires a Wrapper", ErrInvalidOptions)
+	}
+	if o.ParallelEncodeBytes < 0 {
+		return fmt.Errorf("%w: ParallelEncodeBytes must not be negative", ErrInvalidOptions)
+	}
+	if o.OverflowThreshold < 0 {
+		return fmt.Errorf("%w: OverflowThreshold must not be negative", ErrInvalidOptions)
+	}
+	if o.SlowOpThreshold < 0 {
+		return fmt.Errorf("%w: SlowOpThreshold must not be negative", ErrInvalidOptions)
+	}
+	if o.ReadAhead < 0 {
+		return fmt.Errorf("%w: ReadAhead must not be negative", ErrInvalidOptions)
+	}
+	if o.CacheSize < 0 {
+		return fmt.Errorf("%w: CacheSize must not be negative", ErrInvalidOptions)
+	}
+	if o.InitialMmapSize < 0 {
+		return fmt.Errorf("%w: InitialMmapSize must not be negative", ErrInvalidOptions)
+	}
+	if o.InitialSizeBytes < 0 {
+		return fmt.Errorf("%w: InitialSizeBytes must not be negative", ErrInvalidOptions)
+	}
+	if o.PageSize < 0 || o.PageSize&(o.PageSize-1) != 0 {
+		return fmt.Errorf("%w: PageSize %d is not a power of two", ErrInvalidOptions, o.PageSize)
+	}
+	return nil
+}
+
+// boltOptions merges the first-class fields over BoltOptions, returning
+// a fresh copy that is safe to hand to bbolt.Open.
+func (o *Options) boltOptions() *bbolt.Options {
+	opts := *bbolt.DefaultOptions
+	opts.FreelistType = ""
+	if o.BoltOptions != nil {
+		opts = *o.BoltOptions
+	}
+	if opts.FreelistType == "" {
+		opts.FreelistType = bbolt.FreelistMapType
+	}
+
+	if o.ReadOnly {
+		opts.ReadOnly = true
+	}
+	if o.LockTimeout != 0 {
+		opts.Timeout = o.LockTimeout
+	}
+	if o.NoFreelistSync {
+		opts.NoFreelistSync = true
+	}
+	if o.FreelistType != "" {
+		opts.FreelistType = bbolt.FreelistType(o.FreelistType)
+	}
+	if o.InitialMmapSize != 0 {
+		opts.InitialMmapSize = o.InitialMmapSize
+	}
+	if int(o.InitialSizeBytes) > opts.InitialMmapSize {
+		opts.InitialMmapSize = int(o.InitialSizeBytes)
+	}
+	if o.PageSize != 0 {
+		opts.PageSize = o.PageSize
+	}
+	if o.MmapFlags != 0 {
+		opts.MmapFlags = o.MmapFlags
+	}
+	return &opts
+}
@@ -0,0 +1,191 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestOptions_Validate(t *testing.T) {
+	cases := []struct {
+		name    string
+		options Options
+		valid   bool
+	}{
+		{"defaults", Options{}, true},
+		{"all set", Options{LockTimeout: time.Second, FreelistType: FreelistMap, InitialMmapSize: 1 << 20, PageSize: 8192}, true},
+		{"file and dir modes", Options{FileMode: 0640, CreateDir: true, DirMode: 0750}, true},
+		{"non-permission file mode bits", Options{FileMode: os.ModeSetuid | 0600}, false},
+		{"non-permission dir mode bits", Options{DirMode: os.ModeDir | 0700}, false},
+		{"negative timeout", Options{LockTimeout: -1}, false},
+		{"unknown freelist", Options{FreelistType: "tree"}, false},
+		{"negative slow op threshold", Options{SlowOpThreshold: -1}, false},
+		{"negative read ahead", Options{ReadAhead: -1}, false},
+		{"negative trim chunk size", Options{TrimChunkSize: -1}, false},
+		{"negative trim pause", Options{TrimPause: -1}, false},
+		{"negative segment size", Options{LogSegmentSize: -1}, false},
+		{"auto compact without thresholds", Options{AutoCompact: &AutoCompact{}}, false},
+		{"auto compact shrinking", Options{AutoCompact: &AutoCompact{FileGrowthRatio: 0.5}}, false},
+		{"auto compact negative interval", Options{AutoCompact: &AutoCompact{FreelistBytesThreshold: 1, CheckInterval: -1}}, false},
+		{"auto compact", Options{AutoCompact: &AutoCompact{FileGrowthRatio: 2}}, true},
+		{"retention without rules", Options{RetentionPolicy: &RetentionPolicy{}}, false},
+		{"retention negative age", Options{RetentionPolicy: &RetentionPolicy{MaxAge: -1}}, false},
+		{"retention negative interval", Options{RetentionPolicy: &RetentionPolicy{KeepLast: 1, CheckInterval: -1}}, false},
+		{"retention", Options{RetentionPolicy: &RetentionPolicy{KeepLast: 1000, MaxAge: time.Hour}}, true},
+		{"unknown compression", Options{Compression: "lz4"}, false},
+		{"negative compression threshold", Options{Compression: CompressionZstd, CompressionThreshold: -1}, false},
+		{"compression", Options{Compression: CompressionSnappy, CompressionThreshold: 1024}, true},
+		{"negative overflow threshold", Options{OverflowThreshold: -1}, false},
+		{"conf encryption without wrapper", Options{EncryptConfOnly: true}, false},
+		{"negative cache size", Options{CacheSize: -1}, false},
+		{"negative batch size", Options{MaxBatchSize: -1}, false},
+		{"negative batch delay", Options{MaxBatchDelay: -1}, false},
+		{"fill percent too low", Options{LogsFillPercent: 0.05}, false},
+		{"fill percent too high", Options{LogsFillPercent: 1.5}, false},
+		{"negative mmap size", Options{InitialMmapSize: -1}, false},
+		{"odd page size", Options{PageSize: 5000}, false},
+		{"negative page size", Options{PageSize: -4096}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.options.validate()
+			if tc.valid && err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if !tc.valid && !errors.Is(err, ErrInvalidOptions) {
+				t.Fatalf("expected invalid options error, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestOptions_BoltOptions(t *testing.T) {
+	// First-class fields take precedence over BoltOptions
+	options := Options{
+		BoltOptions: &bbolt.Options{
+			Timeout:  time.Minute,
+			ReadOnly: true,
+		},
+		LockTimeout:    time.Second,
+		NoFreelistSync: true,
+		FreelistType:   FreelistMap,
+		PageSize:       8192,
+	}
+	opts := options.boltOptions()
+	if opts.Timeout != time.Second {
+		t.Fatalf("bad: %v", opts.Timeout)
+	}
+	if !opts.ReadOnly || !opts.NoFreelistSync {
+		t.Fatalf("bad: %#v", opts)
+	}
+	if opts.FreelistType != bbolt.FreelistMapType || opts.PageSize != 8192 {
+		t.Fatalf("bad: %#v", opts)
+	}
+
+	// The caller's BoltOptions must not be modified
+	if options.BoltOptions.Timeout != time.Minute {
+		t.Fatalf("BoltOptions was modified: %#v", options.BoltOptions)
+	}
+
+	// With nothing set we should get the Bbolt defaults, apart from the
+	// freelist, unless BoltOptions chooses one
+	opts = (&Options{}).boltOptions()
+	if opts.FreelistType != bbolt.FreelistMapType || opts.Timeout != 0 {
+		t.Fatalf("bad: %#v", opts)
+	}
+	opts = (&Options{BoltOptions: &bbolt.Options{FreelistType: bbolt.FreelistArrayType}}).boltOptions()
+	if opts.FreelistType != bbolt.FreelistArrayType {
+		t.Fatalf("bad: %#v", opts)
+	}
+}
+
+func TestNew_FirstClassOptions(t *testing.T) {
+	fh, err := ioutil.TempFile("", "bolt")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	os.Remove(fh.Name())
+	defer os.Remove(fh.Name())
+
+	store, err := New(Options{
+		Path:           fh.Name(),
+		NoFreelistSync: true,
+		FreelistType:   FreelistMap,
+		PageSize:       8192,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	if store.conn.FreelistType != bbolt.FreelistMapType {
+		t.Fatalf("bad: %v", store.conn.FreelistType)
+	}
+	if !store.conn.NoFreelistSync {
+		t.Fatalf("expected NoFreelistSync to be set")
+	}
+	if size := store.conn.Info().PageSize; size != 8192 {
+		t.Fatalf("bad: %d", size)
+	}
+
+	// Invalid options fail before the file is touched
+	if _, err := New(Options{Path: fh.Name() + ".bad", PageSize: 3}); !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("expected invalid options error, got: %v", err)
+	}
+	if _, err := os.Stat(fh.Name() + ".bad"); !os.IsNotExist(err) {
+		t.Fatalf("expected no file to be created, got: %v", err)
+	}
+}
+
+func TestNew_FileModeAndCreateDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "nested", "raft", "raft.db")
+
+	// Without CreateDir a missing parent is an error
+	if _, err := New(Options{Path: path}); !os.IsNotExist(err) {
+		t.Fatalf("expected not exist error, got: %v", err)
+	}
+
+	store, err := New(Options{
+		Path:      path,
+		FileMode:  0640,
+		CreateDir: true,
+		DirMode:   0750,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	// Modes are subject to the umask, so just make sure nothing was
+	// granted beyond what we asked for
+	if fi.Mode().Perm()&^0640 != 0 {
+		t.Fatalf("bad file mode: %v", fi.Mode().Perm())
+	}
+	for _, d := range []string{filepath.Join(dir, "nested"), filepath.Dir(path)} {
+		fi, err := os.Stat(d)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if !fi.IsDir() || fi.Mode().Perm()&^0750 != 0 {
+			t.Fatalf("bad dir mode for %s: %v", d, fi.Mode().Perm())
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/hashicorp/raft"
+)
+
+var (
+	// dbOverflow holds the data of log entries too large to be stored
+	// in the logs bucket, see Options.OverflowThreshold. Each entry's data
+	// is split into chunks keyed by its index followed by the chunk's
+	// 4-byte sequence number.
+	dbOverflow = []byte("overflow")
+)
+
+const (
+	// overflowPageOverhead is left out of each chunk so it fits in a
+	// page with its key and Bbolt's headers.
+	overflowPageOverhead = 64
+)
+
+// overflowKey returns the key of a chunk of the data of idx, backed by
+// the encoder's key buffer.
+func (e *logEncoder) overflowKey(idx uint64, chunk uint32) []byte {
+	start := len(e.keys)
+	e.keys = binary.BigEndian.AppendUint64(e.keys, idx)
+	e.keys = binary.BigEndian.AppendUint32(e.keys, chunk)
+	return e.keys[start:len(e.keys):len(e.keys)]
+}
+
+// overflowAAD returns the additional data the overflow data of idx is
+// encrypted with, which is the key of its first chunk. The data is
+// encrypted as a whole before it's split into chunks, so none of them can
+// be moved to another entry, reordered or dropped, and it can't be
+// mistaken for an entry's value, which is bound to the 8-byte key alone.
+func overflowAAD(idx uint64) []byte {
+	return binary.BigEndian.AppendUint32(uint64ToBytes(idx), 0)
+}
+
+// putOverflow stores data as the overflow data of idx. Like put, data
+// must remain valid for the life of the transaction.
+func (l *logBucket) putOverflow(enc *logEncoder, idx uint64, data []byte) error {
+	for chunk := uint32(0); len(data) > 0; chunk++ {
+		n := min(len(data), l.overflowChunkSize)
+		if err := l.overflow.Put(enc.overflowKey(idx, chunk), data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// getOverflow returns a copy of the overflow data of idx.
+func (l *logBucket) getOverflow(idx uint64) ([]byte, error) {
+	if l.overflow == nil {
+		return nil, fmt.Errorf("overflow bucket does not exist")
+	}
+	prefix := uint64ToBytes(idx)
+	var data []byte
+	next := uint32(0)
+	curs := l.overflow.Cursor()
+	for k, v := curs.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = curs.Next() {
+		if len(k) != len(prefix)+4 || binary.BigEndian.Uint32(k[len(prefix):]) != next {
+			return nil, fmt.Errorf("overflow chunk %d is missing", next)
+		}
+		data = append(data, v...)
+		next++
+	}
+	if next == 0 {
+		return nil, fmt.Errorf("overflow data is missing")
+	}
+	return data, nil
+}
+
+// deleteOverflow deletes the overflow data of the entries from min to
+// max inclusive. Each delete is followed by a Seek, as Bbolt's Next can
+// skip entries after a delete.
+func (l *logBucket) deleteOverflow(min, max uint64) error {
+	if l.overflow == nil {
+		return nil
+	}
+	start := uint64ToBytes(min)
+	curs := l.overflow.Cursor()
+	for k, _ := curs.Seek(start); k != nil && bytesToUint64(k[:8]) <= max; k, _ = curs.Seek(start) {
+		if err := curs.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decode decodes the entry val stored under idx into log, reading its
+// data from the overflow bucket if it's stored there.
+func (l *logBucket) decode(idx uint64, val []byte, log *raft.Log) error {
+	if err := decodeLog(idx, val, l.keys, log); err != nil {
+		return err
+	}
+	flags := entryFlags(val)
+	if flags&entryOverflow == 0 {
+		return nil
+	}
+	data, err := l.getOverflow(idx)
+	if err != nil {
+		return err
+	}
+	if flags&entryEncrypted != 0 {
+		if data, err = l.keys.open(data, overflowAAD(idx)); err != nil {
+			return err
+		}
+	}
+	log.Data = data
+	return nil
+}
@@ -0,0 +1,118 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"go.etcd.io/bbolt"
+)
+
+// overflowChunks returns the number of overflow chunks stored for each
+// index.
+func overflowChunks(t *testing.T, store *BoltStore) map[uint64]int {
+	t.Helper()
+	chunks := make(map[uint64]int)
+	err := store.conn.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(dbOverflow)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			chunks[bytesToUint64(k[:8])]++
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return chunks
+}
+
+func TestBoltStore_Overflow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "raft.db")
+	store, err := New(Options{Path: path, OverflowThreshold: 1024, Checksums: true, LogSegmentSize: 8})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	large := string(bytes.Repeat([]byte("0123456789"), 1000))
+	logs := []*raft.Log{
+		testRaftLog(1, "small"),
+		testRaftLog(2, large),
+		testRaftLog(3, large[:1024]),
+		testRaftLog(4, "small"),
+		testRaftLog(5, large),
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	perChunk := store.overflowChunkSize
+	full := (len(large) + perChunk - 1) / perChunk
+	expected := map[uint64]int{2: full, 3: 1, 5: full}
+	if chunks := overflowChunks(t, store); !reflect.DeepEqual(chunks, expected) {
+		t.Fatalf("bad: %v", chunks)
+	}
+
+	for _, expected := range logs {
+		var log raft.Log
+		if err := store.GetLog(expected.Index, &log); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if !reflect.DeepEqual(&log, expected) {
+			t.Fatalf("bad: %d", expected.Index)
+		}
+	}
+	got, err := store.GetLogs(1, 5, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(got, logs) {
+		t.Fatalf("bad: %v", got)
+	}
+
+	// Overwriting an entry drops its old chunks, and deleting a range
+	// drops all of them
+	if err := store.StoreLogs([]*raft.Log{testRaftLog(2, "small"), testRaftLog(3, large)}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected = map[uint64]int{3: full, 5: full}
+	if chunks := overflowChunks(t, store); !reflect.DeepEqual(chunks, expected) {
+		t.Fatalf("bad: %v", chunks)
+	}
+	if err := store.DeleteRange(4, 5); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected = map[uint64]int{3: full}
+	if chunks := overflowChunks(t, store); !reflect.DeepEqual(chunks, expected) {
+		t.Fatalf("bad: %v", chunks)
+	}
+
+	// Missing chunks are reported like any other corruption
+	err = store.conn.Update(func(tx *bbolt.Tx) error {
+		curs := tx.Bucket(dbOverflow).Cursor()
+		curs.First()
+		return curs.Delete()
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	var log raft.Log
+	if err := store.GetLog(3, &log); err == nil {
+		t.Fatalf("expected error")
+	}
+	report, err := store.VerifyAll()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(report.Problems) != 1 || report.Problems[0].Kind != ProblemDecode || report.Problems[0].Index != 3 {
+		t.Fatalf("bad: %v", report.Problems)
+	}
+}
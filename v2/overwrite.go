@@ -0,0 +1,79 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/raft"
+	"go.etcd.io/bbolt"
+)
+
+var (
+	// ErrConflictingOverwrite is returned by StoreLogs when
+	// Options.RejectConflictingOverwrites is set and an entry would be
+	// replaced by a different one with the same term. Raft only ever
+	// replaces entries from an earlier term, so this means the log or the
+	// caller is broken. Nothing in the batch is written.
+	ErrConflictingOverwrite = errors.New("conflicting overwrite of log entry with the same term")
+)
+
+// checkOverwrites compares the entries in logs with the ones they'd
+// replace in bucket, counting those being replaced from a different term
+// and those that conflict. Conflicts are returned as an error if
+// Options.RejectConflictingOverwrites was set. Otherwise they're counted
+// and logged once tx commits, as Batch may run the write more than once.
+func (b *BoltStore) checkOverwrites(tx *bbolt.Tx, bucket *logBucket, logs []*raft.Log) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	lastKey, _ := bucket.cursor().Last()
+	if lastKey == nil || logs[0].Index > bytesToUint64(lastKey) {
+		return nil
+	}
+
+	replaced, conflicts := 0, 0
+	var existing raft.Log
+	for _, log := range logs {
+		val := bucket.get(log.Index)
+		if val == nil {
+			continue
+		}
+		existing = raft.Log{}
+		if err := bucket.decode(log.Index, val, &existing); err != nil {
+			return fmt.Errorf("%w at index %d: %w", ErrLogCorrupt, log.Index, err)
+		}
+
+		switch {
+		case existing.Term != log.Term:
+			replaced++
+		case existing.Type != log.Type || !bytes.Equal(existing.Data, log.Data) ||
+			!bytes.Equal(existing.Extensions, log.Extensions):
+			if b.rejectConflicts {
+				return fmt.Errorf("%w at index %d, term %d", ErrConflictingOverwrite, log.Index, log.Term)
+			}
+			conflicts++
+		}
+	}
+
+	if replaced == 0 && conflicts == 0 {
+		return nil
+	}
+	first := logs[0].Index
+	tx.OnCommit(func() {
+		if replaced > 0 {
+			b.metrics.incrCounter([]string{"overwrite"}, float32(replaced))
+			b.logger.Info("replaced log entries from an earlier term",
+				"path", b.path, "first_index", first, "entries", replaced)
+		}
+		if conflicts > 0 {
+			b.metrics.incrCounter([]string{"overwrite", "conflict"}, float32(conflicts))
+			b.logger.Error("replaced log entries with different ones from the same term",
+				"path", b.path, "first_index", first, "entries", conflicts)
+		}
+	})
+	return nil
+}
@@ -0,0 +1,86 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/raft"
+)
+
+func TestBoltStore_Overwrite(t *testing.T) {
+	for _, reject := range []bool{false, true} {
+		sink := metrics.NewInmemSink(time.Minute, time.Minute)
+		store, err := New(Options{
+			Path:                        filepath.Join(t.TempDir(), "raft.db"),
+			MetricSink:                  sink,
+			RejectConflictingOverwrites: reject,
+		})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		defer store.Close()
+
+		log := func(idx, term uint64, data string) *raft.Log {
+			log := testRaftLog(idx, data)
+			log.Term = term
+			return log
+		}
+		if err := store.StoreLogs([]*raft.Log{log(1, 1, "a"), log(2, 1, "b"), log(3, 1, "c")}); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		// Rewriting the same entries or replacing them from a later term
+		// is fine
+		if err := store.StoreLogs([]*raft.Log{log(3, 1, "c")}); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := store.StoreLogs([]*raft.Log{log(2, 2, "x"), log(3, 2, "y")}); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		err = store.StoreLogs([]*raft.Log{log(3, 2, "z")})
+		if reject != errors.Is(err, ErrConflictingOverwrite) {
+			t.Fatalf("bad: %v", err)
+		}
+		expected := "z"
+		if reject {
+			expected = "y"
+		}
+		var got raft.Log
+		if err := store.GetLog(3, &got); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if string(got.Data) != expected {
+			t.Fatalf("bad: %q, expected %q", got.Data, expected)
+		}
+
+		counters := sink.Data()[0].Counters
+		if c := counters["raft.boltdb.overwrite"]; c.Sum != 2 {
+			t.Fatalf("bad: %#v", c)
+		}
+		if c, ok := counters["raft.boltdb.overwrite.conflict"]; reject == ok {
+			t.Fatalf("bad: %#v", c)
+		}
+
+		// Overwrites that are rolled back aren't counted
+		errFailed := errors.New("failed")
+		err = store.Update(func(tx *StoreTx) error {
+			if err := tx.StoreLogs([]*raft.Log{log(3, 3, "w")}); err != nil {
+				return err
+			}
+			return errFailed
+		})
+		if err != errFailed {
+			t.Fatalf("expected failed error, got: %v", err)
+		}
+		if c := sink.Data()[0].Counters["raft.boltdb.overwrite"]; c.Sum != 2 {
+			t.Fatalf("bad: %#v", c)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+const (
+	// defaultPingTimeout is how long Ping waits if the context doesn't
+	// have a deadline.
+	defaultPingTimeout = 10 * time.Second
+)
+
+var (
+	// dbPingKey is the key in the conf bucket of the log file that Ping
+	// writes to. It's internal, so it isn't mistaken for a stable store
+	// key, and migrating or exporting the store leaves it behind.
+	dbPingKey = []byte("raftboltdb.ping")
+
+	// ErrPingTimeout is returned by Ping if the disk doesn't respond
+	// before the deadline.
+	ErrPingTimeout = errors.New("ping timed out")
+)
+
+// PingError is returned when Ping fails.
+type PingError struct {
+	// Path is the database file.
+	Path string
+
+	// ReadOnly is set if the ping only read the file, because the store
+	// was opened read-only.
+	ReadOnly bool
+
+	// Elapsed is how long the ping ran before it failed or timed out.
+	Elapsed time.Duration
+
+	// Err is why it failed, which wraps ErrPingTimeout and the context's
+	// error if it timed out.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *PingError) Error() string {
+	return fmt.Sprintf("ping of %s failed after %s: %v", e.Path, e.Elapsed, e.Err)
+}
+
+// Unwrap returns the reason the ping failed.
+func (e *PingError) Unwrap() error {
+	return e.Err
+}
+
+// pingCall is a ping that's running, which callers of Ping share.
+type pingCall struct {
+	done chan struct{}
+	err  error
+}
+
+// pinger holds the ping that's running, if any.
+type pinger struct {
+	lock sync.Mutex
+	call *pingCall
+}
+
+// Ping checks the disk holding the store is responding, for liveness
+// probes. It writes and syncs a tiny value in the log file, or only
+// reads the file if the store was opened read-only, and returns a
+// *PingError if that fails or doesn't finish before the context is done,
+// or within ten seconds if it has no deadline.
+//
+// A write to a hung volume can't be interrupted, so Ping returns while
+// it carries on in the background. Pings made while it's still running
+// wait for the same one rather than piling up behind it.
+func (b *BoltStore) Ping(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultPingTimeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	b.pinger.lock.Lock()
+	call := b.pinger.call
+	if call == nil {
+		call = &pingCall{done: make(chan struct{})}
+		b.pinger.call = call
+		go func() {
+			call.err = b.ping()
+			b.pinger.lock.Lock()
+			b.pinger.call = nil
+			b.pinger.lock.Unlock()
+			close(call.done)
+		}()
+	}
+	b.pinger.lock.Unlock()
+
+	var err error
+	select {
+	case <-call.done:
+		err = call.err
+	case <-ctx.Done():
+		err = fmt.Errorf("%w: %w", ErrPingTimeout, ctx.Err())
+	}
+	elapsed := time.Since(start)
+	b.metrics.measureSince([]string{"ping"}, start)
+	if err != nil {
+		b.logger.Warn("ping failed", "path", b.path, "elapsed", elapsed, "error", err)
+		return &PingError{Path: b.path, ReadOnly: b.readOnly, Elapsed: elapsed, Err: err}
+	}
+	return nil
+}
+
+// ping writes and syncs the time to the log file, or reads the file if
+// the store is read-only.
+func (b *BoltStore) ping() error {
+	if b.readOnly {
+		tx, err := b.begin(false)
+		if err != nil {
+			return err
+		}
+		tx.Rollback()
+		_, err = os.Stat(b.path)
+		return err
+	}
+
+	_, err := b.update("Ping", func(tx *bbolt.Tx) error {
+		bucket, err := b.bucket(tx, dbConf)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(dbPingKey, uint64ToBytes(uint64(time.Now().UnixNano())))
+	})
+	if err != nil {
+		return err
+	}
+
+	// The commit may not have synced, depending on the SyncPolicy
+	return b.Sync()
+}
@@ -0,0 +1,78 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBoltStore_Ping(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "raft.db")
+	store, err := New(Options{Path: path})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Ping(context.Background()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// A write that can't get the file times out, and later pings wait
+	// for it to finish
+	tx, err := store.begin(true)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = store.Ping(ctx)
+	var perr *PingError
+	if !errors.As(err, &perr) || perr.Path != path || perr.ReadOnly {
+		t.Fatalf("err: %v", err)
+	}
+	if !errors.Is(err, ErrPingTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err: %v", err)
+	}
+	tx.Rollback()
+	if err := store.Ping(context.Background()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	store.Close()
+	if err := store.Ping(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Fatalf("err: %v", err)
+	}
+
+	// A read-only store is only read
+	store, err = New(Options{Path: path, ReadOnly: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	if err := store.Ping(context.Background()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestBoltStore_Ping_AutoMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "raft.db")
+	store, err := New(Options{Path: path})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Ping(context.Background()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.Close()
+
+	// Pinging doesn't leave a bucket that AutoMigrate would refuse to drop
+	store, err = New(Options{Path: path, AutoMigrate: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.Close()
+}
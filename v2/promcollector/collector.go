@@ -0,0 +1,205 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package promcollector exports the health of a raftboltdb.BoltStore to
+// Prometheus without requiring go-metrics to be set up.
+//
+// The Collector reads the state of the log whenever it is scraped. It also
+// implements metrics.MetricSink so that, when given as the store's
+// Options.MetricSink, it can record per-operation latency histograms:
+//
+//	collector := promcollector.New(promcollector.Options{})
+//	store, err := raftboltdb.New(raftboltdb.Options{
+//		Path:       path,
+//		MetricSink: collector,
+//	})
+//	...
+//	collector.SetStore(store)
+//	prometheus.MustRegister(collector)
+//
+// Wrap the collector in a metrics.FanoutSink to keep sending the store's
+// metrics to go-metrics as well.
+package promcollector
+
+import (
+	"sync"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// operations are the store metrics that are recorded as latency
+	// histograms. Samples for any other keys are ignored.
+	operations = map[string]bool{
+		"storeLogs":   true,
+		"getLog":      true,
+		"getLogs":     true,
+		"deleteRange": true,
+		"set":         true,
+		"get":         true,
+	}
+)
+
+// Options configures a Collector.
+type Options struct {
+	// Namespace and Subsystem prefix all metric names. They default to
+	// "raft" and "boltdb".
+	Namespace string
+	Subsystem string
+
+	// ConstLabels are added to every metric, e.g. to tell apart several
+	// stores in one process.
+	ConstLabels prometheus.Labels
+
+	// Buckets are the latency histogram buckets in seconds. Defaults to
+	// prometheus.DefBuckets.
+	Buckets []float64
+}
+
+// Collector implements prometheus.Collector for a BoltStore.
+type Collector struct {
+	lock  sync.RWMutex
+	store *raftboltdb.BoltStore
+
+	logCount      *prometheus.Desc
+	firstIndex    *prometheus.Desc
+	lastIndex     *prometheus.Desc
+	fileSize      *prometheus.Desc
+	freePages     *prometheus.Desc
+	pendingPages  *prometheus.Desc
+	freelistBytes *prometheus.Desc
+
+	latency *prometheus.HistogramVec
+}
+
+// New returns a Collector. SetStore must be called before it reports
+// anything other than latencies.
+func New(opts Options) *Collector {
+	if opts.Namespace == "" {
+		opts.Namespace = "raft"
+	}
+	if opts.Subsystem == "" {
+		opts.Subsystem = "boltdb"
+	}
+	if opts.Buckets == nil {
+		opts.Buckets = prometheus.DefBuckets
+	}
+
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(opts.Namespace, opts.Subsystem, name), help, nil, opts.ConstLabels)
+	}
+	return &Collector{
+		logCount:      desc("log_entries", "Number of entries in the raft log."),
+		firstIndex:    desc("first_index", "First index in the raft log, or zero if it is empty."),
+		lastIndex:     desc("last_index", "Last index in the raft log, or zero if it is empty."),
+		fileSize:      desc("file_size_bytes", "Size of the database file in bytes."),
+		freePages:     desc("free_pages", "Number of free pages in the database file."),
+		pendingPages:  desc("pending_pages", "Number of pages that will be free once open read transactions finish."),
+		freelistBytes: desc("freelist_bytes", "Number of bytes used to encode the freelist."),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   opts.Subsystem,
+			Name:        "operation_duration_seconds",
+			Help:        "Latency of store operations.",
+			ConstLabels: opts.ConstLabels,
+			Buckets:     opts.Buckets,
+		}, []string{"operation"}),
+	}
+}
+
+// SetStore sets the store whose state is reported on each scrape.
+func (c *Collector) SetStore(store *raftboltdb.BoltStore) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.store = store
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.logCount
+	ch <- c.firstIndex
+	ch <- c.lastIndex
+	ch <- c.fileSize
+	ch <- c.freePages
+	ch <- c.pendingPages
+	ch <- c.freelistBytes
+	c.latency.Describe(ch)
+}
+
+// Collect implements prometheus.Collector. State that can't be read,
+// e.g. because the store has been closed, is left out of the scrape.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.latency.Collect(ch)
+
+	c.lock.RLock()
+	store := c.store
+	c.lock.RUnlock()
+	if store == nil {
+		return
+	}
+
+	first, err := store.FirstIndex()
+	if err != nil {
+		return
+	}
+	last, err := store.LastIndex()
+	if err != nil {
+		return
+	}
+
+	// Raft logs are contiguous, so the count follows from the range
+	var count uint64
+	if last != 0 {
+		count = last - first + 1
+	}
+	ch <- prometheus.MustNewConstMetric(c.logCount, prometheus.GaugeValue, float64(count))
+	ch <- prometheus.MustNewConstMetric(c.firstIndex, prometheus.GaugeValue, float64(first))
+	ch <- prometheus.MustNewConstMetric(c.lastIndex, prometheus.GaugeValue, float64(last))
+
+	if size, err := store.Size(); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.fileSize, prometheus.GaugeValue, float64(size))
+	}
+
+	stats := store.Stats()
+	ch <- prometheus.MustNewConstMetric(c.freePages, prometheus.GaugeValue, float64(stats.FreePageN))
+	ch <- prometheus.MustNewConstMetric(c.pendingPages, prometheus.GaugeValue, float64(stats.PendingPageN))
+	ch <- prometheus.MustNewConstMetric(c.freelistBytes, prometheus.GaugeValue, float64(stats.FreelistInuse))
+}
+
+// AddSample implements metrics.MetricSink, recording store operation
+// timings in milliseconds as latency histograms.
+func (c *Collector) AddSample(key []string, val float32) {
+	if len(key) == 0 || !operations[key[len(key)-1]] {
+		return
+	}
+	c.latency.WithLabelValues(key[len(key)-1]).Observe(float64(val) * float64(time.Millisecond) / float64(time.Second))
+}
+
+// AddSampleWithLabels implements metrics.MetricSink.
+func (c *Collector) AddSampleWithLabels(key []string, val float32, labels []metrics.Label) {
+	c.AddSample(key, val)
+}
+
+// SetGauge implements metrics.MetricSink. Gauges are read from the store
+// at scrape time instead.
+func (c *Collector) SetGauge(key []string, val float32) {}
+
+// SetGaugeWithLabels implements metrics.MetricSink.
+func (c *Collector) SetGaugeWithLabels(key []string, val float32, labels []metrics.Label) {}
+
+// EmitKey implements metrics.MetricSink.
+func (c *Collector) EmitKey(key []string, val float32) {}
+
+// IncrCounter implements metrics.MetricSink.
+func (c *Collector) IncrCounter(key []string, val float32) {}
+
+// IncrCounterWithLabels implements metrics.MetricSink.
+func (c *Collector) IncrCounterWithLabels(key []string, val float32, labels []metrics.Label) {}
+
+var (
+	_ prometheus.Collector = (*Collector)(nil)
+	_ metrics.MetricSink   = (*Collector)(nil)
+)
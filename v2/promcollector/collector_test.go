@@ -0,0 +1,92 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package promcollector
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector(t *testing.T) {
+	fh, err := ioutil.TempFile("", "bolt")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	os.Remove(fh.Name())
+	defer os.Remove(fh.Name())
+
+	collector := New(Options{ConstLabels: prometheus.Labels{"store": "test"}})
+	store, err := raftboltdb.New(raftboltdb.Options{
+		Path:       fh.Name(),
+		MetricSink: collector,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(collector); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Nothing but latencies until the store is set
+	if n, err := testutil.GatherAndCount(reg, "raft_boltdb_last_index"); err != nil || n != 0 {
+		t.Fatalf("bad: %d %v", n, err)
+	}
+	collector.SetStore(store)
+
+	logs := []*raft.Log{
+		{Index: 3, Data: []byte("log3")},
+		{Index: 4, Data: []byte("log4")},
+		{Index: 5, Data: []byte("log5")},
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.GetLog(4, new(raft.Log)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := `
+# HELP raft_boltdb_first_index First index in the raft log, or zero if it is empty.
+# TYPE raft_boltdb_first_index gauge
+raft_boltdb_first_index{store="test"} 3
+# HELP raft_boltdb_last_index Last index in the raft log, or zero if it is empty.
+# TYPE raft_boltdb_last_index gauge
+raft_boltdb_last_index{store="test"} 5
+# HELP raft_boltdb_log_entries Number of entries in the raft log.
+# TYPE raft_boltdb_log_entries gauge
+raft_boltdb_log_entries{store="test"} 3
+`
+	err = testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"raft_boltdb_first_index", "raft_boltdb_last_index", "raft_boltdb_log_entries")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	for _, name := range []string{"raft_boltdb_file_size_bytes", "raft_boltdb_free_pages", "raft_boltdb_pending_pages", "raft_boltdb_freelist_bytes"} {
+		if n, err := testutil.GatherAndCount(reg, name); err != nil || n != 1 {
+			t.Fatalf("bad %s: %d %v", name, n, err)
+		}
+	}
+
+	// One histogram for each operation that has run
+	if n, err := testutil.GatherAndCount(reg, "raft_boltdb_operation_duration_seconds"); err != nil || n != 2 {
+		t.Fatalf("bad: %d %v", n, err)
+	}
+
+	// A closed store is left out of scrapes rather than failing them
+	store.Close()
+	if n, err := testutil.GatherAndCount(reg, "raft_boltdb_last_index"); err != nil || n != 0 {
+		t.Fatalf("bad: %d %v", n, err)
+	}
+}
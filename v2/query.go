@@ -0,0 +1,56 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"math"
+
+	"github.com/hashicorp/raft"
+)
+
+// FindLogsByType returns the last limit entries of the given type, in
+// index order, or all of them if limit is zero. It scans the log
+// backwards from its end in a single read transaction, so finding recent
+// entries is quick, but finding old or rare ones reads the whole log.
+func (b *BoltStore) FindLogsByType(typ raft.LogType, limit int) ([]*raft.Log, error) {
+	it, err := b.ReverseIterator(0, math.MaxUint64)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	var found []*raft.Log
+	for it.Next() {
+		if log := it.Log(); log.Type == typ {
+			found = append(found, log)
+			if len(found) == limit {
+				break
+			}
+		}
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	// Put them back in index order
+	for i, j := 0, len(found)-1; i < j; i, j = i+1, j-1 {
+		found[i], found[j] = found[j], found[i]
+	}
+	return found, nil
+}
+
+// LatestConfigurationEntry returns the last configuration change in the
+// log, which can be decoded with raft.DecodeConfiguration, or
+// raft.ErrLogNotFound if there isn't one. This is the membership a
+// node would start with if it has no later snapshot.
+func (b *BoltStore) LatestConfigurationEntry() (*raft.Log, error) {
+	logs, err := b.FindLogsByType(raft.LogConfiguration, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(logs) == 0 {
+		return nil, raft.ErrLogNotFound
+	}
+	return logs[0], nil
+}
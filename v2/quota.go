@@ -0,0 +1,96 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"fmt"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	// ErrQuotaExceeded is returned by log appends that would take the
+	// store past Options.MaxSizeBytes. The returned error is a
+	// *QuotaError.
+	ErrQuotaExceeded = errors.New("store size quota exceeded")
+)
+
+// QuotaError is returned when a log append would take the store past
+// Options.MaxSizeBytes.
+type QuotaError struct {
+	// Path is the database file.
+	Path string
+
+	// Size is the size of the data in the file before the append, and
+	// Projected is what it's estimated to be after.
+	Size      int64
+	Projected int64
+
+	// Max is Options.MaxSizeBytes.
+	Max int64
+}
+
+// Error implements the error interface.
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%v: %s would grow from %d to about %d bytes, the maximum is %d",
+		ErrQuotaExceeded, e.Path, e.Size, e.Projected, e.Max)
+}
+
+// Unwrap allows errors.Is to match ErrQuotaExceeded.
+func (e *QuotaError) Unwrap() error {
+	return ErrQuotaExceeded
+}
+
+// checkQuota returns a *QuotaError if Options.MaxSizeBytes is set and
+// writing another written bytes in tx would take the store past it. The
+// store is assumed to grow by however much of written doesn't fit in the
+// file's free pages, which ignores the pages of the tree rewritten along
+// with the data, so it's an estimate. Appends that fit in the free pages
+// are allowed even once the store is past the quota, so deleting logs
+// makes room without compacting the file.
+//
+// Free pages can be too scattered to hold an entry, though, so past the
+// quota appends also use up the space freed by deleting logs, or that
+// was free when the store was opened, and are refused once it's gone.
+func (b *BoltStore) checkQuota(tx *bbolt.Tx, written int) error {
+	if b.maxSizeBytes == 0 {
+		return nil
+	}
+	size := tx.Size()
+	db := tx.DB()
+	free := int64(db.Stats().FreePageN) * int64(db.Info().PageSize)
+	projected := size + max(0, int64(written)-free)
+	if projected <= b.maxSizeBytes || (projected == size && b.reuseFreed(tx, int64(written))) {
+		return nil
+	}
+	b.metrics.incrCounter([]string{"quotaExceeded"}, 1)
+	return &QuotaError{Path: b.path, Size: size, Projected: projected, Max: b.maxSizeBytes}
+}
+
+// reuseFreed returns true if there are n bytes of freed space left for
+// an append past the quota in tx, and takes them when tx commits, so an
+// append that's rolled back or retried by Batch doesn't use any up.
+// Appends batched into the same transaction are each checked against
+// what was left before it, so together they can overshoot a little.
+func (b *BoltStore) reuseFreed(tx *bbolt.Tx, n int64) bool {
+	if b.quotaFreed.Load() < n {
+		return false
+	}
+	tx.OnCommit(func() {
+		b.quotaFreed.Add(-n)
+	})
+	return true
+}
+
+// freed adds n bytes of logs deleted in tx to the space appends past the
+// quota can reuse, once tx commits.
+func (b *BoltStore) freed(tx *bbolt.Tx, n int) {
+	if b.maxSizeBytes == 0 || n <= 0 {
+		return
+	}
+	tx.OnCommit(func() {
+		b.quotaFreed.Add(int64(n))
+	})
+}
@@ -0,0 +1,82 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestBoltStore_MaxSizeBytes(t *testing.T) {
+	const max = 1 << 20
+	store, err := New(Options{Path: filepath.Join(t.TempDir(), "raft.db"), MaxSizeBytes: max})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	// Append until the quota is reached
+	data := strings.Repeat("x", 64<<10)
+	var qerr *QuotaError
+	var last uint64
+	for i := uint64(1); i <= 100; i++ {
+		err := store.StoreLog(testRaftLog(i, data))
+		if errors.As(err, &qerr) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		last = i
+	}
+	if qerr == nil {
+		t.Fatalf("quota not enforced")
+	}
+	if !errors.Is(qerr, ErrQuotaExceeded) || qerr.Max != max || qerr.Projected <= max || qerr.Size > qerr.Projected {
+		t.Fatalf("bad: %+v", qerr)
+	}
+
+	// Nothing was written by the failed append
+	if got, err := store.LastIndex(); err != nil || got != last {
+		t.Fatalf("bad: %d %v", got, err)
+	}
+	var log raft.Log
+	if err := store.GetLog(last+1, &log); !errors.Is(err, raft.ErrLogNotFound) {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The stable store isn't limited, and deleting logs makes room
+	if err := store.SetUint64(keyCurrentTerm, 2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.DeleteRange(1, last); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.SetUint64(keyCurrentTerm, 3); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// An append that's rolled back doesn't use up the freed space
+	freed := store.quotaFreed.Load()
+	errFailed := errors.New("failed")
+	err = store.Update(func(tx *StoreTx) error {
+		if err := tx.StoreLog(testRaftLog(last+1, data)); err != nil {
+			return err
+		}
+		return errFailed
+	})
+	if err != errFailed {
+		t.Fatalf("expected failed error, got: %v", err)
+	}
+	if got := store.quotaFreed.Load(); got != freed {
+		t.Fatalf("bad: %d %d", got, freed)
+	}
+	if err := store.StoreLog(testRaftLog(last+1, data)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
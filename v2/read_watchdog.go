@@ -0,0 +1,148 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"time"
+)
+
+const (
+	// defaultReadTxCheckInterval is used when
+	// ReadTxWatchdog.CheckInterval isn't set.
+	defaultReadTxCheckInterval = 10 * time.Second
+)
+
+// ReadTxWatchdog makes the store watch the read transactions that can be
+// kept open for a long time, which are those of iterators, snapshots and
+// View. Bbolt can't reuse a page freed after the oldest open read
+// transaction started, so one that's left open makes the file and its
+// freelist grow without bound. The watchdog logs a warning and counts
+// each one that's been open longer than MaxAge, and reports how many are
+// open and the age of the oldest as the readTx.open and readTx.oldestAge
+// gauges.
+type ReadTxWatchdog struct {
+	// MaxAge is how long a read transaction can be open before it's
+	// stale. Required.
+	MaxAge time.Duration
+
+	// ExpireSnapshots ends snapshots that are stale, as if they'd reached
+	// their own max age, to protect the node from a job that has
+	// forgotten to close one. Iterators and View are only reported, as
+	// they can't be ended without the code using them noticing. All of
+	// them are ended when the store is closed, compacted or grown.
+	ExpireSnapshots bool
+
+	// CheckInterval is how often open read transactions are checked.
+	// Defaults to 10 seconds.
+	CheckInterval time.Duration
+}
+
+// checkInterval returns how often open read transactions are checked.
+func (w *ReadTxWatchdog) checkInterval() time.Duration {
+	if w.CheckInterval == 0 {
+		return defaultReadTxCheckInterval
+	}
+	return w.CheckInterval
+}
+
+// trackedRead is a read transaction that can be kept open for a long
+// time, tracked from when it's opened until it's ended.
+type trackedRead struct {
+	op    string
+	start time.Time
+
+	// snap is the snapshot holding the transaction, if it's one.
+	snap *StoreSnapshot
+
+	// end ends the transaction, after which whatever is using it gets
+	// the given error, returning false if it had already ended.
+	end func(error) bool
+
+	// stale is set once the transaction has been reported as stale.
+	stale bool
+}
+
+// trackRead starts tracking a read transaction opened by op, which end
+// ends, returning a function to call once it's ended.
+func (b *BoltStore) trackRead(op string, snap *StoreSnapshot, end func(error) bool) func() {
+	r := &trackedRead{op: op, start: time.Now(), snap: snap, end: end}
+	b.readsLock.Lock()
+	b.reads[r] = struct{}{}
+	b.readsLock.Unlock()
+	return func() {
+		b.readsLock.Lock()
+		delete(b.reads, r)
+		b.readsLock.Unlock()
+	}
+}
+
+// runReadTxWatchdog checks the open read transactions every interval
+// until the store is closed.
+func (b *BoltStore) runReadTxWatchdog(w ReadTxWatchdog) {
+	ticker := time.NewTicker(w.checkInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-b.closeCh:
+			return
+		}
+		b.checkReads(w, time.Now())
+	}
+}
+
+// checkReads reports the read transactions that were open for longer than
+// w.MaxAge at the given time, and ends stale snapshots if
+// w.ExpireSnapshots is set.
+func (b *BoltStore) checkReads(w ReadTxWatchdog, now time.Time) {
+	var expire []*StoreSnapshot
+	var oldest time.Duration
+	b.readsLock.Lock()
+	open := len(b.reads)
+	for r := range b.reads {
+		age := now.Sub(r.start)
+		oldest = max(oldest, age)
+		if age < w.MaxAge {
+			continue
+		}
+		if r.snap != nil && w.ExpireSnapshots {
+			expire = append(expire, r.snap)
+			continue
+		}
+		if !r.stale {
+			r.stale = true
+			b.metrics.incrCounter([]string{"readTx", "stale"}, 1)
+			b.logger.Warn("read transaction has been open for a long time, the file can't reuse pages freed since it started",
+				"path", b.path, "op", r.op, "age", age)
+		}
+	}
+	b.readsLock.Unlock()
+	b.metrics.setGauge([]string{"readTx", "open"}, float32(open))
+	b.metrics.setGauge([]string{"readTx", "oldestAge"}, float32(oldest.Milliseconds()))
+
+	// Snapshots take readsLock as they end
+	for _, snap := range expire {
+		if snap.end(ErrSnapshotExpired) {
+			b.metrics.incrCounter([]string{"readTx", "expired"}, 1)
+			b.logger.Warn("ended stale store snapshot", "path", b.path, "max_age", w.MaxAge)
+		}
+	}
+}
+
+// endReads ends the open iterators, snapshots and View transactions with
+// err, so that closing the file, which waits for every read transaction,
+// doesn't wait for them to be closed or expire.
+func (b *BoltStore) endReads(err error) {
+	var ends []func(error) bool
+	b.readsLock.Lock()
+	for r := range b.reads {
+		ends = append(ends, r.end)
+	}
+	b.readsLock.Unlock()
+
+	// Reads take readsLock as they end
+	for _, end := range ends {
+		end(err)
+	}
+}
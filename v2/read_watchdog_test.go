@@ -0,0 +1,130 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBoltStore_ReadTxWatchdog(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+
+	if err := store.StoreLog(testRaftLog(1, "log1")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	it, err := store.Iterator(1, 1)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer it.Close()
+	snap, err := store.Snapshot(0)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer snap.Close()
+
+	// Nothing is stale yet
+	w := ReadTxWatchdog{MaxAge: time.Minute, ExpireSnapshots: true}
+	store.checkReads(w, time.Now())
+	if _, err := snap.LastIndex(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Only reported without ExpireSnapshots
+	later := time.Now().Add(2 * time.Minute)
+	store.checkReads(ReadTxWatchdog{MaxAge: time.Minute}, later)
+	if _, err := snap.LastIndex(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The snapshot is ended, and the iterator left open
+	store.checkReads(w, later)
+	if _, err := snap.LastIndex(); !errors.Is(err, ErrSnapshotExpired) {
+		t.Fatalf("err: %v", err)
+	}
+	if !it.Next() {
+		t.Fatalf("bad: %v", it.Err())
+	}
+	store.readsLock.Lock()
+	open := len(store.reads)
+	store.readsLock.Unlock()
+	if open != 1 {
+		t.Fatalf("bad: %d", open)
+	}
+
+	// Closing untracks it
+	it.Close()
+	store.readsLock.Lock()
+	open = len(store.reads)
+	store.readsLock.Unlock()
+	if open != 0 {
+		t.Fatalf("bad: %d", open)
+	}
+}
+
+func TestBoltStore_ReadsEndedOnClose(t *testing.T) {
+	store := testBoltStore(t)
+
+	if err := store.StoreLog(testRaftLog(1, "log1")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	it, err := store.Iterator(1, 1)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer it.Close()
+
+	// Hold a View open until the store has been closed
+	inView := make(chan struct{})
+	closed := make(chan struct{})
+	viewErr := make(chan error, 1)
+	go func() {
+		viewErr <- store.View(func(tx ReadTx) error {
+			close(inView)
+			<-closed
+			_, err := tx.LastIndex()
+			return err
+		})
+	}()
+	<-inView
+
+	done := make(chan error, 1)
+	go func() { done <- store.Close() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("close waited for the open reads")
+	}
+	close(closed)
+
+	if err := <-viewErr; err != ErrClosed {
+		t.Fatalf("err: %v", err)
+	}
+	if it.Next() || it.Err() != ErrClosed {
+		t.Fatalf("err: %v", it.Err())
+	}
+	if err := it.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestBoltStore_ReadTxWatchdog_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "raft.db")
+	for _, w := range []*ReadTxWatchdog{
+		{},
+		{MaxAge: time.Minute, CheckInterval: -1},
+	} {
+		if _, err := New(Options{Path: path, ReadTxWatchdog: w}); !errors.Is(err, ErrInvalidOptions) {
+			t.Fatalf("err: %v", err)
+		}
+	}
+}
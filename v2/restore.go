@@ -0,0 +1,114 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// RestoreOptions controls how Restore writes a backup.
+type RestoreOptions struct {
+	// Overwrite replaces an existing file at the path. Restore fails if
+	// the file is locked by a store that's still open, as it would carry
+	// on using the replaced file.
+	Overwrite bool
+
+	// FileMode is used for the restored file. Defaults to 0600.
+	FileMode os.FileMode
+
+	// LockTimeout is how long to wait for the lock on an existing file
+	// when Overwrite is set. Defaults to one second.
+	LockTimeout time.Duration
+
+	// SkipStructureCheck skips Bbolt's page level consistency check of
+	// the backup, which can be slow on very large files. The buckets and
+	// log entries are always checked.
+	SkipStructureCheck bool
+
+	// Wrapper unwraps the data keys of an encrypted backup so it can be
+	// checked, see Options.Wrapper.
+	Wrapper Wrapper
+
+	// CryptoProvider is used with Wrapper, see Options.CryptoProvider.
+	CryptoProvider CryptoProvider
+}
+
+// Restore writes a backup made by Backup, or served by the backuphttp
+// package, to path so it can be opened as a store. The backup is written
+// to a temporary file in the same directory and checked with Verify,
+// including that the log has no gaps, before it's moved into place, so
+// path is left alone if the backup is incomplete or corrupt. If the check
+// finds problems the returned error is a *VerifyError.
+func Restore(path string, r io.Reader, opts RestoreOptions) (err error) {
+	mode := opts.FileMode
+	if mode == 0 {
+		mode = dbFileMode
+	}
+	if opts.LockTimeout == 0 {
+		opts.LockTimeout = defaultVerifyLockTimeout
+	}
+
+	// Hold the lock on any existing file until it has been replaced, so
+	// it can't be opened in the meantime
+	if _, err := os.Stat(path); err == nil {
+		if !opts.Overwrite {
+			return fmt.Errorf("%w: %s", os.ErrExist, path)
+		}
+		existing, err := bbolt.Open(path, mode, &bbolt.Options{Timeout: opts.LockTimeout})
+		if err != nil {
+			return openError(path, err)
+		}
+		defer existing.Close()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	fh, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".restore*")
+	if err != nil {
+		return err
+	}
+	tmpPath := fh.Name()
+	defer func() {
+		if err != nil {
+			fh.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if err := fh.Chmod(mode); err != nil {
+		return err
+	}
+	if _, err := io.Copy(fh, r); err != nil {
+		return fmt.Errorf("failed writing backup: %w", err)
+	}
+	if err := fh.Sync(); err != nil {
+		return err
+	}
+	if err := fh.Close(); err != nil {
+		return err
+	}
+
+	report, err := Verify(tmpPath, VerifyOptions{
+		SkipStructureCheck: opts.SkipStructureCheck,
+		Wrapper:            opts.Wrapper,
+		CryptoProvider:     opts.CryptoProvider,
+	})
+	if err != nil {
+		return fmt.Errorf("failed checking backup: %w", err)
+	}
+	if !report.OK() {
+		return &VerifyError{Path: path, Report: report}
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
@@ -0,0 +1,112 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRestore(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+	for i := uint64(1); i <= 10; i++ {
+		if err := store.StoreLog(testRaftLog(i, "data")); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	if err := store.Set([]byte("foo"), []byte("bar")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	var buf bytes.Buffer
+	if _, err := store.Backup(&buf); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "raft.db")
+	if err := Restore(path, bytes.NewReader(buf.Bytes()), RestoreOptions{}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	restored, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	checkIndexes(t, restored, 1, 10)
+	checkLogs(t, restored, 1, 10)
+	if val, err := restored.Get([]byte("foo")); err != nil || string(val) != "bar" {
+		t.Fatalf("bad: %q %v", val, err)
+	}
+
+	// An existing file is only replaced when asked, and not while it's open
+	err = Restore(path, bytes.NewReader(buf.Bytes()), RestoreOptions{})
+	if !errors.Is(err, os.ErrExist) {
+		t.Fatalf("expected exists error, got: %v", err)
+	}
+	opts := RestoreOptions{Overwrite: true, LockTimeout: 10 * time.Millisecond}
+	if err := Restore(path, bytes.NewReader(buf.Bytes()), opts); err == nil {
+		t.Fatalf("expected an error replacing an open store")
+	}
+	restored.Close()
+	if err := Restore(path, bytes.NewReader(buf.Bytes()), opts); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected only the restored file, got: %v", entries)
+	}
+}
+
+func TestRestore_Invalid(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+	for i := uint64(1); i <= 10; i++ {
+		if err := store.StoreLog(testRaftLog(i, "data")); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "raft.db")
+	if err := Restore(path, strings.NewReader("not a backup"), RestoreOptions{}); err == nil {
+		t.Fatalf("expected an error restoring garbage")
+	}
+
+	// A backup with entries missing from the middle of the log is rejected
+	if err := store.DeleteRange(4, 6); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	var buf bytes.Buffer
+	if _, err := store.Backup(&buf); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	err := Restore(path, &buf, RestoreOptions{})
+	var verr *VerifyError
+	if !errors.As(err, &verr) || !errors.Is(err, ErrVerifyFailed) {
+		t.Fatalf("expected verify error, got: %v", err)
+	}
+	if p := verr.Report.Problems; len(p) != 1 || p[0].Kind != ProblemGap || p[0].Index != 4 {
+		t.Fatalf("bad: %v", p)
+	}
+
+	// Nothing is left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected no files, got: %v", entries)
+	}
+}
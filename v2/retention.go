@@ -0,0 +1,130 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+const (
+	// defaultRetentionInterval is used when RetentionPolicy.CheckInterval
+	// isn't set.
+	defaultRetentionInterval = time.Minute
+)
+
+// RetentionPolicy makes the store truncate the start of its log in the
+// background, using TrimPrefixAsync's chunked deletes. An entry is kept if
+// any of the rules that are set keeps it, and at least one must be set.
+//
+// Raft expects the log to hold everything since its last snapshot, so
+// the policy must be at least as generous as the raft configuration's
+// TrailingLogs and snapshot settings, or raft must not use this store.
+type RetentionPolicy struct {
+	// KeepLast keeps this many entries at the end of the log.
+	KeepLast uint64
+
+	// MaxAge keeps entries whose AppendedAt is within this long of now.
+	// Entries without an AppendedAt, from versions of raft before it was
+	// added, are treated as expired.
+	MaxAge time.Duration
+
+	// CheckInterval is how often the policy is enforced. Defaults to one
+	// minute.
+	CheckInterval time.Duration
+}
+
+// checkInterval returns how often the policy is enforced.
+func (p *RetentionPolicy) checkInterval() time.Duration {
+	if p.CheckInterval == 0 {
+		return defaultRetentionInterval
+	}
+	return p.CheckInterval
+}
+
+// runRetention enforces the policy every interval until the store is
+// closed.
+func (b *BoltStore) runRetention(policy RetentionPolicy) {
+	ticker := time.NewTicker(policy.checkInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-b.closeCh:
+			return
+		}
+
+		index, err := b.retentionIndex(policy, time.Now())
+		if err == nil && index > 0 {
+			err = b.trimPrefix(index)
+		}
+		if err != nil && err != ErrClosed {
+			b.logger.Error("failed enforcing retention policy", "path", b.path, "error", err)
+		}
+	}
+}
+
+// retentionIndex returns the last index the policy allows to be deleted
+// at the given time, or zero if nothing can be.
+func (b *BoltStore) retentionIndex(policy RetentionPolicy, now time.Time) (uint64, error) {
+	tx, err := b.begin(false)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	bucket, err := b.logs(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	curs := bucket.cursor()
+	firstKey, _ := curs.First()
+	lastKey, _ := curs.Last()
+	if firstKey == nil {
+		return 0, nil
+	}
+	first, last := bytesToUint64(firstKey), bytesToUint64(lastKey)
+
+	// Each rule gives the last index it would delete, and the most
+	// generous one wins
+	index := last
+	if policy.KeepLast > 0 {
+		if last-first < policy.KeepLast {
+			return 0, nil
+		}
+		index = last - policy.KeepLast
+	}
+	if policy.MaxAge > 0 {
+		// Entries are appended in order, so find the first one that
+		// hasn't expired
+		cutoff := now.Add(-policy.MaxAge)
+		var decodeErr error
+		n := sort.Search(int(last-first+1), func(i int) bool {
+			idx := first + uint64(i)
+			val := bucket.get(idx)
+			if val == nil || decodeErr != nil {
+				return false
+			}
+			var log raft.Log
+			if err := decodeLog(idx, val, bucket.keys, &log); err != nil {
+				decodeErr = fmt.Errorf("%w at index %d: %w", ErrLogCorrupt, idx, err)
+				return true
+			}
+			return log.AppendedAt.After(cutoff)
+		})
+		if decodeErr != nil {
+			return 0, decodeErr
+		}
+		if n == 0 {
+			return 0, nil
+		}
+		if expired := first + uint64(n) - 1; expired < index {
+			index = expired
+		}
+	}
+	return index, nil
+}
@@ -0,0 +1,88 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestBoltStore_RetentionIndex(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	// Entries 1-100 were appended a minute apart, finishing an hour ago
+	now := time.Now()
+	var logs []*raft.Log
+	for i := uint64(1); i <= 100; i++ {
+		log := testRaftLog(i, "data")
+		log.AppendedAt = now.Add(-time.Hour - time.Duration(100-i)*time.Minute)
+		logs = append(logs, log)
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	cases := []struct {
+		policy   RetentionPolicy
+		expected uint64
+	}{
+		{RetentionPolicy{KeepLast: 10}, 90},
+		{RetentionPolicy{KeepLast: 100}, 0},
+		{RetentionPolicy{MaxAge: 2 * time.Hour}, 40},
+		{RetentionPolicy{MaxAge: time.Minute}, 100},
+		{RetentionPolicy{MaxAge: 24 * time.Hour}, 0},
+		{RetentionPolicy{KeepLast: 10, MaxAge: 2 * time.Hour}, 40},
+		{RetentionPolicy{KeepLast: 10, MaxAge: time.Minute}, 90},
+	}
+	for _, c := range cases {
+		index, err := store.retentionIndex(c.policy, now)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if index != c.expected {
+			t.Fatalf("bad: %d, expected %d for %+v", index, c.expected, c.policy)
+		}
+	}
+}
+
+func TestBoltStore_RetentionPolicy(t *testing.T) {
+	store, err := New(Options{
+		Path:            filepath.Join(t.TempDir(), "raft.db"),
+		RetentionPolicy: &RetentionPolicy{KeepLast: 10, CheckInterval: 5 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	var logs []*raft.Log
+	for i := uint64(1); i <= 100; i++ {
+		logs = append(logs, testRaftLog(i, "data"))
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		first, err := store.FirstIndex()
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if first == 91 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the log to be truncated, first index %d", first)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	checkIndexes(t, store, 91, 100)
+}
@@ -0,0 +1,270 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"go.etcd.io/bbolt"
+)
+
+// rewrapBatchSize is how many log entries Rewrap re-encrypts in each
+// transaction.
+const rewrapBatchSize = 1024
+
+// ErrNotEncrypted is returned by Rewrap for a store opened without a
+// Wrapper.
+var ErrNotEncrypted = errors.New("store is not encrypted")
+
+// RewrapProgress reports how far a call to Rewrap has got.
+type RewrapProgress struct {
+	// Index is the last log index checked so far.
+	Index uint64
+
+	// LastIndex is the last index in the store when Rewrap started.
+	// Entries appended since then are already under the new key.
+	LastIndex uint64
+
+	// Rewritten is the number of entries re-encrypted so far.
+	Rewritten int
+}
+
+// Rewrap rotates the store's encryption keys while it stays in use. It
+// creates a new data key wrapped by newWrapper, which protects the store
+// from then on, and re-encrypts every stored value with it a batch of
+// entries at a time, so writers are only held up briefly. progress, if
+// not nil, is called after each batch. Once everything is re-encrypted
+// the old data keys are removed from the file, so it can no longer be
+// opened with the old Wrapper.
+//
+// If ctx is done or Rewrap fails part way through, the store is still
+// consistent, with values under both the old and new keys, and Rewrap can
+// be called again to finish.
+func (b *BoltStore) Rewrap(ctx context.Context, newWrapper Wrapper, progress func(RewrapProgress)) error {
+	start := time.Now()
+	defer b.metrics.measureSince([]string{"rewrap"}, start)
+
+	if b.readOnly {
+		return ErrReadOnly
+	}
+	if newWrapper == nil {
+		return fmt.Errorf("%w: no Wrapper was given", ErrInvalidOptions)
+	}
+	b.rewrapLock.Lock()
+	defer b.rewrapLock.Unlock()
+	if b.keys.Load() == nil {
+		return ErrNotEncrypted
+	}
+
+	if err := b.rotateDataKey(ctx, newWrapper); err != nil {
+		return err
+	}
+	b.wrapper = newWrapper
+
+	rewritten, err := b.rewrapLogs(ctx, progress)
+	if err != nil {
+		return err
+	}
+
+	// Everything is now under the active key, so the old ones can go. They
+	// stay in memory for any reads that raced with the last batch.
+	active := b.keys.Load().active
+	_, err = b.update("Rewrap", func(tx *bbolt.Tx) error {
+		conf := tx.Bucket(dbConf)
+		var stale [][]byte
+		curs := conf.Cursor()
+		for k, _ := curs.Seek(dbDataKeyPrefix); k != nil && bytes.HasPrefix(k, dbDataKeyPrefix); k, _ = curs.Next() {
+			if id, ok := dataKeyID(k); ok && id != active {
+				stale = append(stale, append([]byte(nil), k...))
+			}
+		}
+		for _, k := range stale {
+			if err := conf.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	b.logger.Info("rotated encryption keys",
+		"path", b.path, "key_id", active, "entries", rewritten, "duration", time.Since(start))
+	return nil
+}
+
+// rotateDataKey adds a new active data key wrapped by newWrapper,
+// rewraps the existing data keys with it, and re-encrypts the stable
+// store values under the new key.
+func (b *BoltStore) rotateDataKey(ctx context.Context, newWrapper Wrapper) error {
+	old := b.keys.Load()
+	_, err := b.update("Rewrap", func(tx *bbolt.Tx) error {
+		conf := tx.Bucket(dbConf)
+
+		// Unwrap every stored key, not just the ones in memory, in case a
+		// previous Rewrap stopped before removing them
+		var ids []uint32
+		var unwrapped [][]byte
+		curs := conf.Cursor()
+		for k, v := curs.Seek(dbDataKeyPrefix); k != nil && bytes.HasPrefix(k, dbDataKeyPrefix); k, v = curs.Next() {
+			id, ok := dataKeyID(k)
+			if !ok {
+				continue
+			}
+			key, err := b.wrapper.Decrypt(ctx, v)
+			if err != nil {
+				return fmt.Errorf("failed to unwrap data key %d: %w", id, err)
+			}
+			ids = append(ids, id)
+			unwrapped = append(unwrapped, key)
+		}
+
+		keys := &keyring{aeads: maps.Clone(old.aeads)}
+		for id := range keys.aeads {
+			keys.active = max(keys.active, id)
+		}
+		for _, id := range ids {
+			keys.active = max(keys.active, id)
+		}
+		keys.active++
+
+		key := make([]byte, dataKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+		aead, err := newAEAD(b.crypto, key)
+		if err != nil {
+			return err
+		}
+		keys.aeads[keys.active] = aead
+		ids = append(ids, keys.active)
+		unwrapped = append(unwrapped, key)
+
+		for i, id := range ids {
+			wrapped, err := newWrapper.Encrypt(ctx, unwrapped[i])
+			if err != nil {
+				return fmt.Errorf("failed to wrap data key %d: %w", id, err)
+			}
+			dataKey := binary.BigEndian.AppendUint32(append([]byte(nil), dbDataKeyPrefix...), id)
+			if err := conf.Put(dataKey, wrapped); err != nil {
+				return err
+			}
+		}
+
+		var pairs []KV
+		err = conf.ForEach(func(k, v []byte) error {
+			if !bytes.HasPrefix(k, internalKeyPrefix) {
+				pairs = append(pairs, KV{Key: k, Value: v})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, kv := range pairs {
+			plaintext, err := keys.open(kv.Value, kv.Key)
+			if err != nil {
+				return fmt.Errorf("key %q: %w", kv.Key, err)
+			}
+			sealed, err := keys.seal(nil, plaintext, kv.Key)
+			if err != nil {
+				return err
+			}
+			if err := conf.Put(append([]byte(nil), kv.Key...), sealed); err != nil {
+				return err
+			}
+		}
+
+		// Writers pick up the keyring inside their transactions, which
+		// can't start until this one is done, so none can use the old key
+		// once it commits
+		b.keys.Store(keys)
+		return nil
+	})
+	if err != nil {
+		b.keys.Store(old)
+		return err
+	}
+	return nil
+}
+
+// rewrapLogs re-encrypts every log entry that isn't under the active data
+// key, returning how many it rewrote. With EncryptConfOnly, entries under
+// an old key are rewritten unencrypted and unencrypted ones are left.
+func (b *BoltStore) rewrapLogs(ctx context.Context, progress func(RewrapProgress)) (int, error) {
+	lastIndex, err := b.LastIndex()
+	if err != nil {
+		return 0, err
+	}
+	report := RewrapProgress{LastIndex: lastIndex}
+	next, err := b.FirstIndex()
+	if err != nil {
+		return 0, err
+	}
+
+	for done := lastIndex == 0; !done; {
+		if err := ctx.Err(); err != nil {
+			return report.Rewritten, err
+		}
+
+		enc := getLogEncoder(b.msgpackUseNewTimeFormat)
+		_, err := b.update("Rewrap", func(tx *bbolt.Tx) error {
+			bucket, err := b.logs(tx)
+			if err != nil {
+				return err
+			}
+			active := bucket.keys.active
+
+			var logs []*raft.Log
+			curs := bucket.cursor()
+			k, v := curs.Seek(uint64ToBytes(next))
+			for ; k != nil && len(logs) < rewrapBatchSize; k, v = curs.Next() {
+				idx := bytesToUint64(k)
+				if idx > lastIndex {
+					break
+				}
+				report.Index, next = idx, idx+1
+				if id := entryKeyID(v); id == active || (id == 0 && b.encryptConfOnly) {
+					continue
+				}
+				log := new(raft.Log)
+				if err := bucket.decode(idx, v, log); err != nil {
+					return fmt.Errorf("%w at index %d: %w", ErrLogCorrupt, idx, err)
+				}
+				logs = append(logs, log)
+			}
+			done = k == nil || bytesToUint64(k) > lastIndex
+
+			_, err = b.writeEntries(bucket, enc, logs, lastIndex)
+			report.Rewritten += len(logs)
+			return err
+		})
+		enc.release()
+		if err != nil {
+			return report.Rewritten, err
+		}
+		if progress != nil {
+			progress(report)
+		}
+	}
+	return report.Rewritten, nil
+}
+
+// entryKeyID returns the ID of the data key the entry in val is encrypted
+// with, or zero if it isn't encrypted.
+func entryKeyID(val []byte) uint32 {
+	if entryFlags(val)&entryEncrypted == 0 || len(val) < entryHeaderSize+keyIDSize {
+		return 0
+	}
+	return binary.BigEndian.Uint32(val[entryHeaderSize:])
+}
@@ -0,0 +1,126 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"go.etcd.io/bbolt"
+)
+
+func TestBoltStore_Rewrap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "raft.db")
+
+	// Start with an entry from before the store was encrypted
+	store, err := New(Options{Path: path})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.StoreLog(testRaftLog(1, "plain-log")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.Close()
+
+	store, err = New(Options{Path: path, Wrapper: testWrapper{id: 1}, OverflowThreshold: 1024})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	var logs []*raft.Log
+	for i := uint64(2); i <= 2*rewrapBatchSize; i++ {
+		logs = append(logs, testRaftLog(i, fmt.Sprintf("log-%d", i)))
+	}
+	logs = append(logs, testRaftLog(2*rewrapBatchSize+1, string(bytes.Repeat([]byte("big"), 1000))))
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Set([]byte("vote"), []byte("conf")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var reports []RewrapProgress
+	if err := store.Rewrap(context.Background(), testWrapper{id: 2}, func(p RewrapProgress) {
+		reports = append(reports, p)
+	}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(reports) < 2 {
+		t.Fatalf("bad: %v", reports)
+	}
+	last := reports[len(reports)-1]
+	if last.Index != 2*rewrapBatchSize+1 || last.LastIndex != 2*rewrapBatchSize+1 || last.Rewritten != 2*rewrapBatchSize+1 {
+		t.Fatalf("bad: %+v", last)
+	}
+	if rawContains(t, store, "plain-log") {
+		t.Fatalf("unencrypted entry wasn't rewritten")
+	}
+
+	// Still usable while open
+	if err := store.StoreLog(testRaftLog(2*rewrapBatchSize+2, "after")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	var ids []uint32
+	err = store.conn.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(dbConf).ForEach(func(k, _ []byte) error {
+			if id, ok := dataKeyID(k); ok {
+				ids = append(ids, id)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(ids) != 1 || ids[0] != 2 {
+		t.Fatalf("bad: %v", ids)
+	}
+	store.Close()
+
+	if _, err := New(Options{Path: path, Wrapper: testWrapper{id: 1}}); err == nil {
+		t.Fatalf("should fail with the old wrapper")
+	}
+	store, err = New(Options{Path: path, Wrapper: testWrapper{id: 2}})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	var log raft.Log
+	if err := store.GetLog(1, &log); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(log.Data) != "plain-log" {
+		t.Fatalf("bad: %q", log.Data)
+	}
+	if err := store.GetLog(2*rewrapBatchSize+1, &log); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !bytes.Equal(log.Data, logs[len(logs)-1].Data) {
+		t.Fatalf("bad: %q", log.Data)
+	}
+	val, err := store.Get([]byte("vote"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(val) != "conf" {
+		t.Fatalf("bad: %q", val)
+	}
+}
+
+func TestBoltStore_Rewrap_NotEncrypted(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	err := store.Rewrap(context.Background(), testWrapper{id: 2}, nil)
+	if !errors.Is(err, ErrNotEncrypted) {
+		t.Fatalf("err: %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+)
+
+var (
+	// dbSalvageKey is the key in the conf bucket a SalvageResult is
+	// written to when a store is salvaged.
+	dbSalvageKey = []byte("raftboltdb.salvage")
+)
+
+// SalvageResult describes the entries removed by a salvage. It is also
+// written to the conf bucket as a recovery marker.
+type SalvageResult struct {
+	// Time is when the salvage happened.
+	Time time.Time
+
+	// TruncatedAt is the first index that was removed from the log, or
+	// zero if only bad keys were removed. Every entry from this index
+	// onwards was deleted.
+	TruncatedAt uint64
+
+	// Logs is the number of log entries that were removed.
+	Logs uint64
+
+	// BadKeys is the number of keys that weren't valid log indexes and
+	// were removed.
+	BadKeys uint64
+}
+
+// salvage removes the corrupt entries found by verify. Nothing is done,
+// and nil is returned, if the report has no entry-level problems.
+func (b *BoltStore) salvage(report *VerifyReport) (*SalvageResult, error) {
+	result := &SalvageResult{Time: time.Now().UTC()}
+	badKeys := false
+	for _, p := range report.Problems {
+		switch p.Kind {
+		case ProblemDecode, ProblemChecksum, ProblemIndexMismatch:
+			if result.TruncatedAt == 0 || p.Index < result.TruncatedAt {
+				result.TruncatedAt = p.Index
+			}
+		case ProblemKeyLength:
+			badKeys = true
+		}
+	}
+	if result.TruncatedAt == 0 && !badKeys {
+		return nil, nil
+	}
+
+	tx, err := b.begin(true)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	logs, err := b.logs(tx)
+	if err != nil {
+		return nil, err
+	}
+	curs := logs.cursor()
+	for k, _ := curs.First(); k != nil; k, _ = curs.Next() {
+		switch {
+		case len(k) != 8:
+			result.BadKeys++
+		case result.TruncatedAt != 0 && bytesToUint64(k) >= result.TruncatedAt:
+			result.Logs++
+		default:
+			continue
+		}
+		if err := curs.Delete(); err != nil {
+			return nil, err
+		}
+	}
+	if result.TruncatedAt != 0 {
+		if err := logs.deleteOverflow(result.TruncatedAt, math.MaxUint64); err != nil {
+			return nil, err
+		}
+	}
+	b.trackIndexes(tx, logs)
+
+	conf, err := b.bucket(tx, dbConf)
+	if err != nil {
+		return nil, err
+	}
+	marker, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	if err := conf.Put(dbSalvageKey, marker); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// SalvageMarker returns the recovery marker written the last time the
+// store was salvaged, or ErrKeyNotFound if it never has been.
+func (b *BoltStore) SalvageMarker() (*SalvageResult, error) {
+	// The marker is kept in the log file, even if the stable store isn't
+	tx, err := b.begin(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	conf, err := b.bucket(tx, dbConf)
+	if err != nil {
+		return nil, err
+	}
+	val := conf.Get(dbSalvageKey)
+	if val == nil {
+		return nil, ErrKeyNotFound
+	}
+
+	var result SalvageResult
+	if err := json.Unmarshal(val, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,420 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	// dbSegmentSizeKey is the key in the conf bucket holding the number
+	// of indexes in each segment of the logs bucket. The logs bucket is
+	// in the flat format if it's missing.
+	dbSegmentSizeKey = []byte("raftboltdb.segmentSize")
+
+	// dbLogsMigrating holds the segments while a flat logs bucket is
+	// being converted. It only exists inside the converting transaction.
+	dbLogsMigrating = []byte("logs.migrating")
+)
+
+// logBucket is the logs bucket in either of its formats. In the flat
+// format every entry is a key in the bucket. In the segmented format the
+// bucket holds a nested bucket for every segmentSize indexes, keyed by
+// the first index the segment covers, so a whole segment can be deleted
+// with DeleteBucket rather than an entry at a time.
+type logBucket struct {
+	root        *bbolt.Bucket
+	segmentSize uint64
+	fillPercent float64
+
+	// seg is the segment last written to by put, which covers the
+	// indexes from segStart.
+	seg      *bbolt.Bucket
+	segStart uint64
+
+	// modified is set if the bucket may already have been written to in
+	// this transaction, see deleteEntries.
+	modified bool
+
+	// terms is the term index, if the store keeps one.
+	terms *bbolt.Bucket
+
+	// overflow holds the data of large entries, if there are any, split
+	// into chunks of overflowChunkSize bytes.
+	overflow          *bbolt.Bucket
+	overflowChunkSize int
+
+	// keys decrypts encrypted entries, if the store is encrypted.
+	keys *keyring
+}
+
+// readSegmentSize returns the segment size recorded in tx, or zero if
+// the logs bucket is flat.
+func readSegmentSize(tx *bbolt.Tx) uint64 {
+	conf := tx.Bucket(dbConf)
+	if conf == nil {
+		return 0
+	}
+	if v := conf.Get(dbSegmentSizeKey); len(v) == 8 {
+		return bytesToUint64(v)
+	}
+	return 0
+}
+
+// logs returns the logs bucket of tx in the store's format.
+func (b *BoltStore) logs(tx *bbolt.Tx) (*logBucket, error) {
+	bucket, err := b.bucket(tx, dbLogs)
+	if err != nil {
+		return nil, err
+	}
+	bucket.FillPercent = b.logsFillPercent
+	l := &logBucket{root: bucket, segmentSize: b.segmentSize, fillPercent: b.logsFillPercent}
+	if b.termIndex {
+		l.terms = tx.Bucket(dbTerms)
+	}
+	l.overflow, l.overflowChunkSize = tx.Bucket(dbOverflow), b.overflowChunkSize
+	l.keys = b.keys.Load()
+	return l, nil
+}
+
+// segmentStart returns the first index of the segment holding idx.
+func (l *logBucket) segmentStart(idx uint64) uint64 {
+	return idx - idx%l.segmentSize
+}
+
+// segmentEnd returns the last index of the segment starting at start.
+func (l *logBucket) segmentEnd(start uint64) uint64 {
+	if start > math.MaxUint64-l.segmentSize {
+		return math.MaxUint64
+	}
+	return start + l.segmentSize - 1
+}
+
+// get returns the encoded entry at idx, or nil if there isn't one.
+func (l *logBucket) get(idx uint64) []byte {
+	key := uint64ToBytes(idx)
+	if l.segmentSize == 0 {
+		return l.root.Get(key)
+	}
+	seg := l.root.Bucket(uint64ToBytes(l.segmentStart(idx)))
+	if seg == nil {
+		return nil
+	}
+	return seg.Get(key)
+}
+
+// put stores the encoded entry val under key, creating its segment if
+// needed. Like bbolt.Bucket.Put, key and val must remain valid for the
+// life of the transaction.
+func (l *logBucket) put(key, val []byte) error {
+	if l.segmentSize == 0 {
+		return l.root.Put(key, val)
+	}
+	start := l.segmentStart(bytesToUint64(key))
+	if l.seg == nil || start != l.segStart {
+		seg, err := l.root.CreateBucketIfNotExists(uint64ToBytes(start))
+		if err != nil {
+			return err
+		}
+		seg.FillPercent = l.fillPercent
+		l.seg, l.segStart = seg, start
+	}
+	return l.seg.Put(key, val)
+}
+
+// deleteRange deletes the entries from min to max inclusive, returning
+// how many were deleted and their total size. Segments that are wholly
+// in the range are dropped without reading their entries, so their size
+// isn't included.
+func (l *logBucket) deleteRange(min, max uint64) (deleted, size int, err error) {
+	if err := l.unindexRange(min, max); err != nil {
+		return 0, 0, err
+	}
+	if err := l.deleteOverflow(min, max); err != nil {
+		return 0, 0, err
+	}
+	if l.segmentSize == 0 {
+		return deleteEntries(l.root.Cursor(), min, max, l.modified)
+	}
+
+	// Buckets can't be deleted while a cursor is iterating over their
+	// parent, so empty segments are collected and dropped afterwards
+	var drop [][]byte
+	curs := l.root.Cursor()
+	for k, v := curs.Seek(uint64ToBytes(l.segmentStart(min))); k != nil; k, v = curs.Next() {
+		if v != nil {
+			continue
+		}
+		start := bytesToUint64(k)
+		if start > max {
+			break
+		}
+
+		seg := l.root.Bucket(k)
+		if start >= min && l.segmentEnd(start) <= max {
+			deleted += seg.Stats().KeyN
+		} else {
+			n, s, err := deleteEntries(seg.Cursor(), min, max, l.modified)
+			if err != nil {
+				return 0, 0, err
+			}
+			deleted += n
+			size += s
+			if first, _ := seg.Cursor().First(); first != nil {
+				continue
+			}
+		}
+		drop = append(drop, append([]byte(nil), k...))
+	}
+
+	for _, k := range drop {
+		if err := l.root.DeleteBucket(k); err != nil {
+			return 0, 0, err
+		}
+		if l.seg != nil && bytes.Equal(k, uint64ToBytes(l.segStart)) {
+			l.seg = nil
+		}
+	}
+	return deleted, size, nil
+}
+
+// deleteEntries deletes the entries from min to max inclusive from a
+// flat bucket or a single segment. Bbolt's Next skips an entry after a
+// delete from a page that has already been modified in the transaction,
+// so if modified is set each delete is followed by a slower Seek instead.
+func deleteEntries(curs *bbolt.Cursor, min, max uint64, modified bool) (deleted, size int, err error) {
+	k, v := curs.Seek(uint64ToBytes(min))
+	for k != nil && bytesToUint64(k) <= max {
+		key := bytesToUint64(k)
+		if err := curs.Delete(); err != nil {
+			return 0, 0, err
+		}
+		deleted++
+		size += len(v)
+
+		if modified {
+			k, v = curs.Seek(uint64ToBytes(key + 1))
+		} else {
+			k, v = curs.Next()
+		}
+	}
+	return deleted, size, nil
+}
+
+// chunkEnd returns the last index of a chunk of n indexes starting at
+// first. Chunks of a segmented log are rounded up to a whole segment, so
+// they can be dropped rather than deleted an entry at a time.
+func (l *logBucket) chunkEnd(first uint64, n int) uint64 {
+	end := first + uint64(n) - 1
+	if end < first {
+		end = math.MaxUint64
+	}
+	if l.segmentSize != 0 {
+		if segEnd := l.segmentEnd(l.segmentStart(first)); segEnd > end {
+			end = segEnd
+		}
+	}
+	return end
+}
+
+// cursor returns a cursor over the entries in index order.
+func (l *logBucket) cursor() *logCursor {
+	if l.segmentSize == 0 {
+		return &logCursor{flat: l.root.Cursor()}
+	}
+	return &logCursor{root: l.root, segs: l.root.Cursor(), segmentSize: l.segmentSize}
+}
+
+// logCursor works like a bbolt.Cursor over the entries in a logBucket,
+// stepping from one segment to the next in the segmented format. Empty
+// segments are skipped.
+type logCursor struct {
+	flat *bbolt.Cursor
+
+	root        *bbolt.Bucket
+	segs        *bbolt.Cursor
+	inner       *bbolt.Cursor
+	segmentSize uint64
+}
+
+// First moves to the first entry and returns its key and value.
+func (c *logCursor) First() ([]byte, []byte) {
+	if c.flat != nil {
+		return c.flat.First()
+	}
+	return c.firstFrom(c.segs.First())
+}
+
+// Last moves to the last entry and returns its key and value.
+func (c *logCursor) Last() ([]byte, []byte) {
+	if c.flat != nil {
+		return cursorLast(c.flat)
+	}
+	return c.lastFrom(cursorLast(c.segs))
+}
+
+// Seek moves to the first entry at or after key.
+func (c *logCursor) Seek(key []byte) ([]byte, []byte) {
+	if c.flat != nil {
+		return c.flat.Seek(key)
+	}
+	idx := bytesToUint64(key)
+	segKey := uint64ToBytes(idx - idx%c.segmentSize)
+	k, v := c.segs.Seek(segKey)
+	if k != nil && v == nil && bytes.Equal(k, segKey) {
+		c.inner = c.root.Bucket(k).Cursor()
+		if ek, ev := c.inner.Seek(key); ek != nil {
+			return ek, ev
+		}
+		k, v = c.segs.Next()
+	}
+	return c.firstFrom(k, v)
+}
+
+// Next moves to the next entry.
+func (c *logCursor) Next() ([]byte, []byte) {
+	if c.flat != nil {
+		return c.flat.Next()
+	}
+	if c.inner == nil {
+		return nil, nil
+	}
+	if k, v := c.inner.Next(); k != nil {
+		return k, v
+	}
+	return c.firstFrom(c.segs.Next())
+}
+
+// Prev moves to the previous entry.
+func (c *logCursor) Prev() ([]byte, []byte) {
+	if c.flat != nil {
+		return c.flat.Prev()
+	}
+	if c.inner == nil {
+		return nil, nil
+	}
+	if k, v := c.inner.Prev(); k != nil {
+		return k, v
+	}
+	return c.lastFrom(c.segs.Prev())
+}
+
+// Delete removes the current entry. Segments are left in place even if
+// this empties them.
+func (c *logCursor) Delete() error {
+	if c.flat != nil {
+		return c.flat.Delete()
+	}
+	return c.inner.Delete()
+}
+
+// firstFrom returns the first entry in the segment at k, or in the first
+// non-empty segment after it.
+func (c *logCursor) firstFrom(k, v []byte) ([]byte, []byte) {
+	for ; k != nil; k, v = c.segs.Next() {
+		if v != nil {
+			continue
+		}
+		c.inner = c.root.Bucket(k).Cursor()
+		if ek, ev := c.inner.First(); ek != nil {
+			return ek, ev
+		}
+	}
+	c.inner = nil
+	return nil, nil
+}
+
+// lastFrom returns the last entry in the segment at k, or in the last
+// non-empty segment before it.
+func (c *logCursor) lastFrom(k, v []byte) ([]byte, []byte) {
+	for ; k != nil; k, v = c.segs.Prev() {
+		if v != nil {
+			continue
+		}
+		c.inner = c.root.Bucket(k).Cursor()
+		if ek, ev := cursorLast(c.inner); ek != nil {
+			return ek, ev
+		}
+	}
+	c.inner = nil
+	return nil, nil
+}
+
+// cursorLast moves curs to the last key in its bucket, like curs.Last.
+// Before v1.3.7, Bbolt's Last returns nothing if deletes earlier in the
+// transaction have left the bucket's last page empty, so this steps back
+// from there to the last key that's left.
+func cursorLast(curs *bbolt.Cursor) ([]byte, []byte) {
+	k, v := curs.Last()
+	if k != nil {
+		return k, v
+	}
+	if first, _ := curs.Bucket().Cursor().First(); first == nil {
+		return nil, nil
+	}
+	for k == nil {
+		k, v = curs.Prev()
+	}
+	return k, v
+}
+
+// initSegments records the segment format in tx, converting a flat logs
+// bucket if it has any entries, and returns the new segment size.
+func initSegments(tx *bbolt.Tx, size uint64, fillPercent float64) (uint64, error) {
+	conf := tx.Bucket(dbConf)
+	flat := tx.Bucket(dbLogs)
+	if first, _ := flat.Cursor().First(); first != nil {
+		if err := segmentLogs(tx, flat, size, fillPercent); err != nil {
+			return 0, fmt.Errorf("failed converting logs to segments: %w", err)
+		}
+	}
+	if err := conf.Put(dbSegmentSizeKey, uint64ToBytes(size)); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// segmentLogs converts a flat logs bucket to segments. Buckets can't be
+// renamed, so the segments are built in a temporary bucket and then
+// copied into a fresh logs bucket.
+func segmentLogs(tx *bbolt.Tx, flat *bbolt.Bucket, size uint64, fillPercent float64) error {
+	tmp, err := tx.CreateBucket(dbLogsMigrating)
+	if err != nil {
+		return err
+	}
+	staged := &logBucket{root: tmp, segmentSize: size, fillPercent: fillPercent}
+	err = flat.ForEach(func(k, v []byte) error {
+		if v == nil || len(k) != 8 {
+			return fmt.Errorf("unexpected key %x in flat logs bucket", k)
+		}
+		return staged.put(k, v)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.DeleteBucket(dbLogs); err != nil {
+		return err
+	}
+	logs, err := tx.CreateBucket(dbLogs)
+	if err != nil {
+		return err
+	}
+	err = tmp.ForEach(func(k, _ []byte) error {
+		seg, err := logs.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+		seg.FillPercent = fillPercent
+		return tmp.Bucket(k).ForEach(seg.Put)
+	})
+	if err != nil {
+		return err
+	}
+	return tx.DeleteBucket(dbLogsMigrating)
+}
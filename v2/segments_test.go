@@ -0,0 +1,238 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"go.etcd.io/bbolt"
+)
+
+func testSegmentedStore(t *testing.T, segmentSize int) *BoltStore {
+	fh, err := ioutil.TempFile("", "bolt")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	os.Remove(fh.Name())
+
+	store, err := New(Options{Path: fh.Name(), LogSegmentSize: segmentSize})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return store
+}
+
+// segmentStarts returns the keys of the segments in the logs bucket.
+func segmentStarts(t *testing.T, store *BoltStore) []uint64 {
+	t.Helper()
+	var starts []uint64
+	err := store.conn.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(dbLogs).ForEach(func(k, v []byte) error {
+			if v != nil {
+				t.Fatalf("unexpected entry %x outside a segment", k)
+			}
+			starts = append(starts, bytesToUint64(k))
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return starts
+}
+
+func checkLogs(t *testing.T, store *BoltStore, min, max uint64) {
+	t.Helper()
+	for i := min; i <= max; i++ {
+		log := new(raft.Log)
+		if err := store.GetLog(i, log); err != nil {
+			t.Fatalf("err: %s at index %d", err, i)
+		}
+		if log.Index != i {
+			t.Fatalf("bad: %#v", log)
+		}
+	}
+	logs, err := store.GetLogs(min, max, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(logs) != int(max-min+1) {
+		t.Fatalf("bad: %d logs", len(logs))
+	}
+}
+
+func TestBoltStore_Segments(t *testing.T) {
+	store := testSegmentedStore(t, 10)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	var logs []*raft.Log
+	for i := uint64(1); i <= 95; i++ {
+		logs = append(logs, testRaftLog(i, "data"))
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	checkLogs(t, store, 1, 95)
+	checkIndexes(t, store, 1, 95)
+	if starts := segmentStarts(t, store); !reflect.DeepEqual(starts, []uint64{0, 10, 20, 30, 40, 50, 60, 70, 80, 90}) {
+		t.Fatalf("bad: %v", starts)
+	}
+	log := new(raft.Log)
+	if err := store.GetLog(96, log); err != raft.ErrLogNotFound {
+		t.Fatalf("expected not found error, got: %v", err)
+	}
+
+	// Whole segments are dropped, and a partly deleted one is kept
+	var info DeleteRangeInfo
+	store.hooks.OnDeleteRange = func(i DeleteRangeInfo) { info = i }
+	if err := store.DeleteRange(1, 35); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if info.Logs != 35 {
+		t.Fatalf("bad: %#v", info)
+	}
+	checkLogs(t, store, 36, 95)
+	if starts := segmentStarts(t, store); !reflect.DeepEqual(starts, []uint64{30, 40, 50, 60, 70, 80, 90}) {
+		t.Fatalf("bad: %v", starts)
+	}
+
+	// Deleting the rest of a segment drops it too
+	if err := store.DeleteRange(85, 95); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if starts := segmentStarts(t, store); !reflect.DeepEqual(starts, []uint64{30, 40, 50, 60, 70, 80}) {
+		t.Fatalf("bad: %v", starts)
+	}
+
+	// The slow paths read the same range
+	store.indexes.Store(nil)
+	checkIndexes(t, store, 36, 84)
+	stats, err := store.LogStats()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if stats.Logs != 49 || stats.FirstIndex != 36 || stats.LastIndex != 84 {
+		t.Fatalf("bad: %#v", stats)
+	}
+	report, err := store.verify(VerifyOptions{})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !report.OK() || report.Logs != 49 {
+		t.Fatalf("bad: %#v", report)
+	}
+}
+
+func TestBoltStore_Segments_Trim(t *testing.T) {
+	store := testSegmentedStore(t, 16)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	var logs []*raft.Log
+	for i := uint64(1); i <= 100; i++ {
+		logs = append(logs, testRaftLog(i, "data"))
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Chunks are rounded up to whole segments
+	store.trimChunkSize = 1
+	if err := <-store.TrimPrefixAsync(50); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	checkIndexes(t, store, 51, 100)
+	checkLogs(t, store, 51, 100)
+	if starts := segmentStarts(t, store); !reflect.DeepEqual(starts, []uint64{48, 64, 80, 96}) {
+		t.Fatalf("bad: %v", starts)
+	}
+}
+
+func TestBoltStore_Segments_Convert(t *testing.T) {
+	store := testBoltStore(t)
+	defer os.Remove(store.path)
+
+	var logs []*raft.Log
+	for i := uint64(1); i <= 50; i++ {
+		logs = append(logs, testRaftLog(i, "data"))
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Set([]byte("foo"), []byte("bar")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.Close()
+
+	// Opening with a segment size converts the existing entries
+	store, err := New(Options{Path: store.path, LogSegmentSize: 16})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	checkLogs(t, store, 1, 50)
+	if starts := segmentStarts(t, store); !reflect.DeepEqual(starts, []uint64{0, 16, 32, 48}) {
+		t.Fatalf("bad: %v", starts)
+	}
+	if val, err := store.Get([]byte("foo")); err != nil || string(val) != "bar" {
+		t.Fatalf("bad: %q %v", val, err)
+	}
+	err = store.conn.View(func(tx *bbolt.Tx) error {
+		if tx.Bucket(dbLogsMigrating) != nil {
+			t.Fatalf("temporary bucket wasn't removed")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.Close()
+
+	// The file's segment size is used from then on, whatever the options
+	// say
+	for _, options := range []Options{
+		{Path: store.path},
+		{Path: store.path, LogSegmentSize: 100},
+		{Path: store.path, ReadOnly: true},
+	} {
+		store, err := New(options)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if store.segmentSize != 16 {
+			t.Fatalf("bad: %d", store.segmentSize)
+		}
+		checkLogs(t, store, 1, 50)
+		store.Close()
+	}
+}
+
+func TestBoltStore_DeleteTail(t *testing.T) {
+	for _, segmentSize := range []int{0, 16} {
+		store := testSegmentedStore(t, segmentSize)
+		defer store.Close()
+		defer os.Remove(store.path)
+
+		// Entries big enough that deleting the tail empties whole pages
+		var logs []*raft.Log
+		for i := uint64(1); i <= 40; i++ {
+			logs = append(logs, testRaftLog(i, string(make([]byte, 1000))))
+		}
+		if err := store.StoreLogs(logs); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := store.DeleteRange(20, 40); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		// The cached index is read in the deleting transaction
+		if last, err := store.LastIndex(); err != nil || last != 19 {
+			t.Fatalf("bad: %d %v", last, err)
+		}
+	}
+}
@@ -0,0 +1,496 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"go.etcd.io/bbolt"
+)
+
+var (
+	// dbSnapshots holds the snapshots written by BoltSnapshotStore, each
+	// in a bucket named by its ID. A snapshot's bucket holds its metadata
+	// under snapshotMetaKey, once it's complete, and its data in the
+	// snapshotDataBucket bucket in chunks keyed by 4-byte sequence number.
+	dbSnapshots        = []byte("snapshots")
+	snapshotMetaKey    = []byte("meta")
+	snapshotDataBucket = []byte("data")
+
+	// ErrSnapshotNotFound is returned by BoltSnapshotStore.Open for a
+	// snapshot that doesn't exist or hasn't been completed.
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+
+	// ErrSnapshotCorrupt is returned when reading a snapshot whose data
+	// doesn't match the checksum taken when it was written.
+	ErrSnapshotCorrupt = errors.New("snapshot is corrupt")
+)
+
+const (
+	// snapshotTxSize is how much snapshot data is written or read in each
+	// transaction.
+	snapshotTxSize = 1 << 20
+)
+
+// BoltSnapshotStore is a raft.SnapshotStore that keeps snapshots in the
+// same file as a BoltStore, so all of a node's raft state is in one file
+// that can be backed up, restored and compacted as a unit. It's meant for
+// deployments whose snapshots are small enough to comfortably share the
+// file with the log, as every snapshot written grows the file by its size
+// and is rewritten by compaction.
+//
+// Snapshot data is written a megabyte at a time in its own transactions,
+// and only appears in List once the sink is closed, so a snapshot that
+// was being written when the process stopped is never restored. Only one
+// BoltSnapshotStore should be used with a store at a time.
+type BoltSnapshotStore struct {
+	store  *BoltStore
+	retain int
+
+	// reading counts the open readers of each snapshot, which aren't
+	// reaped until they're closed.
+	lock    sync.Mutex
+	reading map[string]int
+}
+
+// snapshotMeta is what's stored under snapshotMetaKey.
+type snapshotMeta struct {
+	raft.SnapshotMeta
+
+	// CRC is the CRC-64 of the snapshot's data, as in raft's
+	// FileSnapshotStore.
+	CRC []byte
+}
+
+// NewBoltSnapshotStore returns a snapshot store that keeps up to retain
+// snapshots in the file of store. Snapshots that were left incomplete
+// when the process stopped are removed. Stores encrypted with
+// Options.Wrapper aren't supported, as snapshots would be left out of
+// Rewrap.
+func NewBoltSnapshotStore(store *BoltStore, retain int) (*BoltSnapshotStore, error) {
+	if retain < 1 {
+		return nil, fmt.Errorf("%w: must retain at least one snapshot", ErrInvalidOptions)
+	}
+	if store.keys.Load() != nil {
+		return nil, fmt.Errorf("%w: snapshots can't be stored in an encrypted store", ErrInvalidOptions)
+	}
+
+	s := &BoltSnapshotStore{store: store, retain: retain, reading: make(map[string]int)}
+	if store.readOnly {
+		return s, nil
+	}
+	_, err := store.update("SnapshotStoreInit", func(tx *bbolt.Tx) error {
+		snapshots, err := tx.CreateBucketIfNotExists(dbSnapshots)
+		if err != nil {
+			return err
+		}
+		var incomplete [][]byte
+		err = snapshots.ForEach(func(k, _ []byte) error {
+			if snap := snapshots.Bucket(k); snap != nil && snap.Get(snapshotMetaKey) == nil {
+				incomplete = append(incomplete, bytes.Clone(k))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range incomplete {
+			store.logger.Warn("removing incomplete snapshot", "path", store.path, "id", string(id))
+			if err := snapshots.DeleteBucket(id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Create implements raft.SnapshotStore, starting a new snapshot. The
+// deprecated Peers field of its metadata isn't set, as raft only uses it
+// for snapshots of version 0, which aren't supported.
+func (s *BoltSnapshotStore) Create(version raft.SnapshotVersion, index, term uint64,
+	configuration raft.Configuration, configurationIndex uint64, trans raft.Transport) (raft.SnapshotSink, error) {
+	if version < 1 || version > raft.SnapshotVersionMax {
+		return nil, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+
+	id := fmt.Sprintf("%d-%d-%d", term, index, time.Now().UnixMilli())
+	_, err := s.store.update("SnapshotCreate", func(tx *bbolt.Tx) error {
+		snapshots, err := s.store.bucket(tx, dbSnapshots)
+		if err != nil {
+			return err
+		}
+		snap, err := snapshots.CreateBucket([]byte(id))
+		if err != nil {
+			return fmt.Errorf("failed to create snapshot %s: %w", id, err)
+		}
+		_, err = snap.CreateBucket(snapshotDataBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &boltSnapshotSink{
+		store: s,
+		meta: snapshotMeta{SnapshotMeta: raft.SnapshotMeta{
+			Version:            version,
+			ID:                 id,
+			Index:              index,
+			Term:               term,
+			Configuration:      configuration,
+			ConfigurationIndex: configurationIndex,
+		}},
+		crc: crc64.New(crc64.MakeTable(crc64.ECMA)),
+	}, nil
+}
+
+// List implements raft.SnapshotStore, returning the complete snapshots,
+// newest first.
+func (s *BoltSnapshotStore) List() ([]*raft.SnapshotMeta, error) {
+	metas, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	var list []*raft.SnapshotMeta
+	for _, meta := range metas {
+		list = append(list, &meta.SnapshotMeta)
+	}
+	if len(list) > s.retain {
+		list = list[:s.retain]
+	}
+	return list, nil
+}
+
+// list returns the metadata of every complete snapshot, newest first.
+func (s *BoltSnapshotStore) list() ([]*snapshotMeta, error) {
+	tx, err := s.store.begin(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	snapshots := tx.Bucket(dbSnapshots)
+	if snapshots == nil {
+		return nil, nil
+	}
+	return completeSnapshots(snapshots)
+}
+
+// completeSnapshots returns the metadata of every complete snapshot in
+// the snapshots bucket, newest first.
+func completeSnapshots(snapshots *bbolt.Bucket) ([]*snapshotMeta, error) {
+	var metas []*snapshotMeta
+	err := snapshots.ForEach(func(k, _ []byte) error {
+		meta, err := readSnapshotMeta(snapshots.Bucket(k))
+		if err != nil || meta == nil {
+			return err
+		}
+		metas = append(metas, meta)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The same order as raft's FileSnapshotStore
+	sort.Slice(metas, func(i, j int) bool {
+		a, b := metas[i], metas[j]
+		if a.Term != b.Term {
+			return a.Term > b.Term
+		}
+		if a.Index != b.Index {
+			return a.Index > b.Index
+		}
+		return a.ID > b.ID
+	})
+	return metas, nil
+}
+
+// readSnapshotMeta decodes the metadata of the snapshot in bucket, or
+// returns nil if it's incomplete.
+func readSnapshotMeta(bucket *bbolt.Bucket) (*snapshotMeta, error) {
+	if bucket == nil {
+		return nil, nil
+	}
+	val := bucket.Get(snapshotMetaKey)
+	if val == nil {
+		return nil, nil
+	}
+	var meta snapshotMeta
+	if err := json.Unmarshal(val, &meta); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot metadata: %w", err)
+	}
+	return &meta, nil
+}
+
+// Open implements raft.SnapshotStore. The data is read a megabyte at a
+// time in its own transactions, and checked against its checksum once it
+// has all been read, when the reader returns an error wrapping
+// ErrSnapshotCorrupt rather than io.EOF if it doesn't match.
+func (s *BoltSnapshotStore) Open(id string) (*raft.SnapshotMeta, io.ReadCloser, error) {
+	tx, err := s.store.begin(false)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var meta *snapshotMeta
+	if snapshots := tx.Bucket(dbSnapshots); snapshots != nil {
+		if meta, err = readSnapshotMeta(snapshots.Bucket([]byte(id))); err != nil {
+			return nil, nil, err
+		}
+	}
+	if meta == nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrSnapshotNotFound, id)
+	}
+
+	s.lock.Lock()
+	s.reading[id]++
+	s.lock.Unlock()
+	return &meta.SnapshotMeta, &boltSnapshotReader{
+		store: s,
+		meta:  meta,
+		crc:   crc64.New(crc64.MakeTable(crc64.ECMA)),
+	}, nil
+}
+
+// reap deletes all but the newest retain snapshots, except those that are
+// being read.
+func (s *BoltSnapshotStore) reap(tx *bbolt.Tx) error {
+	snapshots, err := s.store.bucket(tx, dbSnapshots)
+	if err != nil {
+		return err
+	}
+	metas, err := completeSnapshots(snapshots)
+	if err != nil || len(metas) <= s.retain {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, meta := range metas[s.retain:] {
+		if s.reading[meta.ID] > 0 {
+			continue
+		}
+		if err := snapshots.DeleteBucket([]byte(meta.ID)); err != nil {
+			return err
+		}
+		s.store.logger.Debug("reaped snapshot", "path", s.store.path, "id", meta.ID)
+	}
+	return nil
+}
+
+// boltSnapshotSink writes a snapshot into a BoltSnapshotStore.
+type boltSnapshotSink struct {
+	store *BoltSnapshotStore
+	meta  snapshotMeta
+	crc   hash.Hash64
+
+	// buf holds data that hasn't been written yet, and next is the
+	// sequence number of the next chunk.
+	buf  []byte
+	next uint32
+
+	done bool
+}
+
+// ID implements raft.SnapshotSink.
+func (s *boltSnapshotSink) ID() string {
+	return s.meta.ID
+}
+
+// Write implements io.Writer, buffering data and writing it to the store
+// a megabyte at a time.
+func (s *boltSnapshotSink) Write(p []byte) (int, error) {
+	if s.done {
+		return 0, fmt.Errorf("snapshot %s is already closed", s.meta.ID)
+	}
+	s.buf = append(s.buf, p...)
+	s.crc.Write(p)
+	s.meta.Size += int64(len(p))
+	if len(s.buf) >= snapshotTxSize {
+		if err := s.flush(nil); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// flush writes the buffered data in a transaction, along with anything
+// fn does in it.
+func (s *boltSnapshotSink) flush(fn func(tx *bbolt.Tx, snap *bbolt.Bucket) error) error {
+	chunkSize := s.store.store.overflowChunkSize
+	_, err := s.store.store.update("SnapshotWrite", func(tx *bbolt.Tx) error {
+		snapshots, err := s.store.store.bucket(tx, dbSnapshots)
+		if err != nil {
+			return err
+		}
+		snap := snapshots.Bucket([]byte(s.meta.ID))
+		if snap == nil {
+			return fmt.Errorf("%w: %s was removed while it was being written", ErrSnapshotNotFound, s.meta.ID)
+		}
+		data := snap.Bucket(snapshotDataBucket)
+		data.FillPercent = 1.0
+		next := s.next
+		for buf := s.buf; len(buf) > 0; next++ {
+			n := min(len(buf), chunkSize)
+			if err := data.Put(binary.BigEndian.AppendUint32(nil, next), buf[:n]); err != nil {
+				return err
+			}
+			buf = buf[n:]
+		}
+		if fn != nil {
+			if err := fn(tx, snap); err != nil {
+				return err
+			}
+		}
+		s.next = next
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.buf = s.buf[:0]
+	return nil
+}
+
+// Close implements raft.SnapshotSink, writing the rest of the data and
+// the snapshot's metadata in one transaction, which makes it available,
+// and reaping old snapshots.
+func (s *boltSnapshotSink) Close() error {
+	if s.done {
+		return nil
+	}
+	s.done = true
+	s.meta.CRC = s.crc.Sum(nil)
+	meta, err := json.Marshal(&s.meta)
+	if err != nil {
+		return err
+	}
+	return s.flush(func(tx *bbolt.Tx, snap *bbolt.Bucket) error {
+		if err := snap.Put(snapshotMetaKey, meta); err != nil {
+			return err
+		}
+		return s.store.reap(tx)
+	})
+}
+
+// Cancel implements raft.SnapshotSink, removing what has been written.
+func (s *boltSnapshotSink) Cancel() error {
+	if s.done {
+		return nil
+	}
+	s.done = true
+	_, err := s.store.store.update("SnapshotCancel", func(tx *bbolt.Tx) error {
+		snapshots, err := s.store.store.bucket(tx, dbSnapshots)
+		if err != nil {
+			return err
+		}
+		err = snapshots.DeleteBucket([]byte(s.meta.ID))
+		if err == bbolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
+	})
+	return err
+}
+
+// boltSnapshotReader reads a snapshot from a BoltSnapshotStore.
+type boltSnapshotReader struct {
+	store *BoltSnapshotStore
+	meta  *snapshotMeta
+	crc   hash.Hash64
+
+	// buf holds data that's been read from the store but not returned,
+	// and next is the sequence number of the next chunk to read.
+	buf  []byte
+	next uint32
+	read int64
+
+	closed bool
+}
+
+// Read implements io.Reader.
+func (r *boltSnapshotReader) Read(p []byte) (int, error) {
+	if r.closed {
+		return 0, fmt.Errorf("snapshot %s is closed", r.meta.ID)
+	}
+	if len(r.buf) == 0 {
+		if r.read == r.meta.Size {
+			if got := r.crc.Sum(nil); !bytes.Equal(got, r.meta.CRC) {
+				return 0, fmt.Errorf("%w: %s has CRC %x, expected %x", ErrSnapshotCorrupt, r.meta.ID, got, r.meta.CRC)
+			}
+			return 0, io.EOF
+		}
+		if err := r.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// fill reads the next megabyte of chunks into buf.
+func (r *boltSnapshotReader) fill() error {
+	tx, err := r.store.store.begin(false)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	snapshots, err := r.store.store.bucket(tx, dbSnapshots)
+	if err != nil {
+		return err
+	}
+	snap := snapshots.Bucket([]byte(r.meta.ID))
+	if snap == nil {
+		return fmt.Errorf("%w: %s", ErrSnapshotNotFound, r.meta.ID)
+	}
+	curs := snap.Bucket(snapshotDataBucket).Cursor()
+	buf := make([]byte, 0, snapshotTxSize)
+	for k, v := curs.Seek(binary.BigEndian.AppendUint32(nil, r.next)); k != nil && len(buf) < snapshotTxSize; k, v = curs.Next() {
+		if binary.BigEndian.Uint32(k) != r.next {
+			return fmt.Errorf("%w: %s is missing chunk %d", ErrSnapshotCorrupt, r.meta.ID, r.next)
+		}
+		buf = append(buf, v...)
+		r.next++
+	}
+	if len(buf) == 0 || r.read+int64(len(buf)) > r.meta.Size {
+		return fmt.Errorf("%w: %s has %d bytes of data, expected %d", ErrSnapshotCorrupt, r.meta.ID, r.read+int64(len(buf)), r.meta.Size)
+	}
+	r.read += int64(len(buf))
+	r.crc.Write(buf)
+	r.buf = buf
+	return nil
+}
+
+// Close implements io.Closer, letting the snapshot be reaped.
+func (r *boltSnapshotReader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	r.store.lock.Lock()
+	defer r.store.lock.Unlock()
+	if r.store.reading[r.meta.ID]--; r.store.reading[r.meta.ID] == 0 {
+		delete(r.store.reading, r.meta.ID)
+	}
+	return nil
+}
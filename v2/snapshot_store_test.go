@@ -0,0 +1,333 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"go.etcd.io/bbolt"
+)
+
+var _ raft.SnapshotStore = &BoltSnapshotStore{}
+
+// testSnapshot writes a snapshot of data at index to snaps.
+func testSnapshot(t *testing.T, snaps *BoltSnapshotStore, index uint64, data []byte) string {
+	t.Helper()
+	config := raft.Configuration{Servers: []raft.Server{{ID: "a", Address: "127.0.0.1:8300"}}}
+	sink, err := snaps.Create(raft.SnapshotVersionMax, index, 3, config, 2, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := sink.Write(data); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return sink.ID()
+}
+
+// readSnapshot returns the data of the snapshot id in snaps.
+func readSnapshot(snaps *BoltSnapshotStore, id string) (*raft.SnapshotMeta, []byte, error) {
+	meta, r, err := snaps.Open(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	return meta, data, err
+}
+
+func TestBoltSnapshotStore(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	snaps, err := NewBoltSnapshotStore(store, 2)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if list, err := snaps.List(); err != nil || len(list) != 0 {
+		t.Fatalf("bad: %v %v", list, err)
+	}
+
+	// Big enough to be written in more than one transaction
+	data := make([]byte, 3*snapshotTxSize+100)
+	rand.New(rand.NewSource(1)).Read(data)
+	id := testSnapshot(t, snaps, 10, data)
+
+	list, err := snaps.List()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(list) != 1 || list[0].ID != id || list[0].Index != 10 || list[0].Term != 3 ||
+		list[0].Size != int64(len(data)) || list[0].ConfigurationIndex != 2 || len(list[0].Configuration.Servers) != 1 {
+		t.Fatalf("bad: %#v", list)
+	}
+	meta, got, err := readSnapshot(snaps, id)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if meta.ID != id || !bytes.Equal(got, data) {
+		t.Fatalf("bad: %v %d", meta, len(got))
+	}
+
+	if _, _, err := snaps.Open("nope"); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Fatalf("bad: %v", err)
+	}
+
+	// Snapshots survive the store being reopened
+	store.Close()
+	store, err = NewBoltStore(store.path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	if snaps, err = NewBoltSnapshotStore(store, 2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, got, err := readSnapshot(snaps, id); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("bad: %d %v", len(got), err)
+	}
+}
+
+func TestBoltSnapshotStore_Retain(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	if _, err := NewBoltSnapshotStore(store, 0); !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("bad: %v", err)
+	}
+	snaps, err := NewBoltSnapshotStore(store, 2)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var ids []string
+	for i := uint64(1); i <= 3; i++ {
+		ids = append(ids, testSnapshot(t, snaps, 10*i, []byte(fmt.Sprintf("snapshot %d", i))))
+	}
+
+	// The oldest is reaped, and the rest are listed newest first
+	list, err := snaps.List()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(list) != 2 || list[0].ID != ids[2] || list[1].ID != ids[1] {
+		t.Fatalf("bad: %v", list)
+	}
+	if _, _, err := snaps.Open(ids[0]); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Fatalf("bad: %v", err)
+	}
+
+	// A snapshot that's being read isn't reaped until it's closed
+	_, r, err := snaps.Open(ids[1])
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	ids = append(ids, testSnapshot(t, snaps, 40, []byte("snapshot 4")))
+	got, err := io.ReadAll(r)
+	if err != nil || string(got) != "snapshot 2" {
+		t.Fatalf("bad: %q %v", got, err)
+	}
+	r.Close()
+	ids = append(ids, testSnapshot(t, snaps, 50, []byte("snapshot 5")))
+	if all, err := snaps.list(); err != nil || len(all) != 2 || all[0].ID != ids[4] || all[1].ID != ids[3] {
+		t.Fatalf("bad: %v %v", all, err)
+	}
+}
+
+func TestBoltSnapshotStore_Incomplete(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	snaps, err := NewBoltSnapshotStore(store, 2)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	id := testSnapshot(t, snaps, 10, []byte("complete"))
+
+	// A cancelled snapshot is removed
+	sink, err := snaps.Create(raft.SnapshotVersionMax, 20, 3, raft.Configuration{}, 2, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := sink.Write([]byte("cancelled")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := sink.Cancel(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// And one that was never closed isn't listed, and is removed when
+	// the store is next opened
+	sink, err = snaps.Create(raft.SnapshotVersionMax, 30, 3, raft.Configuration{}, 2, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := sink.Write(make([]byte, snapshotTxSize+1)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if list, err := snaps.List(); err != nil || len(list) != 1 || list[0].ID != id {
+		t.Fatalf("bad: %v %v", list, err)
+	}
+	if _, _, err := snaps.Open(sink.ID()); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Fatalf("bad: %v", err)
+	}
+
+	if _, err := NewBoltSnapshotStore(store, 2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	err = store.conn.View(func(tx *bbolt.Tx) error {
+		if n := tx.Bucket(dbSnapshots).Stats().BucketN; n != 3 {
+			return fmt.Errorf("expected only the complete snapshot, found %d buckets", n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestBoltSnapshotStore_Corrupt(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	snaps, err := NewBoltSnapshotStore(store, 2)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	id := testSnapshot(t, snaps, 10, []byte("snapshot data"))
+
+	err = store.conn.Update(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(dbSnapshots).Bucket([]byte(id)).Bucket(snapshotDataBucket)
+		return data.Put([]byte{0, 0, 0, 0}, []byte("snapshot dat!"))
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, _, err := readSnapshot(snaps, id); !errors.Is(err, ErrSnapshotCorrupt) {
+		t.Fatalf("bad: %v", err)
+	}
+}
+
+func TestBoltSnapshotStore_Encrypted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "raft.db")
+	store, err := New(Options{Path: path, Wrapper: testWrapper{id: 1}})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	if _, err := NewBoltSnapshotStore(store, 2); !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("bad: %v", err)
+	}
+}
+
+// countFSM counts the entries applied to it.
+type countFSM struct {
+	count uint64
+}
+
+func (f *countFSM) Apply(*raft.Log) interface{} {
+	f.count++
+	return nil
+}
+
+func (f *countFSM) Snapshot() (raft.FSMSnapshot, error) {
+	return &countSnapshot{count: f.count}, nil
+}
+
+func (f *countFSM) Restore(r io.ReadCloser) error {
+	defer r.Close()
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	f.count = bytesToUint64(buf)
+	return nil
+}
+
+type countSnapshot struct {
+	count uint64
+}
+
+func (s *countSnapshot) Persist(sink raft.SnapshotSink) error {
+	if _, err := sink.Write(uint64ToBytes(s.count)); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *countSnapshot) Release() {}
+
+func TestBoltSnapshotStore_Raft(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+	snaps, err := NewBoltSnapshotStore(store, 2)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	conf := raft.DefaultConfig()
+	conf.LocalID = "a"
+	conf.HeartbeatTimeout = 50 * time.Millisecond
+	conf.ElectionTimeout = 50 * time.Millisecond
+	conf.LeaderLeaseTimeout = 50 * time.Millisecond
+	conf.CommitTimeout = 5 * time.Millisecond
+	conf.LogOutput = io.Discard
+	start := func(fsm raft.FSM) *raft.Raft {
+		_, trans := raft.NewInmemTransport("a")
+		r, err := raft.NewRaft(conf, fsm, store, store, snaps, trans)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		return r
+	}
+
+	fsm := &countFSM{}
+	r := start(fsm)
+	err = r.BootstrapCluster(raft.Configuration{Servers: []raft.Server{{ID: "a", Address: "a"}}}).Error()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	select {
+	case <-r.LeaderCh():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no leader")
+	}
+	for i := 0; i < 10; i++ {
+		if err := r.Apply([]byte("x"), time.Second).Error(); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	if err := r.Snapshot().Error(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := r.Shutdown().Error(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// A new node restores the snapshot from the file when it starts
+	restored := &countFSM{}
+	r = start(restored)
+	defer r.Shutdown()
+	if restored.count != fsm.count {
+		t.Fatalf("bad: %d %d", restored.count, fsm.count)
+	}
+}
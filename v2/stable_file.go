@@ -0,0 +1,201 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	// dbStablePathKey is the key in the conf bucket of the log file that
+	// records where the stable store is kept, once Options.StablePath has
+	// been used, so the file can't be opened without it.
+	dbStablePathKey = []byte("raftboltdb.stablePath")
+)
+
+// initStable opens the separate stable file if Options.StablePath is set,
+// moving the stable store's keys into it from the log file the first
+// time, and otherwise makes sure the log file has never been used with
+// one.
+func (b *BoltStore) initStable(options *Options) error {
+	if options.StablePath == "" {
+		tx, err := b.begin(false)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		if v := tx.Bucket(dbConf).Get(dbStablePathKey); v != nil {
+			return fmt.Errorf("%w: the stable store of %s is kept in %s, StablePath must be set", ErrInvalidOptions, b.path, v)
+		}
+		return nil
+	}
+
+	if options.readOnly() {
+		if _, err := os.Stat(options.StablePath); err != nil {
+			return err
+		}
+	} else if options.CreateDir {
+		if err := os.MkdirAll(filepath.Dir(options.StablePath), options.dirMode()); err != nil {
+			return err
+		}
+	}
+	stable, err := bbolt.Open(options.StablePath, options.fileMode(), &bbolt.Options{
+		Timeout:  options.LockTimeout,
+		ReadOnly: options.readOnly(),
+	})
+	if err != nil {
+		return openError(options.StablePath, err)
+	}
+	b.stable = stable
+	if b.readOnly {
+		return nil
+	}
+	return b.moveStableKeys(options.StablePath)
+}
+
+// moveStableKeys moves the stable store's keys from the conf bucket of
+// the log file into the stable file. The conf bucket is created in the
+// stable file first, and then the log file is marked, so it can't be
+// opened without a stable file once the keys could be in one. The keys
+// are only deleted from the log file once they're copied, so an
+// interrupted move is finished the next time the store is opened.
+func (b *BoltStore) moveStableKeys(stablePath string) error {
+	var pairs []KV
+	var marked bool
+	err := b.conn.View(func(tx *bbolt.Tx) error {
+		conf := tx.Bucket(dbConf)
+		marked = conf.Get(dbStablePathKey) != nil
+		return conf.ForEach(func(k, v []byte) error {
+			if !bytes.HasPrefix(k, internalKeyPrefix) {
+				pairs = append(pairs, KV{Key: bytes.Clone(k), Value: bytes.Clone(v)})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	// A stable file without a conf bucket has never held the keys, so if
+	// they've already been moved they're somewhere else
+	_, err = b.updateConf("StableInit", func(tx *bbolt.Tx) error {
+		if tx.Bucket(dbConf) == nil && marked && len(pairs) == 0 {
+			return fmt.Errorf("%w: the stable store of %s was moved to another file, not %s", ErrInvalidOptions, b.path, stablePath)
+		}
+		_, err := tx.CreateBucketIfNotExists(dbConf)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = b.update("StableInit", func(tx *bbolt.Tx) error {
+		return tx.Bucket(dbConf).Put(dbStablePathKey, []byte(stablePath))
+	})
+	if err != nil || len(pairs) == 0 {
+		return err
+	}
+
+	// Keys left in the log file by an interrupted move were already
+	// copied if the stable file has any
+	_, err = b.updateConf("StableInit", func(tx *bbolt.Tx) error {
+		conf := tx.Bucket(dbConf)
+		if k, _ := conf.Cursor().First(); k != nil {
+			return nil
+		}
+		for _, kv := range pairs {
+			if err := conf.Put(kv.Key, kv.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	_, err = b.update("StableInit", func(tx *bbolt.Tx) error {
+		conf := tx.Bucket(dbConf)
+		for _, kv := range pairs {
+			if err := conf.Delete(kv.Key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		b.logger.Info("moved stable store to its own file", "path", b.path, "stable_path", stablePath, "keys", len(pairs))
+	}
+	return err
+}
+
+// beginConf starts a transaction on the file holding the stable store's
+// keys, which is the log file unless Options.StablePath is set.
+func (b *BoltStore) beginConf(writable bool) (*bbolt.Tx, error) {
+	if b.stable == nil {
+		return b.begin(writable)
+	}
+	if b.closed.Load() {
+		return nil, ErrClosed
+	}
+	if writable && b.readOnly {
+		return nil, ErrReadOnly
+	}
+	if writable {
+		if err := b.checkFreeSpace(b.stable.Path()); err != nil {
+			return nil, err
+		}
+	}
+	tx, err := b.stable.Begin(writable)
+	if err == bbolt.ErrDatabaseNotOpen {
+		return nil, ErrClosed
+	}
+	return tx, err
+}
+
+// commitConf commits a write transaction started by beginConf. The stable
+// file is synced by every commit, whatever the log file's SyncPolicy.
+func (b *BoltStore) commitConf(tx *bbolt.Tx, op string) (time.Duration, error) {
+	if b.stable == nil {
+		return b.commit(tx, op)
+	}
+	start := time.Now()
+	err := b.diskError(b.stable.Path(), tx.Commit())
+	elapsed := time.Since(start)
+	b.hooks.commit(CommitInfo{Op: op, Duration: elapsed, Err: err})
+	return elapsed, err
+}
+
+// updateConf is like update, but for the file holding the stable store's
+// keys.
+func (b *BoltStore) updateConf(op string, fn func(*bbolt.Tx) error) (time.Duration, error) {
+	tx, err := b.beginConf(true)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return 0, err
+	}
+	return b.commitConf(tx, op)
+}
+
+// viewConf returns a read transaction on the file holding the stable
+// store's keys, for a caller already reading the log file in tx. That's
+// tx itself unless Options.StablePath is set. done must be called once
+// the caller has finished with it.
+func (b *BoltStore) viewConf(tx *bbolt.Tx) (confTx *bbolt.Tx, done func(), err error) {
+	if b.stable == nil {
+		return tx, func() {}, nil
+	}
+	if confTx, err = b.beginConf(false); err != nil {
+		return nil, nil, err
+	}
+	return confTx, func() { confTx.Rollback() }, nil
+}
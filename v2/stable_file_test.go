@@ -0,0 +1,191 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+// readConfKey reads key from the conf bucket of the Bbolt file at path.
+func readConfKey(t *testing.T, path string, key []byte) []byte {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer db.Close()
+
+	var val []byte
+	db.View(func(tx *bbolt.Tx) error {
+		if conf := tx.Bucket(dbConf); conf != nil {
+			val = bytes.Clone(conf.Get(key))
+		}
+		return nil
+	})
+	return val
+}
+
+func TestBoltStore_StablePath(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "raft.db")
+	stablePath := filepath.Join(dir, "stable.db")
+
+	// Start with the stable store in the log file
+	store, err := New(Options{Path: path})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.SetUint64(keyCurrentTerm, 3); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.StoreLog(testRaftLog(1, "log1")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.Close()
+
+	// The keys are moved to the stable file on open
+	store, err = New(Options{Path: path, StablePath: stablePath})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	term, err := store.GetUint64(keyCurrentTerm)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if term != 3 {
+		t.Fatalf("bad: %d", term)
+	}
+	if err := store.SetUint64(keyCurrentTerm, 4); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Set([]byte("foo"), []byte("bar")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if swapped, err := store.CAS([]byte("foo"), []byte("bar"), []byte("baz")); err != nil || !swapped {
+		t.Fatalf("bad: %v %v", swapped, err)
+	}
+	if err := store.StoreLog(testRaftLog(2, "log2")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	keys, err := store.Keys([]byte("foo"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("bad: %q", keys)
+	}
+	stats, err := store.LogStats()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if stats.Logs != 2 || stats.ConfKeys < 2 {
+		t.Fatalf("bad: %+v", stats)
+	}
+
+	// The stable store can't be written along with the logs
+	err = store.Update(func(tx *StoreTx) error {
+		return tx.SetUint64(keyCurrentTerm, 5)
+	})
+	if !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("err: %v", err)
+	}
+	store.Close()
+
+	if v := readConfKey(t, path, keyCurrentTerm); v != nil {
+		t.Fatalf("bad: %v", v)
+	}
+	if v := readConfKey(t, stablePath, keyCurrentTerm); bytesToUint64(v) != 4 {
+		t.Fatalf("bad: %v", v)
+	}
+
+	// The log file can't be opened without its stable file
+	if _, err := New(Options{Path: path}); !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := New(Options{Path: path, StablePath: filepath.Join(dir, "other.db")}); !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Both files can be read read-only
+	store, err = New(Options{Path: path, StablePath: stablePath, ReadOnly: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	val, err := store.Get([]byte("foo"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(val) != "baz" {
+		t.Fatalf("bad: %q", val)
+	}
+	last, err := store.LastIndex()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if last != 2 {
+		t.Fatalf("bad: %d", last)
+	}
+	if err := store.Set([]byte("foo"), nil); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestBoltStore_StablePath_Interrupted(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "raft.db")
+	stablePath := filepath.Join(dir, "stable.db")
+
+	store, err := New(Options{Path: path})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.SetUint64(keyCurrentTerm, 3); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.Close()
+
+	// Mark the file as if a move was interrupted before the keys were
+	// copied
+	db, err := bbolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(dbConf).Put(dbStablePathKey, []byte(stablePath))
+	})
+	db.Close()
+
+	store, err = New(Options{Path: path, StablePath: stablePath})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	term, err := store.GetUint64(keyCurrentTerm)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if term != 3 {
+		t.Fatalf("bad: %d", term)
+	}
+}
+
+func TestBoltStore_StablePath_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "raft.db")
+
+	for _, options := range []Options{
+		{Path: path, StablePath: path},
+		{Path: path, StablePath: filepath.Join(dir, ".", "raft.db")},
+		{Path: path, StablePath: filepath.Join(dir, "stable.db"), Wrapper: &testWrapper{id: 1}},
+	} {
+		if _, err := New(options); !errors.Is(err, ErrInvalidOptions) {
+			t.Fatalf("err: %v", err)
+		}
+	}
+}
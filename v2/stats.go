@@ -0,0 +1,102 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"bytes"
+	"time"
+)
+
+var (
+	// dbLastCompactionKey is the key in the conf bucket holding the time
+	// the file was last compacted, in Unix nanoseconds.
+	dbLastCompactionKey = []byte("raftboltdb.lastCompaction")
+)
+
+// LogStoreStats is a summary of the contents of a store, suitable for
+// exposing over an admin API.
+type LogStoreStats struct {
+	// Logs is the number of entries in the log.
+	Logs uint64
+
+	// FirstIndex and LastIndex are the range of the log, or zero if it's
+	// empty.
+	FirstIndex uint64
+	LastIndex  uint64
+
+	// LogBytes is the total encoded size of every entry in the log.
+	LogBytes uint64
+
+	// ConfKeys is the number of keys in the conf bucket, which holds the
+	// StableStore values.
+	ConfKeys uint64
+
+	// FileSize is the size of the database file in bytes.
+	FileSize int64
+
+	// FreelistBytes is the size of the freelist, which is written on
+	// every commit unless Options.NoFreelistSync is set.
+	FreelistBytes int
+
+	// LastCompaction is when the file was last compacted, or the zero
+	// time if it never has been.
+	LastCompaction time.Time
+}
+
+// LogStats returns a summary of the store's contents. It reads every
+// entry in the log to total their sizes, so it's not meant to be called
+// on a hot path.
+func (b *BoltStore) LogStats() (*LogStoreStats, error) {
+	tx, err := b.begin(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	logs, err := b.logs(tx)
+	if err != nil {
+		return nil, err
+	}
+	conf, err := b.bucket(tx, dbConf)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &LogStoreStats{
+		FileSize:      tx.Size(),
+		FreelistBytes: tx.DB().Stats().FreelistInuse,
+	}
+
+	logCurs := logs.cursor()
+	for k, v := logCurs.First(); k != nil; k, v = logCurs.Next() {
+		if stats.Logs == 0 {
+			stats.FirstIndex = bytesToUint64(k)
+		}
+		stats.LastIndex = bytesToUint64(k)
+		stats.Logs++
+		stats.LogBytes += uint64(len(v))
+	}
+
+	curs := conf.Cursor()
+	for k, v := curs.First(); k != nil; k, v = curs.Next() {
+		stats.ConfKeys++
+		if bytes.Equal(k, dbLastCompactionKey) && len(v) == 8 {
+			stats.LastCompaction = time.Unix(0, int64(bytesToUint64(v)))
+		}
+	}
+
+	// The stable store's own keys are counted too if they're kept in a
+	// file of their own
+	if b.stable != nil {
+		confTx, done, err := b.viewConf(tx)
+		if err != nil {
+			return nil, err
+		}
+		defer done()
+		if stable := confTx.Bucket(dbConf); stable != nil {
+			stats.ConfKeys += uint64(stable.Stats().KeyN)
+		}
+	}
+	return stats, nil
+}
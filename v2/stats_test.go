@@ -0,0 +1,78 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"go.etcd.io/bbolt"
+)
+
+func TestBoltStore_LogStats(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	// An empty store
+	stats, err := store.LogStats()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if stats.Logs != 0 || stats.FirstIndex != 0 || stats.LastIndex != 0 || stats.LogBytes != 0 {
+		t.Fatalf("bad: %#v", stats)
+	}
+	if stats.FileSize <= 0 || !stats.LastCompaction.IsZero() {
+		t.Fatalf("bad: %#v", stats)
+	}
+
+	logs := []*raft.Log{
+		testRaftLog(3, "log3"),
+		testRaftLog(4, "log4"),
+		testRaftLog(5, "log5"),
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.SetUint64([]byte("CurrentTerm"), 2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	compacted := time.Unix(1700000000, 0)
+	err = store.db().Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(dbConf).Put(dbLastCompactionKey, uint64ToBytes(uint64(compacted.UnixNano())))
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	stats, err = store.LogStats()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if stats.Logs != 3 || stats.FirstIndex != 3 || stats.LastIndex != 5 {
+		t.Fatalf("bad: %#v", stats)
+	}
+	var expected uint64
+	for _, log := range logs {
+		val, err := encodeMsgPack(log, false)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		expected += uint64(val.Len())
+	}
+	if stats.LogBytes != expected {
+		t.Fatalf("bad: %d != %d", stats.LogBytes, expected)
+	}
+	// The term, the last compaction and the format version
+	if stats.ConfKeys != 3 || !stats.LastCompaction.Equal(compacted) {
+		t.Fatalf("bad: %#v", stats)
+	}
+
+	store.Close()
+	if _, err := store.LogStats(); err != ErrClosed {
+		t.Fatalf("expected closed error, got: %v", err)
+	}
+}
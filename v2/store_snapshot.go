@@ -0,0 +1,182 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+const (
+	// DefaultSnapshotMaxAge is how long a StoreSnapshot stays open if
+	// Snapshot isn't given a max age.
+	DefaultSnapshotMaxAge = 10 * time.Minute
+)
+
+var (
+	// ErrSnapshotExpired is returned by a StoreSnapshot that was ended
+	// because it was open for longer than its max age.
+	ErrSnapshotExpired = errors.New("store snapshot expired")
+)
+
+// StoreSnapshot is a read-only view of the store pinned to a single read
+// transaction, returned by BoltStore.Snapshot. Everything read through it,
+// however long after it was taken, comes from the store as it was then,
+// so analysis jobs can work through a consistent image of the log while
+// writes carry on. Nothing is copied, and writers aren't blocked.
+//
+// That comes at a cost to the file. Bbolt can't reuse any page freed
+// after the snapshot was taken until it's closed, so while it's open the
+// file grows by everything written, and the freelist, which is written on
+// every commit, grows with every page freed. A write that needs to grow
+// the memory map waits until it's closed. So a snapshot must always be
+// closed, and it's ended anyway once it's older than its max age, after
+// which it returns ErrSnapshotExpired. Close, Compact and Grow end any
+// snapshots that are open, after which they return ErrClosed.
+//
+// A snapshot can be used from several goroutines at once.
+type StoreSnapshot struct {
+	// lock is held for reading by every read, and for writing to end the
+	// transaction, so it can't end in the middle of one.
+	lock sync.RWMutex
+
+	rtx     readTx
+	done    func()
+	untrack func()
+
+	// err is returned by every read once the transaction has ended.
+	err error
+
+	// timer ends the snapshot at its max age. It's nil for the snapshot
+	// behind View, which ends when fn returns.
+	timer *time.Timer
+}
+
+// Snapshot returns a read-only view of the store as it is now, see
+// StoreSnapshot. It's ended after maxAge, or DefaultSnapshotMaxAge if
+// maxAge isn't positive. If Options.StablePath is set the stable store is
+// pinned by a transaction of its own.
+func (b *BoltStore) Snapshot(maxAge time.Duration) (*StoreSnapshot, error) {
+	if maxAge <= 0 {
+		maxAge = DefaultSnapshotMaxAge
+	}
+	tx, err := b.begin(false)
+	if err != nil {
+		return nil, err
+	}
+	conf, done, err := b.viewConf(tx)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	s := &StoreSnapshot{rtx: readTx{store: b, tx: tx, conf: conf}, done: done}
+	s.untrack = b.trackRead("Snapshot", s, s.end)
+	s.timer = time.AfterFunc(maxAge, func() {
+		if s.end(ErrSnapshotExpired) {
+			b.logger.Warn("ended store snapshot that was open for longer than its max age", "path", b.path, "max_age", maxAge)
+		}
+	})
+	return s, nil
+}
+
+// end ends the snapshot's transaction, after which reads return err,
+// returning false if it had already ended.
+func (s *StoreSnapshot) end(err error) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.err != nil {
+		return false
+	}
+	s.err = err
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.rtx.tx.Rollback()
+	s.done()
+	s.untrack()
+	return true
+}
+
+// Close ends the snapshot. It's safe to call more than once, and after
+// the snapshot has expired.
+func (s *StoreSnapshot) Close() error {
+	s.end(ErrTxDone)
+	return nil
+}
+
+// read runs fn with the snapshot's transaction, unless it has ended.
+func (s *StoreSnapshot) read(fn func(tx *readTx) error) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.err != nil {
+		return s.err
+	}
+	return fn(&s.rtx)
+}
+
+// FirstIndex is like BoltStore.FirstIndex.
+func (s *StoreSnapshot) FirstIndex() (first uint64, err error) {
+	err = s.read(func(tx *readTx) error {
+		first, err = tx.FirstIndex()
+		return err
+	})
+	return first, err
+}
+
+// LastIndex is like BoltStore.LastIndex.
+func (s *StoreSnapshot) LastIndex() (last uint64, err error) {
+	err = s.read(func(tx *readTx) error {
+		last, err = tx.LastIndex()
+		return err
+	})
+	return last, err
+}
+
+// GetLog is like BoltStore.GetLog.
+func (s *StoreSnapshot) GetLog(idx uint64, log *raft.Log) error {
+	return s.read(func(tx *readTx) error {
+		return tx.GetLog(idx, log)
+	})
+}
+
+// Get is like BoltStore.Get.
+func (s *StoreSnapshot) Get(k []byte) (val []byte, err error) {
+	err = s.read(func(tx *readTx) error {
+		val, err = tx.Get(k)
+		return err
+	})
+	return val, err
+}
+
+// GetUint64 is like BoltStore.GetUint64.
+func (s *StoreSnapshot) GetUint64(key []byte) (val uint64, err error) {
+	err = s.read(func(tx *readTx) error {
+		val, err = tx.GetUint64(key)
+		return err
+	})
+	return val, err
+}
+
+// Iterator is like BoltStore.Iterator, but iterates over the snapshot.
+// The iterator stops with the snapshot's error if the snapshot ends
+// first, and closing it leaves the snapshot open.
+func (s *StoreSnapshot) Iterator(min, max uint64) (*LogIterator, error) {
+	var it *LogIterator
+	err := s.read(func(tx *readTx) error {
+		logs, err := tx.store.logs(tx.tx)
+		if err != nil {
+			return err
+		}
+		it = &LogIterator{tx: tx.tx, logs: logs, curs: logs.cursor(), min: min, max: max, snap: s}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return it, nil
+}
@@ -0,0 +1,198 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestBoltStore_Snapshot(t *testing.T) {
+	// Writes that need to grow the memory map would wait for the
+	// snapshot to be closed
+	store, err := New(Options{Path: filepath.Join(t.TempDir(), "raft.db"), InitialMmapSize: 16 << 20})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	for i := uint64(1); i <= 10; i++ {
+		if err := store.StoreLog(testRaftLog(i, "old")); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	if err := store.SetUint64(keyCurrentTerm, 1); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	snap, err := store.Snapshot(0)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer snap.Close()
+
+	// Writes carry on without being seen by the snapshot
+	if err := store.DeleteRange(1, 5); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.StoreLog(testRaftLog(11, "new")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.SetUint64(keyCurrentTerm, 2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	first, err := snap.FirstIndex()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	last, err := snap.LastIndex()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if first != 1 || last != 10 {
+		t.Fatalf("bad: %d %d", first, last)
+	}
+	term, err := snap.GetUint64(keyCurrentTerm)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if term != 1 {
+		t.Fatalf("bad: %d", term)
+	}
+	var log raft.Log
+	if err := snap.GetLog(11, &log); err != raft.ErrLogNotFound {
+		t.Fatalf("err: %v", err)
+	}
+
+	it, err := snap.Iterator(1, 100)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	n := 0
+	for it.Next() {
+		n++
+		if string(it.Log().Data) != "old" {
+			t.Fatalf("bad: %q", it.Log().Data)
+		}
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	it.Close()
+	if n != 10 {
+		t.Fatalf("bad: %d", n)
+	}
+
+	// Closing the iterator leaves the snapshot open, closing the
+	// snapshot stops it
+	if err := snap.GetLog(1, &log); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	it, err = snap.Iterator(1, 100)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer it.Close()
+	snap.Close()
+	snap.Close()
+	if it.Next() || it.Err() != ErrTxDone {
+		t.Fatalf("err: %v", it.Err())
+	}
+	if err := snap.GetLog(1, &log); err != ErrTxDone {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestBoltStore_Snapshot_MaxAge(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	snap, err := store.Snapshot(10 * time.Millisecond)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer snap.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := snap.LastIndex()
+		if err == ErrSnapshotExpired {
+			break
+		}
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("snapshot didn't expire")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// The store can be closed once the snapshot has ended
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestBoltStore_Snapshot_StoreClosed(t *testing.T) {
+	store := testBoltStore(t)
+	defer os.Remove(store.path)
+
+	snap, err := store.Snapshot(time.Hour)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer snap.Close()
+
+	// Close doesn't wait for the snapshot to expire
+	done := make(chan error, 1)
+	go func() { done <- store.Close() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("close waited for the snapshot")
+	}
+	if _, err := snap.LastIndex(); err != ErrClosed {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestBoltStore_Snapshot_Compact(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	snap, err := store.Snapshot(time.Hour)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer snap.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- store.Compact(context.Background()) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("compact waited for the snapshot")
+	}
+	if _, err := snap.LastIndex(); err != ErrClosed {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := store.LastIndex(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
@@ -0,0 +1,326 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"go.etcd.io/bbolt"
+)
+
+var (
+	// ErrTxDone is returned when a StoreTx or ReadTx is used after the
+	// function it was passed to has returned.
+	ErrTxDone = errors.New("transaction has finished")
+
+	// errStableTx is returned by a StoreTx asked to use the stable store
+	// when it's kept in a file of its own, as one transaction can't span
+	// both files.
+	errStableTx = fmt.Errorf("%w: StoreTx can't use the stable store when StablePath is set", ErrInvalidOptions)
+)
+
+// ReadTx is a read transaction on the store, passed to the function given
+// to View. Everything read through it comes from the same consistent view
+// of the store.
+type ReadTx interface {
+	// FirstIndex is like BoltStore.FirstIndex.
+	FirstIndex() (uint64, error)
+
+	// LastIndex is like BoltStore.LastIndex.
+	LastIndex() (uint64, error)
+
+	// GetLog is like BoltStore.GetLog.
+	GetLog(idx uint64, log *raft.Log) error
+
+	// Get is like BoltStore.Get.
+	Get(k []byte) ([]byte, error)
+
+	// GetUint64 is like BoltStore.GetUint64.
+	GetUint64(key []byte) (uint64, error)
+}
+
+// WriteTx is what can be done with a StoreTx, for helpers that compose
+// multi-step operations without depending on it directly. Reads see the
+// writes made earlier in the transaction.
+type WriteTx interface {
+	ReadTx
+
+	// StoreLog is like BoltStore.StoreLog.
+	StoreLog(log *raft.Log) error
+
+	// StoreLogs is like BoltStore.StoreLogs.
+	StoreLogs(logs []*raft.Log) error
+
+	// DeleteRange is like BoltStore.DeleteRange.
+	DeleteRange(min, max uint64) error
+
+	// Set is like BoltStore.Set.
+	Set(k, v []byte) error
+
+	// SetUint64 is like BoltStore.SetUint64.
+	SetUint64(key []byte, val uint64) error
+
+	// Delete is like BoltStore.Delete.
+	Delete(k []byte) error
+}
+
+var _ WriteTx = (*StoreTx)(nil)
+
+// readTx is the ReadTx passed to the function given to View, and the
+// reading half of a StoreTx.
+type readTx struct {
+	store *BoltStore
+	tx    *bbolt.Tx
+	done  bool
+
+	// conf is the transaction holding the stable store, which is tx
+	// unless Options.StablePath is set. It's nil if the stable store
+	// can't be used.
+	conf *bbolt.Tx
+}
+
+// StoreTx is a write transaction on the store, passed to the function
+// given to Update. Everything done through it is committed together, or
+// not at all. It must only be used by the goroutine running that
+// function.
+type StoreTx struct {
+	readTx
+
+	// wroteLogs is set once the logs bucket has been written to.
+	wroteLogs bool
+
+	// encoders back the keys and values written by StoreLogs until the
+	// transaction has finished.
+	encoders []*logEncoder
+}
+
+// Update runs fn in a write transaction, and commits everything it did
+// if it returns nil. This makes it possible to write log entries and
+// stable store keys atomically, e.g. a bootstrap configuration together
+// with the current term. The stable store can't be used through it if
+// Options.StablePath is set. Other writes wait for fn, so it should be
+// quick. Hooks aren't called for the individual operations. Helpers can
+// take a WriteTx rather than a *StoreTx.
+func (b *BoltStore) Update(fn func(tx *StoreTx) error) error {
+	defer b.metrics.measureSince([]string{"update"}, time.Now())
+
+	stx := &StoreTx{readTx: readTx{store: b}}
+	defer stx.release()
+	_, err := b.update("Update", func(tx *bbolt.Tx) error {
+		stx.tx = tx
+		if b.stable == nil {
+			stx.conf = tx
+		}
+		defer func() { stx.done = true }()
+		return fn(stx)
+	})
+	return err
+}
+
+// View runs fn in a read transaction, so everything it reads comes from
+// the same consistent view of the store, without blocking writers. If
+// Options.StablePath is set the stable store is read from a transaction
+// of its own. Long running transactions stop Bbolt reusing pages freed
+// since they started, so fn should be quick. If the store is closed,
+// compacted or grown while fn is running, its reads return ErrClosed from
+// then on.
+func (b *BoltStore) View(fn func(tx ReadTx) error) error {
+	defer b.metrics.measureSince([]string{"view"}, time.Now())
+
+	tx, err := b.begin(false)
+	if err != nil {
+		return err
+	}
+	conf, done, err := b.viewConf(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Reads go through a snapshot so that they can be ended if the store
+	// is closed while fn is running
+	s := &StoreSnapshot{rtx: readTx{store: b, tx: tx, conf: conf}, done: done}
+	s.untrack = b.trackRead("View", nil, s.end)
+	defer s.end(ErrTxDone)
+	return fn(s)
+}
+
+// FirstIndex is like BoltStore.FirstIndex.
+func (t *readTx) FirstIndex() (uint64, error) {
+	if t.done {
+		return 0, ErrTxDone
+	}
+	logs, err := t.store.logs(t.tx)
+	if err != nil {
+		return 0, err
+	}
+	first, _ := logs.cursor().First()
+	if first == nil {
+		return 0, nil
+	}
+	return bytesToUint64(first), nil
+}
+
+// LastIndex is like BoltStore.LastIndex.
+func (t *readTx) LastIndex() (uint64, error) {
+	if t.done {
+		return 0, ErrTxDone
+	}
+	logs, err := t.store.logs(t.tx)
+	if err != nil {
+		return 0, err
+	}
+	last, _ := logs.cursor().Last()
+	if last == nil {
+		return 0, nil
+	}
+	return bytesToUint64(last), nil
+}
+
+// GetLog is like BoltStore.GetLog, but is always read from the file
+// rather than the log cache.
+func (t *readTx) GetLog(idx uint64, log *raft.Log) error {
+	if t.done {
+		return ErrTxDone
+	}
+	logs, err := t.store.logs(t.tx)
+	if err != nil {
+		return err
+	}
+	val := logs.get(idx)
+	if val == nil {
+		return raft.ErrLogNotFound
+	}
+	if err := logs.decode(idx, val, log); err != nil {
+		return fmt.Errorf("%w at index %d: %w", ErrLogCorrupt, idx, err)
+	}
+	return nil
+}
+
+// Get is like BoltStore.Get.
+func (t *readTx) Get(k []byte) ([]byte, error) {
+	if t.done {
+		return nil, ErrTxDone
+	}
+	if t.conf == nil {
+		return nil, errStableTx
+	}
+	bucket, err := t.store.bucket(t.conf, dbConf)
+	if err != nil {
+		return nil, err
+	}
+	val, err := t.store.getConf(bucket, k)
+	if err != nil {
+		return nil, err
+	}
+	if val == nil {
+		return nil, ErrKeyNotFound
+	}
+	return val, nil
+}
+
+// GetUint64 is like BoltStore.GetUint64.
+func (t *readTx) GetUint64(key []byte) (uint64, error) {
+	val, err := t.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return valueToUint64(key, val)
+}
+
+// release returns the transaction's encoders to their pool.
+func (t *StoreTx) release() {
+	for _, enc := range t.encoders {
+		enc.release()
+	}
+	t.encoders = nil
+}
+
+// StoreLog is like BoltStore.StoreLog.
+func (t *StoreTx) StoreLog(log *raft.Log) error {
+	return t.StoreLogs([]*raft.Log{log})
+}
+
+// StoreLogs is like BoltStore.StoreLogs.
+func (t *StoreTx) StoreLogs(logs []*raft.Log) error {
+	if t.done {
+		return ErrTxDone
+	}
+	enc := getLogEncoder(t.store.msgpackUseNewTimeFormat)
+	t.encoders = append(t.encoders, enc)
+	t.wroteLogs = true
+	_, err := t.store.putLogs(t.tx, enc, logs)
+	return err
+}
+
+// DeleteRange is like BoltStore.DeleteRange.
+func (t *StoreTx) DeleteRange(min, max uint64) error {
+	if t.done {
+		return ErrTxDone
+	}
+	b := t.store
+	bucket, err := b.logs(t.tx)
+	if err != nil {
+		return err
+	}
+	bucket.modified = t.wroteLogs
+	t.wroteLogs = true
+	if err := b.archiveRange(bucket, min, max); err != nil {
+		return err
+	}
+	_, bytesDeleted, err := bucket.deleteRange(min, max)
+	if err != nil {
+		return err
+	}
+	b.freed(t.tx, bytesDeleted)
+	seq := b.trackIndexes(t.tx, bucket)
+	b.cache.cacheWrite(t.tx, seq, true, min, max, nil)
+	return nil
+}
+
+// Set is like BoltStore.Set, so it returns ErrReservedKey for the
+// store's internal keys.
+func (t *StoreTx) Set(k, v []byte) error {
+	if t.done {
+		return ErrTxDone
+	}
+	if t.conf == nil {
+		return errStableTx
+	}
+	if err := checkKey(k); err != nil {
+		return err
+	}
+	bucket, err := t.store.bucket(t.conf, dbConf)
+	if err != nil {
+		return err
+	}
+	return t.store.putConf(bucket, k, v)
+}
+
+// Delete is like BoltStore.Delete, so it returns ErrReservedKey for the
+// store's internal keys.
+func (t *StoreTx) Delete(k []byte) error {
+	if t.done {
+		return ErrTxDone
+	}
+	if t.conf == nil {
+		return errStableTx
+	}
+	if err := checkKey(k); err != nil {
+		return err
+	}
+	bucket, err := t.store.bucket(t.conf, dbConf)
+	if err != nil {
+		return err
+	}
+	return bucket.Delete(k)
+}
+
+// SetUint64 is like BoltStore.SetUint64.
+func (t *StoreTx) SetUint64(key []byte, val uint64) error {
+	return t.Set(key, uint64ToBytes(val))
+}
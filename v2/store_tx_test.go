@@ -0,0 +1,190 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestBoltStore_Update(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	var logs []*raft.Log
+	for i := uint64(1); i <= 10; i++ {
+		logs = append(logs, testRaftLog(i, "data"))
+	}
+	var saved *StoreTx
+	err := store.Update(func(tx *StoreTx) error {
+		saved = tx
+		if err := tx.StoreLogs(logs); err != nil {
+			return err
+		}
+		// Deletes see the entries written earlier in the transaction
+		if err := tx.DeleteRange(1, 3); err != nil {
+			return err
+		}
+		if err := tx.DeleteRange(9, 10); err != nil {
+			return err
+		}
+		if err := tx.SetUint64([]byte("CurrentTerm"), 5); err != nil {
+			return err
+		}
+		return tx.Set([]byte("foo"), []byte("bar"))
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	checkIndexes(t, store, 4, 8)
+	checkLogs(t, store, 4, 8)
+	if term, err := store.GetUint64([]byte("CurrentTerm")); err != nil || term != 5 {
+		t.Fatalf("bad: %d %v", term, err)
+	}
+	if err := saved.Set([]byte("foo"), []byte("baz")); err != ErrTxDone {
+		t.Fatalf("expected done error, got: %v", err)
+	}
+
+	// Nothing is written if the function fails
+	errFailed := errors.New("failed")
+	err = store.Update(func(tx *StoreTx) error {
+		if err := tx.StoreLog(testRaftLog(9, "data")); err != nil {
+			return err
+		}
+		if err := tx.Set([]byte("foo"), []byte("baz")); err != nil {
+			return err
+		}
+		return errFailed
+	})
+	if err != errFailed {
+		t.Fatalf("expected failed error, got: %v", err)
+	}
+	checkIndexes(t, store, 4, 8)
+	if val, err := store.Get([]byte("foo")); err != nil || string(val) != "bar" {
+		t.Fatalf("bad: %q %v", val, err)
+	}
+
+	// The store's internal keys can't be written
+	key := []byte("raftboltdb.test")
+	err = store.Update(func(tx *StoreTx) error {
+		return tx.Set(key, []byte("bad"))
+	})
+	if !errors.Is(err, ErrReservedKey) {
+		t.Fatalf("err: %v", err)
+	}
+	err = store.Update(func(tx *StoreTx) error {
+		return tx.Delete(key)
+	})
+	if !errors.Is(err, ErrReservedKey) {
+		t.Fatalf("err: %v", err)
+	}
+
+	store.Close()
+	if err := store.Update(func(*StoreTx) error { return nil }); err != ErrClosed {
+		t.Fatalf("expected closed error, got: %v", err)
+	}
+}
+
+func TestBoltStore_View(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	// Reads in an update see its earlier writes
+	err := store.Update(func(tx *StoreTx) error {
+		return appendWithTerm(tx, testRaftLog(1, "first"), 2)
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var saved ReadTx
+	err = store.View(func(tx ReadTx) error {
+		saved = tx
+		first, err := tx.FirstIndex()
+		if err != nil {
+			return err
+		}
+		last, err := tx.LastIndex()
+		if err != nil {
+			return err
+		}
+		if first != 1 || last != 1 {
+			t.Fatalf("bad: %d %d", first, last)
+		}
+		var log raft.Log
+		if err := tx.GetLog(1, &log); err != nil {
+			return err
+		}
+		if string(log.Data) != "first" {
+			t.Fatalf("bad: %q", log.Data)
+		}
+		if err := tx.GetLog(2, &log); err != raft.ErrLogNotFound {
+			t.Fatalf("err: %v", err)
+		}
+		term, err := tx.GetUint64(keyCurrentTerm)
+		if err != nil {
+			return err
+		}
+		if term != 2 {
+			t.Fatalf("bad: %d", term)
+		}
+		if _, err := tx.Get([]byte("missing")); err != ErrKeyNotFound {
+			t.Fatalf("err: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := saved.LastIndex(); err != ErrTxDone {
+		t.Fatalf("expected done error, got: %v", err)
+	}
+
+	// A failed append leaves nothing behind
+	errFailed := errors.New("failed")
+	err = store.Update(func(tx *StoreTx) error {
+		if err := appendWithTerm(tx, testRaftLog(2, "second"), 3); err != nil {
+			return err
+		}
+		return errFailed
+	})
+	if err != errFailed {
+		t.Fatalf("err: %v", err)
+	}
+	if term, err := store.GetUint64(keyCurrentTerm); err != nil || term != 2 {
+		t.Fatalf("bad: %d %v", term, err)
+	}
+}
+
+// appendWithTerm appends log and raises the current term to term, unless
+// it's already higher, using only the WriteTx API.
+func appendWithTerm(tx WriteTx, log *raft.Log, term uint64) error {
+	last, err := tx.LastIndex()
+	if err != nil {
+		return err
+	}
+	if log.Index != last+1 {
+		return errors.New("not an append")
+	}
+	if err := tx.StoreLog(log); err != nil {
+		return err
+	}
+	var stored raft.Log
+	if err := tx.GetLog(log.Index, &stored); err != nil {
+		return err
+	}
+	cur, err := tx.GetUint64(keyCurrentTerm)
+	if err != nil && err != ErrKeyNotFound {
+		return err
+	}
+	if cur >= term {
+		return nil
+	}
+	return tx.SetUint64(keyCurrentTerm, term)
+}
@@ -0,0 +1,434 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package storetest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+const (
+	// defaultSimulatorMaxBatch is the default for
+	// SimulatorOptions.MaxBatch.
+	defaultSimulatorMaxBatch = 32
+
+	// defaultSimulatorMaxDataSize is the default for
+	// SimulatorOptions.MaxDataSize.
+	defaultSimulatorMaxDataSize = 1024
+
+	// simulatorLargeData is the size of the entries written now and then
+	// that are much larger than the rest.
+	simulatorLargeData = 256 * 1024
+
+	// simulatorCheckInterval is how many steps Run takes between
+	// comparing the whole store with the model.
+	simulatorCheckInterval = 64
+
+	// simulatorKeys is how many keys the Set and SetUint64 operations
+	// are spread over.
+	simulatorKeys = 4
+)
+
+// LogStableStore is a raft log store that's also its stable store, which
+// is what Simulator drives.
+type LogStableStore interface {
+	raft.LogStore
+	raft.StableStore
+}
+
+// SimulatorOptions configures a Simulator.
+type SimulatorOptions struct {
+	// Seed seeds the random choice of operations, so that a failing run
+	// can be repeated.
+	Seed int64
+
+	// MaxBatch is the most entries written by a single StoreLogs call.
+	// Defaults to 32.
+	MaxBatch int
+
+	// MaxDataSize is the largest Data of most entries written. Defaults
+	// to 1024. One entry in a hundred is 256KiB regardless, to exercise
+	// how stores handle large entries.
+	MaxDataSize int
+
+	// Logf, if not nil, is called with each operation as it's run, such
+	// as with testing.T.Logf.
+	Logf func(format string, args ...interface{})
+}
+
+// SimulationError is returned by a Simulator when the store disagrees
+// with the model.
+type SimulationError struct {
+	// Seed is SimulatorOptions.Seed, with which the run can be repeated.
+	Seed int64
+
+	// Step counts the operations run so far, including the one that
+	// failed.
+	Step int
+
+	// Op describes the operation that failed.
+	Op string
+
+	// Err is what went wrong.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *SimulationError) Error() string {
+	return fmt.Sprintf("seed %d, step %d: %s: %v", e.Seed, e.Step, e.Op, e.Err)
+}
+
+// Unwrap returns Err.
+func (e *SimulationError) Unwrap() error {
+	return e.Err
+}
+
+// Simulator runs a random sequence of the operations raft makes against
+// a store, and checks every result against a simple in-memory model of
+// how a store behaves. Entries are appended in batches, the head of the
+// log is deleted as raft does after a snapshot, and its tail is replaced
+// with entries from a later term as raft does when a follower's log
+// conflicts with the leader's. Entries are read from inside and just
+// outside the log, and stable store keys are set and read, including
+// ones that were never set.
+//
+// The store is shared with whatever else the caller does with it, so it
+// can be, for example, a wrapper around a store that's being closed and
+// reopened between calls to Run. It must not be changed other than
+// through the Simulator.
+type Simulator struct {
+	store LogStableStore
+	opts  SimulatorOptions
+	rng   *rand.Rand
+	step  int
+
+	// The model: the entries in the log, its first and last index, the
+	// index the next entry is appended at, the current term, and the
+	// values of the stable store keys
+	logs        map[uint64]*raft.Log
+	first, last uint64
+	next        uint64
+	term        uint64
+	values      map[string][]byte
+	uint64s     map[string]uint64
+}
+
+// NewSimulator returns a Simulator driving store, which must be empty.
+func NewSimulator(store LogStableStore, opts SimulatorOptions) *Simulator {
+	if opts.MaxBatch <= 0 {
+		opts.MaxBatch = defaultSimulatorMaxBatch
+	}
+	if opts.MaxDataSize <= 0 {
+		opts.MaxDataSize = defaultSimulatorMaxDataSize
+	}
+	return &Simulator{
+		store:   store,
+		opts:    opts,
+		rng:     rand.New(rand.NewSource(opts.Seed)),
+		logs:    make(map[uint64]*raft.Log),
+		next:    1,
+		term:    1,
+		values:  make(map[string][]byte),
+		uint64s: make(map[string]uint64),
+	}
+}
+
+// Run runs steps random operations, comparing the whole store with the
+// model every so often and once they're done. It returns a
+// *SimulationError for the first disagreement, after which the
+// Simulator can't be used again.
+func (s *Simulator) Run(steps int) error {
+	for i := 0; i < steps; i++ {
+		s.step++
+		op, err := s.runStep()
+		if err != nil {
+			return &SimulationError{Seed: s.opts.Seed, Step: s.step, Op: op, Err: err}
+		}
+		if s.step%simulatorCheckInterval == 0 {
+			if err := s.Check(); err != nil {
+				return err
+			}
+		}
+	}
+	return s.Check()
+}
+
+// Check compares everything in the store with the model, returning a
+// *SimulationError if they disagree.
+func (s *Simulator) Check() error {
+	s.logf("Check()")
+	err := s.checkIndexes()
+	for idx := s.first; err == nil && s.last != 0 && idx <= s.last; idx++ {
+		err = s.checkLog(idx)
+	}
+	for k := 0; err == nil && k < simulatorKeys; k++ {
+		err = s.checkValue(fmt.Sprintf("key-%d", k))
+		if err == nil {
+			err = s.checkUint64(fmt.Sprintf("uint64-%d", k))
+		}
+	}
+	if err != nil {
+		return &SimulationError{Seed: s.opts.Seed, Step: s.step, Op: "Check", Err: err}
+	}
+	return nil
+}
+
+// runStep runs a random operation, returning a description of it.
+func (s *Simulator) runStep() (string, error) {
+	switch n := s.rng.Intn(100); {
+	case n < 30:
+		return s.append()
+	case n < 35 && s.last != 0:
+		return s.truncateTail()
+	case n < 40 && s.last != 0:
+		return s.deleteHead()
+	case n < 70:
+		idx := s.first + uint64(s.rng.Intn(int(s.last-s.first)+5))
+		if idx >= 2 {
+			idx -= 2
+		}
+		op := fmt.Sprintf("GetLog(%d)", idx)
+		s.logf("%s", op)
+		return op, s.checkLog(idx)
+	case n < 80:
+		k := fmt.Sprintf("key-%d", s.rng.Intn(simulatorKeys))
+		if s.rng.Intn(2) == 0 {
+			op := fmt.Sprintf("Get(%q)", k)
+			s.logf("%s", op)
+			return op, s.checkValue(k)
+		}
+		v := s.data(64)
+		op := fmt.Sprintf("Set(%q, %d bytes)", k, len(v))
+		s.logf("%s", op)
+		if err := s.store.Set([]byte(k), v); err != nil {
+			return op, err
+		}
+		s.values[k] = bytes.Clone(v)
+		return op, nil
+	case n < 90:
+		k := fmt.Sprintf("uint64-%d", s.rng.Intn(simulatorKeys))
+		if s.rng.Intn(2) == 0 {
+			op := fmt.Sprintf("GetUint64(%q)", k)
+			s.logf("%s", op)
+			return op, s.checkUint64(k)
+		}
+		v := s.rng.Uint64()
+		op := fmt.Sprintf("SetUint64(%q, %d)", k, v)
+		s.logf("%s", op)
+		if err := s.store.SetUint64([]byte(k), v); err != nil {
+			return op, err
+		}
+		s.uint64s[k] = v
+		return op, nil
+	default:
+		op := "FirstIndex() and LastIndex()"
+		s.logf("%s", op)
+		return op, s.checkIndexes()
+	}
+}
+
+// append writes a batch of entries at the end of the log.
+func (s *Simulator) append() (string, error) {
+	n := 1 + s.rng.Intn(s.opts.MaxBatch)
+	logs := make([]*raft.Log, n)
+	for i := range logs {
+		logs[i] = s.newLog(s.next + uint64(i))
+	}
+	op := fmt.Sprintf("StoreLogs(%d to %d)", s.next, s.next+uint64(n)-1)
+	s.logf("%s", op)
+	if err := s.store.StoreLogs(logs); err != nil {
+		return op, err
+	}
+	for _, log := range logs {
+		s.logs[log.Index] = copyLog(log)
+	}
+	if s.last == 0 {
+		s.first = s.next
+	}
+	s.next += uint64(n)
+	s.last = s.next - 1
+	return op, s.checkIndexes()
+}
+
+// truncateTail deletes entries from the end of the log and replaces them
+// with ones from a new term.
+func (s *Simulator) truncateTail() (string, error) {
+	from := s.first + uint64(s.rng.Int63n(int64(s.last-s.first+1)))
+	op := fmt.Sprintf("DeleteRange(%d, %d)", from, s.last)
+	s.logf("%s", op)
+	if err := s.store.DeleteRange(from, s.last); err != nil {
+		return op, err
+	}
+	s.deleteModel(from, s.last)
+	s.next = from
+	s.term++
+	if err := s.checkIndexes(); err != nil {
+		return op, err
+	}
+	return s.append()
+}
+
+// deleteHead deletes entries from the start of the log, sometimes all of
+// them.
+func (s *Simulator) deleteHead() (string, error) {
+	to := s.first + uint64(s.rng.Int63n(int64(s.last-s.first+1)))
+	if s.rng.Intn(10) == 0 {
+		to = s.last
+	}
+	op := fmt.Sprintf("DeleteRange(%d, %d)", s.first, to)
+	s.logf("%s", op)
+	if err := s.store.DeleteRange(s.first, to); err != nil {
+		return op, err
+	}
+	s.deleteModel(s.first, to)
+	return op, s.checkIndexes()
+}
+
+// deleteModel removes the entries from min to max from the model. They
+// must be at the start or the end of the log.
+func (s *Simulator) deleteModel(min, max uint64) {
+	for idx := min; idx <= max; idx++ {
+		delete(s.logs, idx)
+	}
+	switch {
+	case len(s.logs) == 0:
+		s.first, s.last = 0, 0
+	case min == s.first:
+		s.first = max + 1
+	default:
+		s.last = min - 1
+	}
+}
+
+// newLog returns a random entry for idx in the current term.
+func (s *Simulator) newLog(idx uint64) *raft.Log {
+	log := &raft.Log{
+		Index:      idx,
+		Term:       s.term,
+		Type:       raft.LogCommand,
+		AppendedAt: time.Unix(1700000000+int64(idx), 0),
+	}
+	if s.rng.Intn(20) == 0 {
+		log.Type = raft.LogNoop
+	}
+	if s.rng.Intn(100) == 0 {
+		log.Data = s.data(simulatorLargeData)
+	} else {
+		log.Data = s.data(s.opts.MaxDataSize)
+	}
+	if s.rng.Intn(4) == 0 {
+		log.Extensions = s.data(32)
+	}
+	return log
+}
+
+// data returns up to max random bytes, compressible as real entries
+// often are.
+func (s *Simulator) data(max int) []byte {
+	data := make([]byte, s.rng.Intn(max+1))
+	for i := range data {
+		data[i] = byte('a' + s.rng.Intn(4))
+	}
+	return data
+}
+
+// checkIndexes compares the store's first and last index with the
+// model.
+func (s *Simulator) checkIndexes() error {
+	first, err := s.store.FirstIndex()
+	if err != nil {
+		return fmt.Errorf("FirstIndex: %w", err)
+	}
+	last, err := s.store.LastIndex()
+	if err != nil {
+		return fmt.Errorf("LastIndex: %w", err)
+	}
+	if first != s.first || last != s.last {
+		return fmt.Errorf("expected indexes %d to %d, got %d to %d", s.first, s.last, first, last)
+	}
+	return nil
+}
+
+// checkLog compares the entry at idx with the model.
+func (s *Simulator) checkLog(idx uint64) error {
+	var got raft.Log
+	err := s.store.GetLog(idx, &got)
+	want, ok := s.logs[idx]
+	if !ok {
+		if !errors.Is(err, raft.ErrLogNotFound) {
+			return fmt.Errorf("GetLog(%d): expected raft.ErrLogNotFound, got %v", idx, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("GetLog(%d): %w", idx, err)
+	}
+	if got.Index != want.Index || got.Term != want.Term || got.Type != want.Type ||
+		!bytes.Equal(got.Data, want.Data) || !bytes.Equal(got.Extensions, want.Extensions) ||
+		!got.AppendedAt.Equal(want.AppendedAt) {
+		return fmt.Errorf("GetLog(%d): expected index %d, term %d, type %s, %d bytes of data, %d of extensions, got index %d, term %d, type %s, %d bytes of data, %d of extensions",
+			idx, want.Index, want.Term, want.Type, len(want.Data), len(want.Extensions),
+			got.Index, got.Term, got.Type, len(got.Data), len(got.Extensions))
+	}
+	return nil
+}
+
+// checkValue compares the value of k with the model.
+func (s *Simulator) checkValue(k string) error {
+	got, err := s.store.Get([]byte(k))
+	want, ok := s.values[k]
+	if !ok {
+		if err == nil || err.Error() != "not found" {
+			return fmt.Errorf("Get(%q): expected \"not found\", got %v", k, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Get(%q): %w", k, err)
+	}
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("Get(%q): expected %q, got %q", k, want, got)
+	}
+	return nil
+}
+
+// checkUint64 compares the uint64 value of k with the model.
+func (s *Simulator) checkUint64(k string) error {
+	got, err := s.store.GetUint64([]byte(k))
+	want, ok := s.uint64s[k]
+	if !ok {
+		if err == nil || err.Error() != "not found" {
+			return fmt.Errorf("GetUint64(%q): expected \"not found\", got %v", k, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("GetUint64(%q): %w", k, err)
+	}
+	if got != want {
+		return fmt.Errorf("GetUint64(%q): expected %d, got %d", k, want, got)
+	}
+	return nil
+}
+
+// logf passes an operation to SimulatorOptions.Logf.
+func (s *Simulator) logf(format string, args ...interface{}) {
+	if s.opts.Logf != nil {
+		s.opts.Logf("step %d: "+format, append([]interface{}{s.step}, args...)...)
+	}
+}
+
+// copyLog returns a copy of log that shares nothing with it, so the model
+// isn't changed by a store that modifies the entries it's given.
+func copyLog(log *raft.Log) *raft.Log {
+	out := *log
+	out.Data = bytes.Clone(log.Data)
+	out.Extensions = bytes.Clone(log.Extensions)
+	return &out
+}
@@ -0,0 +1,366 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package storetest checks that a raft.LogStore or raft.StableStore
+// behaves like the stores in raftboltdb, so that wrappers around them, such
+// as caching, metrics or encryption layers, and alternative backends can be
+// verified against the same expectations:
+//
+//	func TestMyStore(t *testing.T) {
+//		storetest.TestLogStore(t, func(t *testing.T) raft.LogStore {
+//			store := newMyStore(t.TempDir())
+//			t.Cleanup(func() { store.Close() })
+//			return store
+//		})
+//	}
+package storetest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// LogStoreFactory returns a new, empty log store for the test t. It's
+// called once for each subtest, and is responsible for cleaning up the
+// store, for example with t.Cleanup.
+type LogStoreFactory func(t *testing.T) raft.LogStore
+
+// StableStoreFactory returns a new, empty stable store for the test t, like
+// LogStoreFactory.
+type StableStoreFactory func(t *testing.T) raft.StableStore
+
+// TestLogStore runs a subtest of t for each of the expectations on a
+// raft.LogStore, with a new store from factory for each:
+//
+//   - An empty store has a first and last index of 0.
+//   - Entries are read back exactly as they were written, including their
+//     extensions and the time they were appended.
+//   - The first and last index follow the entries written and deleted,
+//     and return to 0 once every entry is deleted.
+//   - Reading an index that was never written or has been deleted returns
+//     raft.ErrLogNotFound, which raft relies on.
+//   - Writing an index that exists replaces the entry.
+//   - DeleteRange removes exactly the entries within the range, including
+//     both ends, and doesn't fail if some or all of them don't exist.
+//
+// Stores may share memory between the entries written, stored and read, as
+// BoltStore does with Options.CacheSize set, since raft never modifies an
+// entry once it's been written or read.
+func TestLogStore(t *testing.T, factory LogStoreFactory) {
+	t.Run("Empty", func(t *testing.T) {
+		store := factory(t)
+		checkIndexes(t, store, 0, 0)
+		var log raft.Log
+		if err := store.GetLog(1, &log); !errors.Is(err, raft.ErrLogNotFound) {
+			t.Fatalf("GetLog on an empty store: expected raft.ErrLogNotFound, got %v", err)
+		}
+	})
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		store := factory(t)
+		in := &raft.Log{
+			Index:      1,
+			Term:       2,
+			Type:       raft.LogConfiguration,
+			Data:       []byte("data"),
+			Extensions: []byte("extensions"),
+			AppendedAt: time.Unix(1700000000, 123456789),
+		}
+		if err := store.StoreLog(in); err != nil {
+			t.Fatalf("StoreLog: %s", err)
+		}
+		checkLog(t, store, in)
+		checkIndexes(t, store, 1, 1)
+	})
+
+	t.Run("StoreLogs", func(t *testing.T) {
+		store := factory(t)
+		logs := testLogs(10, 20)
+		if err := store.StoreLogs(logs); err != nil {
+			t.Fatalf("StoreLogs: %s", err)
+		}
+		checkIndexes(t, store, 10, 20)
+		for _, log := range logs {
+			checkLog(t, store, log)
+		}
+		if err := store.StoreLogs(nil); err != nil {
+			t.Fatalf("StoreLogs with no entries: %s", err)
+		}
+		checkIndexes(t, store, 10, 20)
+	})
+
+	t.Run("LargeEntry", func(t *testing.T) {
+		store := factory(t)
+		log := testLog(1)
+		log.Data = bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+		if err := store.StoreLog(log); err != nil {
+			t.Fatalf("StoreLog: %s", err)
+		}
+		checkLog(t, store, log)
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		store := factory(t)
+		if err := store.StoreLogs(append(testLogs(5, 6), testLogs(9, 10)...)); err != nil {
+			t.Fatalf("StoreLogs: %s", err)
+		}
+		var log raft.Log
+		for _, idx := range []uint64{0, 4, 7, 8, 11, math.MaxUint64} {
+			if err := store.GetLog(idx, &log); !errors.Is(err, raft.ErrLogNotFound) {
+				t.Fatalf("GetLog(%d): expected raft.ErrLogNotFound, got %v", idx, err)
+			}
+		}
+	})
+
+	t.Run("Overwrite", func(t *testing.T) {
+		store := factory(t)
+		if err := store.StoreLogs(testLogs(1, 3)); err != nil {
+			t.Fatalf("StoreLogs: %s", err)
+		}
+		log := testLog(2)
+		log.Term = 5
+		log.Data = []byte("replaced")
+		if err := store.StoreLog(log); err != nil {
+			t.Fatalf("StoreLog: %s", err)
+		}
+		checkLog(t, store, log)
+		checkIndexes(t, store, 1, 3)
+	})
+
+	t.Run("DeleteRange", func(t *testing.T) {
+		store := factory(t)
+		if err := store.StoreLogs(testLogs(1, 10)); err != nil {
+			t.Fatalf("StoreLogs: %s", err)
+		}
+
+		// A prefix, as raft does after a snapshot
+		deleteRange(t, store, 1, 3)
+		checkIndexes(t, store, 4, 10)
+
+		// A suffix, as raft does when a conflicting entry is found
+		deleteRange(t, store, 8, 10)
+		checkIndexes(t, store, 4, 7)
+
+		// Ranges that are partly or entirely missing
+		deleteRange(t, store, 1, 4)
+		deleteRange(t, store, 20, 30)
+		checkIndexes(t, store, 5, 7)
+
+		var log raft.Log
+		for idx := uint64(1); idx <= 10; idx++ {
+			err := store.GetLog(idx, &log)
+			if idx >= 5 && idx <= 7 {
+				if err != nil {
+					t.Fatalf("GetLog(%d): %s", idx, err)
+				}
+			} else if !errors.Is(err, raft.ErrLogNotFound) {
+				t.Fatalf("GetLog(%d) after deleting it: expected raft.ErrLogNotFound, got %v", idx, err)
+			}
+		}
+
+		deleteRange(t, store, 0, math.MaxUint64)
+		checkIndexes(t, store, 0, 0)
+
+		// The store is usable again once it's empty
+		if err := store.StoreLogs(testLogs(100, 101)); err != nil {
+			t.Fatalf("StoreLogs: %s", err)
+		}
+		checkIndexes(t, store, 100, 101)
+	})
+}
+
+// TestStableStore runs a subtest of t for each of the expectations on a
+// raft.StableStore, with a new store from factory for each:
+//
+//   - Reading a key that was never set with Get or GetUint64 returns an
+//     error whose message is "not found", which raft relies on.
+//   - Values are read back exactly as they were set, including empty
+//     values and keys and values containing any byte.
+//   - Setting a key that exists replaces its value.
+//   - SetUint64 and GetUint64 round-trip every uint64, and share the key
+//     space of Set and Get with values stored as 8 big-endian bytes.
+//     GetUint64 of a value of any other length returns an error rather
+//     than panicking.
+//   - Stored values share no memory with those set or read.
+func TestStableStore(t *testing.T, factory StableStoreFactory) {
+	t.Run("NotFound", func(t *testing.T) {
+		store := factory(t)
+		if _, err := store.Get([]byte("missing")); err == nil || err.Error() != "not found" {
+			t.Fatalf("Get of a missing key: expected \"not found\", got %v", err)
+		}
+		if _, err := store.GetUint64([]byte("missing")); err == nil || err.Error() != "not found" {
+			t.Fatalf("GetUint64 of a missing key: expected \"not found\", got %v", err)
+		}
+	})
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		store := factory(t)
+		values := map[string][]byte{
+			"key":        []byte("value"),
+			"empty":      {},
+			"\x00\xff\n": {0, 0xff, '\n'},
+		}
+		for k, v := range values {
+			if err := store.Set([]byte(k), v); err != nil {
+				t.Fatalf("Set(%q): %s", k, err)
+			}
+		}
+		for k, v := range values {
+			checkValue(t, store, []byte(k), v)
+		}
+	})
+
+	t.Run("Uint64", func(t *testing.T) {
+		store := factory(t)
+		values := map[string]uint64{"zero": 0, "one": 1, "max": math.MaxUint64}
+		for k, v := range values {
+			if err := store.SetUint64([]byte(k), v); err != nil {
+				t.Fatalf("SetUint64(%q): %s", k, err)
+			}
+		}
+		for k, v := range values {
+			got, err := store.GetUint64([]byte(k))
+			if err != nil {
+				t.Fatalf("GetUint64(%q): %s", k, err)
+			}
+			if got != v {
+				t.Fatalf("GetUint64(%q): expected %d, got %d", k, v, got)
+			}
+			checkValue(t, store, []byte(k), binary.BigEndian.AppendUint64(nil, v))
+		}
+
+		// Values set with Set are read as big-endian integers
+		if err := store.Set([]byte("raw"), []byte{0, 0, 0, 0, 0, 0, 1, 2}); err != nil {
+			t.Fatalf("Set: %s", err)
+		}
+		if got, err := store.GetUint64([]byte("raw")); err != nil || got != 258 {
+			t.Fatalf("GetUint64(\"raw\"): expected 258, got %d, %v", got, err)
+		}
+		for _, v := range [][]byte{{}, {1, 2, 3}, make([]byte, 9)} {
+			if err := store.Set([]byte("short"), v); err != nil {
+				t.Fatalf("Set: %s", err)
+			}
+			if _, err := store.GetUint64([]byte("short")); err == nil {
+				t.Fatalf("GetUint64 of a %d byte value: expected an error", len(v))
+			}
+		}
+	})
+
+	t.Run("Overwrite", func(t *testing.T) {
+		store := factory(t)
+		key := []byte("key")
+		for _, v := range []string{"first", "second", ""} {
+			if err := store.Set(key, []byte(v)); err != nil {
+				t.Fatalf("Set: %s", err)
+			}
+			checkValue(t, store, key, []byte(v))
+		}
+		for _, v := range []uint64{1, 2} {
+			if err := store.SetUint64(key, v); err != nil {
+				t.Fatalf("SetUint64: %s", err)
+			}
+			if got, err := store.GetUint64(key); err != nil || got != v {
+				t.Fatalf("GetUint64: expected %d, got %d, %v", v, got, err)
+			}
+		}
+	})
+
+	t.Run("Copies", func(t *testing.T) {
+		store := factory(t)
+		key := []byte("key")
+		in := []byte("value")
+		if err := store.Set(key, in); err != nil {
+			t.Fatalf("Set: %s", err)
+		}
+		in[0] = 'X'
+		key[0] = 'X'
+		key = []byte("key")
+		checkValue(t, store, key, []byte("value"))
+
+		out, err := store.Get(key)
+		if err != nil {
+			t.Fatalf("Get: %s", err)
+		}
+		out[0] = 'Y'
+		checkValue(t, store, key, []byte("value"))
+	})
+}
+
+// testLog returns an entry for idx with every field set.
+func testLog(idx uint64) *raft.Log {
+	return &raft.Log{
+		Index:      idx,
+		Term:       idx/4 + 1,
+		Type:       raft.LogCommand,
+		Data:       []byte(fmt.Sprintf("data %d", idx)),
+		Extensions: []byte(fmt.Sprintf("extensions %d", idx)),
+		AppendedAt: time.Unix(1700000000+int64(idx), 0),
+	}
+}
+
+// testLogs returns the entries from first to last.
+func testLogs(first, last uint64) []*raft.Log {
+	var logs []*raft.Log
+	for idx := first; idx <= last; idx++ {
+		logs = append(logs, testLog(idx))
+	}
+	return logs
+}
+
+// checkLog fails t unless the entry at want.Index in store matches want.
+func checkLog(t *testing.T, store raft.LogStore, want *raft.Log) {
+	t.Helper()
+	var got raft.Log
+	if err := store.GetLog(want.Index, &got); err != nil {
+		t.Fatalf("GetLog(%d): %s", want.Index, err)
+	}
+	if got.Index != want.Index || got.Term != want.Term || got.Type != want.Type ||
+		!bytes.Equal(got.Data, want.Data) || !bytes.Equal(got.Extensions, want.Extensions) ||
+		!got.AppendedAt.Equal(want.AppendedAt) {
+		t.Fatalf("GetLog(%d): expected %+v, got %+v", want.Index, *want, got)
+	}
+}
+
+// checkIndexes fails t unless store's first and last index are first and
+// last.
+func checkIndexes(t *testing.T, store raft.LogStore, first, last uint64) {
+	t.Helper()
+	gotFirst, err := store.FirstIndex()
+	if err != nil {
+		t.Fatalf("FirstIndex: %s", err)
+	}
+	gotLast, err := store.LastIndex()
+	if err != nil {
+		t.Fatalf("LastIndex: %s", err)
+	}
+	if gotFirst != first || gotLast != last {
+		t.Fatalf("expected indexes %d to %d, got %d to %d", first, last, gotFirst, gotLast)
+	}
+}
+
+// deleteRange deletes the entries from min to max, failing t on an error.
+func deleteRange(t *testing.T, store raft.LogStore, min, max uint64) {
+	t.Helper()
+	if err := store.DeleteRange(min, max); err != nil {
+		t.Fatalf("DeleteRange(%d, %d): %s", min, max, err)
+	}
+}
+
+// checkValue fails t unless the value of key in store is want.
+func checkValue(t *testing.T, store raft.StableStore, key, want []byte) {
+	t.Helper()
+	got, err := store.Get(key)
+	if err != nil {
+		t.Fatalf("Get(%q): %s", key, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("Get(%q): expected %q, got %q", key, want, got)
+	}
+}
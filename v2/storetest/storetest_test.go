@@ -0,0 +1,143 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package storetest
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+)
+
+// openStore returns a new store using engine and options, closed when t
+// finishes.
+func openStore(t *testing.T, engine raftboltdb.Engine, options raftboltdb.Options) raftboltdb.Store {
+	options.Path = filepath.Join(t.TempDir(), "raft.db")
+	options.NoSync = true
+	store, err := raftboltdb.Open(raftboltdb.Config{Engine: engine, Options: options})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestEngines(t *testing.T) {
+	for _, engine := range []raftboltdb.Engine{raftboltdb.EngineBbolt, raftboltdb.EngineBoltDB, raftboltdb.EngineInmem} {
+		engine := engine
+		t.Run(string(engine), func(t *testing.T) {
+			TestLogStore(t, func(t *testing.T) raft.LogStore { return openStore(t, engine, raftboltdb.Options{}) })
+			TestStableStore(t, func(t *testing.T) raft.StableStore { return openStore(t, engine, raftboltdb.Options{}) })
+		})
+	}
+}
+
+func TestBoltStoreOptions(t *testing.T) {
+	options := raftboltdb.Options{
+		Checksums:           true,
+		Compression:         raftboltdb.CompressionZstd,
+		OverflowThreshold:   64 * 1024,
+		LogSegmentSize:      4,
+		CacheSize:           8,
+		ParallelEncodeBytes: 1,
+	}
+	TestLogStore(t, func(t *testing.T) raft.LogStore { return openStore(t, raftboltdb.EngineBbolt, options) })
+	TestStableStore(t, func(t *testing.T) raft.StableStore { return openStore(t, raftboltdb.EngineBbolt, options) })
+}
+
+func TestSimulator(t *testing.T) {
+	for _, engine := range []raftboltdb.Engine{raftboltdb.EngineBbolt, raftboltdb.EngineBoltDB, raftboltdb.EngineInmem} {
+		engine := engine
+		t.Run(string(engine), func(t *testing.T) {
+			sim := NewSimulator(openStore(t, engine, raftboltdb.Options{}), SimulatorOptions{Seed: 1})
+			if err := sim.Run(2000); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+		})
+	}
+	t.Run("Options", func(t *testing.T) {
+		store := openStore(t, raftboltdb.EngineBbolt, raftboltdb.Options{
+			Checksums:         true,
+			Compression:       raftboltdb.CompressionSnappy,
+			OverflowThreshold: 64 * 1024,
+			LogSegmentSize:    16,
+			CacheSize:         32,
+			StrictAppend:      true,
+		})
+		sim := NewSimulator(store, SimulatorOptions{Seed: 2})
+		if err := sim.Run(2000); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	})
+}
+
+// offByOneStore deletes one entry too few from the end of each range.
+type offByOneStore struct {
+	LogStableStore
+}
+
+func (s offByOneStore) DeleteRange(min, max uint64) error {
+	return s.LogStableStore.DeleteRange(min, max-1)
+}
+
+func TestSimulator_FindsBugs(t *testing.T) {
+	store := offByOneStore{raftboltdb.NewInmemStore()}
+	err := NewSimulator(store, SimulatorOptions{Seed: 1}).Run(2000)
+	var simErr *SimulationError
+	if !errors.As(err, &simErr) || simErr.Seed != 1 || simErr.Step == 0 {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestStablePath(t *testing.T) {
+	TestStableStore(t, func(t *testing.T) raft.StableStore {
+		dir := t.TempDir()
+		store, err := raftboltdb.New(raftboltdb.Options{
+			Path:       filepath.Join(dir, "raft.db"),
+			StablePath: filepath.Join(dir, "stable.db"),
+			NoSync:     true,
+		})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	})
+}
+
+func TestMultiStore(t *testing.T) {
+	open := func(t *testing.T) *raftboltdb.GroupStore {
+		multi, err := raftboltdb.NewMultiStoreWithOptions(raftboltdb.Options{
+			Path:              filepath.Join(t.TempDir(), "raft.db"),
+			NoSync:            true,
+			BatchWrites:       true,
+			OverflowThreshold: 64 * 1024,
+		})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		t.Cleanup(func() { multi.Close() })
+
+		// Another group's writes mustn't show through
+		other, err := multi.Group("other")
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := other.StoreLog(&raft.Log{Index: 1, Data: []byte("other")}); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := other.Set([]byte("other"), []byte("value")); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		group, err := multi.Group("shard-42")
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		return group
+	}
+	TestLogStore(t, func(t *testing.T) raft.LogStore { return open(t) })
+	TestStableStore(t, func(t *testing.T) raft.StableStore { return open(t) })
+}
@@ -0,0 +1,63 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/raft"
+)
+
+var (
+	// ErrNonContiguous is returned by StoreLogs when Options.StrictAppend
+	// is set and a batch would leave a gap in the log or go back a term.
+	// Nothing in the batch is written.
+	ErrNonContiguous = errors.New("non-contiguous logs")
+)
+
+// checkAppend returns an error if writing logs to bucket would leave a
+// gap in the log, or a term lower than the one before it.
+func checkAppend(bucket *logBucket, logs []*raft.Log) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	for i := 1; i < len(logs); i++ {
+		prev, log := logs[i-1], logs[i]
+		if log.Index != prev.Index+1 {
+			return fmt.Errorf("%w: index %d follows %d in the batch", ErrNonContiguous, log.Index, prev.Index)
+		}
+		if log.Term < prev.Term {
+			return fmt.Errorf("%w: term %d at index %d follows term %d in the batch",
+				ErrNonContiguous, log.Term, log.Index, prev.Term)
+		}
+	}
+
+	// The batch may overwrite the end of the log, but mustn't start past
+	// it
+	first := logs[0]
+	lastKey, _ := bucket.cursor().Last()
+	if lastKey == nil {
+		return nil
+	}
+	if last := bytesToUint64(lastKey); first.Index > last+1 {
+		return fmt.Errorf("%w: index %d doesn't follow the last index %d", ErrNonContiguous, first.Index, last)
+	}
+	if first.Index == 0 {
+		return nil
+	}
+	val := bucket.get(first.Index - 1)
+	if val == nil {
+		return nil
+	}
+	var prev raft.Log
+	if err := decodeLog(first.Index-1, val, bucket.keys, &prev); err != nil {
+		return fmt.Errorf("%w at index %d: %w", ErrLogCorrupt, first.Index-1, err)
+	}
+	if first.Term < prev.Term {
+		return fmt.Errorf("%w: term %d at index %d follows term %d in the log",
+			ErrNonContiguous, first.Term, first.Index, prev.Term)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestBoltStore_StrictAppend(t *testing.T) {
+	store, err := New(Options{
+		Path:         filepath.Join(t.TempDir(), "raft.db"),
+		StrictAppend: true,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	log := func(idx, term uint64) *raft.Log {
+		log := testRaftLog(idx, "data")
+		log.Term = term
+		return log
+	}
+
+	// The first batch can start anywhere
+	if err := store.StoreLogs([]*raft.Log{log(10, 1), log(11, 1), log(12, 2)}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	bad := [][]*raft.Log{
+		{log(13, 2), log(15, 2)},
+		{log(13, 2), log(12, 2)},
+		{log(13, 3), log(14, 2)},
+		{log(14, 2)},
+		{log(11, 0)},
+	}
+	for _, logs := range bad {
+		if err := store.StoreLogs(logs); !errors.Is(err, ErrNonContiguous) {
+			t.Fatalf("expected non-contiguous error for %d, got: %v", logs[0].Index, err)
+		}
+	}
+	checkIndexes(t, store, 10, 12)
+
+	// Appending and overwriting the end of the log with a later term are
+	// both fine
+	if err := store.StoreLogs([]*raft.Log{log(13, 2), log(14, 2)}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.StoreLogs([]*raft.Log{log(13, 3)}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	checkIndexes(t, store, 10, 14)
+}
@@ -0,0 +1,56 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"fmt"
+	"time"
+)
+
+// SyncPolicy controls when committed writes are flushed to disk.
+type SyncPolicy struct {
+	// interval is how long writes wait for others to share their
+	// commit, and is only used if group is set.
+	interval time.Duration
+	group    bool
+	onDemand bool
+}
+
+var (
+	// SyncEveryWrite fsyncs as part of every commit. This is the default.
+	SyncEveryWrite = SyncPolicy{}
+
+	// SyncOnDemand never fsyncs as part of a commit. Writes are only
+	// durable once Sync has been called, or the store has been closed.
+	// This is only safe if durability is provided by another layer.
+	SyncOnDemand = SyncPolicy{onDemand: true}
+)
+
+// SyncInterval makes StoreLogs calls from concurrent goroutines wait up
+// to the interval for others to join them, and then commits them together
+// in a single transaction, with Bbolt's Batch. Every commit is still
+// fsynced, so each write is durable once it returns, and a power loss
+// can only lose writes that hadn't returned, but concurrent writers share
+// the cost of the fsync. Other writes are committed and synced on their
+// own. It's BatchWrites with MaxBatchDelay set to the interval.
+func SyncInterval(d time.Duration) SyncPolicy {
+	return SyncPolicy{interval: d, group: true}
+}
+
+// String returns a description of the policy.
+func (p SyncPolicy) String() string {
+	switch {
+	case p.group:
+		return fmt.Sprintf("interval(%s)", p.interval)
+	case p.onDemand:
+		return "on-demand"
+	default:
+		return "every-write"
+	}
+}
+
+// noSync returns true if commits should skip their own fsync.
+func (p SyncPolicy) noSync() bool {
+	return p.onDemand
+}
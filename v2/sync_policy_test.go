@@ -0,0 +1,191 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+func testSyncPolicyStore(t *testing.T, policy SyncPolicy) *BoltStore {
+	fh, err := ioutil.TempFile("", "bolt")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	os.Remove(fh.Name())
+
+	store, err := New(Options{Path: fh.Name(), SyncPolicy: policy})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return store
+}
+
+func TestSyncPolicy_Validate(t *testing.T) {
+	for _, options := range []Options{
+		{SyncPolicy: SyncInterval(0)},
+		{SyncPolicy: SyncInterval(-time.Second)},
+		{SyncPolicy: SyncOnDemand, NoSync: true},
+		{SyncPolicy: SyncInterval(time.Millisecond), MaxBatchDelay: time.Millisecond},
+	} {
+		if err := options.validate(); !errors.Is(err, ErrInvalidOptions) {
+			t.Fatalf("expected invalid options error for %s, got: %v", options.SyncPolicy, err)
+		}
+	}
+	if err := (&Options{SyncPolicy: SyncInterval(time.Millisecond)}).validate(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestSyncPolicy_EveryWrite(t *testing.T) {
+	store := testSyncPolicyStore(t, SyncEveryWrite)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	if store.conn.NoSync || store.batchWrites {
+		t.Fatalf("expected every commit to be synced")
+	}
+}
+
+func TestSyncPolicy_OnDemand(t *testing.T) {
+	store := testSyncPolicyStore(t, SyncOnDemand)
+	defer os.Remove(store.path)
+
+	if !store.conn.NoSync || store.batchWrites {
+		t.Fatalf("expected commits not to be synced")
+	}
+	if err := store.StoreLog(testRaftLog(1, "log1")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Sync(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	store, err := NewBoltStore(store.path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	if idx, err := store.LastIndex(); err != nil || idx != 1 {
+		t.Fatalf("bad: %d %v", idx, err)
+	}
+}
+
+func TestSyncPolicy_Interval(t *testing.T) {
+	store := testSyncPolicyStore(t, SyncInterval(20*time.Millisecond))
+	defer os.Remove(store.path)
+
+	// Commits are synced, so the file's pages are always written before
+	// the meta page that points at them
+	if store.conn.NoSync || !store.batchWrites || store.conn.MaxBatchDelay != 20*time.Millisecond {
+		t.Fatalf("expected batched, synced commits")
+	}
+
+	var commits []CommitInfo
+	var lock sync.Mutex
+	store.hooks.OnCommit = func(info CommitInfo) {
+		lock.Lock()
+		defer lock.Unlock()
+		commits = append(commits, info)
+	}
+
+	// Concurrent writers share a commit, which shows in how far Bbolt's
+	// transaction ID moves
+	txID := func() (id int) {
+		store.conn.View(func(tx *bbolt.Tx) error {
+			id = tx.ID()
+			return nil
+		})
+		return id
+	}
+	before := txID()
+	var wg sync.WaitGroup
+	for i := uint64(1); i <= 8; i++ {
+		wg.Add(1)
+		go func(i uint64) {
+			defer wg.Done()
+			if err := store.StoreLog(testRaftLog(i, "data")); err != nil {
+				t.Errorf("err: %s", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := txID() - before; n >= 8 {
+		t.Fatalf("bad: %d commits", n)
+	}
+
+	// Every write waited for the commit before returning
+	if len(commits) != 8 {
+		t.Fatalf("bad: %v", commits)
+	}
+	for _, info := range commits {
+		if info.Err != nil || info.Duration <= 0 {
+			t.Fatalf("bad: %#v", info)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	store, err := NewBoltStore(store.path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	if idx, err := store.LastIndex(); err != nil || idx != 8 {
+		t.Fatalf("bad: %d %v", idx, err)
+	}
+}
+
+func TestBoltStore_SetNoSync(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	if err := store.SetNoSync(true); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !store.conn.NoSync {
+		t.Fatalf("expected NoSync to be set")
+	}
+
+	// A bulk import, flushed explicitly
+	for i := uint64(1); i <= 10; i++ {
+		if err := store.StoreLog(testRaftLog(i, "data")); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	if err := store.Sync(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := store.SetNoSync(false); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if store.conn.NoSync {
+		t.Fatalf("expected NoSync to be cleared")
+	}
+
+	store.Close()
+	if err := store.SetNoSync(true); err != ErrClosed {
+		t.Fatalf("expected closed error, got: %v", err)
+	}
+
+	// The sync policy owns NoSync if it's set
+	grouped := testSyncPolicyStore(t, SyncOnDemand)
+	defer grouped.Close()
+	defer os.Remove(grouped.path)
+	if err := grouped.SetNoSync(false); !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("expected invalid options error, got: %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/raft"
+	"go.etcd.io/bbolt"
+)
+
+var (
+	// dbTerms holds the term index, see Options.TermIndex. Each key is a
+	// term and each value the first and last index of its entries.
+	dbTerms = []byte("terms")
+
+	// ErrNoTermIndex is returned by TermRange and Terms when the file
+	// doesn't have a term index.
+	ErrNoTermIndex = errors.New("term index not enabled")
+)
+
+// TermRange is the range of log indexes holding a term's entries.
+// Entries deleted from the middle of the range aren't accounted for.
+type TermRange struct {
+	Term  uint64
+	First uint64
+	Last  uint64
+}
+
+func encodeTermRange(first, last uint64) []byte {
+	return append(uint64ToBytes(first), uint64ToBytes(last)...)
+}
+
+func decodeTermRange(term, v []byte) (TermRange, error) {
+	if len(v) != 16 {
+		return TermRange{}, fmt.Errorf("%w: term index entry for term %d is %d bytes",
+			ErrLogCorrupt, bytesToUint64(term), len(v))
+	}
+	return TermRange{Term: bytesToUint64(term), First: bytesToUint64(v[:8]), Last: bytesToUint64(v[8:])}, nil
+}
+
+// TermRange returns the range of indexes holding the entries of a term,
+// or raft.ErrLogNotFound if there are none.
+func (b *BoltStore) TermRange(term uint64) (*TermRange, error) {
+	tx, err := b.begin(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	terms := tx.Bucket(dbTerms)
+	if terms == nil {
+		return nil, ErrNoTermIndex
+	}
+	key := uint64ToBytes(term)
+	v := terms.Get(key)
+	if v == nil {
+		return nil, raft.ErrLogNotFound
+	}
+	r, err := decodeTermRange(key, v)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// Terms returns the range of every term in the log, in term order, which
+// shows when the term changed.
+func (b *BoltStore) Terms() ([]TermRange, error) {
+	tx, err := b.begin(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	terms := tx.Bucket(dbTerms)
+	if terms == nil {
+		return nil, ErrNoTermIndex
+	}
+	var ranges []TermRange
+	err = terms.ForEach(func(k, v []byte) error {
+		r, err := decodeTermRange(k, v)
+		if err != nil {
+			return err
+		}
+		ranges = append(ranges, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ranges, nil
+}
+
+// indexTerms adds logs, which have just been written, to the term index
+// if there is one. Anything they overwrote is removed from it first.
+func (l *logBucket) indexTerms(logs []*raft.Log) error {
+	if l.terms == nil || len(logs) == 0 {
+		return nil
+	}
+	min, max := logs[0].Index, logs[0].Index
+	ranges := make(map[uint64]*TermRange)
+	for _, log := range logs {
+		if log.Index < min {
+			min = log.Index
+		}
+		if log.Index > max {
+			max = log.Index
+		}
+		r := ranges[log.Term]
+		if r == nil {
+			ranges[log.Term] = &TermRange{Term: log.Term, First: log.Index, Last: log.Index}
+			continue
+		}
+		if log.Index < r.First {
+			r.First = log.Index
+		}
+		if log.Index > r.Last {
+			r.Last = log.Index
+		}
+	}
+
+	// Terms only increase along the log, so the last term's range is the
+	// only one that needs checking for an overwrite
+	if k, v := cursorLast(l.terms.Cursor()); k != nil {
+		last, err := decodeTermRange(k, v)
+		if err != nil {
+			return err
+		}
+		if last.Last >= min {
+			if err := l.unindexRange(min, max); err != nil {
+				return err
+			}
+		}
+	}
+
+	for term, r := range ranges {
+		key := uint64ToBytes(term)
+		if v := l.terms.Get(key); v != nil {
+			cur, err := decodeTermRange(key, v)
+			if err != nil {
+				return err
+			}
+			if cur.First < r.First {
+				r.First = cur.First
+			}
+			if cur.Last > r.Last {
+				r.Last = cur.Last
+			}
+		}
+		if err := l.terms.Put(key, encodeTermRange(r.First, r.Last)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unindexRange removes the indexes from min to max inclusive from the
+// term index, if there is one.
+func (l *logBucket) unindexRange(min, max uint64) error {
+	if l.terms == nil {
+		return nil
+	}
+
+	// The bucket can't be changed while it's iterated over
+	var update []TermRange
+	err := l.terms.ForEach(func(k, v []byte) error {
+		r, err := decodeTermRange(k, v)
+		if err != nil {
+			return err
+		}
+		if r.Last < min || r.First > max {
+			return nil
+		}
+		switch {
+		case r.First >= min && r.Last <= max:
+			r.First, r.Last = 0, 0
+		case r.First >= min:
+			r.First = max + 1
+		case r.Last <= max:
+			r.Last = min - 1
+		default:
+			// A hole in the middle of the term
+			return nil
+		}
+		update = append(update, r)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, r := range update {
+		key := uint64ToBytes(r.Term)
+		if r.First == 0 && r.Last == 0 {
+			err = l.terms.Delete(key)
+		} else {
+			err = l.terms.Put(key, encodeTermRange(r.First, r.Last))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// initTermIndex creates the term index in tx from the entries already
+// in the log.
+func (b *BoltStore) initTermIndex(tx *bbolt.Tx) error {
+	terms, err := tx.CreateBucket(dbTerms)
+	if err != nil {
+		return err
+	}
+	bucket, err := b.logs(tx)
+	if err != nil {
+		return err
+	}
+
+	var cur *TermRange
+	put := func() error {
+		if cur == nil {
+			return nil
+		}
+		return terms.Put(uint64ToBytes(cur.Term), encodeTermRange(cur.First, cur.Last))
+	}
+	curs := bucket.cursor()
+	for k, v := curs.First(); k != nil; k, v = curs.Next() {
+		var log raft.Log
+		if err := decodeLog(bytesToUint64(k), v, bucket.keys, &log); err != nil {
+			return fmt.Errorf("%w at index %d: %w", ErrLogCorrupt, bytesToUint64(k), err)
+		}
+		if cur != nil && cur.Term == log.Term {
+			cur.Last = log.Index
+			continue
+		}
+		if err := put(); err != nil {
+			return err
+		}
+		cur = &TermRange{Term: log.Term, First: log.Index, Last: log.Index}
+	}
+	return put()
+}
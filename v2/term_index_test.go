@@ -0,0 +1,96 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func testTermLogs(min, max, term uint64) []*raft.Log {
+	var logs []*raft.Log
+	for i := min; i <= max; i++ {
+		log := testRaftLog(i, "data")
+		log.Term = term
+		logs = append(logs, log)
+	}
+	return logs
+}
+
+func checkTerms(t *testing.T, store *BoltStore, expected ...TermRange) {
+	t.Helper()
+	terms, err := store.Terms()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(terms) != len(expected) || (len(terms) > 0 && !reflect.DeepEqual(terms, expected)) {
+		t.Fatalf("bad: %v, expected %v", terms, expected)
+	}
+}
+
+func TestBoltStore_TermIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "raft.db")
+	store, err := New(Options{Path: path, TermIndex: true, LogSegmentSize: 16})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	if err := store.StoreLogs(testTermLogs(1, 10, 1)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	logs := append(testTermLogs(11, 20, 2), testTermLogs(21, 30, 4)...)
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	checkTerms(t, store, TermRange{1, 1, 10}, TermRange{2, 11, 20}, TermRange{4, 21, 30})
+
+	r, err := store.TermRange(2)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if *r != (TermRange{2, 11, 20}) {
+		t.Fatalf("bad: %v", r)
+	}
+	if _, err := store.TermRange(3); err != raft.ErrLogNotFound {
+		t.Fatalf("expected not found error, got: %v", err)
+	}
+
+	// Truncating and overwriting keep the index up to date
+	if err := store.DeleteRange(1, 12); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.StoreLogs(testTermLogs(26, 30, 5)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	checkTerms(t, store, TermRange{2, 13, 20}, TermRange{4, 21, 25}, TermRange{5, 26, 30})
+	if err := store.DeleteRange(27, 30); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	checkTerms(t, store, TermRange{2, 13, 20}, TermRange{4, 21, 25}, TermRange{5, 26, 26})
+
+	// The index is dropped when it isn't kept up to date, and rebuilt
+	// from the log when it's enabled again
+	store.Close()
+	store, err = New(Options{Path: path})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := store.Terms(); err != ErrNoTermIndex {
+		t.Fatalf("expected no index error, got: %v", err)
+	}
+	if err := store.StoreLogs(testTermLogs(27, 30, 6)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.Close()
+	store, err = New(Options{Path: path, TermIndex: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	checkTerms(t, store, TermRange{2, 13, 20}, TermRange{4, 21, 25}, TermRange{5, 26, 26}, TermRange{6, 27, 30})
+}
@@ -0,0 +1,50 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// tracerName identifies the spans created by this package.
+	tracerName = "github.com/hashicorp/raft-boltdb/v2"
+)
+
+// Span attribute keys.
+const (
+	attrBatchSize    = attribute.Key("raftboltdb.batch_size")
+	attrBytesWritten = attribute.Key("raftboltdb.bytes_written")
+	attrCommitTime   = attribute.Key("raftboltdb.commit_time_ms")
+	attrIndex        = attribute.Key("raftboltdb.index")
+	attrMinIndex     = attribute.Key("raftboltdb.min_index")
+	attrMaxIndex     = attribute.Key("raftboltdb.max_index")
+	attrKeySize      = attribute.Key("raftboltdb.key_size")
+	attrValueSize    = attribute.Key("raftboltdb.value_size")
+)
+
+// startSpan starts a span for a store operation. If tracing isn't
+// configured the returned span is a no-op.
+func (b *BoltStore) startSpan(name string, attrs ...attribute.KeyValue) trace.Span {
+	if b.tracer == nil {
+		return trace.SpanFromContext(context.Background())
+	}
+	_, span := b.tracer.Start(context.Background(), name,
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(attrs...))
+	return span
+}
+
+// endSpan records err on the span, if there was one, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,124 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func spanAttr(span sdktrace.ReadOnlySpan, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestBoltStore_Tracing(t *testing.T) {
+	fh, err := ioutil.TempFile("", "bolt")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	os.Remove(fh.Name())
+	defer os.Remove(fh.Name())
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	store, err := New(Options{Path: fh.Name(), TracerProvider: provider})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	logs := []*raft.Log{
+		testRaftLog(1, "log1"),
+		testRaftLog(2, "log2"),
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	var log raft.Log
+	if err := store.GetLog(2, &log); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.GetLog(3, &log); err != raft.ErrLogNotFound {
+		t.Fatalf("expected not found error, got: %v", err)
+	}
+	if err := store.DeleteRange(1, 2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Set([]byte("foo"), []byte("bar")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	spans := recorder.Ended()
+	expected := []string{
+		"raftboltdb.StoreLogs",
+		"raftboltdb.GetLog",
+		"raftboltdb.GetLog",
+		"raftboltdb.DeleteRange",
+		"raftboltdb.Set",
+	}
+	if len(spans) != len(expected) {
+		t.Fatalf("bad: %d spans", len(spans))
+	}
+	for i, name := range expected {
+		if spans[i].Name() != name {
+			t.Fatalf("bad span %d: %s", i, spans[i].Name())
+		}
+	}
+
+	storeLogs := spans[0]
+	if v, ok := spanAttr(storeLogs, attrBatchSize); !ok || v.AsInt64() != 2 {
+		t.Fatalf("bad batch size: %v", v)
+	}
+	if v, ok := spanAttr(storeLogs, attrBytesWritten); !ok || v.AsInt64() <= 0 {
+		t.Fatalf("bad bytes written: %v", v)
+	}
+	if _, ok := spanAttr(storeLogs, attrCommitTime); !ok {
+		t.Fatalf("missing commit time")
+	}
+
+	// A failed read marks the span as an error
+	if v, ok := spanAttr(spans[2], attrIndex); !ok || v.AsInt64() != 3 {
+		t.Fatalf("bad index: %v", v)
+	}
+	if status := spans[2].Status(); status.Code != codes.Error {
+		t.Fatalf("bad status: %v", status)
+	}
+	if status := spans[1].Status(); status.Code == codes.Error {
+		t.Fatalf("bad status: %v", status)
+	}
+
+	if v, ok := spanAttr(spans[3], attrMaxIndex); !ok || v.AsInt64() != 2 {
+		t.Fatalf("bad max index: %v", v)
+	}
+}
+
+func TestBoltStore_Tracing_Disabled(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	if store.tracer != nil {
+		t.Fatalf("expected no tracer")
+	}
+	store.Close()
+
+	// Operations on a closed store still end their no-op spans cleanly
+	if err := store.Set([]byte("foo"), []byte("bar")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected closed error, got: %v", err)
+	}
+}
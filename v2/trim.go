@@ -0,0 +1,131 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"time"
+)
+
+const (
+	// The number of entries each transaction deletes, and the pause
+	// between transactions, when Options.TrimChunkSize and
+	// Options.TrimPause are not set.
+	defaultTrimChunkSize = 1000
+	defaultTrimPause     = 10 * time.Millisecond
+)
+
+// TrimPrefixAsync deletes every log entry up to and including index in
+// the background, and returns a channel that receives the result once
+// it's done. Raft's log compaction deletes the same prefix with
+// DeleteRange, which holds the writer lock for as long as the whole
+// delete takes. Here the entries are deleted a chunk at a time, pausing
+// between chunks so StoreLogs isn't held up for long.
+//
+// Entries are removed from the front of the log, so FirstIndex moves
+// forward as each chunk commits. Only one trim runs at a time; later
+// calls wait for earlier ones. If the store is closed before the trim
+// finishes, the channel receives ErrClosed and the entries deleted so
+// far stay deleted.
+func (b *BoltStore) TrimPrefixAsync(index uint64) <-chan error {
+	done := make(chan error, 1)
+	ok := b.background(func() {
+		done <- b.trimPrefix(index)
+	})
+	if !ok {
+		done <- ErrClosed
+	}
+	return done
+}
+
+// trimPrefix deletes the entries up to index one chunk at a time.
+func (b *BoltStore) trimPrefix(index uint64) error {
+	b.trimLock.Lock()
+	defer b.trimLock.Unlock()
+
+	start := time.Now()
+	defer b.metrics.measureSince([]string{"trimPrefix"}, start)
+	for {
+		// A whole chunk's tokens are reserved, as that's the most it can
+		// delete
+		if err := b.waitWrite(b.trimChunkSize); err != nil {
+			return err
+		}
+		deleted, more, err := b.trimChunk(index)
+		if err != nil {
+			return err
+		}
+		if deleted > 0 {
+			b.metrics.incrCounter([]string{"trimPrefix", "deleted"}, float32(deleted))
+		}
+		if !more {
+			return nil
+		}
+
+		select {
+		case <-time.After(b.trimPause):
+		case <-b.closeCh:
+			return ErrClosed
+		}
+	}
+}
+
+// trimChunk deletes the first trimChunkSize indexes of the log, as long
+// as they are no later than index. In a segmented log the chunk is
+// rounded up to the end of a segment. It returns true if there may be
+// more entries to delete.
+func (b *BoltStore) trimChunk(index uint64) (int, bool, error) {
+	tx, err := b.begin(true)
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	bucket, err := b.logs(tx)
+	if err != nil {
+		return 0, false, err
+	}
+
+	k, _ := bucket.cursor().First()
+	if k == nil || bytesToUint64(k) > index {
+		return 0, false, nil
+	}
+	first := bytesToUint64(k)
+	last := bucket.chunkEnd(first, b.trimChunkSize)
+	if last > index {
+		last = index
+	}
+	if err := b.archiveRange(bucket, first, last); err != nil {
+		return 0, false, err
+	}
+	deleted, bytesDeleted, err := bucket.deleteRange(first, last)
+	if err != nil {
+		return 0, false, err
+	}
+	b.freed(tx, bytesDeleted)
+
+	seq := b.trackIndexes(tx, bucket)
+	b.cache.cacheWrite(tx, seq, true, first, last, nil)
+	if _, err := b.commit(tx, "TrimPrefix"); err != nil {
+		return 0, false, err
+	}
+	return deleted, last < index, nil
+}
+
+// background runs fn on a new goroutine that Close waits for. It returns
+// false without running fn if the store is already closed. fn should
+// return promptly once closeCh is closed.
+func (b *BoltStore) background(fn func()) bool {
+	b.bgLock.Lock()
+	defer b.bgLock.Unlock()
+	if b.closed.Load() {
+		return false
+	}
+
+	b.bg.Add(1)
+	go func() {
+		defer b.bg.Done()
+		fn()
+	}()
+	return true
+}
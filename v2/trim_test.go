@@ -0,0 +1,131 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/raft"
+)
+
+func testTrimStore(t *testing.T, chunkSize int, pause time.Duration) *BoltStore {
+	fh, err := ioutil.TempFile("", "bolt")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	os.Remove(fh.Name())
+
+	store, err := New(Options{
+		Path:          fh.Name(),
+		TrimChunkSize: chunkSize,
+		TrimPause:     pause,
+		CacheSize:     100,
+		MetricSink:    metrics.NewInmemSink(time.Minute, time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var logs []*raft.Log
+	for i := uint64(1); i <= 100; i++ {
+		logs = append(logs, testRaftLog(i, "data"))
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return store
+}
+
+func TestBoltStore_TrimPrefixAsync(t *testing.T) {
+	store := testTrimStore(t, 7, time.Millisecond)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	if err := <-store.TrimPrefixAsync(50); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	checkIndexes(t, store, 51, 100)
+
+	// The cache must not serve anything that was trimmed
+	log := new(raft.Log)
+	if err := store.GetLog(50, log); err != raft.ErrLogNotFound {
+		t.Fatalf("expected not found error, got: %v", err)
+	}
+	if err := store.GetLog(51, log); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Trimming to an index that's already gone does nothing
+	if err := <-store.TrimPrefixAsync(10); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	checkIndexes(t, store, 51, 100)
+
+	// Trimming beyond the end of the log empties it
+	if err := <-store.TrimPrefixAsync(200); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	checkIndexes(t, store, 0, 0)
+
+	sink := store.metrics.sink.(*metrics.InmemSink)
+	counter := sink.Data()[0].Counters["raft.boltdb.trimPrefix.deleted"]
+	if counter.Sum != 100 || counter.Count != 16 {
+		t.Fatalf("bad: %#v", counter)
+	}
+}
+
+func TestBoltStore_TrimPrefixAsync_Concurrent(t *testing.T) {
+	store := testTrimStore(t, 10, time.Millisecond)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	// Later trims wait for earlier ones, and writes can continue while
+	// they run
+	first := store.TrimPrefixAsync(40)
+	second := store.TrimPrefixAsync(80)
+	if err := store.StoreLog(testRaftLog(101, "data")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := <-first; err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := <-second; err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	checkIndexes(t, store, 81, 101)
+}
+
+func TestBoltStore_TrimPrefixAsync_Close(t *testing.T) {
+	store := testTrimStore(t, 1, time.Hour)
+	defer os.Remove(store.path)
+
+	// Close interrupts the pause between chunks rather than waiting it
+	// out
+	done := store.TrimPrefixAsync(100)
+	time.Sleep(10 * time.Millisecond)
+	if err := store.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := <-done; !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected closed error, got: %v", err)
+	}
+
+	// Anything already trimmed stays trimmed
+	store, err := New(Options{Path: store.path})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	checkIndexes(t, store, 2, 100)
+
+	store.Close()
+	if err := <-store.TrimPrefixAsync(100); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected closed error, got: %v", err)
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"go.etcd.io/bbolt"
+)
+
+// UnsafeStore is the escape hatch returned by BoltStore.Unsafe, for
+// embedders that need to do something the store's API doesn't. Nothing
+// done through it is checked by the store, and the layout of the file
+// isn't covered by any compatibility promise between versions. Writing to
+// the buckets the store uses can corrupt it, and bypasses its caches,
+// hooks and metrics, so the store should be closed and opened again after
+// doing so. Buckets of the embedder's own are safe to use, and are kept
+// by Compact.
+type UnsafeStore struct {
+	store *BoltStore
+}
+
+// Unsafe returns access to the internals of the store. See UnsafeStore.
+func (b *BoltStore) Unsafe() UnsafeStore {
+	return UnsafeStore{store: b}
+}
+
+// DB returns the Bbolt database the store has open. It must not be
+// closed. Compact replaces it with a new one, after which this one is
+// closed, so it should be fetched again for each use rather than kept.
+// It's nil once the store has been closed.
+func (u UnsafeStore) DB() *bbolt.DB {
+	if u.store.closed.Load() {
+		return nil
+	}
+	u.store.connLock.RLock()
+	defer u.store.connLock.RUnlock()
+	return u.store.conn
+}
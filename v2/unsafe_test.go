@@ -0,0 +1,55 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestBoltStore_Accessors(t *testing.T) {
+	store := testBoltStore(t)
+	defer os.Remove(store.path)
+
+	if store.Path() != store.path {
+		t.Fatalf("bad: %s", store.Path())
+	}
+	if store.IsClosed() {
+		t.Fatalf("bad: closed")
+	}
+
+	// The embedder's own buckets survive compaction
+	err := store.Unsafe().DB().Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucket([]byte("app"))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte("foo"), []byte("bar"))
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := store.Compact(context.Background()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	var val []byte
+	store.Unsafe().DB().View(func(tx *bbolt.Tx) error {
+		val = append(val, tx.Bucket([]byte("app")).Get([]byte("foo"))...)
+		return nil
+	})
+	if string(val) != "bar" {
+		t.Fatalf("bad: %q", val)
+	}
+
+	store.Close()
+	if !store.IsClosed() {
+		t.Fatalf("bad: not closed")
+	}
+	if store.Unsafe().DB() != nil {
+		t.Fatalf("bad: DB of a closed store")
+	}
+}
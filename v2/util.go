@@ -6,39 +6,276 @@ package raftboltdb
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"runtime"
+	"sync"
 
 	"github.com/hashicorp/go-msgpack/v2/codec"
+	"github.com/hashicorp/raft"
 )
 
-// Decode reverses the encode operation on a byte slice input
+const (
+	// maxPooledEncoderSize is the largest buffer a logEncoder will hold
+	// on to once it's returned to the pool, so one huge batch doesn't pin
+	// its memory forever.
+	maxPooledEncoderSize = 4 << 20
+)
+
+var (
+	// msgpackHandles are shared by every encoder, indexed by
+	// timeFormatIndex. Handles are safe for concurrent use once they've
+	// been set up.
+	msgpackHandles = [2]*codec.MsgpackHandle{
+		{BasicHandle: codec.BasicHandle{TimeNotBuiltin: true}},
+		{BasicHandle: codec.BasicHandle{TimeNotBuiltin: false}},
+	}
+
+	// logEncoderPools hold logEncoders for reuse, indexed like
+	// msgpackHandles.
+	logEncoderPools [2]sync.Pool
+
+	// msgpackDecodeHandle is shared by every decoder. Decoding handles
+	// both time formats, so there's only one.
+	msgpackDecodeHandle = &codec.MsgpackHandle{}
+
+	// msgpackDecoderPool holds *codec.Decoders for reuse.
+	msgpackDecoderPool sync.Pool
+)
+
+func timeFormatIndex(useNewTimeFormat bool) int {
+	if useNewTimeFormat {
+		return 1
+	}
+	return 0
+}
+
+// Decode reverses the encode operation on a byte slice input. The input is
+// read in place rather than through an io.Reader, and nothing in out will
+// refer to it afterwards, since the codec copies byte slices and strings
+// unless its ZeroCopy option is set. This means buf can come straight
+// from a Bbolt transaction, as long as it's decoded before the
+// transaction ends.
 func decodeMsgPack(buf []byte, out interface{}) error {
-	r := bytes.NewBuffer(buf)
-	hd := codec.MsgpackHandle{}
-	dec := codec.NewDecoder(r, &hd)
-	return dec.Decode(out)
+	dec, _ := msgpackDecoderPool.Get().(*codec.Decoder)
+	if dec == nil {
+		dec = codec.NewDecoderBytes(buf, msgpackDecodeHandle)
+	} else {
+		dec.ResetBytes(buf)
+	}
+	err := dec.Decode(out)
+
+	// Don't keep the input alive through the pool
+	dec.ResetBytes(nil)
+	msgpackDecoderPool.Put(dec)
+	return err
 }
 
 // Encode writes an encoded object to a new bytes buffer
 func encodeMsgPack(in interface{}, useNewTimeFormat bool) (*bytes.Buffer, error) {
 	buf := bytes.NewBuffer(nil)
-	hd := codec.MsgpackHandle{
-		BasicHandle: codec.BasicHandle{
-			TimeNotBuiltin: !useNewTimeFormat,
-		},
-	}
-	enc := codec.NewEncoder(buf, &hd)
+	enc := codec.NewEncoder(buf, msgpackHandles[timeFormatIndex(useNewTimeFormat)])
 	err := enc.Encode(in)
 	return buf, err
 }
 
+// logEncoder encodes a batch of logs and their keys into buffers that are
+// reused from one batch to the next. Bbolt requires keys and values to
+// stay valid until the transaction they're written in has finished, so
+// everything in a batch is appended rather than overwritten, and a
+// logEncoder must not be reset or released before then.
+type logEncoder struct {
+	buf  bytes.Buffer
+	keys []byte
+	enc  *codec.Encoder
+	pool *sync.Pool
+
+	// newTimeFormat is the time format enc was made for, and workers are
+	// the encoders used by encodeParallel, which back the values it
+	// returned until this one is released.
+	newTimeFormat bool
+	workers       []*logEncoder
+
+	// checksums is set to wrap each value with a checksum, see
+	// Options.Checksums.
+	checksums bool
+
+	// compressor compresses values of at least compressThreshold bytes
+	// if it's set, see Options.Compression.
+	compressor        *compressor
+	compressThreshold int
+	scratch           []byte
+
+	// dataKeys encrypts values if it's set, see Options.Wrapper.
+	dataKeys *keyring
+}
+
+// getLogEncoder returns an encoder from the pool, or a new one.
+func getLogEncoder(useNewTimeFormat bool) *logEncoder {
+	i := timeFormatIndex(useNewTimeFormat)
+	pool := &logEncoderPools[i]
+	if e, ok := pool.Get().(*logEncoder); ok {
+		return e
+	}
+	e := &logEncoder{pool: pool, newTimeFormat: useNewTimeFormat}
+	e.enc = codec.NewEncoder(&e.buf, msgpackHandles[i])
+	return e
+}
+
+// reset discards everything encoded so far and makes room for n keys.
+func (e *logEncoder) reset(n int) {
+	e.buf.Reset()
+	e.enc.Reset(&e.buf)
+	if cap(e.keys) < 8*n {
+		e.keys = make([]byte, 0, 8*n)
+	}
+	e.keys = e.keys[:0]
+}
+
+// key returns idx encoded as a key.
+func (e *logEncoder) key(idx uint64) []byte {
+	start := len(e.keys)
+	e.keys = binary.BigEndian.AppendUint64(e.keys, idx)
+	return e.keys[start:len(e.keys):len(e.keys)]
+}
+
+// encode returns log encoded as a value.
+func (e *logEncoder) encode(log *raft.Log) ([]byte, error) {
+	return e.encodeEntry(log, 0)
+}
+
+// encodeEntry is like encode, but sets flags in the value's header.
+func (e *logEncoder) encodeEntry(log *raft.Log, flags byte) ([]byte, error) {
+	start := e.buf.Len()
+	wrap := flags != 0 || e.checksums || e.compressor != nil || e.dataKeys != nil
+	if wrap {
+		e.buf.Write([]byte{entryMarker, 0})
+	}
+	if err := e.enc.Encode(log); err != nil {
+		return nil, err
+	}
+	if !wrap {
+		return e.buf.Bytes()[start:e.buf.Len():e.buf.Len()], nil
+	}
+
+	if payload := e.buf.Bytes()[start+entryHeaderSize:]; e.compressor != nil && len(payload) >= e.compressThreshold {
+		e.scratch = e.compressor.compress(e.scratch[:0], payload)
+		if len(e.scratch) < len(payload) {
+			e.buf.Truncate(start + entryHeaderSize)
+			e.buf.Write(e.scratch)
+			flags |= e.compressor.flag
+		}
+	}
+	if e.dataKeys != nil {
+		sealed, err := e.dataKeys.seal(e.scratch[:0], e.buf.Bytes()[start+entryHeaderSize:], uint64ToBytes(log.Index))
+		if err != nil {
+			return nil, err
+		}
+		e.scratch = sealed
+		e.buf.Truncate(start + entryHeaderSize)
+		e.buf.Write(sealed)
+		flags |= entryEncrypted
+	}
+	if e.checksums {
+		sum := crc32.Checksum(e.buf.Bytes()[start+entryHeaderSize:], crc32c)
+		var b [entryChecksumSize]byte
+		binary.BigEndian.PutUint32(b[:], sum)
+		e.buf.Write(b[:])
+		flags |= entryChecksum
+	}
+
+	val := e.buf.Bytes()[start:e.buf.Len():e.buf.Len()]
+	if flags == 0 {
+		// Compressing didn't help, so there's no need for the header
+		copy(val, val[entryHeaderSize:])
+		e.buf.Truncate(e.buf.Len() - entryHeaderSize)
+		return val[: len(val)-entryHeaderSize : len(val)-entryHeaderSize], nil
+	}
+	val[1] = flags
+	return val, nil
+}
+
+// encodeParallel encodes logs like encode, split between up to
+// GOMAXPROCS goroutines that each have an encoder of their own set up
+// like e, and returns the values in the same order. Entries that skip
+// returns true for are left nil.
+func (e *logEncoder) encodeParallel(logs []*raft.Log, skip func(*raft.Log) bool) ([][]byte, error) {
+	vals := make([][]byte, len(logs))
+	n := min(runtime.GOMAXPROCS(0), len(logs))
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for w := 0; w < n; w++ {
+		worker := getLogEncoder(e.newTimeFormat)
+		worker.checksums = e.checksums
+		worker.compressor = e.compressor
+		worker.compressThreshold = e.compressThreshold
+		worker.dataKeys = e.dataKeys
+		worker.reset(0)
+		e.workers = append(e.workers, worker)
+
+		lo, hi := w*len(logs)/n, (w+1)*len(logs)/n
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := lo; i < hi; i++ {
+				if skip(logs[i]) {
+					continue
+				}
+				val, err := worker.encode(logs[i])
+				if err != nil {
+					errs[w] = err
+					return
+				}
+				vals[i] = val
+			}
+		}()
+	}
+	wg.Wait()
+	return vals, errors.Join(errs...)
+}
+
+// release returns the encoder and its workers to the pool.
+func (e *logEncoder) release() {
+	for _, worker := range e.workers {
+		worker.release()
+	}
+	clear(e.workers)
+	e.workers = e.workers[:0]
+	if e.buf.Cap() > maxPooledEncoderSize || cap(e.keys) > maxPooledEncoderSize || cap(e.scratch) > maxPooledEncoderSize {
+		return
+	}
+	e.checksums, e.compressor, e.dataKeys = false, nil, nil
+	e.pool.Put(e)
+}
+
 // Converts bytes to an integer
 func bytesToUint64(b []byte) uint64 {
 	return binary.BigEndian.Uint64(b)
 }
 
+// valueToUint64 decodes val, the value of key in the stable store, as
+// written by SetUint64, returning ErrInvalidValue if it isn't 8 bytes
+// long, e.g. because it was written by other tooling.
+func valueToUint64(key, val []byte) (uint64, error) {
+	if len(val) != 8 {
+		return 0, fmt.Errorf("%w: %q is %d bytes long, not 8", ErrInvalidValue, key, len(val))
+	}
+	return bytesToUint64(val), nil
+}
+
 // Converts a uint to a byte slice
 func uint64ToBytes(u uint64) []byte {
 	buf := make([]byte, 8)
 	binary.BigEndian.PutUint64(buf, u)
 	return buf
 }
+
+// dataSize returns the total size of the Data of logs.
+func dataSize(logs []*raft.Log) int {
+	size := 0
+	for _, log := range logs {
+		size += len(log.Data)
+	}
+	return size
+}
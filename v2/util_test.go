@@ -0,0 +1,160 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/hashicorp/raft"
+	"go.etcd.io/bbolt"
+)
+
+func TestLogEncoder(t *testing.T) {
+	for _, newTimeFormat := range []bool{false, true} {
+		t.Run(fmt.Sprintf("newTimeFormat=%v", newTimeFormat), func(t *testing.T) {
+			enc := getLogEncoder(newTimeFormat)
+			defer enc.release()
+
+			var logs []*raft.Log
+			for i := uint64(1); i <= 100; i++ {
+				logs = append(logs, testRaftLog(i, fmt.Sprintf("log%d", i)))
+			}
+
+			// Everything encoded in a batch must stay intact until the
+			// next reset, however much the buffers grow
+			enc.reset(1)
+			var keys, vals [][]byte
+			for _, log := range logs {
+				keys = append(keys, enc.key(log.Index))
+				val, err := enc.encode(log)
+				if err != nil {
+					t.Fatalf("err: %s", err)
+				}
+				vals = append(vals, val)
+			}
+			for i, log := range logs {
+				if !bytes.Equal(keys[i], uint64ToBytes(log.Index)) {
+					t.Fatalf("bad key %d: %x", i, keys[i])
+				}
+				expected, err := encodeMsgPack(log, newTimeFormat)
+				if err != nil {
+					t.Fatalf("err: %s", err)
+				}
+				if !bytes.Equal(vals[i], expected.Bytes()) {
+					t.Fatalf("bad value %d", i)
+				}
+
+				var decoded raft.Log
+				if err := decodeMsgPack(vals[i], &decoded); err != nil {
+					t.Fatalf("err: %s", err)
+				}
+				if !reflect.DeepEqual(&decoded, log) {
+					t.Fatalf("bad log %d: %#v", i, decoded)
+				}
+			}
+
+			// Values can't be appended to in place
+			if cap(vals[0]) != len(vals[0]) || cap(keys[0]) != 8 {
+				t.Fatalf("expected capped slices")
+			}
+		})
+	}
+}
+
+func TestLogEncoder_Parallel(t *testing.T) {
+	var logs []*raft.Log
+	for i := uint64(1); i <= 100; i++ {
+		logs = append(logs, testRaftLog(i, strings.Repeat(fmt.Sprintf("log%d", i), 100)))
+	}
+	skip := func(log *raft.Log) bool { return log.Index%10 == 0 }
+
+	setup := func(enc *logEncoder) {
+		enc.checksums = true
+		enc.compressor = snappyCompressor
+		enc.reset(len(logs))
+	}
+	serial := getLogEncoder(false)
+	defer serial.release()
+	setup(serial)
+	parallel := getLogEncoder(false)
+	setup(parallel)
+
+	// The values must match the serial encoding, and stay intact until
+	// the encoder is released
+	vals, err := parallel.encodeParallel(logs, skip)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for i, log := range logs {
+		if skip(log) {
+			if vals[i] != nil {
+				t.Fatalf("bad value %d", i)
+			}
+			continue
+		}
+		expected, err := serial.encode(log)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if !bytes.Equal(vals[i], expected) {
+			t.Fatalf("bad value %d", i)
+		}
+	}
+	if len(parallel.workers) == 0 {
+		t.Fatalf("no workers")
+	}
+	parallel.release()
+	if len(parallel.workers) != 0 {
+		t.Fatalf("bad: %d", len(parallel.workers))
+	}
+}
+
+func TestDecodeMsgPack_NoAliasing(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	in := &raft.Log{
+		Index:      1,
+		Term:       1,
+		Data:       bytes.Repeat([]byte("data"), 64),
+		Extensions: bytes.Repeat([]byte("ext"), 64),
+	}
+	if err := store.StoreLog(in); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Decoding straight from the memory map must copy everything out, or
+	// the log would be left pointing at memory that's unmapped when the
+	// store is closed
+	var log raft.Log
+	err := store.conn.View(func(tx *bbolt.Tx) error {
+		val := tx.Bucket(dbLogs).Get(uint64ToBytes(1))
+		if err := decodeMsgPack(val, &log); err != nil {
+			return err
+		}
+		start := uintptr(unsafe.Pointer(&val[0]))
+		end := start + uintptr(len(val))
+		for _, b := range [][]byte{log.Data, log.Extensions} {
+			if p := uintptr(unsafe.Pointer(&b[0])); p >= start && p < end {
+				t.Fatalf("decoded log refers to the transaction's memory")
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.Close()
+
+	if !reflect.DeepEqual(&log, in) {
+		t.Fatalf("bad: %#v", log)
+	}
+}
@@ -0,0 +1,290 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"go.etcd.io/bbolt"
+)
+
+const (
+	// defaultVerifyLockTimeout is used when VerifyOptions.LockTimeout
+	// isn't set.
+	defaultVerifyLockTimeout = time.Second
+)
+
+var (
+	// ErrVerifyFailed is returned when an integrity check finds problems.
+	// The returned error is a *VerifyError carrying the full report.
+	ErrVerifyFailed = errors.New("integrity check failed")
+)
+
+// ProblemKind classifies a problem found by an integrity check.
+type ProblemKind string
+
+const (
+	// ProblemStructure is an inconsistency in the Bbolt page structure,
+	// as reported by its own consistency check.
+	ProblemStructure ProblemKind = "structure"
+
+	// ProblemKeyLength is a key in the logs bucket that isn't 8 bytes
+	// long, so it can't be a log index.
+	ProblemKeyLength ProblemKind = "key-length"
+
+	// ProblemDecode is a log entry that can't be decoded.
+	ProblemDecode ProblemKind = "decode"
+
+	// ProblemChecksum is a log entry that doesn't match its checksum.
+	ProblemChecksum ProblemKind = "checksum"
+
+	// ProblemIndexMismatch is a log entry stored under a key that doesn't
+	// match its own Index.
+	ProblemIndexMismatch ProblemKind = "index-mismatch"
+
+	// ProblemMissingBucket is one of the store's buckets not existing.
+	ProblemMissingBucket ProblemKind = "missing-bucket"
+
+	// ProblemGap is one or more indexes missing from the middle of the
+	// log. The problem's Index is the first missing index.
+	ProblemGap ProblemKind = "gap"
+
+	// ProblemTermRegression is a log entry with a lower term than the
+	// entry before it.
+	ProblemTermRegression ProblemKind = "term-regression"
+)
+
+// Problem is a single issue found by an integrity check.
+type Problem struct {
+	// Kind classifies the problem.
+	Kind ProblemKind
+
+	// Index is the log index the problem was found at, or zero if it
+	// doesn't relate to a particular entry.
+	Index uint64
+
+	// Err describes the problem.
+	Err error
+}
+
+// String returns a human readable description of the problem.
+func (p Problem) String() string {
+	if p.Index != 0 {
+		return fmt.Sprintf("%s at index %d: %v", p.Kind, p.Index, p.Err)
+	}
+	return fmt.Sprintf("%s: %v", p.Kind, p.Err)
+}
+
+// VerifyReport is the result of an integrity check.
+type VerifyReport struct {
+	// Logs is the number of entries that were scanned in the logs bucket.
+	Logs uint64
+
+	// FirstIndex and LastIndex are the range of indexes that were found,
+	// or zero if the log is empty.
+	FirstIndex uint64
+	LastIndex  uint64
+
+	// Problems lists everything that was found, in the order it was found.
+	Problems []Problem
+
+	// Truncated is set if the check stopped early because it hit
+	// VerifyOptions.MaxProblems.
+	Truncated bool
+
+	// Salvage describes what was removed if VerifyOptions.Salvage was set
+	// and there was something to remove.
+	Salvage *SalvageResult
+}
+
+// OK returns true if no problems were found.
+func (r *VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *VerifyReport) add(kind ProblemKind, index uint64, err error) {
+	r.Problems = append(r.Problems, Problem{Kind: kind, Index: index, Err: err})
+}
+
+// VerifyError is returned when an integrity check finds problems.
+type VerifyError struct {
+	// Path is the database file that was checked.
+	Path string
+
+	// Report holds everything that was found.
+	Report *VerifyReport
+}
+
+// Error implements the error interface.
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("%v: %s has %d problem(s), first: %s",
+		ErrVerifyFailed, e.Path, len(e.Report.Problems), e.Report.Problems[0])
+}
+
+// Unwrap allows errors.Is to match ErrVerifyFailed.
+func (e *VerifyError) Unwrap() error {
+	return ErrVerifyFailed
+}
+
+// VerifyOptions controls an integrity check run by Verify.
+type VerifyOptions struct {
+	// LockTimeout is how long to wait for the file lock. Verify is meant
+	// to be run against a database that isn't in use, so this defaults
+	// to one second.
+	LockTimeout time.Duration
+
+	// SkipStructureCheck skips Bbolt's page level consistency check,
+	// which can be slow on very large files.
+	SkipStructureCheck bool
+
+	// MaxProblems stops the check once this many problems have been
+	// found. Zero means no limit.
+	MaxProblems int
+
+	// Salvage opens the database read-write and, if any entries can't be
+	// decoded or are stored under the wrong index, truncates the log just
+	// before the first of them. Keys that can't be log indexes are removed
+	// too. The conf bucket is left intact and a marker describing what
+	// was removed is written to it, see BoltStore.SalvageMarker. Structural
+	// problems and gaps are not repaired.
+	Salvage bool
+
+	// Wrapper unwraps the data keys of an encrypted store, see
+	// Options.Wrapper. Encrypted stores can't be checked without it.
+	Wrapper Wrapper
+
+	// CryptoProvider is used with Wrapper, see Options.CryptoProvider.
+	CryptoProvider CryptoProvider
+}
+
+// Verify opens the database at path read-only and checks it for
+// corruption, undecodable entries, gaps in the log and term regressions,
+// returning a report of everything found. An error is only returned if
+// the check itself could not be run.
+func Verify(path string, opts VerifyOptions) (*VerifyReport, error) {
+	if opts.LockTimeout == 0 {
+		opts.LockTimeout = defaultVerifyLockTimeout
+	}
+
+	store, err := New(Options{
+		Path:           path,
+		ReadOnly:       !opts.Salvage,
+		LockTimeout:    opts.LockTimeout,
+		Wrapper:        opts.Wrapper,
+		CryptoProvider: opts.CryptoProvider,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+
+	report, err := store.verify(opts)
+	if err != nil || !opts.Salvage {
+		return report, err
+	}
+	if report.Salvage, err = store.salvage(report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// errMaxProblems stops a scan once VerifyOptions.MaxProblems is hit.
+var errMaxProblems = errors.New("too many problems")
+
+// checkTx runs Bbolt's consistency check and then makes sure every entry
+// in the logs bucket is a decodable raft.Log stored under its own index,
+// and that the log has no gaps or term regressions.
+func checkTx(tx *bbolt.Tx, keys *keyring, opts VerifyOptions, report *VerifyReport) {
+	add := func(kind ProblemKind, index uint64, err error) error {
+		report.add(kind, index, err)
+		if opts.MaxProblems > 0 && len(report.Problems) >= opts.MaxProblems {
+			report.Truncated = true
+			return errMaxProblems
+		}
+		return nil
+	}
+
+	if !opts.SkipStructureCheck {
+		for err := range tx.Check() {
+			if add(ProblemStructure, 0, err) != nil {
+				return
+			}
+		}
+	}
+
+	for _, name := range [][]byte{dbLogs, dbConf} {
+		if tx.Bucket(name) == nil {
+			if add(ProblemMissingBucket, 0, fmt.Errorf("bucket %q does not exist", name)) != nil {
+				return
+			}
+		}
+	}
+	bucket := tx.Bucket(dbLogs)
+	if bucket == nil {
+		return
+	}
+	logs := &logBucket{root: bucket, segmentSize: readSegmentSize(tx), overflow: tx.Bucket(dbOverflow), keys: keys}
+
+	var prevIndex, prevTerm uint64
+	check := func(k, v []byte) error {
+		report.Logs++
+		if len(k) != 8 {
+			return add(ProblemKeyLength, 0, fmt.Errorf("key %x is %d bytes", k, len(k)))
+		}
+
+		idx := bytesToUint64(k)
+		if report.FirstIndex == 0 {
+			report.FirstIndex = idx
+		}
+		report.LastIndex = idx
+		if prevIndex != 0 && idx > prevIndex+1 {
+			if err := add(ProblemGap, prevIndex+1, fmt.Errorf("%d missing entries before index %d", idx-prevIndex-1, idx)); err != nil {
+				return err
+			}
+		}
+		prevIndex = idx
+
+		var log raft.Log
+		if err := logs.decode(idx, v, &log); err != nil {
+			if errors.Is(err, ErrChecksumMismatch) {
+				return add(ProblemChecksum, idx, err)
+			}
+			return add(ProblemDecode, idx, err)
+		}
+		if log.Index != idx {
+			if err := add(ProblemIndexMismatch, idx, fmt.Errorf("entry has index %d", log.Index)); err != nil {
+				return err
+			}
+		}
+		if log.Term < prevTerm {
+			if err := add(ProblemTermRegression, idx, fmt.Errorf("term %d follows term %d", log.Term, prevTerm)); err != nil {
+				return err
+			}
+		}
+		prevTerm = log.Term
+		return nil
+	}
+	curs := logs.cursor()
+	for k, v := curs.First(); k != nil; k, v = curs.Next() {
+		if check(k, v) != nil {
+			return
+		}
+	}
+}
+
+// verify runs an integrity check against the open store.
+func (b *BoltStore) verify(opts VerifyOptions) (*VerifyReport, error) {
+	tx, err := b.begin(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	report := &VerifyReport{}
+	checkTx(tx, b.keys.Load(), opts, report)
+	return report, nil
+}
@@ -0,0 +1,254 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"go.etcd.io/bbolt"
+)
+
+// testCorruptStore stores a few good logs and then writes a bad key, an
+// undecodable entry and an entry under the wrong index.
+func testCorruptStore(t *testing.T) *BoltStore {
+	store := testBoltStore(t)
+
+	logs := []*raft.Log{
+		testRaftLog(1, "log1"),
+		testRaftLog(2, "log2"),
+		testRaftLog(3, "log3"),
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	mismatched, err := encodeMsgPack(testRaftLog(7, "log7"), false)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	err = store.conn.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(dbLogs)
+		if err := bucket.Put([]byte("bad"), []byte("key")); err != nil {
+			return err
+		}
+		if err := bucket.Put(uint64ToBytes(4), []byte{0xc1}); err != nil {
+			return err
+		}
+		return bucket.Put(uint64ToBytes(5), mismatched.Bytes())
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return store
+}
+
+func TestBoltStore_CheckOnOpen(t *testing.T) {
+	store := testCorruptStore(t)
+	defer os.Remove(store.path)
+	store.Close()
+
+	// Without the check the store opens as normal
+	store, err := New(Options{Path: store.path})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.Close()
+
+	_, err = New(Options{Path: store.path, CheckOnOpen: true})
+	if !errors.Is(err, ErrVerifyFailed) {
+		t.Fatalf("expected verify error, got: %v", err)
+	}
+	var verr *VerifyError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected a *VerifyError, got: %T", err)
+	}
+	if verr.Path != store.path {
+		t.Fatalf("bad path: %q", verr.Path)
+	}
+
+	report := verr.Report
+	if report.Logs != 6 {
+		t.Fatalf("bad: %d", report.Logs)
+	}
+	expected := []struct {
+		kind  ProblemKind
+		index uint64
+	}{
+		{ProblemDecode, 4},
+		{ProblemIndexMismatch, 5},
+		{ProblemKeyLength, 0},
+	}
+	if len(report.Problems) != len(expected) {
+		t.Fatalf("bad: %v", report.Problems)
+	}
+	for i, e := range expected {
+		if p := report.Problems[i]; p.Kind != e.kind || p.Index != e.index {
+			t.Fatalf("bad problem %d: %s", i, p)
+		}
+	}
+
+	// The failed open must release the file
+	store, err = New(Options{Path: store.path})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.Close()
+}
+
+func TestBoltStore_CheckOnOpen_Healthy(t *testing.T) {
+	store := testBoltStore(t)
+	defer os.Remove(store.path)
+	if err := store.StoreLogs([]*raft.Log{testRaftLog(1, "log1"), testRaftLog(2, "log2")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.Close()
+
+	store, err := New(Options{Path: store.path, CheckOnOpen: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	report, err := store.verify(VerifyOptions{})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !report.OK() || report.Logs != 2 {
+		t.Fatalf("bad: %#v", report)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	store := testBoltStore(t)
+	defer os.Remove(store.path)
+
+	logs := []*raft.Log{
+		{Index: 1, Term: 1},
+		{Index: 2, Term: 2},
+		{Index: 5, Term: 2},
+		{Index: 6, Term: 1},
+		{Index: 7, Term: 3},
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Verify refuses to run against a store that's in use
+	if _, err := Verify(store.path, VerifyOptions{LockTimeout: time.Second / 10}); !errors.Is(err, ErrDatabaseLocked) {
+		t.Fatalf("expected locked error, got: %v", err)
+	}
+	store.Close()
+
+	report, err := Verify(store.path, VerifyOptions{})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if report.OK() || report.Truncated {
+		t.Fatalf("bad: %#v", report)
+	}
+	if report.Logs != 5 || report.FirstIndex != 1 || report.LastIndex != 7 {
+		t.Fatalf("bad: %#v", report)
+	}
+	if len(report.Problems) != 2 {
+		t.Fatalf("bad: %v", report.Problems)
+	}
+	if p := report.Problems[0]; p.Kind != ProblemGap || p.Index != 3 {
+		t.Fatalf("bad: %s", p)
+	}
+	if p := report.Problems[1]; p.Kind != ProblemTermRegression || p.Index != 6 {
+		t.Fatalf("bad: %s", p)
+	}
+
+	// MaxProblems stops the scan early
+	report, err = Verify(store.path, VerifyOptions{MaxProblems: 1, SkipStructureCheck: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(report.Problems) != 1 || !report.Truncated {
+		t.Fatalf("bad: %#v", report)
+	}
+
+	// A missing file is an error rather than an empty report
+	if _, err := Verify(store.path+".missing", VerifyOptions{}); !os.IsNotExist(err) {
+		t.Fatalf("expected not exist error, got: %v", err)
+	}
+}
+
+func TestVerify_Salvage(t *testing.T) {
+	store := testCorruptStore(t)
+	defer os.Remove(store.path)
+	if err := store.SetUint64([]byte("CurrentTerm"), 4); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.Close()
+
+	report, err := Verify(store.path, VerifyOptions{Salvage: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	result := report.Salvage
+	if result == nil {
+		t.Fatalf("expected a salvage result")
+	}
+	if result.TruncatedAt != 4 || result.Logs != 2 || result.BadKeys != 1 {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	// The salvaged store is clean
+	report, err = Verify(store.path, VerifyOptions{})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !report.OK() || report.LastIndex != 3 {
+		t.Fatalf("bad: %#v", report)
+	}
+
+	// The conf bucket was left alone and has the marker
+	store, err = NewBoltStore(store.path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	if term, err := store.GetUint64([]byte("CurrentTerm")); err != nil || term != 4 {
+		t.Fatalf("bad: %d %v", term, err)
+	}
+	marker, err := store.SalvageMarker()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if marker.TruncatedAt != 4 || marker.Logs != 2 || marker.Time.IsZero() {
+		t.Fatalf("bad: %#v", marker)
+	}
+}
+
+func TestVerify_Salvage_Healthy(t *testing.T) {
+	store := testBoltStore(t)
+	defer os.Remove(store.path)
+	if err := store.StoreLogs([]*raft.Log{testRaftLog(1, "log1"), testRaftLog(2, "log2")}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.Close()
+
+	// Nothing to salvage, so nothing is written
+	report, err := Verify(store.path, VerifyOptions{Salvage: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !report.OK() || report.Salvage != nil {
+		t.Fatalf("bad: %#v", report)
+	}
+
+	store, err = NewBoltStore(store.path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+	if _, err := store.SalvageMarker(); err != ErrKeyNotFound {
+		t.Fatalf("expected not found error, got: %v", err)
+	}
+}
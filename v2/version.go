@@ -0,0 +1,95 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"fmt"
+
+	"go.etcd.io/bbolt"
+)
+
+const (
+	// FormatVersion is the newest on-disk format this package can read.
+	// Version 1 is the original format, with a flat logs bucket of bare
+	// msgpack entries, and is assumed for files without a version, such
+	// as those written by the v1 store.
+	// Version 2 adds segmented logs, the term index, checksums,
+	// compression and the overflow bucket, none of which older versions
+	// of this package handle correctly. Version 3 adds encryption.
+	FormatVersion = 3
+)
+
+var (
+	// dbFormatVersionKey is the key in the conf bucket holding the format
+	// version of the file.
+	dbFormatVersionKey = []byte("raftboltdb.formatVersion")
+
+	// ErrIncompatibleVersion is returned when a file was written in a
+	// newer format than this package can read. The returned error is a
+	// *VersionError.
+	ErrIncompatibleVersion = errors.New("incompatible format version")
+)
+
+// VersionError is returned by New when a file's format is too new.
+type VersionError struct {
+	// Path is the database file that was opened.
+	Path string
+
+	// Version is the format version recorded in the file.
+	Version uint64
+}
+
+// Error implements the error interface.
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("%v: %s has format version %d, but this version of raft-boltdb only reads up to %d; "+
+		"open it with the newer version it was written by, or, to downgrade, export the log with that "+
+		"version and import it into a new store with this one",
+		ErrIncompatibleVersion, e.Path, e.Version, FormatVersion)
+}
+
+// Unwrap allows errors.Is to match ErrIncompatibleVersion.
+func (e *VersionError) Unwrap() error {
+	return ErrIncompatibleVersion
+}
+
+// readFormatVersion returns the format version recorded in tx.
+func readFormatVersion(tx *bbolt.Tx) uint64 {
+	conf := tx.Bucket(dbConf)
+	if conf == nil {
+		return 1
+	}
+	if v := conf.Get(dbFormatVersionKey); len(v) == 8 {
+		return bytesToUint64(v)
+	}
+	return 1
+}
+
+// checkFormatVersion returns a *VersionError if tx holds a file this
+// package can't read.
+func (b *BoltStore) checkFormatVersion(tx *bbolt.Tx) error {
+	if version := readFormatVersion(tx); version > FormatVersion {
+		return &VersionError{Path: b.path, Version: version}
+	}
+	return nil
+}
+
+// stampFormatVersion records the oldest format version that can read the
+// file, given the options it's open with, unless a newer one is already
+// recorded. Files that don't need version 2 are stamped with version 1,
+// which older versions of this package ignore, so they can still be
+// opened by them.
+func (b *BoltStore) stampFormatVersion(tx *bbolt.Tx) error {
+	required := uint64(1)
+	if hasDataKeys(tx) {
+		required = 3
+	} else if b.segmentSize != 0 || b.termIndex || b.checksums || b.compressor != nil || tx.Bucket(dbOverflow) != nil {
+		required = 2
+	}
+	stamped := tx.Bucket(dbConf).Get(dbFormatVersionKey) != nil
+	if stamped && required <= readFormatVersion(tx) {
+		return nil
+	}
+	return tx.Bucket(dbConf).Put(dbFormatVersionKey, uint64ToBytes(required))
+}
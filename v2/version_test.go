@@ -0,0 +1,79 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestBoltStore_FormatVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "raft.db")
+	version := func(store *BoltStore) uint64 {
+		t.Helper()
+		var v uint64
+		if err := store.conn.View(func(tx *bbolt.Tx) error {
+			v = readFormatVersion(tx)
+			return nil
+		}); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		return v
+	}
+
+	// Files that don't need a newer format are stamped with version 1
+	store, err := New(Options{Path: path})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if v := version(store); v != 1 {
+		t.Fatalf("bad: %d", v)
+	}
+	if v, err := store.GetUint64(dbFormatVersionKey); err != nil || v != 1 {
+		t.Fatalf("bad: %d %v", v, err)
+	}
+	store.Close()
+
+	// Once they do, the version never goes back
+	for _, options := range []Options{{Path: path, Checksums: true}, {Path: path}} {
+		store, err = New(options)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if v := version(store); v != 2 {
+			t.Fatalf("bad: %d", v)
+		}
+		store.Close()
+	}
+
+	// Newer files can't be opened
+	store, err = New(Options{Path: path})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	err = store.conn.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(dbConf).Put(dbFormatVersionKey, uint64ToBytes(FormatVersion+1))
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	store.Close()
+
+	for _, options := range []Options{{Path: path}, {Path: path, ReadOnly: true}} {
+		_, err = New(options)
+		if !errors.Is(err, ErrIncompatibleVersion) {
+			t.Fatalf("expected version error, got: %v", err)
+		}
+		var verr *VersionError
+		if !errors.As(err, &verr) {
+			t.Fatalf("expected a *VersionError, got: %T", err)
+		}
+		if verr.Path != path || verr.Version != FormatVersion+1 {
+			t.Fatalf("bad: %#v", verr)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+// Command raft-boltdb-wal converts between a raft.db written by
+// raftboltdb.BoltStore and a raft-wal directory, with package walinterop:
+//
+//	raft-boltdb-wal export -src raft.db -dst wal
+//	raft-boltdb-wal import -src wal -dst raft.db
+//
+// The source is left as it is, and the destination mustn't exist yet.
+// Neither can be in use by a running node.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"github.com/hashicorp/raft-boltdb/v2/walinterop"
+)
+
+const (
+	// lockTimeout is how long to wait for the lock on raft.db before
+	// reporting that the file is in use.
+	lockTimeout = time.Second
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	code := run(ctx, os.Args[1:], os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+// run runs the command given by args, returning the exit code.
+func run(ctx context.Context, args []string, stderr io.Writer) int {
+	if len(args) == 0 || (args[0] != "export" && args[0] != "import") {
+		fmt.Fprintf(stderr, "usage: raft-boltdb-wal export|import -src PATH -dst PATH [-batch-size N]\n")
+		return 2
+	}
+	fs := flag.NewFlagSet("raft-boltdb-wal "+args[0], flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	src := fs.String("src", "", "The store to convert, which is left as it is.")
+	dst := fs.String("dst", "", "Where to write the converted store, which mustn't exist yet.")
+	batchSize := fs.Int("batch-size", 0, "How many entries are copied at a time. Defaults to 1000.")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if *src == "" || *dst == "" {
+		fmt.Fprintf(stderr, "-src and -dst are required\n")
+		fs.Usage()
+		return 2
+	}
+
+	opts := raftboltdb.StoreMigrateOptions{BatchSize: *batchSize}
+	var err error
+	if args[0] == "export" {
+		err = export(ctx, *src, *dst, opts)
+	} else {
+		err = importWAL(ctx, *src, *dst, opts)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "raft-boltdb-wal %s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+// export copies the store at src into a new raft-wal directory at dst.
+func export(ctx context.Context, src, dst string, opts raftboltdb.StoreMigrateOptions) error {
+	if _, err := os.Stat(src); err != nil {
+		return err
+	}
+	store, err := raftboltdb.New(raftboltdb.Options{Path: src, ReadOnly: true, LockTimeout: lockTimeout})
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	return walinterop.ExportToWAL(ctx, store, dst, opts)
+}
+
+// importWAL copies the raft-wal directory at src into a new store at dst,
+// which is removed if the copy fails.
+func importWAL(ctx context.Context, src, dst string, opts raftboltdb.StoreMigrateOptions) error {
+	if _, err := os.Stat(dst); err == nil {
+		return fmt.Errorf("file exists in destination %v", dst)
+	}
+	store, err := raftboltdb.New(raftboltdb.Options{Path: dst})
+	if err != nil {
+		return err
+	}
+	if err := walinterop.ImportFromWAL(ctx, src, store, opts); err != nil {
+		store.Close()
+		os.Remove(dst)
+		return err
+	}
+	return store.Close()
+}
@@ -0,0 +1,22 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package walinterop converts between a raftboltdb.BoltStore and a
+// raft-wal directory, in either direction, so operators moving to
+// raft-wal have a supported way there and back again.
+//
+// It's a module of its own, so raft-wal isn't a dependency of
+// raft-boltdb:
+//
+//	go get github.com/hashicorp/raft-boltdb/v2/walinterop
+//
+// The raft-boltdb-wal command in cmd/raft-boltdb-wal wraps it for
+// operators.
+//
+// Both directions stream the log in batches and copy the stable store
+// keys raft uses, with raftboltdb.MigrateStores:
+//
+//	store, err := raftboltdb.New(raftboltdb.Options{Path: "raft/raft.db"})
+//	...
+//	err = walinterop.ExportToWAL(ctx, store, "raft/wal", raftboltdb.StoreMigrateOptions{})
+package walinterop
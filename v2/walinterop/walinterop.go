@@ -0,0 +1,54 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package walinterop
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	wal "github.com/hashicorp/raft-wal"
+)
+
+// ExportToWAL copies the log and stable store of store into a new
+// raft-wal directory at dir, which must not exist or be empty.
+func ExportToWAL(ctx context.Context, store *raftboltdb.BoltStore, dir string, opts raftboltdb.StoreMigrateOptions) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("%s isn't empty", dir)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	w, err := wal.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed opening WAL: %w", err)
+	}
+	if err := raftboltdb.MigrateStores(ctx, store, w, opts); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// ImportFromWAL copies the log and stable store of the raft-wal directory
+// at dir into store, which must not already hold any entries. The
+// directory is left as it was.
+func ImportFromWAL(ctx context.Context, dir string, store *raftboltdb.BoltStore, opts raftboltdb.StoreMigrateOptions) error {
+	if _, err := os.Stat(dir); err != nil {
+		return err
+	}
+
+	w, err := wal.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed opening WAL: %w", err)
+	}
+	defer w.Close()
+	return raftboltdb.MigrateStores(ctx, w, store, opts)
+}
@@ -0,0 +1,66 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package walinterop
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+)
+
+func TestRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	src, err := raftboltdb.New(raftboltdb.Options{Path: filepath.Join(dir, "src.db")})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer src.Close()
+
+	var logs []*raft.Log
+	for i := uint64(1); i <= 100; i++ {
+		logs = append(logs, &raft.Log{Index: i, Term: 1, Data: []byte(fmt.Sprintf("log%d", i))})
+	}
+	if err := src.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := src.SetUint64([]byte("CurrentTerm"), 1); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	walDir := filepath.Join(dir, "wal")
+	if err := ExportToWAL(context.Background(), src, walDir, raftboltdb.StoreMigrateOptions{}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := ExportToWAL(context.Background(), src, walDir, raftboltdb.StoreMigrateOptions{}); err == nil {
+		t.Fatalf("should not export into a directory in use")
+	}
+
+	dst, err := raftboltdb.New(raftboltdb.Options{Path: filepath.Join(dir, "dst.db")})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer dst.Close()
+	if err := ImportFromWAL(context.Background(), walDir, dst, raftboltdb.StoreMigrateOptions{}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	for _, expected := range logs {
+		var log raft.Log
+		if err := dst.GetLog(expected.Index, &log); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if log.Term != expected.Term || !reflect.DeepEqual(log.Data, expected.Data) {
+			t.Fatalf("bad: %#v", log)
+		}
+	}
+	term, err := dst.GetUint64([]byte("CurrentTerm"))
+	if err != nil || term != 1 {
+		t.Fatalf("bad: %d %v", term, err)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"sync"
+	"time"
+)
+
+// WriteLimiter paces the store's bulk writes, so that ImportLogs,
+// TrimPrefixAsync and the RetentionPolicy can't starve raft's own appends
+// of the disk. It's a token bucket in which every entry written or
+// deleted costs a token.
+//
+// StoreLogs and DeleteRange, which are how raft writes, take their
+// tokens without waiting, so raft is never held up. They can run the
+// bucket into debt, but by no more than Burst. Bulk operations reserve
+// the tokens for each chunk and wait until they're paid for before
+// writing it, so they get whatever rate raft leaves spare.
+type WriteLimiter struct {
+	// EntriesPerSecond is the rate at which tokens are added. Required.
+	EntriesPerSecond float64
+
+	// Burst is how many tokens the bucket holds. Defaults to a second's
+	// worth.
+	Burst int
+}
+
+// writeLimiter is the token bucket for a WriteLimiter. Its methods do
+// nothing on a nil limiter, which is used when Options.WriteLimiter isn't
+// set.
+type writeLimiter struct {
+	rate  float64
+	burst float64
+
+	lock   sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newWriteLimiter returns a full token bucket for the given options.
+func newWriteLimiter(opts WriteLimiter) *writeLimiter {
+	burst := float64(opts.Burst)
+	if burst == 0 {
+		burst = max(1, opts.EntriesPerSecond)
+	}
+	return &writeLimiter{rate: opts.EntriesPerSecond, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// refill adds the tokens due since the last refill. It must be called
+// with lock held.
+func (l *writeLimiter) refill(now time.Time) {
+	if now.After(l.last) {
+		l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+		l.last = now
+	}
+}
+
+// take removes n tokens from the bucket without waiting, for raft's
+// writes. The bucket can go into debt, but by no more than it holds, so a
+// large DeleteRange after a snapshot can't hold bulk writes up for long.
+func (l *writeLimiter) take(n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.refill(time.Now())
+	l.tokens = max(l.tokens-float64(n), min(l.tokens, -l.burst))
+}
+
+// reserve removes n tokens from the bucket at the given time, and returns
+// how long it will be until they're paid for. Taking them straight away
+// means bulk writers running at the same time queue up behind each other
+// rather than going ahead on the same tokens.
+func (l *writeLimiter) reserve(n int, now time.Time) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.refill(now)
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// waitWrite reserves tokens for a bulk write of n entries and waits until
+// they're paid for. It returns ErrClosed if the store is closed in the
+// meantime.
+func (b *BoltStore) waitWrite(n int) error {
+	if b.limiter == nil || n <= 0 {
+		return nil
+	}
+	start := time.Now()
+	defer b.metrics.measureSince([]string{"writeLimiter", "wait"}, start)
+	d := b.limiter.reserve(n, start)
+	if d == 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-b.closeCh:
+		return ErrClosed
+	}
+}
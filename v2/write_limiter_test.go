@@ -0,0 +1,106 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package raftboltdb
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteLimiter(t *testing.T) {
+	l := newWriteLimiter(WriteLimiter{EntriesPerSecond: 100, Burst: 10})
+	now := l.last
+	if d := l.reserve(5, now); d != 0 {
+		t.Fatalf("bad: %s", d)
+	}
+
+	// Reserved tokens are gone straight away, so the next bulk write
+	// waits for its own
+	if d := l.reserve(10, now); d != 50*time.Millisecond {
+		t.Fatalf("bad: %s", d)
+	}
+
+	// Raft's writes run the bucket into debt, but by no more than it
+	// holds
+	l.take(100)
+	if d := l.reserve(10, now); d < 190*time.Millisecond || d > 200*time.Millisecond {
+		t.Fatalf("bad: %s", d)
+	}
+
+	// A chunk bigger than the bucket waits for the rest of its tokens
+	later := l.last.Add(time.Hour)
+	if d := l.reserve(30, later); d != 200*time.Millisecond {
+		t.Fatalf("bad: %s", d)
+	}
+}
+
+func TestBoltStore_WriteLimiter(t *testing.T) {
+	src := testBoltStore(t)
+	defer src.Close()
+	defer os.Remove(src.path)
+	for i := uint64(1); i <= 30; i++ {
+		if err := src.StoreLog(testRaftLog(i, "log")); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	var export bytes.Buffer
+	if err := src.ExportRange(&export, 1, 30, FormatMsgpack); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	store, err := New(Options{
+		Path:         filepath.Join(t.TempDir(), "raft.db"),
+		WriteLimiter: &WriteLimiter{EntriesPerSecond: 100, Burst: 10},
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer store.Close()
+
+	// The first chunk uses the burst, the other two wait for it to refill
+	start := time.Now()
+	n, err := store.ImportLogs(&export, ImportOptions{ChunkSize: 10})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if n != 30 {
+		t.Fatalf("bad: %d", n)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("bad: %s", elapsed)
+	}
+
+	// Raft's writes don't wait, but bulk writes wait for them, for no
+	// more than a burst's worth of debt
+	if err := store.DeleteRange(1, 30); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if d := store.limiter.reserve(10, time.Now()); d < 150*time.Millisecond || d > 200*time.Millisecond {
+		t.Fatalf("bad: %s", d)
+	}
+
+	// Closing the store stops a bulk write waiting
+	done := store.TrimPrefixAsync(100)
+	store.Close()
+	if err := <-done; !errors.Is(err, ErrClosed) {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestBoltStore_WriteLimiter_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "raft.db")
+	for _, l := range []*WriteLimiter{
+		{},
+		{EntriesPerSecond: -1},
+		{EntriesPerSecond: 1, Burst: -1},
+	} {
+		if _, err := New(Options{Path: path, WriteLimiter: l}); !errors.Is(err, ErrInvalidOptions) {
+			t.Fatalf("err: %v", err)
+		}
+	}
+}